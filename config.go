@@ -51,6 +51,12 @@ func validateConfig(config *Config) error {
 			return fmt.Errorf("invalid QUIC version: %s", v)
 		}
 	}
+	// [UQUIC]
+	if config.ServerSpec != nil {
+		if err := config.ServerSpec.Validate(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -123,6 +129,12 @@ func populateConfig(config *Config) *Config {
 		InitialPacketSize:                initialPacketSize,
 		DisablePathMTUDiscovery:          config.DisablePathMTUDiscovery,
 		EnableStreamResetPartialDelivery: config.EnableStreamResetPartialDelivery,
+		EnableClientFingerprint:          config.EnableClientFingerprint,   // [UQUIC]
+		ServerSpec:                       config.ServerSpec,                // [UQUIC]
+		CongestionControl:                config.CongestionControl,         // [UQUIC]
+		PreferredAddress:                 config.PreferredAddress,          // [UQUIC]
+		MigrateToPreferredAddress:        config.MigrateToPreferredAddress, // [UQUIC]
+		MigrationPolicy:                  config.MigrationPolicy,           // [UQUIC]
 		Allow0RTT:                        config.Allow0RTT,
 		Tracer:                           config.Tracer,
 		TLSGetClientHelloSpec:            config.TLSGetClientHelloSpec,
@@ -55,6 +55,8 @@ type connIDGenerator struct {
 	statelessResetter *statelessResetter
 
 	queueControlFrame func(wire.Frame)
+
+	maxIssuedConnIDs uint64 // [UQUIC]
 }
 
 func newConnIDGenerator(
@@ -86,9 +88,8 @@ func (m *connIDGenerator) SetMaxActiveConnIDs(limit uint64) error {
 	// connection IDs the peer will store. This limit includes the connection ID
 	// used during the handshake, and the one sent in the preferred_address
 	// transport parameter.
-	// We currently don't send the preferred_address transport parameter,
-	// so we can issue (limit - 1) connection IDs.
-	for i := uint64(len(m.activeSrcConnIDs)); i < min(limit, protocol.MaxIssuedConnectionIDs); i++ {
+	// [UQUIC] Both are in activeSrcConnIDs.
+	for i := uint64(len(m.activeSrcConnIDs)); i < min(limit, m.maxIssued()); i++ { // [UQUIC]
 		if err := m.issueNewConnID(); err != nil {
 			return err
 		}
@@ -42,6 +42,8 @@ type connIDManager struct {
 	closed bool
 
 	connectionIDLimit uint64 // [UQUIC] custom Connection ID limit
+
+	preferredAddressConnID *newConnID // [UQUIC] kept for a new path, see AddFromPreferredAddress
 }
 
 func newConnIDManager(
@@ -59,15 +61,29 @@ func newConnIDManager(
 	}
 }
 
+// [UQUIC]
+// AddFromPreferredAddress adds the connection ID of the preferred_address transport parameter.
+// It is used for the first new path, usually the one to the preferred address, see
+// section 9.6.1 of RFC 9000. Otherwise the client would switch to it right after the
+// handshake, leaving no connection ID for the migration if the server doesn't issue others.
 func (h *connIDManager) AddFromPreferredAddress(connID protocol.ConnectionID, resetToken protocol.StatelessResetToken) error {
-	return h.addConnectionID(1, connID, resetToken)
+	h.preferredAddressConnID = &newConnID{
+		SequenceNumber:      1,
+		ConnectionID:        connID,
+		StatelessResetToken: resetToken,
+	}
+	return nil
 }
 
 func (h *connIDManager) Add(f *wire.NewConnectionIDFrame) error {
 	if err := h.add(f); err != nil {
 		return err
 	}
-	if len(h.queue) >= protocol.MaxActiveConnectionIDs {
+	numConnIDs := len(h.queue) // [UQUIC]
+	if h.preferredAddressConnID != nil {
+		numConnIDs++
+	}
+	if numConnIDs >= h.activeConnectionIDLimit() { // [UQUIC]
 		return &qerr.TransportError{ErrorCode: qerr.ConnectionIDLimitError}
 	}
 	return nil
@@ -100,6 +116,11 @@ func (h *connIDManager) add(f *wire.NewConnectionIDFrame) error {
 			}
 		}
 	}
+	// [UQUIC]
+	if h.preferredAddressConnID != nil && h.preferredAddressConnID.SequenceNumber < f.RetirePriorTo {
+		h.queueControlFrame(&wire.RetireConnectionIDFrame{SequenceNumber: h.preferredAddressConnID.SequenceNumber})
+		h.preferredAddressConnID = nil
+	}
 	// Retire elements in the queue.
 	// Doesn't retire the active connection ID.
 	if f.RetirePriorTo > h.highestRetired {
@@ -267,6 +288,14 @@ func (h *connIDManager) GetConnIDForPath(id pathID) (protocol.ConnectionID, bool
 	if ok {
 		return entry.ConnectionID, true
 	}
+	if h.preferredAddressConnID != nil { // [UQUIC]
+		entry := *h.preferredAddressConnID
+		h.preferredAddressConnID = nil
+		h.pathProbing[id] = entry
+		h.highestProbingID = max(h.highestProbingID, entry.SequenceNumber)
+		h.addStatelessResetToken(entry.StatelessResetToken)
+		return entry.ConnectionID, true
+	}
 	if len(h.queue) == 0 {
 		return protocol.ConnectionID{}, false
 	}
@@ -143,6 +143,9 @@ type Conn struct {
 	pathManager         *pathManager
 	largestRcvdAppData  protocol.PacketNumber
 	pathManagerOutgoing atomic.Pointer[pathManagerOutgoing]
+	transport           *Transport                      // [UQUIC] the Transport a client connection was dialed on, if known
+	migrator            *connMigrator                   // [UQUIC] nil if the client doesn't use a MigrationPolicy
+	uPathRunners        map[uPathRunnerKey]*uConnRunner // [UQUIC] see pathConnRunner
 
 	streamsMap      *streamsMap
 	connIDManager   *connIDManager
@@ -198,6 +201,13 @@ type Conn struct {
 	versionNegotiated   bool
 	receivedFirstPacket bool
 
+	// [UQUIC] compatible version negotiation (RFC 9368)
+	compatibleVersions []protocol.Version // only set for the client
+	switchedVersion    bool
+	originalVersion    protocol.Version // set while Initial packets of the version the connection was started with are accepted
+
+	clientFingerprinter *clientFingerprinter // [UQUIC] only set for the server, see Config.EnableClientFingerprint
+
 	blocked blockMode
 
 	// the minimum of the max_idle_timeout values advertised by both endpoints
@@ -323,6 +333,7 @@ var newConnection = func(
 		s.logger,
 	)
 	s.currentMTUEstimate.Store(uint32(estimateMaxPayloadSize(protocol.ByteCount(s.config.InitialPacketSize))))
+	s.setCongestionControl() // [UQUIC]
 	statelessResetToken := statelessResetter.GetStatelessResetToken(srcConnID)
 	params := &wire.TransportParameters{
 		InitialMaxStreamDataBidiLocal:   protocol.ByteCount(s.config.InitialStreamReceiveWindow),
@@ -346,12 +357,20 @@ var newConnection = func(
 		InitialSourceConnectionID: srcConnID,
 		RetrySourceConnectionID:   retrySrcConnID,
 		EnableResetStreamAt:       conf.EnableStreamResetPartialDelivery,
+		// [UQUIC] only sent to clients sending a version_information transport parameter
+		VersionInformation: &wire.VersionInformation{ChosenVersion: s.version, AvailableVersions: s.config.Versions},
 	}
 	if s.config.EnableDatagrams {
 		params.MaxDatagramFrameSize = wire.MaxDatagramSize
 	} else {
 		params.MaxDatagramFrameSize = protocol.InvalidByteCount
 	}
+	if conf.ServerSpec != nil { // [UQUIC]
+		params.ServerLayout = conf.ServerSpec.transportParametersLayout()
+	}
+	if conf.PreferredAddress != nil && srcConnID.Len() > 0 { // [UQUIC]
+		s.setPreferredAddress(params)
+	}
 	if s.qlogger != nil {
 		s.qlogTransportParameters(params, protocol.PerspectiveServer, false)
 	}
@@ -368,7 +387,11 @@ var newConnection = func(
 		s.version,
 	)
 	s.cryptoStreamHandler = cs
-	s.packer = newPacketPacker(srcConnID, s.connIDManager.Get, s.initialStream, s.handshakeStream, s.sentPacketHandler, s.retransmissionQueue, cs, s.framer, &s.receivedPacketHandler, s.datagramQueue, s.perspective)
+	packer := newPacketPacker(srcConnID, s.connIDManager.Get, s.initialStream, s.handshakeStream, s.sentPacketHandler, s.retransmissionQueue, cs, s.framer, &s.receivedPacketHandler, s.datagramQueue, s.perspective)
+	s.packer = packer
+	if conf.ServerSpec != nil { // [UQUIC]
+		s.packer = newUServerPacketPacker(packer, conf.ServerSpec)
+	}
 	s.unpacker = newPacketUnpacker(cs, s.srcConnIDLen)
 	s.cryptoStreamManager = newCryptoStreamManager(s.initialStream, s.handshakeStream, s.oneRTTStream)
 	return &wrappedConn{Conn: s}
@@ -408,6 +431,9 @@ var newClientConnection = func(
 	if qlogTrace != nil {
 		s.qlogger = qlogTrace.AddProducer()
 	}
+	if tr, ok := runner.(*packetHandlerMap); ok { // [UQUIC]
+		s.transport = (*Transport)(tr)
+	}
 	if s.qlogger != nil {
 		var srcAddr, destAddr *net.UDPAddr
 		if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
@@ -452,6 +478,7 @@ var newClientConnection = func(
 		s.logger,
 	)
 	s.currentMTUEstimate.Store(uint32(estimateMaxPayloadSize(protocol.ByteCount(s.config.InitialPacketSize))))
+	s.setCongestionControl() // [UQUIC]
 	oneRTTStream := newCryptoStream()
 	params := &wire.TransportParameters{
 		InitialMaxStreamDataBidiRemote: protocol.ByteCount(s.config.InitialStreamReceiveWindow),
@@ -703,12 +730,16 @@ runLoop:
 
 		c.connIDGenerator.RemoveRetiredConnIDs(now)
 
+		if c.migrator != nil { // [UQUIC]
+			c.runMigrationPolicy(now)
+		}
+
 		if c.perspective == protocol.PerspectiveClient {
 			pm := c.pathManagerOutgoing.Load()
 			if pm != nil {
-				tr, ok := pm.ShouldSwitchPath()
+				tr, remoteAddr, ok := pm.ShouldSwitchPath()
 				if ok {
-					c.switchToNewPath(tr, now)
+					c.switchToNewPath(tr, remoteAddr, now)
 				}
 			}
 		}
@@ -880,6 +911,10 @@ func (c *Conn) maybeResetTimer() {
 			}
 		}
 	}
+	// [UQUIC] The migration policy probes other paths even if the current path is blocked.
+	if t := c.nextMigrationTime(); !t.IsZero() && t.Before(deadline) {
+		deadline = t
+	}
 	// If the connection is hard-blocked, we can't even send acknowledgments,
 	// nor can we send PTO probe packets.
 	if c.blocked == blockModeHardBlocked {
@@ -912,7 +947,7 @@ func (c *Conn) idleTimeoutStartTime() monotime.Time {
 	return startTime
 }
 
-func (c *Conn) switchToNewPath(tr *Transport, now monotime.Time) {
+func (c *Conn) switchToNewPath(tr *Transport, remoteAddr net.Addr, now monotime.Time) {
 	initialPacketSize := protocol.ByteCount(c.config.InitialPacketSize)
 	c.sentPacketHandler.MigratedPath(now, initialPacketSize)
 	maxPacketSize := protocol.ByteCount(protocol.MaxPacketBufferSize)
@@ -920,7 +955,10 @@ func (c *Conn) switchToNewPath(tr *Transport, now monotime.Time) {
 		maxPacketSize = c.peerParams.MaxUDPPayloadSize
 	}
 	c.mtuDiscoverer.Reset(now, initialPacketSize, maxPacketSize)
-	c.conn = newSendConn(tr.conn, c.conn.RemoteAddr(), packetInfo{}, utils.DefaultLogger) // TODO: find a better way
+	if remoteAddr == nil { // [UQUIC]
+		remoteAddr = c.conn.RemoteAddr()
+	}
+	c.conn = newSendConn(tr.conn, remoteAddr, packetInfo{}, utils.DefaultLogger) // TODO: find a better way
 	c.sendQueue.Close()
 	c.sendQueue = newSendQueue(c.conn)
 	go func() {
@@ -928,6 +966,7 @@ func (c *Conn) switchToNewPath(tr *Transport, now monotime.Time) {
 			c.destroyImpl(err)
 		}
 	}()
+	c.recordMigrationState(qlog.MigrationStateMigrationComplete, c.LocalAddr(), c.RemoteAddr(), "") // [UQUIC]
 }
 
 func (c *Conn) handleHandshakeComplete(now monotime.Time) error {
@@ -949,6 +988,14 @@ func (c *Conn) handleHandshakeComplete(now monotime.Time) error {
 	// During a 0-RTT connection, the client is only allowed to use the new transport parameters for 1-RTT packets.
 	if c.perspective == protocol.PerspectiveClient {
 		c.applyTransportParameters()
+		if c.config.MigrateToPreferredAddress && c.transport != nil { // [UQUIC]
+			if addr, ok := c.preferredAddress(); ok {
+				go c.migrateToPreferredAddress(addr)
+			}
+		}
+		if c.config.MigrationPolicy != nil { // [UQUIC]
+			c.setupMigrationPolicy(now)
+		}
 		return nil
 	}
 
@@ -1053,6 +1100,10 @@ func (c *Conn) handlePackets() (wasProcessed bool, _ error) {
 func (c *Conn) handleOnePacket(rp receivedPacket, datagramID qlog.DatagramID) (wasProcessed bool, _ error) {
 	c.sentPacketHandler.ReceivedBytes(rp.Size(), rp.rcvTime)
 
+	if c.clientFingerprinter != nil { // [UQUIC]
+		c.updateClientFingerprint(rp.data)
+	}
+
 	if wire.IsVersionNegotiationPacket(rp.data) {
 		return false, c.handleVersionNegotiationPacket(rp)
 	}
@@ -1116,7 +1167,12 @@ func (c *Conn) handleOnePacket(rp receivedPacket, datagramID qlog.DatagramID) (w
 			}
 			lastConnID = hdr.DestConnectionID
 
-			if hdr.Version != c.version {
+			// [UQUIC] the server may switch to a compatible version
+			if hdr.Version != c.version && c.acceptsCompatibleVersion(hdr) {
+				c.switchVersion(hdr.Version)
+			}
+
+			if hdr.Version != c.version && !c.acceptsOriginalVersion(hdr) { // [UQUIC]
 				if c.qlogger != nil {
 					c.qlogger.RecordEvent(qlog.PacketDropped{
 						Raw:        qlog.RawInfo{Length: len(data)},
@@ -1146,6 +1202,7 @@ func (c *Conn) handleOnePacket(rp receivedPacket, datagramID qlog.DatagramID) (w
 			}
 			if processed {
 				wasProcessed = true
+				c.receivedNegotiatedVersion(hdr) // [UQUIC]
 			}
 			data = rest
 		} else {
@@ -1264,7 +1321,9 @@ func (c *Conn) handleShortHeaderPacket(
 		return true, nil
 	}
 	if addrsEqual(p.remoteAddr, c.RemoteAddr()) {
-		return true, nil
+		// [UQUIC] The client probes the preferred address from its current address,
+		// see PreferredAddress.
+		return true, c.handlePacketOnLocalAddr(p, pn, pathChallenge, isNonProbing, datagramID)
 	}
 
 	var shouldSwitchPath bool
@@ -1284,7 +1343,7 @@ func (c *Conn) handleShortHeaderPacket(
 		c.logger.Debugf("sending path probe packet to %s", p.remoteAddr)
 		c.logShortHeaderPacketWithDatagramID(probe, protocol.ECNNon, buf.Len(), false, datagramID)
 		c.registerPackedShortHeaderPacket(probe, protocol.ECNNon, p.rcvTime)
-		c.sendQueue.SendProbe(buf, p.remoteAddr)
+		c.sendProbeFrom(buf, p) // [UQUIC]
 	}
 	// We only switch paths in response to the highest-numbered non-probing packet,
 	// see section 9.3 of RFC 9000.
@@ -2369,6 +2428,11 @@ func (c *Conn) handleTransportParameters(params *wire.TransportParameters) error
 		}
 	}
 
+	// [UQUIC]
+	if err := c.handleVersionInformation(params); err != nil {
+		return err
+	}
+
 	c.peerParams = params
 	// On the client side we have to wait for handshake completion.
 	// During a 0-RTT connection, we are only allowed to use the new transport parameters for 1-RTT packets.
@@ -2428,9 +2492,9 @@ func (c *Conn) applyTransportParameters() {
 	if params.StatelessResetToken != nil {
 		c.connIDManager.SetStatelessResetToken(*params.StatelessResetToken)
 	}
-	// We don't support connection migration yet, so we don't have any use for the preferred_address.
+	// [UQUIC] The connection ID is used for the migration to the preferred_address,
+	// see Config.MigrateToPreferredAddress and AddPreferredAddressPath.
 	if params.PreferredAddress != nil {
-		// Retire the connection ID.
 		c.connIDManager.AddFromPreferredAddress(params.PreferredAddress.ConnectionID, params.PreferredAddress.StatelessResetToken)
 	}
 	maxPacketSize := protocol.ByteCount(protocol.MaxPacketBufferSize)
@@ -2448,6 +2512,20 @@ func (c *Conn) applyTransportParameters() {
 func (c *Conn) triggerSending(now monotime.Time) error {
 	c.pacingDeadline = 0
 
+	// [UQUIC] The probes of the migration policy need to be sent when the current path
+	// failed, even if its congestion controller doesn't allow sending anymore.
+	if c.migrator != nil && c.handshakeConfirmed {
+		sent, err := c.maybeSendPathProbe(now)
+		if err != nil {
+			return err
+		}
+		if sent {
+			// There's (likely) more data to send. Loop around again.
+			c.scheduleSending()
+			return nil
+		}
+	}
+
 	sendMode := c.sentPacketHandler.SendMode(now)
 	switch sendMode {
 	case ackhandler.SendAny:
@@ -2485,23 +2563,41 @@ func (c *Conn) triggerSending(now monotime.Time) error {
 	}
 }
 
+// [UQUIC]
+// maybeSendPathProbe sends the next path probe packet, if any.
+func (c *Conn) maybeSendPathProbe(now monotime.Time) (sent bool, _ error) {
+	pm := c.pathManagerOutgoing.Load()
+	if pm == nil {
+		return false, nil
+	}
+	connID, frame, tr, remoteAddr, ok := pm.NextPathToProbe()
+	if !ok {
+		return false, nil
+	}
+	if remoteAddr == nil {
+		remoteAddr = c.conn.RemoteAddr()
+	}
+	probe, buf, err := c.packer.PackPathProbePacket(connID, []ackhandler.Frame{frame}, c.version)
+	if err != nil {
+		return false, err
+	}
+	c.logger.Debugf("sending path probe packet from %s", tr.Conn.LocalAddr())
+	c.logShortHeaderPacket(probe, protocol.ECNNon, buf.Len())
+	c.registerPackedShortHeaderPacket(probe, protocol.ECNNon, now)
+	tr.WriteTo(buf.Data, remoteAddr)
+	return true, nil
+}
+
 func (c *Conn) sendPackets(now monotime.Time) error {
 	if c.perspective == protocol.PerspectiveClient && c.handshakeConfirmed {
-		if pm := c.pathManagerOutgoing.Load(); pm != nil {
-			connID, frame, tr, ok := pm.NextPathToProbe()
-			if ok {
-				probe, buf, err := c.packer.PackPathProbePacket(connID, []ackhandler.Frame{frame}, c.version)
-				if err != nil {
-					return err
-				}
-				c.logger.Debugf("sending path probe packet from %s", c.LocalAddr())
-				c.logShortHeaderPacket(probe, protocol.ECNNon, buf.Len())
-				c.registerPackedShortHeaderPacket(probe, protocol.ECNNon, now)
-				tr.WriteTo(buf.Data, c.conn.RemoteAddr())
-				// There's (likely) more data to send. Loop around again.
-				c.scheduleSending()
-				return nil
-			}
+		sent, err := c.maybeSendPathProbe(now) // [UQUIC]
+		if err != nil {
+			return err
+		}
+		if sent {
+			// There's (likely) more data to send. Loop around again.
+			c.scheduleSending()
+			return nil
 		}
 	}
 
@@ -2563,6 +2659,7 @@ func (c *Conn) sendPacketsWithoutGSO(now monotime.Time) error {
 		if _, err := c.appendOneShortHeaderPacket(buf, c.maxPacketSize(), ecn, now); err != nil {
 			if err == errNothingToPack {
 				buf.Release()
+				ackhandler.SetAppLimited(c.sentPacketHandler) // [UQUIC]
 				return nil
 			}
 			return err
@@ -2604,6 +2701,7 @@ func (c *Conn) sendPacketsWithGSO(now monotime.Time) error {
 			if err != errNothingToPack {
 				return err
 			}
+			ackhandler.SetAppLimited(c.sentPacketHandler) // [UQUIC]
 			if buf.Len() == 0 {
 				buf.Release()
 				return nil
@@ -3089,11 +3187,19 @@ func (c *Conn) AddPath(t *Transport) (*Path, error) {
 	if err := t.init(false); err != nil {
 		return nil, err
 	}
+	return c.addPath(t, nil), nil // [UQUIC]
+}
+
+// [UQUIC]
+// addPath adds a path from the Transport to remoteAddr.
+// If remoteAddr is nil, the path uses the remote address of the connection.
+func (c *Conn) addPath(t *Transport, remoteAddr net.Addr) *Path {
 	return c.getPathManager().NewPath(
 		t,
+		remoteAddr,
 		200*time.Millisecond, // initial RTT estimate
 		func() {
-			runner := (*packetHandlerMap)(t)
+			runner := c.pathConnRunner(t, remoteAddr)
 			c.connIDGenerator.AddConnRunner(
 				runner,
 				connRunnerCallbacks{
@@ -3103,7 +3209,7 @@ func (c *Conn) AddPath(t *Transport) (*Path, error) {
 				},
 			)
 		},
-	), nil
+	)
 }
 
 // HandshakeComplete blocks until the handshake completes (or fails).
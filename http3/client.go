@@ -51,7 +51,7 @@ type ClientConn struct {
 	conn    *quic.Conn
 	rawConn *rawConn
 
-	decoder *qpack.Decoder
+	decoder fieldSectionDecoder // [UQUIC]
 
 	// Additional HTTP/3 settings.
 	// It is invalid to specify any settings defined by RFC 9114 (HTTP/3) and RFC 9297 (HTTP Datagrams).
@@ -71,6 +71,8 @@ type ClientConn struct {
 	// However, if the user explicitly requested gzip it is not automatically uncompressed.
 	disableCompression bool
 
+	profile *Profile // [UQUIC]
+
 	streamMx     sync.Mutex
 	maxStreamID  quic.StreamID // set once a GOAWAY frame is received
 	lastStreamID quic.StreamID // the highest stream ID that was opened
@@ -90,6 +92,7 @@ func newClientConn(
 	additionalSettingsOrder []uint64,
 	maxResponseHeaderBytes int,
 	disableCompression bool,
+	profile *Profile, // [UQUIC]
 	logger *slog.Logger,
 ) *ClientConn {
 	var qlogger qlogwriter.Recorder
@@ -103,9 +106,10 @@ func newClientConn(
 		maxStreamID:             invalidStreamID,
 		lastStreamID:            invalidStreamID,
 		additionalSettingsOrder: additionalSettingsOrder,
+		profile:                 profile,
 		logger:                  logger,
 		qlogger:                 qlogger,
-		decoder:                 qpack.NewDecoder(),
+		decoder:                 staticTableDecoder{qpack.NewDecoder()},
 	}
 	if maxResponseHeaderBytes <= 0 {
 		c.maxResponseHeaderBytes = defaultMaxResponseHeaderBytes
@@ -121,6 +125,32 @@ func newClientConn(
 		qlogger,
 		c.logger,
 	)
+	// [UQUIC]
+	if profile != nil {
+		if maxTableCapacity, blockedStreams := profile.qpackSettings(); maxTableCapacity > 0 {
+			decoder := newDynamicTableDecoder(conn.Context(), maxTableCapacity, blockedStreams)
+			c.decoder = decoder
+			c.rawConn.qpackEncoderStrHandler = func(str *quic.ReceiveStream) {
+				if err := decoder.HandleEncoderStream(str); err != nil && conn.Context().Err() == nil {
+					conn.CloseWithError(quic.ApplicationErrorCode(ErrCodeQPACKEncoderStreamError), "")
+				}
+			}
+		}
+		go func() {
+			err := profile.validate()
+			if err == nil {
+				err = c.openProfileStreams(profile, enableDatagrams)
+			}
+			if err != nil {
+				if c.logger != nil {
+					c.logger.Debug("setting up connection failed", "error", err)
+				}
+				c.conn.CloseWithError(quic.ApplicationErrorCode(ErrCodeInternalError), "")
+			}
+		}()
+		return c
+	}
+	// [/UQUIC]
 	// send the SETTINGs frame, using 0-RTT data, if possible
 	go func() {
 		_, err := c.rawConn.openControlStream(&settingsFrame{
@@ -140,6 +170,8 @@ func newClientConn(
 }
 
 // OpenRequestStream opens a new request stream on the HTTP/3 connection.
+// [UQUIC] If the server uses the QPACK dynamic table, canceling ctx also abandons
+// decoding the response headers while they wait for dynamic table entries.
 func (c *ClientConn) OpenRequestStream(ctx context.Context) (*RequestStream, error) {
 	return c.openRequestStream(ctx, c.requestWriter, nil, c.disableCompression, c.maxResponseHeaderBytes)
 }
@@ -192,8 +224,9 @@ func (c *ClientConn) openRequestStream(
 	rsp := &http.Response{}
 	trace := httptrace.ContextClientTrace(ctx)
 	return newRequestStream(
+		ctx, // [UQUIC]
 		newStream(hstr, c.rawConn, trace, func(r io.Reader, hf *headersFrame) error {
-			hdr, err := decodeTrailers(r, hf, maxHeaderBytes, c.decoder, c.qlogger, str.StreamID())
+			hdr, err := decodeTrailers(ctx, r, hf, maxHeaderBytes, c.decoder, c.qlogger, str.StreamID())
 			if err != nil {
 				return err
 			}
@@ -277,6 +310,9 @@ func (c *ClientConn) RoundTrip(req *http.Request) (*http.Response, error) {
 }
 
 func (c *ClientConn) roundTrip(req *http.Request) (*http.Response, error) {
+	if c.profile != nil {
+		req = c.profile.applyToRequest(req) // [UQUIC]
+	}
 	// Immediately send out this request, if this is a 0-RTT request.
 	switch req.Method {
 	case MethodGet0RTT:
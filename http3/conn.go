@@ -41,6 +41,8 @@ type rawConn struct {
 	rcvdQPACKDecoderStr atomic.Bool
 	controlStrHandler   func(*quic.ReceiveStream, *frameParser) // is called *after* the SETTINGS frame was parsed
 
+	qpackEncoderStrHandler func(*quic.ReceiveStream) // [UQUIC] reads the peer's QPACK encoder stream, if set
+
 	onStreamsEmpty func()
 
 	settings         *Settings
@@ -173,8 +175,12 @@ func (c *rawConn) handleUnidirectionalStream(str *quic.ReceiveStream, isServer b
 	case streamTypeQPACKEncoderStream:
 		if isFirst := c.rcvdQPACKEncoderStr.CompareAndSwap(false, true); !isFirst {
 			c.CloseWithError(quic.ApplicationErrorCode(ErrCodeStreamCreationError), "duplicate QPACK encoder stream")
+			return
+		}
+		// [UQUIC] the dynamic table is only used if a Profile allows it
+		if c.qpackEncoderStrHandler != nil {
+			c.qpackEncoderStrHandler(str)
 		}
-		// Our QPACK implementation doesn't use the dynamic table yet.
 		return
 	case streamTypeQPACKDecoderStream:
 		if isFirst := c.rcvdQPACKDecoderStr.CompareAndSwap(false, true); !isFirst {
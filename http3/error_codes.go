@@ -28,6 +28,7 @@ const (
 	ErrCodeVersionFallback          ErrCode = 0x110
 	ErrCodeDatagramError            ErrCode = 0x33
 	ErrCodeQPACKDecompressionFailed ErrCode = 0x200
+	ErrCodeQPACKEncoderStreamError  ErrCode = 0x201 // [UQUIC]
 )
 
 func (e ErrCode) String() string {
@@ -78,6 +79,8 @@ func (e ErrCode) string() string {
 		return "H3_DATAGRAM_ERROR"
 	case ErrCodeQPACKDecompressionFailed:
 		return "QPACK_DECOMPRESSION_FAILED"
+	case ErrCodeQPACKEncoderStreamError:
+		return "QPACK_ENCODER_STREAM_ERROR"
 	default:
 		return ""
 	}
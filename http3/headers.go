@@ -2,6 +2,7 @@ package http3
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -354,7 +355,7 @@ func writeTrailers(wr io.Writer, trailers http.Header, streamID quic.StreamID, q
 	return true, err
 }
 
-func decodeTrailers(r io.Reader, hf *headersFrame, maxHeaderBytes int, decoder *qpack.Decoder, qlogger qlogwriter.Recorder, streamID quic.StreamID) (http.Header, error) {
+func decodeTrailers(ctx context.Context, r io.Reader, hf *headersFrame, maxHeaderBytes int, decoder fieldSectionDecoder, qlogger qlogwriter.Recorder, streamID quic.StreamID) (http.Header, error) { // [UQUIC] ctx
 	if hf.Length > uint64(maxHeaderBytes) {
 		maybeQlogInvalidHeadersFrame(qlogger, streamID, hf.Length)
 		return nil, fmt.Errorf("http3: HEADERS frame too large: %d bytes (max: %d)", hf.Length, maxHeaderBytes)
@@ -364,7 +365,7 @@ func decodeTrailers(r io.Reader, hf *headersFrame, maxHeaderBytes int, decoder *
 	if _, err := io.ReadFull(r, b); err != nil {
 		return nil, err
 	}
-	decodeFn := decoder.Decode(b)
+	decodeFn := decoder.DecodeFieldSection(ctx, streamID, b) // [UQUIC]
 	var fields []qpack.HeaderField
 	if qlogger != nil {
 		fields = make([]qpack.HeaderField, 0, 16)
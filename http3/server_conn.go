@@ -175,7 +175,7 @@ func (c *RawServerConn) handleRequestStream(str *stateTrackingStream) {
 		contentLength = req.ContentLength
 	}
 	hstr := newStream(str, conn, nil, func(r io.Reader, hf *headersFrame) error {
-		trailers, err := decodeTrailers(r, hf, maxHeaderBytes, decoder, qlogger, str.StreamID())
+		trailers, err := decodeTrailers(req.Context(), r, hf, maxHeaderBytes, staticTableDecoder{decoder}, qlogger, str.StreamID())
 		if err != nil {
 			return err
 		}
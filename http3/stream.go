@@ -162,10 +162,11 @@ func (s *Stream) ReceiveDatagram(ctx context.Context) ([]byte, error) {
 // MASQUE proxying protocols.
 type RequestStream struct {
 	str *Stream
+	ctx context.Context // [UQUIC] cancels decoding field sections blocked on the QPACK dynamic table
 
 	responseBody io.ReadCloser // set by ReadResponse
 
-	decoder            *qpack.Decoder
+	decoder            fieldSectionDecoder // [UQUIC]
 	requestWriter      *requestWriter
 	maxHeaderBytes     int
 	reqDone            chan<- struct{}
@@ -178,16 +179,18 @@ type RequestStream struct {
 }
 
 func newRequestStream(
+	ctx context.Context, // [UQUIC]
 	str *Stream,
 	requestWriter *requestWriter,
 	reqDone chan<- struct{},
-	decoder *qpack.Decoder,
+	decoder fieldSectionDecoder, // [UQUIC]
 	disableCompression bool,
 	maxHeaderBytes int,
 	rsp *http.Response,
 ) *RequestStream {
 	return &RequestStream{
 		str:                str,
+		ctx:                ctx,
 		requestWriter:      requestWriter,
 		reqDone:            reqDone,
 		decoder:            decoder,
@@ -345,7 +348,7 @@ func (s *RequestStream) ReadResponse() (*http.Response, error) {
 		s.str.CancelWrite(quic.StreamErrorCode(ErrCodeRequestIncomplete))
 		return nil, fmt.Errorf("http3: failed to read response headers: %w", err)
 	}
-	decodeFn := s.decoder.Decode(headerBlock)
+	decodeFn := s.decoder.DecodeFieldSection(s.ctx, s.str.StreamID(), headerBlock) // [UQUIC]
 	var hfs []qpack.HeaderField
 	if s.str.qlogger != nil {
 		hfs = make([]qpack.HeaderField, 0, 16)
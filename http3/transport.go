@@ -79,6 +79,15 @@ type Transport struct {
 	// and will be reused for subsequent connections to other servers.
 	Dial func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error)
 
+	// QUICSpec specifies the QUIC layer of the fingerprint used for dialing new connections.
+	// It is ignored if Dial is set.
+	QUICSpec *quic.QUICSpec // [UQUIC]
+
+	// QUICID selects a predefined QUICSpec, see quic.QUICID2Spec. Like for any QUICSpec,
+	// its IPv6 overrides are applied if the hostname resolves to an IPv6 address.
+	// It is ignored if Dial or QUICSpec is set.
+	QUICID *quic.QUICID // [UQUIC]
+
 	// Enable support for HTTP/3 datagrams (RFC 9297).
 	// If a QUICConfig is set, datagram support also needs to be enabled on the QUIC layer by setting EnableDatagrams.
 	EnableDatagrams bool
@@ -90,6 +99,11 @@ type Transport struct {
 	// uQuic being
 	AdditionalSettingsOrder []uint64
 
+	// Profile specifies the HTTP/3 layer of the fingerprint: the unidirectional streams,
+	// the SETTINGS frame and the header order of requests.
+	// If set, AdditionalSettings and AdditionalSettingsOrder are ignored.
+	Profile *Profile // [UQUIC]
+
 	// MaxResponseHeaderBytes specifies a limit on how many response bytes are
 	// allowed in the server's response header.
 	// Zero means to use a default limit.
@@ -114,6 +128,9 @@ type Transport struct {
 	clients   map[string]*roundTripperWithCount
 	transport *quic.Transport
 	closed    bool
+
+	// [UQUIC] used for dialing if QUICSpec or QUICID is set
+	uTransport *quic.UTransport
 }
 
 var (
@@ -129,6 +146,12 @@ var (
 )
 
 func (t *Transport) init() error {
+	// [UQUIC]
+	if t.Profile != nil {
+		if err := t.Profile.validate(); err != nil {
+			return err
+		}
+	}
 	if t.newClientConn == nil {
 		t.newClientConn = func(conn *quic.Conn) clientConn {
 			return newClientConn(
@@ -138,6 +161,7 @@ func (t *Transport) init() error {
 				t.AdditionalSettingsOrder,
 				t.MaxResponseHeaderBytes,
 				t.DisableCompression,
+				t.Profile,
 				t.Logger,
 			)
 		}
@@ -165,6 +189,10 @@ func (t *Transport) init() error {
 			return err
 		}
 		t.transport = &quic.Transport{Conn: udpConn}
+		// [UQUIC]
+		if err := t.initUTransport(); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -380,7 +408,12 @@ func (t *Transport) dial(ctx context.Context, hostname string) (*quic.Conn, clie
 			trace := httptrace.ContextClientTrace(ctx)
 			traceConnectStart(trace, network, udpAddr.String())
 			traceTLSHandshakeStart(trace)
-			conn, err := t.transport.DialEarly(ctx, udpAddr, tlsCfg, cfg)
+			var conn *quic.Conn
+			if t.uTransport != nil { // [UQUIC]
+				conn, err = t.uTransport.DialEarly(ctx, udpAddr, tlsCfg, cfg)
+			} else {
+				conn, err = t.transport.DialEarly(ctx, udpAddr, tlsCfg, cfg)
+			}
 			var state tls.ConnectionState
 			if conn != nil {
 				state = conn.ConnectionState().TLS
@@ -449,6 +482,7 @@ func (t *Transport) NewClientConn(conn *quic.Conn) *ClientConn {
 		t.AdditionalSettingsOrder,
 		t.MaxResponseHeaderBytes,
 		t.DisableCompression,
+		t.Profile,
 		t.Logger,
 	)
 	go func() {
@@ -476,6 +510,7 @@ func (t *Transport) NewRawClientConn(conn *quic.Conn) *RawClientConn {
 			t.AdditionalSettingsOrder,
 			t.MaxResponseHeaderBytes,
 			t.DisableCompression,
+			t.Profile,
 			t.Logger,
 		),
 	}
@@ -500,6 +535,7 @@ func (t *Transport) Close() error {
 			return err
 		}
 		t.transport = nil
+		t.uTransport = nil // [UQUIC]
 	}
 	t.closed = true
 	return nil
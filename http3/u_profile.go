@@ -0,0 +1,260 @@
+package http3
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+
+	"github.com/Noooste/fhttp"
+	"github.com/Noooste/uquic-go/http3/qlog"
+	"github.com/Noooste/uquic-go/quicvarint"
+)
+
+// [UQUIC]
+// The unidirectional stream types a client opens, see Profile.UniStreams.
+const (
+	StreamTypeControl      StreamType = streamTypeControlStream
+	StreamTypeQPACKEncoder StreamType = streamTypeQPACKEncoderStream
+	StreamTypeQPACKDecoder StreamType = streamTypeQPACKDecoderStream
+)
+
+// [UQUIC]
+// A Setting is an HTTP/3 setting sent in the SETTINGS frame.
+type Setting struct {
+	ID    uint64
+	Value uint64
+}
+
+// [UQUIC]
+// A Profile specifies the HTTP/3 layer of a client fingerprint: the unidirectional
+// streams opened when the connection is established, the SETTINGS frame and the
+// defaults applied to requests. It complements the QUICSpec used for the QUIC layer.
+type Profile struct {
+	// UniStreams are the unidirectional streams opened once the connection is
+	// established, in this order. It must contain StreamTypeControl.
+	// No instructions are sent on the QPACK encoder stream, since the request
+	// headers are only encoded using the static table.
+	UniStreams []StreamType
+
+	// Settings are sent in the SETTINGS frame, in this order. A setting with the
+	// ID SettingsGREASE is replaced with a random reserved setting, using a random
+	// value if the Value is 0.
+	//
+	// If SettingsQpackMaxTableCapacity is non-zero, the server may use the QPACK
+	// dynamic table to encode responses. This requires the UniStreams to contain
+	// StreamTypeQPACKDecoder.
+	Settings []Setting
+
+	// GREASEFrame sends a frame of a random reserved type with a random payload
+	// on the control stream, after the SETTINGS frame.
+	GREASEFrame bool
+
+	// PseudoHeaderOrder is the order of the pseudo-header fields, for requests that
+	// don't set http.PHeaderOrderKey.
+	PseudoHeaderOrder []string
+
+	// HeaderOrder is the order of the header fields, for requests that don't set
+	// http.HeaderOrderKey.
+	HeaderOrder []string
+
+	// NavigationPriority is the priority header field (RFC 9218) added to navigation
+	// requests, i.e. requests with the Sec-Fetch-Mode header set to "navigate", that
+	// don't have one. Browsers use other priorities for subresources, which depend
+	// on the type of the resource, so the header is left to the application for them.
+	NavigationPriority string
+}
+
+func (p *Profile) validate() error {
+	var hasControl, hasDecoder bool
+	for _, typ := range p.UniStreams {
+		switch typ {
+		case StreamTypeControl:
+			hasControl = true
+		case StreamTypeQPACKDecoder:
+			hasDecoder = true
+		case StreamTypeQPACKEncoder:
+		default:
+			return fmt.Errorf("http3: unsupported stream type %d in profile", typ)
+		}
+	}
+	if !hasControl {
+		return errors.New("http3: profile doesn't open a control stream")
+	}
+	if capacity, _ := p.qpackSettings(); capacity > 0 && !hasDecoder {
+		return errors.New("http3: profile allows the QPACK dynamic table, but doesn't open a QPACK decoder stream")
+	}
+	return nil
+}
+
+// qpackSettings returns the QPACK settings sent in the SETTINGS frame.
+func (p *Profile) qpackSettings() (maxTableCapacity, blockedStreams uint64) {
+	for _, s := range p.Settings {
+		switch s.ID {
+		case SettingsQpackMaxTableCapacity:
+			maxTableCapacity = s.Value
+		case SettingsQpackBlockedStreams:
+			blockedStreams = s.Value
+		}
+	}
+	return maxTableCapacity, blockedStreams
+}
+
+// appendSettingsFrame appends the SETTINGS frame of the profile. It also returns
+// the frame for qlog. The H3_DATAGRAM setting is added if datagrams are enabled.
+func (p *Profile) appendSettingsFrame(b []byte, enableDatagrams bool) ([]byte, qlog.SettingsFrame) {
+	sf := qlog.SettingsFrame{MaxFieldSectionSize: -1, Other: make(map[uint64]uint64)}
+	payload := make([]byte, 0, 64)
+	var sentDatagram bool
+	for _, s := range p.Settings {
+		id, val := s.ID, s.Value
+		switch id {
+		case SettingsGREASE:
+			id = greaseID()
+			if val == 0 {
+				val = rand.Uint64N(1 << 32)
+			}
+		case SettingsMaxFieldSectionSize:
+			sf.MaxFieldSectionSize = int64(val)
+		case SettingsH3Datagram:
+			sentDatagram = true
+			sf.Datagram = pointer(val == 1)
+		case settingExtendedConnect:
+			sf.ExtendedConnect = pointer(val == 1)
+		}
+		if id != SettingsMaxFieldSectionSize && id != SettingsH3Datagram && id != settingExtendedConnect {
+			sf.Other[id] = val
+		}
+		payload = quicvarint.Append(payload, id)
+		payload = quicvarint.Append(payload, val)
+	}
+	if enableDatagrams && !sentDatagram {
+		payload = quicvarint.Append(payload, SettingsH3Datagram)
+		payload = quicvarint.Append(payload, 1)
+		sf.Datagram = pointer(true)
+	}
+	b = quicvarint.Append(b, 0x4)
+	b = quicvarint.Append(b, uint64(len(payload)))
+	return append(b, payload...), sf
+}
+
+// appendGREASEFrame appends a frame of a reserved type, see section 7.2.8 of RFC 9114.
+func appendGREASEFrame(b []byte) []byte {
+	payload := make([]byte, rand.IntN(16))
+	for i := range payload {
+		payload[i] = byte(rand.Uint32())
+	}
+	b = quicvarint.Append(b, greaseID())
+	b = quicvarint.Append(b, uint64(len(payload)))
+	return append(b, payload...)
+}
+
+// greaseID returns a random reserved identifier of the form 0x1f * N + 0x21.
+func greaseID() uint64 {
+	return 0x1f*rand.Uint64N((quicvarint.Max-0x21)/0x1f) + 0x21
+}
+
+// applyToRequest returns the request with the header order and priority of the profile.
+// The request is copied if it needs to be modified.
+func (p *Profile) applyToRequest(req *http.Request) *http.Request {
+	_, hasPseudoHeaderOrder := req.Header[http.PHeaderOrderKey]
+	setPseudoHeaderOrder := len(p.PseudoHeaderOrder) > 0 && !hasPseudoHeaderOrder
+	_, hasHeaderOrder := req.Header[http.HeaderOrderKey]
+	setHeaderOrder := len(p.HeaderOrder) > 0 && !hasHeaderOrder
+	setPriority := p.NavigationPriority != "" && req.Header.Get("Priority") == "" &&
+		req.Header.Get("Sec-Fetch-Mode") == "navigate"
+	if !setPseudoHeaderOrder && !setHeaderOrder && !setPriority {
+		return req
+	}
+	reqCopy := *req
+	reqCopy.Header = req.Header.Clone()
+	if setPseudoHeaderOrder {
+		reqCopy.Header[http.PHeaderOrderKey] = p.PseudoHeaderOrder
+	}
+	if setHeaderOrder {
+		reqCopy.Header[http.HeaderOrderKey] = p.HeaderOrder
+	}
+	if setPriority {
+		reqCopy.Header.Set("Priority", p.NavigationPriority)
+	}
+	return &reqCopy
+}
+
+// openProfileStreams opens the unidirectional streams of the profile, in order.
+func (c *ClientConn) openProfileStreams(p *Profile, enableDatagrams bool) error {
+	for _, typ := range p.UniStreams {
+		str, err := c.conn.OpenUniStream()
+		if err != nil {
+			return err
+		}
+		b := quicvarint.Append(make([]byte, 0, 64), uint64(typ))
+		switch typ {
+		case StreamTypeControl:
+			var sf qlog.SettingsFrame
+			b, sf = p.appendSettingsFrame(b, enableDatagrams)
+			if c.qlogger != nil {
+				c.qlogger.RecordEvent(qlog.FrameCreated{
+					StreamID: str.StreamID(),
+					Raw:      qlog.RawInfo{Length: len(b)},
+					Frame:    qlog.Frame{Frame: sf},
+				})
+			}
+			if p.GREASEFrame {
+				b = appendGREASEFrame(b)
+			}
+		}
+		if _, err := str.Write(b); err != nil {
+			return err
+		}
+		if d, ok := c.decoder.(*dynamicTableDecoder); ok && typ == StreamTypeQPACKDecoder {
+			d.SetDecoderStream(str)
+		}
+	}
+	return nil
+}
+
+// [UQUIC]
+// Chrome133Profile returns the HTTP/3 profile of Chrome 133. Profiles are not tied to
+// a QUICID: use it with the QUICSpec of the same browser version, the QUIC and HTTP/3
+// layers of other versions differ.
+func Chrome133Profile() *Profile {
+	return &Profile{
+		UniStreams: []StreamType{StreamTypeControl, StreamTypeQPACKEncoder, StreamTypeQPACKDecoder},
+		Settings: []Setting{
+			{ID: SettingsQpackMaxTableCapacity, Value: 65536},
+			{ID: SettingsMaxFieldSectionSize, Value: 262144},
+			{ID: SettingsQpackBlockedStreams, Value: 100},
+			{ID: SettingsH3Datagram, Value: 1},
+			{ID: SettingsGREASE},
+		},
+		GREASEFrame:       true,
+		PseudoHeaderOrder: []string{":method", ":authority", ":scheme", ":path"},
+		HeaderOrder: []string{
+			"content-length", "cache-control", "sec-ch-ua", "sec-ch-ua-mobile", "sec-ch-ua-platform",
+			"origin", "content-type", "upgrade-insecure-requests", "user-agent", "accept",
+			"sec-fetch-site", "sec-fetch-mode", "sec-fetch-user", "sec-fetch-dest", "referer",
+			"accept-encoding", "accept-language", "cookie", "priority",
+		},
+		NavigationPriority: "u=0, i",
+	}
+}
+
+// [UQUIC]
+// Firefox135Profile returns the HTTP/3 profile of Firefox 135, see Chrome133Profile.
+func Firefox135Profile() *Profile {
+	return &Profile{
+		UniStreams: []StreamType{StreamTypeControl, StreamTypeQPACKEncoder, StreamTypeQPACKDecoder},
+		Settings: []Setting{
+			{ID: SettingsQpackMaxTableCapacity, Value: 65536},
+			{ID: SettingsQpackBlockedStreams, Value: 20},
+			{ID: settingExtendedConnect, Value: 1},
+			{ID: SettingsH3Datagram, Value: 1},
+		},
+		PseudoHeaderOrder: []string{":method", ":scheme", ":authority", ":path"},
+		HeaderOrder: []string{
+			"user-agent", "accept", "accept-language", "accept-encoding", "referer",
+			"content-type", "content-length", "origin", "cookie", "upgrade-insecure-requests",
+			"sec-fetch-dest", "sec-fetch-mode", "sec-fetch-site", "sec-fetch-user", "priority", "te",
+		},
+		NavigationPriority: "u=0, i",
+	}
+}
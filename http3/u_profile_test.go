@@ -0,0 +1,244 @@
+package http3
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	http "github.com/Noooste/fhttp"
+	"github.com/Noooste/uquic-go"
+	"github.com/Noooste/uquic-go/internal/testdata"
+	"github.com/Noooste/uquic-go/quicvarint"
+	"github.com/Noooste/utls"
+	"github.com/quic-go/qpack"
+
+	"github.com/stretchr/testify/require"
+)
+
+// profileTestServer is a raw QUIC server recording what an HTTP/3 client sends on the wire:
+// the unidirectional streams, and the field sections of the requests.
+type profileTestServer struct {
+	ln       *quic.Listener
+	conns    chan *quic.Conn
+	requests chan []qpack.HeaderField
+}
+
+func newProfileTestServer(t *testing.T) *profileTestServer {
+	ln, err := quic.ListenAddr("127.0.0.1:0", ConfigureTLSConfig(testdata.GetTLSConfig()), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	s := &profileTestServer{
+		ln:       ln,
+		conns:    make(chan *quic.Conn, 1),
+		requests: make(chan []qpack.HeaderField, 1),
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			s.conns <- conn
+			go s.handleRequests(conn)
+		}
+	}()
+	return s
+}
+
+// handleRequests records the HEADERS frame of each request, and responds with a 200.
+func (s *profileTestServer) handleRequests(conn *quic.Conn) {
+	for {
+		str, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		typ, payload, err := readRawFrame(bufio.NewReader(str))
+		if err != nil || typ != 0x1 {
+			str.CancelRead(quic.StreamErrorCode(ErrCodeFrameUnexpected))
+			continue
+		}
+		var fields []qpack.HeaderField
+		decode := qpack.NewDecoder().Decode(payload)
+		for {
+			hf, err := decode()
+			if err != nil {
+				break
+			}
+			fields = append(fields, hf)
+		}
+		s.requests <- fields
+
+		var buf bytes.Buffer
+		qpack.NewEncoder(&buf).WriteField(qpack.HeaderField{Name: ":status", Value: "200"})
+		b := quicvarint.Append(nil, 0x1)
+		b = quicvarint.Append(b, uint64(buf.Len()))
+		str.Write(append(b, buf.Bytes()...))
+		str.Close()
+	}
+}
+
+// uniStreams accepts n unidirectional streams, and returns them in the order they were opened.
+func (s *profileTestServer) uniStreams(t *testing.T, conn *quic.Conn, n int) []*bufio.Reader {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	strs := make([]*quic.ReceiveStream, 0, n)
+	for range n {
+		str, err := conn.AcceptUniStream(ctx)
+		require.NoError(t, err)
+		strs = append(strs, str)
+	}
+	slices.SortFunc(strs, func(a, b *quic.ReceiveStream) int { return int(a.StreamID() - b.StreamID()) })
+	readers := make([]*bufio.Reader, 0, n)
+	for _, str := range strs {
+		readers = append(readers, bufio.NewReader(str))
+	}
+	return readers
+}
+
+func (s *profileTestServer) nextRequest(t *testing.T) []qpack.HeaderField {
+	t.Helper()
+	select {
+	case fields := <-s.requests:
+		return fields
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	return nil
+}
+
+func readRawFrame(r *bufio.Reader) (uint64, []byte, error) {
+	typ, err := quicvarint.Read(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	l, err := quicvarint.Read(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, l)
+	_, err = io.ReadFull(r, payload)
+	return typ, payload, err
+}
+
+func isGREASE(id uint64) bool { return id >= 0x21 && (id-0x21)%0x1f == 0 }
+
+func TestProfileOnTheWire(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		profile func() *Profile
+	}{
+		{"Chrome 133", Chrome133Profile},
+		{"Firefox 135", Firefox135Profile},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			profile := tc.profile()
+			require.NoError(t, profile.validate())
+			s := newProfileTestServer(t)
+			tr := &Transport{
+				TLSClientConfig: &tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA()},
+				Profile:         profile,
+			}
+			defer tr.Close()
+
+			get := func(header http.Header) {
+				t.Helper()
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://%s/foo", s.ln.Addr()), nil)
+				require.NoError(t, err)
+				req.Header = header
+				rsp, err := tr.RoundTrip(req)
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, rsp.StatusCode)
+				rsp.Body.Close()
+			}
+
+			// a navigation request
+			get(http.Header{
+				"Accept-Language": {"en-US"},
+				"Accept":          {"text/html"},
+				"Sec-Fetch-Dest":  {"document"},
+				"User-Agent":      {"Mozilla/5.0"},
+				"Accept-Encoding": {"gzip, deflate, br, zstd"},
+				"Sec-Fetch-Mode":  {"navigate"},
+			})
+			var conn *quic.Conn
+			select {
+			case conn = <-s.conns:
+			case <-time.After(time.Second):
+				t.Fatal("timeout")
+			}
+
+			// the unidirectional streams, in order
+			strs := s.uniStreams(t, conn, len(profile.UniStreams))
+			for i, str := range strs {
+				typ, err := quicvarint.Read(str)
+				require.NoError(t, err)
+				require.Equal(t, uint64(profile.UniStreams[i]), typ)
+			}
+
+			// the SETTINGS frame is the first frame on the control stream
+			control := strs[slices.Index(profile.UniStreams, StreamTypeControl)]
+			typ, payload, err := readRawFrame(control)
+			require.NoError(t, err)
+			require.Equal(t, uint64(0x4), typ)
+			r := bytes.NewReader(payload)
+			for _, setting := range profile.Settings {
+				id, err := quicvarint.Read(r)
+				require.NoError(t, err)
+				val, err := quicvarint.Read(r)
+				require.NoError(t, err)
+				if setting.ID == SettingsGREASE {
+					require.True(t, isGREASE(id), "expected a GREASE setting, got %#x", id)
+					continue
+				}
+				require.Equal(t, setting, Setting{ID: id, Value: val})
+			}
+			require.Zero(t, r.Len(), "unexpected settings")
+			if profile.GREASEFrame {
+				typ, payload, err := readRawFrame(control)
+				require.NoError(t, err)
+				require.True(t, isGREASE(typ), "expected a GREASE frame, got %#x", typ)
+				require.Less(t, len(payload), 16)
+			}
+
+			// the field section of the request
+			fields := s.nextRequest(t)
+			var pseudoHeaders, headers []string
+			values := make(map[string]string)
+			for _, hf := range fields {
+				values[hf.Name] = hf.Value
+				if strings.HasPrefix(hf.Name, ":") {
+					require.Empty(t, headers, "pseudo-header %s after a regular header", hf.Name)
+					pseudoHeaders = append(pseudoHeaders, hf.Name)
+				} else if slices.Contains(profile.HeaderOrder, hf.Name) {
+					headers = append(headers, hf.Name)
+				}
+			}
+			require.Equal(t, profile.PseudoHeaderOrder, pseudoHeaders)
+			require.Subset(t, headers, []string{"user-agent", "accept", "accept-language", "accept-encoding", "sec-fetch-mode", "sec-fetch-dest", "priority"})
+			require.True(t, slices.IsSortedFunc(headers, func(a, b string) int {
+				return slices.Index(profile.HeaderOrder, a) - slices.Index(profile.HeaderOrder, b)
+			}), "headers not in the profile's order: %v", headers)
+			require.Equal(t, profile.NavigationPriority, values["priority"])
+
+			// requests for subresources don't get the priority of navigations
+			get(http.Header{"Accept": {"image/avif"}, "Sec-Fetch-Mode": {"no-cors"}})
+			fields = s.nextRequest(t)
+			for _, hf := range fields {
+				require.NotEqual(t, "priority", hf.Name)
+			}
+
+			// a priority set by the application is kept
+			get(http.Header{"Sec-Fetch-Mode": {"navigate"}, "Priority": {"u=1"}})
+			require.Contains(t, s.nextRequest(t), qpack.HeaderField{Name: "priority", Value: "u=1"})
+		})
+	}
+}
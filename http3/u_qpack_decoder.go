@@ -0,0 +1,600 @@
+package http3
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/Noooste/uquic-go"
+	"github.com/quic-go/qpack"
+)
+
+// [UQUIC]
+// fieldSectionDecoder decodes QPACK encoded field sections.
+// Decoding may block until the referenced dynamic table entries are received,
+// or ctx is canceled.
+type fieldSectionDecoder interface {
+	DecodeFieldSection(ctx context.Context, streamID quic.StreamID, p []byte) qpack.DecodeFunc
+}
+
+// staticTableDecoder decodes field sections which only reference the static table.
+type staticTableDecoder struct{ *qpack.Decoder }
+
+func (d staticTableDecoder) DecodeFieldSection(_ context.Context, _ quic.StreamID, p []byte) qpack.DecodeFunc {
+	return d.Decode(p)
+}
+
+// qpackStaticTable is the QPACK static table (RFC 9204, Appendix A), obtained from
+// the static table decoder.
+var qpackStaticTable = func() []qpack.HeaderField {
+	var table []qpack.HeaderField
+	for i := uint64(0); ; i++ {
+		b := appendQPACKInt([]byte{0, 0}, 6, 0xc0, i)
+		hf, err := qpack.NewDecoder().Decode(b)()
+		if err != nil {
+			return table
+		}
+		table = append(table, hf)
+	}
+}()
+
+var (
+	errQPACKBlockedStreams = errors.New("too many blocked streams")
+	errQPACKInvalidIndex   = errors.New("invalid dynamic table index")
+)
+
+// dynamicTableDecoder is a QPACK decoder which allows the peer to use the dynamic table.
+// It processes the instructions received on the peer's encoder stream and sends
+// acknowledgments on the decoder stream.
+// Instructions are queued while holding the mutex, and written afterwards, since
+// writing to the decoder stream may block on flow control.
+type dynamicTableDecoder struct {
+	ctx context.Context // the connection's context
+
+	maxCapacity uint64
+	maxBlocked  uint64
+
+	writeMutex sync.Mutex // serializes writes to the decoder stream, must be acquired before the mutex
+
+	mutex    sync.Mutex
+	inserted chan struct{} // closed and replaced whenever entries are inserted
+	err      error         // set if the encoder stream failed
+
+	capacity uint64
+	size     uint64
+	entries  []qpack.HeaderField // the oldest entry first
+	evicted  uint64              // the absolute index of entries[0]
+
+	blocked            uint64
+	knownReceivedCount uint64
+	decoderStr         io.Writer
+	pending            []byte // instructions not yet written to the decoder stream
+}
+
+func newDynamicTableDecoder(ctx context.Context, maxCapacity, maxBlocked uint64) *dynamicTableDecoder {
+	return &dynamicTableDecoder{
+		ctx:         ctx,
+		maxCapacity: maxCapacity,
+		maxBlocked:  maxBlocked,
+		inserted:    make(chan struct{}),
+	}
+}
+
+// SetDecoderStream sets the QPACK decoder stream that instructions are sent on.
+// The stream type must already have been written.
+func (d *dynamicTableDecoder) SetDecoderStream(str io.Writer) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.decoderStr = str
+}
+
+func (d *dynamicTableDecoder) insertCount() uint64 { return d.evicted + uint64(len(d.entries)) }
+
+// HandleEncoderStream reads the instructions sent on the peer's encoder stream,
+// until the stream or the connection is closed.
+// The stream type must already have been consumed.
+func (d *dynamicTableDecoder) HandleEncoderStream(str io.Reader) error {
+	r := bufio.NewReader(str)
+	for {
+		err := d.readEncoderInstruction(r)
+		if err == nil && r.Buffered() > 0 {
+			continue
+		}
+		// all buffered instructions were processed
+		if err == nil {
+			d.mutex.Lock()
+			d.queueInsertCountIncrement()
+			d.mutex.Unlock()
+			err = d.flushDecoderStream()
+		}
+		if err != nil {
+			d.mutex.Lock()
+			d.err = err
+			close(d.inserted)
+			d.inserted = make(chan struct{})
+			d.mutex.Unlock()
+			return err
+		}
+	}
+}
+
+func (d *dynamicTableDecoder) readEncoderInstruction(r *bufio.Reader) error {
+	b, err := r.Peek(1)
+	if err != nil {
+		return err
+	}
+	switch {
+	case b[0]&0x80 > 0: // Insert with Name Reference: 1Txxxxxx
+		isStatic := b[0]&0x40 > 0
+		idx, err := readQPACKInt(r, 6)
+		if err != nil {
+			return err
+		}
+		value, err := readQPACKString(r, 7, d.maxCapacity)
+		if err != nil {
+			return err
+		}
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		var name string
+		if isStatic {
+			if idx >= uint64(len(qpackStaticTable)) {
+				return errQPACKInvalidIndex
+			}
+			name = qpackStaticTable[idx].Name
+		} else {
+			hf, err := d.relativeEntry(idx)
+			if err != nil {
+				return err
+			}
+			name = hf.Name
+		}
+		return d.insert(qpack.HeaderField{Name: name, Value: value})
+	case b[0]&0xc0 == 0x40: // Insert with Literal Name: 01Hxxxxx
+		name, err := readQPACKString(r, 5, d.maxCapacity)
+		if err != nil {
+			return err
+		}
+		value, err := readQPACKString(r, 7, d.maxCapacity)
+		if err != nil {
+			return err
+		}
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		return d.insert(qpack.HeaderField{Name: name, Value: value})
+	case b[0]&0xe0 == 0x20: // Set Dynamic Table Capacity: 001xxxxx
+		capacity, err := readQPACKInt(r, 5)
+		if err != nil {
+			return err
+		}
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		if capacity > d.maxCapacity {
+			return fmt.Errorf("dynamic table capacity %d exceeds the maximum of %d", capacity, d.maxCapacity)
+		}
+		d.capacity = capacity
+		return d.evict(0)
+	default: // Duplicate: 000xxxxx
+		idx, err := readQPACKInt(r, 5)
+		if err != nil {
+			return err
+		}
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		hf, err := d.relativeEntry(idx)
+		if err != nil {
+			return err
+		}
+		return d.insert(hf)
+	}
+}
+
+// relativeEntry returns an entry by the relative index used on the encoder stream.
+func (d *dynamicTableDecoder) relativeEntry(idx uint64) (qpack.HeaderField, error) {
+	if idx >= d.insertCount() {
+		return qpack.HeaderField{}, errQPACKInvalidIndex
+	}
+	return d.absoluteEntry(d.insertCount() - 1 - idx)
+}
+
+func (d *dynamicTableDecoder) absoluteEntry(idx uint64) (qpack.HeaderField, error) {
+	if idx < d.evicted || idx >= d.insertCount() {
+		return qpack.HeaderField{}, errQPACKInvalidIndex
+	}
+	return d.entries[idx-d.evicted], nil
+}
+
+func entrySize(hf qpack.HeaderField) uint64 { return uint64(len(hf.Name) + len(hf.Value) + 32) }
+
+func (d *dynamicTableDecoder) insert(hf qpack.HeaderField) error {
+	if err := d.evict(entrySize(hf)); err != nil {
+		return err
+	}
+	d.entries = append(d.entries, hf)
+	d.size += entrySize(hf)
+	close(d.inserted)
+	d.inserted = make(chan struct{})
+	return nil
+}
+
+// evict evicts entries until an entry of size n fits into the dynamic table.
+func (d *dynamicTableDecoder) evict(n uint64) error {
+	if n > d.capacity {
+		return fmt.Errorf("entry of size %d exceeds the dynamic table capacity", n)
+	}
+	for d.size+n > d.capacity {
+		d.size -= entrySize(d.entries[0])
+		d.entries = d.entries[1:]
+		d.evicted++
+	}
+	return nil
+}
+
+// The queue* functions queue an instruction on the decoder stream, see section 4.4 of RFC 9204.
+// They must be called with the mutex held.
+
+func (d *dynamicTableDecoder) queueInsertCountIncrement() {
+	if d.decoderStr == nil || d.insertCount() == d.knownReceivedCount {
+		return
+	}
+	d.pending = appendQPACKInt(d.pending, 6, 0x00, d.insertCount()-d.knownReceivedCount)
+	d.knownReceivedCount = d.insertCount()
+}
+
+func (d *dynamicTableDecoder) queueSectionAcknowledgment(streamID quic.StreamID, requiredInsertCount uint64) {
+	if d.decoderStr == nil {
+		return
+	}
+	d.knownReceivedCount = max(d.knownReceivedCount, requiredInsertCount)
+	d.pending = appendQPACKInt(d.pending, 7, 0x80, uint64(streamID))
+}
+
+func (d *dynamicTableDecoder) queueStreamCancellation(streamID quic.StreamID) {
+	if d.decoderStr == nil {
+		return
+	}
+	d.pending = appendQPACKInt(d.pending, 6, 0x40, uint64(streamID))
+}
+
+// flushDecoderStream writes the queued instructions to the decoder stream.
+// It must be called without holding the mutex. The writeMutex makes sure that
+// instructions are written in the order they were queued.
+func (d *dynamicTableDecoder) flushDecoderStream() error {
+	d.mutex.Lock()
+	empty := len(d.pending) == 0
+	d.mutex.Unlock()
+	if empty { // instructions queued concurrently are flushed by whoever queued them
+		return nil
+	}
+	d.writeMutex.Lock()
+	defer d.writeMutex.Unlock()
+	d.mutex.Lock()
+	b := d.pending
+	d.pending = nil
+	str := d.decoderStr
+	d.mutex.Unlock()
+	if len(b) == 0 {
+		return nil
+	}
+	_, err := str.Write(b)
+	return err
+}
+
+// DecodeFieldSection decodes a field section. If it references entries which weren't
+// received yet, it blocks until they are, ctx is canceled, or the connection is closed.
+func (d *dynamicTableDecoder) DecodeFieldSection(ctx context.Context, streamID quic.StreamID, p []byte) qpack.DecodeFunc {
+	fields, err := d.decodeFieldSection(ctx, streamID, p)
+	if flushErr := d.flushDecoderStream(); err == nil {
+		err = flushErr
+	}
+	return func() (qpack.HeaderField, error) {
+		if err != nil {
+			return qpack.HeaderField{}, err
+		}
+		if len(fields) == 0 {
+			return qpack.HeaderField{}, io.EOF
+		}
+		hf := fields[0]
+		fields = fields[1:]
+		return hf, nil
+	}
+}
+
+func (d *dynamicTableDecoder) decodeFieldSection(ctx context.Context, streamID quic.StreamID, p []byte) ([]qpack.HeaderField, error) {
+	encInsertCount, p, err := decodeQPACKInt(p, 8)
+	if err != nil {
+		return nil, err
+	}
+	if len(p) == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	negative := p[0]&0x80 > 0
+	deltaBase, p, err := decodeQPACKInt(p, 7)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	requiredInsertCount, err := d.requiredInsertCount(encInsertCount)
+	if err != nil {
+		return nil, err
+	}
+	var base uint64
+	if negative {
+		if deltaBase >= requiredInsertCount {
+			return nil, errors.New("invalid base")
+		}
+		base = requiredInsertCount - deltaBase - 1
+	} else {
+		base = requiredInsertCount + deltaBase
+	}
+	if err := d.waitForInserts(ctx, streamID, requiredInsertCount); err != nil {
+		return nil, err
+	}
+
+	var fields []qpack.HeaderField
+	for len(p) > 0 {
+		var hf qpack.HeaderField
+		hf, p, err = d.decodeFieldLine(p, base)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, hf)
+	}
+	if requiredInsertCount > 0 {
+		d.queueSectionAcknowledgment(streamID, requiredInsertCount)
+	}
+	return fields, nil
+}
+
+// requiredInsertCount decodes the Required Insert Count, see section 4.5.1.1 of RFC 9204.
+func (d *dynamicTableDecoder) requiredInsertCount(encInsertCount uint64) (uint64, error) {
+	if encInsertCount == 0 {
+		return 0, nil
+	}
+	maxEntries := d.maxCapacity / 32
+	fullRange := 2 * maxEntries
+	if encInsertCount > fullRange {
+		return 0, errors.New("invalid Required Insert Count")
+	}
+	maxValue := d.insertCount() + maxEntries
+	maxWrapped := (maxValue / fullRange) * fullRange
+	requiredInsertCount := maxWrapped + encInsertCount - 1
+	if requiredInsertCount > maxValue {
+		if requiredInsertCount <= fullRange {
+			return 0, errors.New("invalid Required Insert Count")
+		}
+		requiredInsertCount -= fullRange
+	}
+	if requiredInsertCount == 0 {
+		return 0, errors.New("invalid Required Insert Count")
+	}
+	return requiredInsertCount, nil
+}
+
+// waitForInserts blocks until the dynamic table contains the required number of entries.
+// If ctx is canceled first, the field section is abandoned, and a Stream Cancellation
+// instruction is queued, see section 4.4.2 of RFC 9204.
+// It must be called with the mutex held.
+func (d *dynamicTableDecoder) waitForInserts(ctx context.Context, streamID quic.StreamID, requiredInsertCount uint64) error {
+	if d.insertCount() >= requiredInsertCount {
+		return nil
+	}
+	if d.blocked >= d.maxBlocked {
+		return errQPACKBlockedStreams
+	}
+	d.blocked++
+	defer func() { d.blocked-- }()
+	for d.insertCount() < requiredInsertCount {
+		if d.err != nil {
+			return d.err
+		}
+		inserted := d.inserted
+		d.mutex.Unlock()
+		select {
+		case <-inserted:
+		case <-ctx.Done():
+			d.mutex.Lock()
+			d.queueStreamCancellation(streamID)
+			return context.Cause(ctx)
+		case <-d.ctx.Done():
+			d.mutex.Lock()
+			return context.Cause(d.ctx)
+		}
+		d.mutex.Lock()
+	}
+	return nil
+}
+
+func (d *dynamicTableDecoder) decodeFieldLine(p []byte, base uint64) (qpack.HeaderField, []byte, error) {
+	b := p[0]
+	switch {
+	case b&0x80 > 0: // Indexed Field Line: 1Txxxxxx
+		idx, rest, err := decodeQPACKInt(p, 6)
+		if err != nil {
+			return qpack.HeaderField{}, nil, err
+		}
+		if b&0x40 > 0 {
+			hf, err := staticEntry(idx)
+			return hf, rest, err
+		}
+		if idx >= base {
+			return qpack.HeaderField{}, nil, errQPACKInvalidIndex
+		}
+		hf, err := d.absoluteEntry(base - 1 - idx)
+		return hf, rest, err
+	case b&0xc0 == 0x40: // Literal Field Line with Name Reference: 01NTxxxx
+		idx, rest, err := decodeQPACKInt(p, 4)
+		if err != nil {
+			return qpack.HeaderField{}, nil, err
+		}
+		var hf qpack.HeaderField
+		if b&0x10 > 0 {
+			hf, err = staticEntry(idx)
+		} else if idx >= base {
+			err = errQPACKInvalidIndex
+		} else {
+			hf, err = d.absoluteEntry(base - 1 - idx)
+		}
+		if err != nil {
+			return qpack.HeaderField{}, nil, err
+		}
+		value, rest, err := decodeQPACKString(rest, 7)
+		return qpack.HeaderField{Name: hf.Name, Value: value}, rest, err
+	case b&0xe0 == 0x20: // Literal Field Line with Literal Name: 001NHxxx
+		name, rest, err := decodeQPACKString(p, 3)
+		if err != nil {
+			return qpack.HeaderField{}, nil, err
+		}
+		value, rest, err := decodeQPACKString(rest, 7)
+		return qpack.HeaderField{Name: name, Value: value}, rest, err
+	case b&0xf0 == 0x10: // Indexed Field Line with Post-Base Index: 0001xxxx
+		idx, rest, err := decodeQPACKInt(p, 4)
+		if err != nil {
+			return qpack.HeaderField{}, nil, err
+		}
+		hf, err := d.absoluteEntry(base + idx)
+		return hf, rest, err
+	default: // Literal Field Line with Post-Base Name Reference: 0000Nxxx
+		idx, rest, err := decodeQPACKInt(p, 3)
+		if err != nil {
+			return qpack.HeaderField{}, nil, err
+		}
+		hf, err := d.absoluteEntry(base + idx)
+		if err != nil {
+			return qpack.HeaderField{}, nil, err
+		}
+		value, rest, err := decodeQPACKString(rest, 7)
+		return qpack.HeaderField{Name: hf.Name, Value: value}, rest, err
+	}
+}
+
+func staticEntry(idx uint64) (qpack.HeaderField, error) {
+	if idx >= uint64(len(qpackStaticTable)) {
+		return qpack.HeaderField{}, fmt.Errorf("invalid static table index %d", idx)
+	}
+	return qpackStaticTable[idx], nil
+}
+
+// appendQPACKInt appends an integer using an n-bit prefix, see section 5.1 of RFC 7541.
+// The bits of the first byte that aren't part of the prefix are set to flags.
+func appendQPACKInt(b []byte, n uint8, flags byte, i uint64) []byte {
+	k := uint64(1)<<n - 1
+	if i < k {
+		return append(b, flags|byte(i))
+	}
+	b = append(b, flags|byte(k))
+	i -= k
+	for ; i >= 0x80; i >>= 7 {
+		b = append(b, byte(0x80|i&0x7f))
+	}
+	return append(b, byte(i))
+}
+
+// decodeQPACKInt decodes an integer using an n-bit prefix.
+func decodeQPACKInt(p []byte, n uint8) (uint64, []byte, error) {
+	r := &byteSliceReader{b: p}
+	i, err := readQPACKInt(r, n)
+	return i, r.b, err
+}
+
+// decodeQPACKString decodes a string literal, using an n-bit prefix for the length.
+// The Huffman flag is the bit before the prefix.
+func decodeQPACKString(p []byte, n uint8) (string, []byte, error) {
+	r := &byteSliceReader{b: p}
+	s, err := readQPACKString(r, n, uint64(len(p)))
+	return s, r.b, err
+}
+
+func readQPACKInt(r io.ByteReader, n uint8) (uint64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, io.ErrUnexpectedEOF
+	}
+	k := uint64(1)<<n - 1
+	i := uint64(b) & k
+	if i < k {
+		return i, nil
+	}
+	for m := 0; ; m += 7 {
+		if m > 56 {
+			return 0, errors.New("integer overflow")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		i += uint64(b&0x7f) << m
+		if b&0x80 == 0 {
+			return i, nil
+		}
+	}
+}
+
+type qpackStringReader interface {
+	io.ByteReader
+	io.Reader
+}
+
+// The length is limited to maxLen bytes.
+func readQPACKString(r qpackStringReader, n uint8, maxLen uint64) (string, error) {
+	var huffman bool
+	l, err := readQPACKInt(&huffmanFlagReader{r: r, n: n, huffman: &huffman}, n)
+	if err != nil {
+		return "", err
+	}
+	if l > maxLen {
+		return "", errors.New("string literal too long")
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", io.ErrUnexpectedEOF
+	}
+	if !huffman {
+		return string(b), nil
+	}
+	return hpack.HuffmanDecodeToString(b)
+}
+
+// huffmanFlagReader records the Huffman flag, which precedes the length prefix.
+type huffmanFlagReader struct {
+	r       io.ByteReader
+	n       uint8
+	huffman *bool
+	read    bool
+}
+
+func (r *huffmanFlagReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil && !r.read {
+		*r.huffman = b&(1<<r.n) > 0
+		r.read = true
+	}
+	return b, err
+}
+
+type byteSliceReader struct{ b []byte }
+
+func (r *byteSliceReader) ReadByte() (byte, error) {
+	if len(r.b) == 0 {
+		return 0, io.EOF
+	}
+	b := r.b[0]
+	r.b = r.b[1:]
+	return b, nil
+}
+
+func (r *byteSliceReader) Read(p []byte) (int, error) {
+	if len(r.b) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.b)
+	r.b = r.b[n:]
+	return n, nil
+}
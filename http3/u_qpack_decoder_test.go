@@ -0,0 +1,204 @@
+package http3
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/quic-go/qpack"
+
+	"github.com/stretchr/testify/require"
+)
+
+func decodeAllFields(t *testing.T, decodeFn qpack.DecodeFunc) []qpack.HeaderField {
+	t.Helper()
+	var fields []qpack.HeaderField
+	for {
+		hf, err := decodeFn()
+		if err == io.EOF {
+			return fields
+		}
+		require.NoError(t, err)
+		fields = append(fields, hf)
+	}
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+// uses the examples from Appendix B of RFC 9204
+func TestDynamicTableDecoder(t *testing.T) {
+	require.Len(t, qpackStaticTable, 99)
+
+	d := newDynamicTableDecoder(context.Background(), 220, 1)
+	encoderStr, w := io.Pipe()
+	defer w.Close()
+	go d.HandleEncoderStream(encoderStr)
+
+	// the field section is blocked until the entries are inserted
+	decoded := make(chan []qpack.HeaderField, 1)
+	go func() {
+		decoded <- decodeAllFields(t, d.DecodeFieldSection(context.Background(), 4, mustDecodeHex(t, "03811011")))
+	}()
+	select {
+	case <-decoded:
+		t.Fatal("field section should be blocked")
+	case <-time.After(20 * time.Millisecond):
+	}
+	_, err := w.Write(mustDecodeHex(t, "3fbd01c00f7777772e6578616d706c652e636f6dc10c2f73616d706c652f70617468"))
+	require.NoError(t, err)
+	select {
+	case fields := <-decoded:
+		require.Equal(t, []qpack.HeaderField{
+			{Name: ":authority", Value: "www.example.com"},
+			{Name: ":path", Value: "/sample/path"},
+		}, fields)
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+
+	// insert with literal name, and duplicate
+	_, err = w.Write(mustDecodeHex(t, "4a637573746f6d2d6b65790c637573746f6d2d76616c756502"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		return d.insertCount() == 4
+	}, time.Second, time.Millisecond)
+	require.Equal(t, []qpack.HeaderField{
+		{Name: ":authority", Value: "www.example.com"},
+		{Name: ":path", Value: "/"},
+		{Name: "custom-key", Value: "custom-value"},
+	}, decodeAllFields(t, d.DecodeFieldSection(context.Background(), 8, mustDecodeHex(t, "050080c181"))))
+
+	// the oldest entry is evicted
+	_, err = w.Write(mustDecodeHex(t, "810d637573746f6d2d76616c756532"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		return d.insertCount() == 5
+	}, time.Second, time.Millisecond)
+	d.mutex.Lock()
+	require.EqualValues(t, 1, d.evicted)
+	require.EqualValues(t, 215, d.size)
+	d.mutex.Unlock()
+	decodeFn := d.DecodeFieldSection(context.Background(), 12, mustDecodeHex(t, "050083"))
+	_, err = decodeFn()
+	require.ErrorIs(t, err, errQPACKInvalidIndex)
+}
+
+func TestDynamicTableDecoderBlockedStreams(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	d := newDynamicTableDecoder(ctx, 220, 1)
+
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := d.DecodeFieldSection(context.Background(), 0, mustDecodeHex(t, "0200"))()
+		errChan <- err
+	}()
+	require.Eventually(t, func() bool {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		return d.blocked == 1
+	}, time.Second, time.Millisecond)
+	_, err := d.DecodeFieldSection(context.Background(), 4, mustDecodeHex(t, "0200"))()
+	require.ErrorIs(t, err, errQPACKBlockedStreams)
+
+	// closing the connection unblocks the stream
+	cancel(io.ErrClosedPipe)
+	select {
+	case err := <-errChan:
+		require.ErrorIs(t, err, io.ErrClosedPipe)
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestDynamicTableDecoderCapacity(t *testing.T) {
+	d := newDynamicTableDecoder(context.Background(), 100, 1)
+	encoderStr, w := io.Pipe()
+	errChan := make(chan error, 1)
+	go func() { errChan <- d.HandleEncoderStream(encoderStr) }()
+	_, err := w.Write(mustDecodeHex(t, "3fbd01")) // Set Dynamic Table Capacity=220
+	require.NoError(t, err)
+	select {
+	case err := <-errChan:
+		require.ErrorContains(t, err, "exceeds the maximum")
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestDynamicTableDecoderStreamCancellation(t *testing.T) {
+	d := newDynamicTableDecoder(context.Background(), 220, 1)
+	var decoderStr bytes.Buffer
+	d.SetDecoderStream(&decoderStr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := d.DecodeFieldSection(ctx, 4, mustDecodeHex(t, "0200"))()
+		errChan <- err
+	}()
+	require.Eventually(t, func() bool {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		return d.blocked == 1
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-errChan:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	// Stream Cancellation for stream 4: 01xxxxxx
+	require.Equal(t, []byte{0x40 | 4}, decoderStr.Bytes())
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	require.Zero(t, d.blocked)
+}
+
+func TestDynamicTableDecoderWritesWithoutLock(t *testing.T) {
+	d := newDynamicTableDecoder(context.Background(), 220, 1)
+	decoderStrR, decoderStrW := io.Pipe()
+	d.SetDecoderStream(decoderStrW)
+	encoderStr, w := io.Pipe()
+	defer w.Close()
+	go d.HandleEncoderStream(encoderStr)
+
+	// the Insert Count Increment blocks until the decoder stream is read
+	_, err := w.Write(mustDecodeHex(t, "3fbd01c00f7777772e6578616d706c652e636f6d"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		return len(d.pending) == 0 && d.knownReceivedCount == 1
+	}, time.Second, time.Millisecond)
+
+	// meanwhile, field sections can be decoded
+	decoded := make(chan []qpack.HeaderField, 1)
+	go func() {
+		decoded <- decodeAllFields(t, d.DecodeFieldSection(context.Background(), 4, mustDecodeHex(t, "0000d1")))
+	}()
+	select {
+	case fields := <-decoded:
+		require.Equal(t, []qpack.HeaderField{{Name: ":method", Value: "GET"}}, fields)
+	case <-time.After(time.Second):
+		t.Fatal("decoding blocked while writing to the decoder stream")
+	}
+
+	b := make([]byte, 10)
+	n, err := decoderStrR.Read(b)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x01}, b[:n]) // Insert Count Increment: 00xxxxxx
+}
@@ -0,0 +1,24 @@
+package http3
+
+import (
+	"github.com/Noooste/uquic-go"
+)
+
+// [UQUIC]
+// initUTransport sets up the UTransport used for dialing with the QUICSpec or the QUICID.
+// It shares the quic.Transport, and therefore the UDP socket.
+func (t *Transport) initUTransport() error {
+	spec := t.QUICSpec
+	if spec == nil {
+		if t.QUICID == nil {
+			return nil
+		}
+		s, err := quic.QUICID2Spec(*t.QUICID)
+		if err != nil {
+			return err
+		}
+		spec = &s
+	}
+	t.uTransport = &quic.UTransport{Transport: t.transport, QUICSpec: spec}
+	return nil
+}
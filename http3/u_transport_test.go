@@ -0,0 +1,179 @@
+package http3
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	http "github.com/Noooste/fhttp"
+	"github.com/Noooste/uquic-go"
+	"github.com/Noooste/uquic-go/internal/testdata"
+	"github.com/Noooste/utls"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportQUICID(t *testing.T) {
+	id := quic.QUICChrome_115
+	tr := &Transport{QUICID: &id}
+	require.NoError(t, tr.init())
+	defer tr.Close()
+
+	spec, err := quic.QUICID2Spec(quic.QUICChrome_115)
+	require.NoError(t, err)
+	// a single UTransport dials addresses of both families
+	require.NotNil(t, tr.uTransport)
+	require.Equal(t, spec.UDPDatagramMinSize, tr.uTransport.QUICSpec.UDPDatagramMinSize)
+	require.Equal(t, spec.IPv6, tr.uTransport.QUICSpec.IPv6)
+
+	id = quic.QUICID{Client: "unknown"}
+	require.Error(t, (&Transport{QUICID: &id}).init())
+}
+
+func TestTransportQUICSpec(t *testing.T) {
+	spec, err := quic.QUICID2Spec(quic.QUICFirefox_116)
+	require.NoError(t, err)
+	id := quic.QUICChrome_115
+	// the QUICSpec takes precedence over the QUICID
+	tr := &Transport{QUICSpec: &spec, QUICID: &id}
+	require.NoError(t, tr.init())
+	defer tr.Close()
+
+	require.Same(t, &spec, tr.uTransport.QUICSpec)
+
+	tr = &Transport{}
+	require.NoError(t, tr.init())
+	defer tr.Close()
+	require.Nil(t, tr.uTransport)
+}
+
+// fingerprintListener records the connections accepted by the server.
+type fingerprintListener struct {
+	*quic.EarlyListener
+	accepted chan *quic.Conn
+}
+
+func (ln *fingerprintListener) Accept(ctx context.Context) (*quic.Conn, error) {
+	conn, err := ln.EarlyListener.Accept(ctx)
+	if err == nil {
+		ln.accepted <- conn
+	}
+	return conn, err
+}
+
+// newFingerprintTestServer starts an HTTP/3 server fingerprinting its clients.
+func newFingerprintTestServer(t *testing.T) *fingerprintListener {
+	ln, err := quic.ListenAddrEarly(
+		"127.0.0.1:0",
+		ConfigureTLSConfig(testdata.GetTLSConfig()),
+		&quic.Config{EnableClientFingerprint: true},
+	)
+	require.NoError(t, err)
+	fln := &fingerprintListener{EarlyListener: ln, accepted: make(chan *quic.Conn, 1)}
+	s := &Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("foobar"))
+	})}
+	go s.ServeListener(fln)
+	t.Cleanup(func() {
+		s.Close()
+		ln.Close()
+	})
+	return fln
+}
+
+// acceptedFingerprint returns the fingerprint of the client of the next connection accepted by the server.
+func (ln *fingerprintListener) acceptedFingerprint(t *testing.T) *quic.ClientFingerprint {
+	t.Helper()
+	select {
+	case conn := <-ln.accepted:
+		fp := conn.ConnectionState().ClientFingerprint
+		require.NotNil(t, fp)
+		require.True(t, fp.ClientHelloComplete)
+		return fp
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	return nil
+}
+
+func TestTransportFingerprintOnTheWire(t *testing.T) {
+	ln := newFingerprintTestServer(t)
+	tlsConf := &tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA()}
+
+	// get sends a request with the Transport, and returns the fingerprint seen by the server
+	get := func(t *testing.T, tr *Transport) *quic.ClientFingerprint {
+		t.Helper()
+		tr.TLSClientConfig = tlsConf
+		defer tr.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://%s/", ln.Addr()), nil)
+		require.NoError(t, err)
+		rsp, err := tr.RoundTrip(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(rsp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "foobar", string(body))
+		return ln.acceptedFingerprint(t)
+	}
+	// expected returns the fingerprint of a connection dialed by a quic.UTransport with the QUICSpec
+	expected := func(t *testing.T, spec *quic.QUICSpec) *quic.ClientFingerprint {
+		t.Helper()
+		udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.NoError(t, err)
+		tr := &quic.UTransport{Transport: &quic.Transport{Conn: udpConn}, QUICSpec: spec}
+		defer tr.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := tr.DialEarly(ctx, ln.Addr(), ConfigureTLSConfig(tlsConf.Clone()), nil)
+		require.NoError(t, err)
+		defer conn.CloseWithError(0, "")
+		return ln.acceptedFingerprint(t)
+	}
+
+	chromeSpec, err := quic.QUICID2Spec(quic.QUICChrome_115)
+	require.NoError(t, err)
+	chrome := expected(t, &chromeSpec)
+	require.Zero(t, chrome.SrcConnIDLen)
+	require.Equal(t, 8, chrome.DestConnIDLen)
+	require.Equal(t, 1250, chrome.DatagramSize) // Chrome 115 pads the Initial packets to 1250 bytes over IPv4
+	require.NotEmpty(t, chrome.JA4)
+	require.NotEmpty(t, chrome.QUICHash)
+
+	t.Run("QUICID", func(t *testing.T) {
+		id := quic.QUICChrome_115
+		fp := get(t, &Transport{QUICID: &id})
+		require.Zero(t, fp.SrcConnIDLen)
+		require.Equal(t, chrome.DestConnIDLen, fp.DestConnIDLen)
+		require.Equal(t, chrome.DatagramSize, fp.DatagramSize)
+		require.Equal(t, chrome.InitialPackets, fp.InitialPackets)
+		require.Equal(t, chrome.JA4, fp.JA4)
+		// Chrome shuffles the transport parameters, only the first part of the QUICHash is stable
+		require.Equal(t, strings.Split(chrome.QUICHash, "_")[0], strings.Split(fp.QUICHash, "_")[0])
+		require.Len(t, fp.TransportParameters, len(chrome.TransportParameters))
+	})
+
+	t.Run("QUICSpec", func(t *testing.T) {
+		spec, err := quic.QUICID2Spec(quic.QUICFirefox_116)
+		require.NoError(t, err)
+		firefox := expected(t, &spec)
+		fp := get(t, &Transport{QUICSpec: &spec})
+		require.Equal(t, firefox.SrcConnIDLen, fp.SrcConnIDLen)
+		require.Equal(t, firefox.DestConnIDLen, fp.DestConnIDLen)
+		require.Equal(t, firefox.DatagramSize, fp.DatagramSize)
+		require.Equal(t, firefox.JA4, fp.JA4)
+		require.Equal(t, firefox.QUICHash, fp.QUICHash)
+		require.NotEqual(t, chrome.QUICHash, fp.QUICHash)
+	})
+
+	t.Run("without a QUICSpec", func(t *testing.T) {
+		fp := get(t, &Transport{})
+		require.NotZero(t, fp.SrcConnIDLen)
+		require.NotEqual(t, strings.Split(chrome.QUICHash, "_")[0], strings.Split(fp.QUICHash, "_")[0])
+		require.NotEqual(t, chrome.JA4, fp.JA4)
+	})
+}
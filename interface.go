@@ -176,6 +176,33 @@ type Config struct {
 	// Enable QUIC Stream Resets with Partial Delivery.
 	// See https://datatracker.ietf.org/doc/html/draft-ietf-quic-reliable-stream-reset-07.
 	EnableStreamResetPartialDelivery bool
+	// EnableClientFingerprint makes the server fingerprint the Initial packets carrying
+	// the ClientHello of the clients, see ClientFingerprint. The fingerprint is
+	// available in the ClientInfo, based on the first Initial packet, and in the
+	// ConnectionState, completed as the following Initial packets are received.
+	// Only valid for the server.
+	EnableClientFingerprint bool // [UQUIC]
+	// ServerSpec specifies how the server builds its first flight, see ServerSpec.
+	// It can be chosen for each client by GetConfigForClient.
+	// Only valid for the server.
+	ServerSpec *ServerSpec // [UQUIC]
+	// CongestionControl creates the congestion controller of the connections, see
+	// CongestionController. If nil, the Cubic sender of quic-go is used, in its Reno mode.
+	CongestionControl CongestionControllerFactory // [UQUIC]
+	// PreferredAddress is sent to the clients, asking them to migrate to this address
+	// after the handshake, see PreferredAddress.
+	// Only valid for the server.
+	PreferredAddress *PreferredAddress // [UQUIC]
+	// MigrateToPreferredAddress makes the client migrate to the preferred address of the
+	// server after the handshake, if the server sent one. The address is probed from the
+	// Transport the connection was dialed on, and the connection switches to it once it
+	// is validated. Applications can also migrate with Conn.AddPreferredAddressPath.
+	// Only valid for the client.
+	MigrateToPreferredAddress bool // [UQUIC]
+	// MigrationPolicy makes the client migrate the connection to a backup Transport when
+	// the current path fails, see MigrationPolicy.
+	// Only valid for the client.
+	MigrationPolicy *MigrationPolicy // [UQUIC]
 
 	Tracer func(ctx context.Context, isClient bool, connID ConnectionID) qlogwriter.Trace
 
@@ -192,6 +219,10 @@ type ClientInfo struct {
 	// Note that the Retry mechanism costs one network roundtrip,
 	// and is not performed unless Transport.MaxUnvalidatedHandshakes is surpassed.
 	AddrVerified bool
+	// ClientFingerprint is the fingerprint of the client, based on the first Initial
+	// packet. It is only set if Config.EnableClientFingerprint is set. If the ClientHello
+	// spans multiple Initial packets, the fingerprint is incomplete.
+	ClientFingerprint *ClientFingerprint // [UQUIC]
 }
 
 // ConnectionState records basic details about a QUIC connection.
@@ -216,4 +247,8 @@ type ConnectionState struct {
 	Version Version
 	// GSO says if generic segmentation offload is used.
 	GSO bool
+	// ClientFingerprint is the fingerprint of the client, see Config.EnableClientFingerprint.
+	// It is only set on the server, and is completed as the Initial packets carrying the
+	// rest of the ClientHello are received.
+	ClientFingerprint *ClientFingerprint // [UQUIC]
 }
@@ -25,6 +25,8 @@ type packet struct {
 
 	includedInBytesInFlight bool
 	isPathProbePacket       bool
+
+	rate packetRateState // [UQUIC] only used when estimating the delivery rate
 }
 
 func (p *packet) Outstanding() bool {
@@ -48,6 +50,7 @@ func getPacket() *packet {
 	p.IsPathMTUProbePacket = false
 	p.includedInBytesInFlight = false
 	p.isPathProbePacket = false
+	p.rate = packetRateState{} // [UQUIC]
 	return p
 }
 
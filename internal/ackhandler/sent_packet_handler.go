@@ -90,8 +90,12 @@ type sentPacketHandler struct {
 	bytesInFlight protocol.ByteCount
 
 	congestion congestion.SendAlgorithmWithDebugInfos
-	rttStats   *utils.RTTStats
-	connStats  *utils.ConnectionStats
+	// [UQUIC] creates the congestion controller, see SetCongestionControl
+	newCongestion func(initialMaxDatagramSize protocol.ByteCount) congestion.SendAlgorithmWithDebugInfos
+	// [UQUIC] nil unless the congestion controller uses delivery rate samples
+	rateSampler *deliveryRateSampler
+	rttStats    *utils.RTTStats
+	connStats   *utils.ConnectionStats
 
 	// The number of times a PTO has been sent without receiving an ack.
 	ptoCount uint32
@@ -293,6 +297,9 @@ func (h *sentPacketHandler) SentPacket(
 		pnSpace.lastAckElicitingPacketTime = t
 		h.bytesInFlight += size
 		p.includedInBytesInFlight = true
+		if h.rateSampler != nil { // [UQUIC]
+			h.rateSampler.OnPacketSent(p, h.bytesInFlight)
+		}
 		if h.numProbesToSend > 0 {
 			h.numProbesToSend--
 		}
@@ -439,6 +446,9 @@ func (h *sentPacketHandler) ReceivedAck(ack *wire.AckFrame, encLevel protocol.En
 	for _, p := range ackedPackets {
 		if p.includedInBytesInFlight {
 			h.congestion.OnPacketAcked(p.PacketNumber, p.Length, priorInFlight, rcvTime)
+			if h.rateSampler != nil { // [UQUIC]
+				h.rateSampler.OnPacketAcked(p.packet, rcvTime)
+			}
 		}
 		if p.EncryptionLevel == protocol.Encryption1RTT {
 			acked1RTTPacket = true
@@ -448,6 +458,9 @@ func (h *sentPacketHandler) ReceivedAck(ack *wire.AckFrame, encLevel protocol.En
 			putPacket(p.packet)
 		}
 	}
+	if h.rateSampler != nil { // [UQUIC]
+		h.onRateSample(rcvTime)
+	}
 
 	// detect spurious losses for application data packets, if the ACK was not reordered
 	if encLevel == protocol.Encryption1RTT && largestAcked == pnSpace.largestAcked {
@@ -855,6 +868,9 @@ func (h *sentPacketHandler) detectLostPackets(now monotime.Time, encLevel protoc
 				h.queueFramesForRetransmission(p)
 				if !p.IsPathMTUProbePacket {
 					h.congestion.OnCongestionEvent(pn, p.Length, priorInFlight)
+					if h.rateSampler != nil { // [UQUIC]
+						h.rateSampler.OnPacketLost(p)
+					}
 				}
 				if encLevel == protocol.Encryption1RTT && h.ecnTracker != nil {
 					h.ecnTracker.LostPacket(pn)
@@ -1131,13 +1147,25 @@ func (h *sentPacketHandler) MigratedPath(now monotime.Time, initialMaxDatagramSi
 	for pn := range h.appDataPackets.history.PathProbes() {
 		h.appDataPackets.history.RemovePathProbe(pn)
 	}
-	h.congestion = congestion.NewCubicSender(
-		congestion.DefaultClock{},
-		h.rttStats,
-		h.connStats,
-		initialMaxDatagramSize,
-		true, // use Reno
-		h.qlogger,
-	)
+	// [UQUIC] The PTO backoff of the old path doesn't apply to the new path.
+	// All packets were declared lost, so there's nothing left to probe.
+	if h.qlogger != nil && h.ptoCount != 0 {
+		h.qlogger.RecordEvent(qlog.PTOCountUpdated{PTOCount: 0})
+	}
+	h.ptoCount = 0
+	h.numProbesToSend = 0
+	h.ptoMode = SendNone
+	if h.newCongestion != nil { // [UQUIC]
+		h.setCongestion(h.newCongestion(initialMaxDatagramSize))
+	} else {
+		h.congestion = congestion.NewCubicSender(
+			congestion.DefaultClock{},
+			h.rttStats,
+			h.connStats,
+			initialMaxDatagramSize,
+			true, // use Reno
+			h.qlogger,
+		)
+	}
 	h.setLossDetectionTimer(now)
 }
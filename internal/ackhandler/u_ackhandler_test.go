@@ -0,0 +1,180 @@
+package ackhandler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/congestion"
+	"github.com/Noooste/uquic-go/internal/monotime"
+	"github.com/Noooste/uquic-go/internal/protocol"
+	"github.com/Noooste/uquic-go/internal/utils"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetAckPolicy(t *testing.T) {
+	handler := NewReceivedPacketHandler(utils.DefaultLogger)
+	handler.SetAckPolicy(5, 10*time.Millisecond)
+
+	now := monotime.Now()
+	for pn := protocol.PacketNumber(1); pn <= 4; pn++ {
+		require.NoError(t, handler.ReceivedPacket(pn, protocol.ECNNon, protocol.Encryption1RTT, now, true))
+		require.Nil(t, handler.GetAckFrame(protocol.Encryption1RTT, now, true))
+	}
+	require.Equal(t, now.Add(10*time.Millisecond), handler.GetAlarmTimeout())
+	require.NoError(t, handler.ReceivedPacket(5, protocol.ECNNon, protocol.Encryption1RTT, now, true))
+	require.NotNil(t, handler.GetAckFrame(protocol.Encryption1RTT, now, true))
+
+	// zero values keep the defaults
+	handler = NewReceivedPacketHandler(utils.DefaultLogger)
+	handler.SetAckPolicy(0, 0)
+	require.NoError(t, handler.ReceivedPacket(1, protocol.ECNNon, protocol.Encryption1RTT, now, true))
+	require.Equal(t, now.Add(protocol.MaxAckDelay), handler.GetAlarmTimeout())
+	require.NoError(t, handler.ReceivedPacket(2, protocol.ECNNon, protocol.Encryption1RTT, now, true))
+	require.NotNil(t, handler.GetAckFrame(protocol.Encryption1RTT, now, true))
+}
+
+func TestDisablePacketNumberSkipping(t *testing.T) {
+	newHandler := func() SentPacketHandler {
+		return NewUSentPacketHandler(
+			0,
+			1200,
+			utils.NewRTTStats(),
+			&utils.ConnectionStats{},
+			false,
+			false,
+			func(protocol.PacketNumber) {},
+			protocol.PerspectiveClient,
+			nil,
+			utils.DefaultLogger,
+		)
+	}
+	// returns true if a packet number was skipped
+	skipped := func(sph SentPacketHandler) bool {
+		last := sph.PopPacketNumber(protocol.Encryption1RTT)
+		for range 1000 {
+			pn := sph.PopPacketNumber(protocol.Encryption1RTT)
+			if pn != last+1 {
+				return true
+			}
+			last = pn
+		}
+		return false
+	}
+
+	require.True(t, skipped(newHandler()))
+
+	sph := newHandler()
+	DisablePacketNumberSkipping(sph)
+	require.False(t, skipped(sph))
+
+	// the packet number spaces are recreated when a Retry is received
+	sph = newHandler()
+	DisablePacketNumberSkipping(sph)
+	sph.ResetForRetry(monotime.Now())
+	require.False(t, skipped(sph))
+}
+
+func TestSetCongestionControl(t *testing.T) {
+	for _, pers := range []protocol.Perspective{protocol.PerspectiveClient, protocol.PerspectiveServer} {
+		t.Run(pers.String(), func(t *testing.T) {
+			newHandler := NewSentPacketHandler
+			if pers == protocol.PerspectiveClient {
+				newHandler = NewUSentPacketHandler
+			}
+			sph := newHandler(0, 1200, utils.NewRTTStats(), &utils.ConnectionStats{}, true, false, func(protocol.PacketNumber) {}, pers, nil, utils.DefaultLogger)
+
+			var created []protocol.ByteCount
+			SetCongestionControl(sph, 1200, func(initialMaxDatagramSize protocol.ByteCount) congestion.SendAlgorithmWithDebugInfos {
+				created = append(created, initialMaxDatagramSize)
+				return congestion.NewCubicSender(congestion.DefaultClock{}, utils.NewRTTStats(), &utils.ConnectionStats{}, initialMaxDatagramSize, false, nil)
+			})
+			require.Equal(t, []protocol.ByteCount{1200}, created)
+
+			// a new congestion controller is created for the new path
+			sph.MigratedPath(monotime.Now(), 1300)
+			require.Equal(t, []protocol.ByteCount{1200, 1300}, created)
+		})
+	}
+}
+
+func TestDeliveryRateSampler(t *testing.T) {
+	var s deliveryRateSampler
+	start := monotime.Now()
+	var bytesInFlight protocol.ByteCount
+	sendPacket := func(sendTime monotime.Time) *packet {
+		p := &packet{SendTime: sendTime, Length: 1000}
+		bytesInFlight += p.Length
+		s.OnPacketSent(p, bytesInFlight)
+		return p
+	}
+	ackPackets := func(now monotime.Time, packets ...*packet) {
+		for _, p := range packets {
+			bytesInFlight -= p.Length
+			s.OnPacketAcked(p, now)
+		}
+	}
+
+	var packets []*packet
+	for i := range 10 {
+		packets = append(packets, sendPacket(start.Add(time.Duration(i)*time.Millisecond)))
+	}
+	_, ok := s.GenerateSample(50*time.Millisecond, bytesInFlight)
+	require.False(t, ok)
+
+	ackPackets(start.Add(54*time.Millisecond), packets[:5]...)
+	s.OnPacketLost(packets[5])
+	bytesInFlight -= packets[5].Length
+	rs, ok := s.GenerateSample(50*time.Millisecond, bytesInFlight)
+	require.True(t, ok)
+	require.Equal(t, 54*time.Millisecond, rs.Interval)
+	require.Equal(t, protocol.ByteCount(5000), rs.Delivered)
+	require.Equal(t, congestion.BandwidthFromDelta(5000, 54*time.Millisecond), rs.DeliveryRate)
+	require.Equal(t, protocol.ByteCount(5000), rs.NewlyAcked)
+	require.Equal(t, protocol.ByteCount(1000), rs.NewlyLost)
+	require.Equal(t, protocol.ByteCount(5000), rs.TxInFlight)
+	require.Equal(t, 50*time.Millisecond, rs.RTT)
+	require.False(t, rs.IsAppLimited)
+
+	// an interval shorter than the minimum RTT doesn't produce a delivery rate
+	ackPackets(start.Add(59*time.Millisecond), packets[6:]...)
+	rs, ok = s.GenerateSample(time.Minute, bytesInFlight)
+	require.True(t, ok)
+	require.Zero(t, rs.DeliveryRate)
+	require.Equal(t, protocol.ByteCount(9000), rs.TotalDelivered)
+	require.Equal(t, protocol.ByteCount(1000), rs.Lost)
+	require.Zero(t, rs.NewlyLost)
+	require.Zero(t, bytesInFlight)
+
+	// the application runs out of data, a new sampling interval starts
+	s.OnAppLimited(bytesInFlight)
+	p := sendPacket(start.Add(100 * time.Millisecond))
+	ackPackets(start.Add(150*time.Millisecond), p)
+	rs, ok = s.GenerateSample(50*time.Millisecond, bytesInFlight)
+	require.True(t, ok)
+	require.True(t, rs.IsAppLimited)
+	require.Equal(t, 50*time.Millisecond, rs.Interval)
+	require.Equal(t, protocol.ByteCount(9000), rs.PriorDelivered)
+	require.Equal(t, protocol.ByteCount(1000), rs.Delivered)
+
+	// the app-limited phase ends once the packets sent during the phase are acknowledged
+	p = sendPacket(start.Add(200 * time.Millisecond))
+	ackPackets(start.Add(250*time.Millisecond), p)
+	rs, ok = s.GenerateSample(50*time.Millisecond, bytesInFlight)
+	require.True(t, ok)
+	require.False(t, rs.IsAppLimited)
+}
+
+func TestMigratedPathResetsPTOCount(t *testing.T) {
+	sph := NewUSentPacketHandler(0, 1200, utils.NewRTTStats(), &utils.ConnectionStats{}, true, false, func(protocol.PacketNumber) {}, protocol.PerspectiveClient, nil, utils.DefaultLogger)
+	require.Zero(t, PTOCount(sph))
+	h := unwrapSentPacketHandler(sph)
+	h.ptoCount = 3
+	h.numProbesToSend = 2
+	h.ptoMode = SendPTOAppData
+	require.Equal(t, uint32(3), PTOCount(sph))
+
+	sph.MigratedPath(monotime.Now(), 1200)
+	require.Zero(t, PTOCount(sph))
+	require.Equal(t, SendAny, sph.SendMode(monotime.Now()))
+}
@@ -0,0 +1,122 @@
+package ackhandler
+
+import (
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/congestion"
+	"github.com/Noooste/uquic-go/internal/monotime"
+	"github.com/Noooste/uquic-go/internal/protocol"
+)
+
+// [UQUIC]
+// packetRateState is the state of the delivery rate estimation when a packet was sent.
+type packetRateState struct {
+	delivered     protocol.ByteCount
+	deliveredTime monotime.Time
+	firstSentTime monotime.Time
+	isAppLimited  bool
+	txInFlight    protocol.ByteCount
+	lost          protocol.ByteCount
+}
+
+// [UQUIC]
+// deliveryRateSampler generates the delivery rate samples of a path, following
+// draft-cheng-iccrg-delivery-rate-estimation. It is only used if the congestion
+// controller is a congestion.RateSampleConsumer.
+type deliveryRateSampler struct {
+	delivered     protocol.ByteCount
+	deliveredTime monotime.Time
+	firstSentTime monotime.Time
+	// the delivered count marking the end of an app-limited phase, or 0
+	appLimited protocol.ByteCount
+	lost       protocol.ByteCount
+
+	// the newest packet acknowledged since the last sample
+	hasSample   bool
+	newest      packetRateState
+	sendElapsed time.Duration
+	ackElapsed  time.Duration
+	rtt         time.Duration
+
+	newlyAcked protocol.ByteCount
+	newlyLost  protocol.ByteCount
+}
+
+// OnPacketSent records the state of the delivery rate estimation in the packet.
+// bytesInFlight includes the packet.
+func (s *deliveryRateSampler) OnPacketSent(p *packet, bytesInFlight protocol.ByteCount) {
+	if bytesInFlight == p.Length {
+		// nothing was in flight, start a new sampling interval
+		s.firstSentTime = p.SendTime
+		s.deliveredTime = p.SendTime
+	}
+	p.rate = packetRateState{
+		delivered:     s.delivered,
+		deliveredTime: s.deliveredTime,
+		firstSentTime: s.firstSentTime,
+		isAppLimited:  s.appLimited != 0,
+		txInFlight:    bytesInFlight,
+		lost:          s.lost,
+	}
+}
+
+// OnPacketAcked is called for every packet in flight that is acknowledged.
+func (s *deliveryRateSampler) OnPacketAcked(p *packet, now monotime.Time) {
+	s.delivered += p.Length
+	s.deliveredTime = now
+	s.newlyAcked += p.Length
+	// use the most recently sent packet to sample the delivery rate
+	if !s.hasSample || p.rate.delivered >= s.newest.delivered {
+		s.hasSample = true
+		s.newest = p.rate
+		s.sendElapsed = p.SendTime.Sub(p.rate.firstSentTime)
+		s.ackElapsed = now.Sub(p.rate.deliveredTime)
+		s.rtt = now.Sub(p.SendTime)
+		s.firstSentTime = p.SendTime
+	}
+}
+
+// OnPacketLost is called for every packet in flight that is declared lost.
+func (s *deliveryRateSampler) OnPacketLost(p *packet) {
+	s.lost += p.Length
+	s.newlyLost += p.Length
+}
+
+// OnAppLimited is called when the application doesn't have any data to send, while the
+// congestion window would allow sending more.
+func (s *deliveryRateSampler) OnAppLimited(bytesInFlight protocol.ByteCount) {
+	s.appLimited = max(s.delivered+bytesInFlight, 1)
+}
+
+// GenerateSample generates the sample for an ACK frame, after all packets were passed to
+// OnPacketAcked and OnPacketLost. It returns false if no packet was acknowledged.
+func (s *deliveryRateSampler) GenerateSample(minRTT time.Duration, bytesInFlight protocol.ByteCount) (congestion.RateSample, bool) {
+	if s.appLimited != 0 && s.delivered > s.appLimited {
+		s.appLimited = 0
+	}
+	if !s.hasSample {
+		return congestion.RateSample{}, false
+	}
+	rs := congestion.RateSample{
+		IsAppLimited:   s.newest.isAppLimited,
+		Interval:       max(s.sendElapsed, s.ackElapsed),
+		Delivered:      s.delivered - s.newest.delivered,
+		PriorDelivered: s.newest.delivered,
+		TotalDelivered: s.delivered,
+		TxInFlight:     s.newest.txInFlight,
+		Lost:           s.lost - s.newest.lost,
+		RTT:            s.rtt,
+		NewlyAcked:     s.newlyAcked,
+		NewlyLost:      s.newlyLost,
+		BytesInFlight:  bytesInFlight,
+	}
+	// An interval shorter than the minimum RTT would overestimate the delivery rate,
+	// most likely due to ACK compression.
+	if rs.Interval > 0 && rs.Interval >= minRTT {
+		rs.DeliveryRate = congestion.BandwidthFromDelta(rs.Delivered, rs.Interval)
+	}
+	s.hasSample = false
+	s.newlyAcked = 0
+	s.newlyLost = 0
+	return rs, true
+}
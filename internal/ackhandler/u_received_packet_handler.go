@@ -0,0 +1,16 @@
+package ackhandler
+
+import "time"
+
+// [UQUIC]
+// SetAckPolicy sets when ACKs for Application Data packets are sent: once packetsBeforeAck
+// ack-eliciting packets were received, or once maxAckDelay has passed since the first
+// unacknowledged one. Zero values keep the defaults.
+func (h *ReceivedPacketHandler) SetAckPolicy(packetsBeforeAck int, maxAckDelay time.Duration) {
+	if packetsBeforeAck > 0 {
+		h.appDataPackets.packetsBeforeAck = packetsBeforeAck
+	}
+	if maxAckDelay > 0 {
+		h.appDataPackets.maxAckDelay = maxAckDelay
+	}
+}
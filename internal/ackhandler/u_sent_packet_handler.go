@@ -1,11 +1,16 @@
 package ackhandler
 
-import "github.com/Noooste/uquic-go/internal/protocol"
+import (
+	"github.com/Noooste/uquic-go/internal/congestion"
+	"github.com/Noooste/uquic-go/internal/monotime"
+	"github.com/Noooste/uquic-go/internal/protocol"
+)
 
 type uSentPacketHandler struct {
 	*sentPacketHandler
 
-	initialPacketNumberLength protocol.PacketNumberLen // [UQUIC]
+	initialPacketNumberLength   protocol.PacketNumberLen // [UQUIC]
+	disablePacketNumberSkipping bool                     // [UQUIC]
 }
 
 func (h *uSentPacketHandler) PeekPacketNumber(encLevel protocol.EncryptionLevel) (protocol.PacketNumber, protocol.PacketNumberLen) {
@@ -29,6 +34,25 @@ func SetInitialPacketNumberLength(h SentPacketHandler, pnLen protocol.PacketNumb
 	}
 }
 
+// [UQUIC]
+// DisablePacketNumberSkipping makes the SentPacketHandler use consecutive packet numbers
+// for Application Data packets. By default, packet numbers are skipped randomly to detect
+// optimistic ACK attacks. Packet numbers are still skipped when sending PTO probes.
+func DisablePacketNumberSkipping(h SentPacketHandler) {
+	if sph, ok := h.(*uSentPacketHandler); ok {
+		sph.disablePacketNumberSkipping = true
+		sph.appDataPackets.pns = newSequentialPacketNumberGenerator(sph.appDataPackets.pns.Peek())
+	}
+}
+
+func (h *uSentPacketHandler) ResetForRetry(now monotime.Time) {
+	h.sentPacketHandler.ResetForRetry(now)
+	// [UQUIC] the Application Data packet number space was recreated
+	if h.disablePacketNumberSkipping {
+		h.appDataPackets.pns = newSequentialPacketNumberGenerator(h.appDataPackets.pns.Peek())
+	}
+}
+
 // func (h *uSentPacketHandler) OnLossDetectionTimeout() error {
 // 	defer h.setLossDetectionTimer()
 // 	earliestLossTime, encLevel := h.getLossTimeAndSpace()
@@ -97,3 +121,73 @@ func SetInitialPacketNumberLength(h SentPacketHandler, pnLen protocol.PacketNumb
 // 	}
 // 	return nil
 // }
+
+// [UQUIC]
+// SetCongestionControl replaces the congestion controller of the SentPacketHandler with
+// one created by newCongestion, which is also used to create a new congestion
+// controller when the connection migrates to a new path. It must be called before any
+// packet is sent.
+func SetCongestionControl(
+	h SentPacketHandler,
+	initialMaxDatagramSize protocol.ByteCount,
+	newCongestion func(initialMaxDatagramSize protocol.ByteCount) congestion.SendAlgorithmWithDebugInfos,
+) {
+	sph := unwrapSentPacketHandler(h)
+	if sph == nil {
+		return
+	}
+	sph.newCongestion = newCongestion
+	sph.setCongestion(newCongestion(initialMaxDatagramSize))
+}
+
+// [UQUIC]
+// SetAppLimited tells the SentPacketHandler that the application has no data to send,
+// although the congestion controller allows sending more. The delivery rate samples of
+// the packets sent from now on are marked as app-limited, until they're acknowledged.
+func SetAppLimited(h SentPacketHandler) {
+	if sph := unwrapSentPacketHandler(h); sph != nil && sph.rateSampler != nil {
+		sph.rateSampler.OnAppLimited(sph.bytesInFlight)
+	}
+}
+
+// [UQUIC]
+// PTOCount returns the number of consecutive probe timeouts, i.e. the number of PTOs
+// that fired since an ACK frame acknowledged new packets.
+func PTOCount(h SentPacketHandler) uint32 {
+	if sph := unwrapSentPacketHandler(h); sph != nil {
+		return sph.ptoCount
+	}
+	return 0
+}
+
+func unwrapSentPacketHandler(h SentPacketHandler) *sentPacketHandler {
+	switch h := h.(type) {
+	case *sentPacketHandler:
+		return h
+	case *uSentPacketHandler:
+		return h.sentPacketHandler
+	default:
+		return nil
+	}
+}
+
+// [UQUIC]
+// setCongestion sets the congestion controller, and estimates the delivery rate if the
+// congestion controller uses rate samples.
+func (h *sentPacketHandler) setCongestion(c congestion.SendAlgorithmWithDebugInfos) {
+	h.congestion = c
+	h.rateSampler = nil
+	if _, ok := c.(congestion.RateSampleConsumer); ok {
+		h.rateSampler = &deliveryRateSampler{}
+	}
+}
+
+// [UQUIC]
+// onRateSample passes the delivery rate sample of an ACK frame to the congestion controller.
+func (h *sentPacketHandler) onRateSample(now monotime.Time) {
+	rs, ok := h.rateSampler.GenerateSample(h.rttStats.MinRTT(), h.bytesInFlight)
+	if !ok {
+		return
+	}
+	h.congestion.(congestion.RateSampleConsumer).OnRateSample(&rs, now)
+}
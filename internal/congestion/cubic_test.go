@@ -7,8 +7,7 @@ import (
 
 	"github.com/Noooste/uquic-go/internal/protocol"
 
-	. "github.com/onsi/ginkgo/v2"
-	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/require"
 )
 
 const (
@@ -0,0 +1,899 @@
+package congestion
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/monotime"
+	"github.com/Noooste/uquic-go/internal/protocol"
+)
+
+// [UQUIC]
+// This file implements BBRv3, following draft-ietf-ccwg-bbr. BBR builds a model of the
+// path from the delivery rate and RTT samples, instead of reacting to every packet loss,
+// making it suitable for lossy paths with a large bandwidth-delay product.
+
+type bbrState uint8
+
+const (
+	bbrStateStartup bbrState = iota
+	bbrStateDrain
+	bbrStateProbeBWDown
+	bbrStateProbeBWCruise
+	bbrStateProbeBWRefill
+	bbrStateProbeBWUp
+	bbrStateProbeRTT
+)
+
+func (s bbrState) String() string {
+	switch s {
+	case bbrStateStartup:
+		return "Startup"
+	case bbrStateDrain:
+		return "Drain"
+	case bbrStateProbeBWDown:
+		return "ProbeBW_DOWN"
+	case bbrStateProbeBWCruise:
+		return "ProbeBW_CRUISE"
+	case bbrStateProbeBWRefill:
+		return "ProbeBW_REFILL"
+	case bbrStateProbeBWUp:
+		return "ProbeBW_UP"
+	case bbrStateProbeRTT:
+		return "ProbeRTT"
+	default:
+		return "unknown BBR state"
+	}
+}
+
+type bbrAckPhase uint8
+
+const (
+	bbrAcksInit bbrAckPhase = iota
+	bbrAcksRefilling
+	bbrAcksProbeStarting
+	bbrAcksProbeFeedback
+	bbrAcksProbeStopping
+)
+
+const (
+	bbrStartupPacingGain = 2.77 // 4 * ln(2)
+	bbrStartupCwndGain   = 2.0
+	bbrDrainPacingGain   = 0.35
+	bbrDefaultCwndGain   = 2.0
+	bbrProbeUpCwndGain   = 2.25
+	bbrProbeDownGain     = 0.9
+	bbrProbeUpGain       = 1.25
+	bbrProbeRTTCwndGain  = 0.5
+
+	// bbrPacingMarginPercent paces at slightly less than the estimated bandwidth, to
+	// reduce the queue at the bottleneck.
+	bbrPacingMarginPercent = 1
+	// bbrLossThresh is the maximum fraction of lost bytes tolerated in a round trip.
+	// Random losses below this threshold don't reduce the sending rate.
+	bbrLossThresh = 0.02
+	// bbrBeta is the multiplicative decrease applied to the bounds of the model
+	// when losses exceed bbrLossThresh.
+	bbrBeta = 0.7
+	// bbrHeadroom is the fraction of inflight_hi left free for other flows when cruising.
+	bbrHeadroom = 0.15
+	// bbrMinPipeCwndPackets is the minimum congestion window, in packets.
+	bbrMinPipeCwndPackets = 4
+
+	// bbrMaxBwFilterLen keeps the bandwidth samples of the current and the previous
+	// ProbeBW cycle.
+	bbrMaxBwFilterLen = 1
+	// bbrExtraAckedFilterLen is the window of the ACK aggregation filter, in rounds.
+	bbrExtraAckedFilterLen = 10
+	// bbrStartupFullBwRounds is the number of rounds without significant bandwidth
+	// growth after which Startup ends.
+	bbrStartupFullBwRounds = 3
+	// bbrStartupFullLossCount is the number of loss events in a round that end Startup,
+	// if the losses exceed bbrLossThresh.
+	bbrStartupFullLossCount = 6
+
+	bbrMinRTTFilterLen   = 10 * time.Second
+	bbrProbeRTTInterval  = 5 * time.Second
+	bbrProbeRTTDuration  = 200 * time.Millisecond
+	bbrMaxProbeUpRounds  = 30
+	bbrMaxRenoProbeRound = 63
+)
+
+// bbrRTTStats provides the smoothed RTT, used to pace the first flight of packets.
+type bbrRTTStats interface {
+	SmoothedRTT() time.Duration
+}
+
+type bbrSender struct {
+	clock    Clock
+	rttStats bbrRTTStats
+	pacer    *pacer
+
+	maxDatagramSize protocol.ByteCount
+
+	state       bbrState
+	pacingGain  float64
+	cwndGain    float64
+	pacingRate  Bandwidth
+	cwnd        protocol.ByteCount
+	priorCwnd   protocol.ByteCount
+	sendQuantum protocol.ByteCount
+
+	// the sample being processed
+	rs *RateSample
+	// the number of bytes delivered, as of the last sample
+	delivered protocol.ByteCount
+	// set if the congestion window limited the sending during the last round
+	cwndLimited bool
+
+	// round counting
+	nextRoundDelivered protocol.ByteCount
+	roundStart         bool
+	roundCount         uint64
+
+	// the model of the path
+	maxBwFilter   windowedMaxFilter[Bandwidth]
+	maxBw         Bandwidth
+	bwLo          Bandwidth
+	bw            Bandwidth
+	cycleCount    uint64
+	minRTT        time.Duration
+	minRTTStamp   monotime.Time
+	inflightHi    protocol.ByteCount
+	inflightLo    protocol.ByteCount
+	bdp           protocol.ByteCount
+	extraAcked    protocol.ByteCount
+	maxInflight   protocol.ByteCount
+	offloadBudget protocol.ByteCount
+
+	extraAckedFilter        windowedMaxFilter[protocol.ByteCount]
+	extraAckedIntervalStart monotime.Time
+	extraAckedDelivered     protocol.ByteCount
+
+	// congestion signals
+	lossRoundDelivered  protocol.ByteCount
+	lossRoundStart      bool
+	lossInRound         bool
+	lossEventsInRound   int
+	lossEventsLastRound int
+	bwLatest            Bandwidth
+	inflightLatest      protocol.ByteCount
+
+	// Startup
+	fullBw        Bandwidth
+	fullBwCount   int
+	fullBwNow     bool
+	fullBwReached bool
+
+	// ProbeBW
+	ackPhase           bbrAckPhase
+	cycleStamp         monotime.Time
+	bwProbeWait        time.Duration
+	roundsSinceBwProbe uint64
+	bwProbeSamples     bool
+	bwProbeUpRounds    int
+	bwProbeUpAcks      protocol.ByteCount
+	probeUpCount       protocol.ByteCount
+
+	// ProbeRTT
+	probeRTTMinDelay  time.Duration
+	probeRTTMinStamp  monotime.Time
+	probeRTTExpired   bool
+	probeRTTDoneStamp monotime.Time
+	probeRTTRoundDone bool
+	idleRestart       bool
+	// samples of packets sent before this delivered count are app-limited, see
+	// handleProbeRTT
+	appLimitedUntil protocol.ByteCount
+}
+
+var (
+	_ SendAlgorithm               = &bbrSender{}
+	_ SendAlgorithmWithDebugInfos = &bbrSender{}
+	_ RateSampleConsumer          = &bbrSender{}
+)
+
+// NewBBRSender makes a new BBR sender. It relies on the delivery rate samples passed to
+// OnRateSample.
+func NewBBRSender(clock Clock, rttStats bbrRTTStats, initialMaxDatagramSize protocol.ByteCount) *bbrSender {
+	now := clock.Now()
+	b := &bbrSender{
+		clock:                   clock,
+		rttStats:                rttStats,
+		maxDatagramSize:         initialMaxDatagramSize,
+		cwnd:                    initialCongestionWindow * initialMaxDatagramSize,
+		maxBwFilter:             newWindowedMaxFilter[Bandwidth](bbrMaxBwFilterLen),
+		extraAckedFilter:        newWindowedMaxFilter[protocol.ByteCount](bbrExtraAckedFilterLen),
+		extraAckedIntervalStart: now,
+		minRTT:                  math.MaxInt64,
+		minRTTStamp:             now,
+		probeRTTMinDelay:        math.MaxInt64,
+		probeRTTMinStamp:        now,
+	}
+	if srtt := rttStats.SmoothedRTT(); srtt > 0 {
+		b.minRTT = srtt
+		b.probeRTTMinDelay = srtt
+	}
+	b.resetShortTermModel()
+	b.resetCongestionSignals()
+	b.resetFullBw()
+	b.initPacingRate()
+	b.enterStartup()
+	b.pacer = newPacer(func() Bandwidth {
+		// the pacer paces 25% faster than the bandwidth it is given
+		return b.pacingRate * 4 / 5
+	})
+	b.pacer.SetMaxDatagramSize(initialMaxDatagramSize)
+	return b
+}
+
+func (b *bbrSender) TimeUntilSend(protocol.ByteCount) monotime.Time {
+	return b.pacer.TimeUntilSend()
+}
+
+func (b *bbrSender) HasPacingBudget(now monotime.Time) bool {
+	return b.pacer.Budget(now) >= b.maxDatagramSize
+}
+
+func (b *bbrSender) OnPacketSent(sentTime monotime.Time, bytesInFlight protocol.ByteCount, _ protocol.PacketNumber, bytes protocol.ByteCount, isRetransmittable bool) {
+	b.pacer.SentPacket(sentTime, bytes)
+	if !isRetransmittable {
+		return
+	}
+	// restarting after an idle period
+	if bytesInFlight == bytes {
+		b.idleRestart = true
+		b.extraAckedIntervalStart = sentTime
+		if b.isInAProbeBWState() {
+			b.setPacingRateWithGain(1)
+		} else if b.state == bbrStateProbeRTT {
+			b.checkProbeRTTDone(sentTime)
+		}
+	}
+	if bytesInFlight >= b.cwnd {
+		b.cwndLimited = true
+	}
+}
+
+func (b *bbrSender) CanSend(bytesInFlight protocol.ByteCount) bool {
+	return bytesInFlight < b.cwnd
+}
+
+// MaybeExitSlowStart is not used by BBR, which leaves Startup based on the rate samples.
+func (b *bbrSender) MaybeExitSlowStart() {}
+
+// OnPacketAcked is not used by BBR, which updates its model once per ACK frame, in OnRateSample.
+func (b *bbrSender) OnPacketAcked(protocol.PacketNumber, protocol.ByteCount, protocol.ByteCount, monotime.Time) {
+}
+
+// OnCongestionEvent is not used by BBR. The losses are accounted for by the rate samples.
+func (b *bbrSender) OnCongestionEvent(protocol.PacketNumber, protocol.ByteCount, protocol.ByteCount) {
+}
+
+func (b *bbrSender) OnRetransmissionTimeout(packetsRetransmitted bool) {
+	if packetsRetransmitted {
+		b.saveCwnd()
+		b.cwnd = max(b.minPipeCwnd(), b.cwnd/2)
+	}
+}
+
+func (b *bbrSender) SetMaxDatagramSize(s protocol.ByteCount) {
+	if s < b.maxDatagramSize {
+		panic("congestion BUG: decreased max datagram size")
+	}
+	if b.cwnd == initialCongestionWindow*b.maxDatagramSize {
+		b.cwnd = initialCongestionWindow * s
+	}
+	b.maxDatagramSize = s
+	b.pacer.SetMaxDatagramSize(s)
+}
+
+func (b *bbrSender) InSlowStart() bool { return b.state == bbrStateStartup }
+
+func (b *bbrSender) InRecovery() bool { return false }
+
+func (b *bbrSender) GetCongestionWindow() protocol.ByteCount { return b.cwnd }
+
+// OnRateSample updates the model of the path, and the control parameters.
+func (b *bbrSender) OnRateSample(sample *RateSample, now monotime.Time) {
+	rs := *sample
+	if rs.PriorDelivered < b.appLimitedUntil {
+		rs.IsAppLimited = true
+	}
+	b.rs = &rs
+	b.delivered = rs.TotalDelivered
+
+	b.updateModelAndState(now)
+	b.updateControlParameters()
+
+	if b.roundStart {
+		b.cwndLimited = false
+	}
+	b.rs = nil
+}
+
+func (b *bbrSender) updateModelAndState(now monotime.Time) {
+	b.updateLatestDeliverySignals()
+	b.updateCongestionSignals()
+	b.updateACKAggregation(now)
+	b.checkFullBwReached()
+	b.checkStartupDone()
+	b.checkDrainDone(now)
+	b.updateProbeBWCyclePhase(now)
+	b.updateMinRTT(now)
+	b.checkProbeRTT(now)
+	b.advanceLatestDeliverySignals()
+	b.boundBwForModel()
+}
+
+func (b *bbrSender) updateControlParameters() {
+	b.setPacingRate()
+	b.setSendQuantum()
+	b.setCwnd()
+}
+
+func (b *bbrSender) minPipeCwnd() protocol.ByteCount {
+	return bbrMinPipeCwndPackets * b.maxDatagramSize
+}
+
+func (b *bbrSender) isInAProbeBWState() bool {
+	switch b.state {
+	case bbrStateProbeBWDown, bbrStateProbeBWCruise, bbrStateProbeBWRefill, bbrStateProbeBWUp:
+		return true
+	}
+	return false
+}
+
+func (b *bbrSender) isProbingBw() bool {
+	return b.state == bbrStateStartup || b.state == bbrStateProbeBWRefill || b.state == bbrStateProbeBWUp
+}
+
+// Round counting
+
+func (b *bbrSender) startRound() {
+	b.nextRoundDelivered = b.delivered
+}
+
+func (b *bbrSender) updateRound() {
+	if b.rs.PriorDelivered >= b.nextRoundDelivered {
+		b.startRound()
+		b.roundCount++
+		b.roundsSinceBwProbe++
+		b.roundStart = true
+	} else {
+		b.roundStart = false
+	}
+}
+
+// Startup and Drain
+
+func (b *bbrSender) enterStartup() {
+	b.state = bbrStateStartup
+	b.pacingGain = bbrStartupPacingGain
+	b.cwndGain = bbrStartupCwndGain
+}
+
+func (b *bbrSender) resetFullBw() {
+	b.fullBw = 0
+	b.fullBwCount = 0
+	b.fullBwNow = false
+}
+
+func (b *bbrSender) checkFullBwReached() {
+	if b.fullBwNow || !b.roundStart || b.rs.IsAppLimited {
+		return
+	}
+	if b.rs.DeliveryRate >= b.fullBw*5/4 {
+		b.resetFullBw()
+		b.fullBw = b.rs.DeliveryRate
+		return
+	}
+	b.fullBwCount++
+	b.fullBwNow = b.fullBwCount >= bbrStartupFullBwRounds
+	if b.fullBwNow {
+		b.fullBwReached = true
+	}
+}
+
+func (b *bbrSender) checkStartupDone() {
+	b.checkStartupHighLoss()
+	if b.state == bbrStateStartup && b.fullBwReached {
+		b.enterDrain()
+	}
+}
+
+func (b *bbrSender) checkStartupHighLoss() {
+	if b.fullBwReached || !b.lossRoundStart {
+		return
+	}
+	if b.lossEventsLastRound >= bbrStartupFullLossCount && b.isInflightTooHigh() {
+		b.fullBwReached = true
+		b.inflightHi = max(b.bdpMultiple(b.bw, 1), b.inflightLatest)
+	}
+}
+
+func (b *bbrSender) enterDrain() {
+	b.state = bbrStateDrain
+	b.pacingGain = bbrDrainPacingGain
+	b.cwndGain = bbrStartupCwndGain
+}
+
+func (b *bbrSender) checkDrainDone(now monotime.Time) {
+	if b.state == bbrStateDrain && b.rs.BytesInFlight <= b.inflight(b.bw, 1) {
+		b.enterProbeBW(now)
+	}
+}
+
+// ProbeBW
+
+func (b *bbrSender) enterProbeBW(now monotime.Time) {
+	b.cwndGain = bbrDefaultCwndGain
+	b.startProbeBWDown(now)
+}
+
+func (b *bbrSender) startProbeBWDown(now monotime.Time) {
+	b.resetCongestionSignals()
+	b.probeUpCount = protocol.MaxByteCount
+	b.pickProbeWait()
+	b.cycleStamp = now
+	b.ackPhase = bbrAcksProbeStopping
+	b.startRound()
+	b.state = bbrStateProbeBWDown
+	b.pacingGain = bbrProbeDownGain
+	b.cwndGain = bbrDefaultCwndGain
+}
+
+func (b *bbrSender) startProbeBWCruise() {
+	b.state = bbrStateProbeBWCruise
+	b.pacingGain = 1
+	b.cwndGain = bbrDefaultCwndGain
+}
+
+func (b *bbrSender) startProbeBWRefill() {
+	b.resetShortTermModel()
+	b.bwProbeUpRounds = 0
+	b.bwProbeUpAcks = 0
+	b.ackPhase = bbrAcksRefilling
+	b.startRound()
+	b.state = bbrStateProbeBWRefill
+	b.pacingGain = 1
+	b.cwndGain = bbrDefaultCwndGain
+}
+
+func (b *bbrSender) startProbeBWUp(now monotime.Time) {
+	b.ackPhase = bbrAcksProbeStarting
+	b.startRound()
+	b.resetFullBw()
+	b.fullBw = b.rs.DeliveryRate
+	b.cycleStamp = now
+	b.state = bbrStateProbeBWUp
+	b.pacingGain = bbrProbeUpGain
+	b.cwndGain = bbrProbeUpCwndGain
+	b.raiseInflightHiSlope()
+}
+
+func (b *bbrSender) updateProbeBWCyclePhase(now monotime.Time) {
+	if !b.fullBwReached {
+		return
+	}
+	b.adaptUpperBounds(now)
+	if !b.isInAProbeBWState() {
+		return
+	}
+	//nolint:exhaustive // only ProbeBW states
+	switch b.state {
+	case bbrStateProbeBWDown:
+		if b.isTimeToProbeBw(now) {
+			return
+		}
+		if b.isTimeToCruise() {
+			b.startProbeBWCruise()
+		}
+	case bbrStateProbeBWCruise:
+		b.isTimeToProbeBw(now)
+	case bbrStateProbeBWRefill:
+		// After one round of REFILL, start UP.
+		if b.roundStart {
+			b.bwProbeSamples = true
+			b.startProbeBWUp(now)
+		}
+	case bbrStateProbeBWUp:
+		if b.isTimeToGoDown() {
+			b.startProbeBWDown(now)
+		}
+	}
+}
+
+func (b *bbrSender) isTimeToCruise() bool {
+	if b.rs.BytesInFlight > b.inflightWithHeadroom() {
+		return false
+	}
+	return b.rs.BytesInFlight <= b.inflight(b.maxBw, 1)
+}
+
+func (b *bbrSender) isTimeToGoDown() bool {
+	if b.cwndLimited && b.cwnd >= b.inflightHi {
+		b.resetFullBw()
+		b.fullBw = b.rs.DeliveryRate
+	} else if b.fullBwNow {
+		return true
+	}
+	return false
+}
+
+func (b *bbrSender) isTimeToProbeBw(now monotime.Time) bool {
+	if now.Sub(b.cycleStamp) > b.bwProbeWait || b.isRenoCoexistenceProbeTime() {
+		b.startProbeBWRefill()
+		return true
+	}
+	return false
+}
+
+// pickProbeWait randomizes the time until the next bandwidth probe, to desynchronize
+// competing flows.
+func (b *bbrSender) pickProbeWait() {
+	b.roundsSinceBwProbe = uint64(rand.IntN(2))
+	b.bwProbeWait = 2*time.Second + rand.N(time.Second)
+}
+
+// isRenoCoexistenceProbeTime probes at least as often as Reno would grow its window to
+// the current inflight.
+func (b *bbrSender) isRenoCoexistenceProbeTime() bool {
+	renoRounds := uint64(b.targetInflight() / b.maxDatagramSize)
+	return b.roundsSinceBwProbe >= min(renoRounds, bbrMaxRenoProbeRound)
+}
+
+func (b *bbrSender) targetInflight() protocol.ByteCount {
+	return min(b.bdp, b.cwnd)
+}
+
+// raiseInflightHiSlope doubles the growth of inflight_hi every round: it grows by
+// growthThisRound packets for every congestion window of acknowledged bytes.
+func (b *bbrSender) raiseInflightHiSlope() {
+	growthThisRound := protocol.ByteCount(1) << b.bwProbeUpRounds
+	b.bwProbeUpRounds = min(b.bwProbeUpRounds+1, bbrMaxProbeUpRounds)
+	b.probeUpCount = max(b.cwnd/growthThisRound, 1)
+}
+
+func (b *bbrSender) probeInflightHiUpward() {
+	if !b.cwndLimited || b.cwnd < b.inflightHi {
+		return
+	}
+	b.bwProbeUpAcks += b.rs.NewlyAcked
+	if b.bwProbeUpAcks >= b.probeUpCount {
+		delta := b.bwProbeUpAcks / b.probeUpCount
+		b.bwProbeUpAcks -= delta * b.probeUpCount
+		b.inflightHi += delta * b.maxDatagramSize
+	}
+	if b.roundStart {
+		b.raiseInflightHiSlope()
+	}
+}
+
+func (b *bbrSender) adaptUpperBounds(now monotime.Time) {
+	if b.ackPhase == bbrAcksProbeStarting && b.roundStart {
+		// starting to get bandwidth probing samples
+		b.ackPhase = bbrAcksProbeFeedback
+	}
+	if b.ackPhase == bbrAcksProbeStopping && b.roundStart {
+		// End of the samples from the bandwidth probing phase. This is the best time
+		// to forget the bandwidth samples of the previous cycle.
+		b.bwProbeSamples = false
+		b.ackPhase = bbrAcksInit
+		if b.isInAProbeBWState() && !b.rs.IsAppLimited {
+			b.advanceMaxBwFilter()
+		}
+	}
+	if b.checkInflightTooHigh(now) {
+		return
+	}
+	if b.inflightHi == protocol.MaxByteCount {
+		return
+	}
+	if b.rs.TxInFlight > b.inflightHi {
+		b.inflightHi = b.rs.TxInFlight
+	}
+	if b.state == bbrStateProbeBWUp {
+		b.probeInflightHiUpward()
+	}
+}
+
+func (b *bbrSender) checkInflightTooHigh(now monotime.Time) bool {
+	if !b.isInflightTooHigh() {
+		return false
+	}
+	if b.bwProbeSamples {
+		b.handleInflightTooHigh(now)
+	}
+	return true
+}
+
+func (b *bbrSender) isInflightTooHigh() bool {
+	return float64(b.rs.Lost) > float64(b.rs.TxInFlight)*bbrLossThresh
+}
+
+func (b *bbrSender) handleInflightTooHigh(now monotime.Time) {
+	b.bwProbeSamples = false
+	if !b.rs.IsAppLimited {
+		b.inflightHi = max(b.rs.TxInFlight, protocol.ByteCount(float64(b.targetInflight())*bbrBeta))
+	}
+	if b.state == bbrStateProbeBWUp {
+		b.startProbeBWDown(now)
+	}
+}
+
+func (b *bbrSender) inflightWithHeadroom() protocol.ByteCount {
+	if b.inflightHi == protocol.MaxByteCount {
+		return protocol.MaxByteCount
+	}
+	headroom := max(b.maxDatagramSize, protocol.ByteCount(bbrHeadroom*float64(b.inflightHi)))
+	return max(b.inflightHi-headroom, b.minPipeCwnd())
+}
+
+// The bandwidth model
+
+func (b *bbrSender) updateMaxBw() {
+	b.updateRound()
+	if b.rs.DeliveryRate >= b.maxBw || !b.rs.IsAppLimited {
+		b.maxBw = b.maxBwFilter.Update(b.rs.DeliveryRate, b.cycleCount)
+	}
+}
+
+func (b *bbrSender) advanceMaxBwFilter() {
+	b.cycleCount++
+}
+
+func (b *bbrSender) boundBwForModel() {
+	b.bw = min(b.maxBw, b.bwLo)
+}
+
+func (b *bbrSender) updateLatestDeliverySignals() {
+	b.lossRoundStart = false
+	b.bwLatest = max(b.bwLatest, b.rs.DeliveryRate)
+	b.inflightLatest = max(b.inflightLatest, b.rs.Delivered)
+	if b.rs.PriorDelivered >= b.lossRoundDelivered {
+		b.lossRoundDelivered = b.rs.TotalDelivered
+		b.lossRoundStart = true
+	}
+}
+
+func (b *bbrSender) advanceLatestDeliverySignals() {
+	if b.lossRoundStart {
+		b.bwLatest = b.rs.DeliveryRate
+		b.inflightLatest = b.rs.Delivered
+	}
+}
+
+func (b *bbrSender) resetCongestionSignals() {
+	b.lossInRound = false
+	b.lossEventsInRound = 0
+	b.bwLatest = 0
+	b.inflightLatest = 0
+}
+
+func (b *bbrSender) updateCongestionSignals() {
+	b.updateMaxBw()
+	if b.rs.NewlyLost > 0 {
+		b.lossInRound = true
+		b.lossEventsInRound++
+	}
+	if !b.lossRoundStart {
+		return
+	}
+	b.adaptLowerBoundsFromCongestion()
+	b.lossInRound = false
+	b.lossEventsLastRound = b.lossEventsInRound
+	b.lossEventsInRound = 0
+}
+
+func (b *bbrSender) adaptLowerBoundsFromCongestion() {
+	if b.isProbingBw() || !b.lossInRound {
+		return
+	}
+	// The loss rate doesn't matter here: only losses above bbrLossThresh end the
+	// bandwidth probing, but the sending rate is then reduced whenever a loss occurs.
+	if b.bwLo == math.MaxUint64 {
+		b.bwLo = b.maxBw
+	}
+	if b.inflightLo == protocol.MaxByteCount {
+		b.inflightLo = b.cwnd
+	}
+	b.bwLo = max(b.bwLatest, Bandwidth(bbrBeta*float64(b.bwLo)))
+	b.inflightLo = max(b.inflightLatest, protocol.ByteCount(bbrBeta*float64(b.inflightLo)))
+}
+
+func (b *bbrSender) resetShortTermModel() {
+	b.bwLo = math.MaxUint64
+	b.inflightLo = protocol.MaxByteCount
+	if b.inflightHi == 0 {
+		b.inflightHi = protocol.MaxByteCount
+	}
+}
+
+func (b *bbrSender) updateACKAggregation(now monotime.Time) {
+	interval := now.Sub(b.extraAckedIntervalStart)
+	expectedDelivered := protocol.ByteCount(uint64(b.bw/BytesPerSecond) * uint64(interval) / uint64(time.Second))
+	// reset the interval when ACKs arrive at the expected rate
+	if b.extraAckedDelivered <= expectedDelivered {
+		b.extraAckedDelivered = 0
+		b.extraAckedIntervalStart = now
+		expectedDelivered = 0
+	}
+	b.extraAckedDelivered += b.rs.NewlyAcked
+	extra := min(b.extraAckedDelivered-expectedDelivered, b.cwnd)
+	b.extraAcked = b.extraAckedFilter.Update(extra, b.roundCount)
+}
+
+// ProbeRTT
+
+func (b *bbrSender) updateMinRTT(now monotime.Time) {
+	b.probeRTTExpired = now.Sub(b.probeRTTMinStamp) > bbrProbeRTTInterval
+	if b.rs.RTT > 0 && (b.rs.RTT < b.probeRTTMinDelay || b.probeRTTExpired) {
+		b.probeRTTMinDelay = b.rs.RTT
+		b.probeRTTMinStamp = now
+	}
+	minRTTExpired := now.Sub(b.minRTTStamp) > bbrMinRTTFilterLen
+	if b.probeRTTMinDelay < b.minRTT || minRTTExpired {
+		b.minRTT = b.probeRTTMinDelay
+		b.minRTTStamp = b.probeRTTMinStamp
+	}
+}
+
+func (b *bbrSender) checkProbeRTT(now monotime.Time) {
+	if b.state != bbrStateProbeRTT && b.probeRTTExpired && !b.idleRestart {
+		b.enterProbeRTT()
+		b.saveCwnd()
+		b.probeRTTDoneStamp = 0
+		b.ackPhase = bbrAcksProbeStopping
+		b.startRound()
+	}
+	if b.state == bbrStateProbeRTT {
+		b.handleProbeRTT(now)
+	}
+	if b.rs.Delivered > 0 {
+		b.idleRestart = false
+	}
+}
+
+func (b *bbrSender) enterProbeRTT() {
+	b.state = bbrStateProbeRTT
+	b.pacingGain = 1
+	b.cwndGain = bbrProbeRTTCwndGain
+}
+
+func (b *bbrSender) handleProbeRTT(now monotime.Time) {
+	// The delivery rate is limited by the small window during ProbeRTT: ignore these
+	// samples for the bandwidth model.
+	b.appLimitedUntil = max(b.rs.TotalDelivered+b.rs.BytesInFlight, 1)
+	if b.probeRTTDoneStamp == 0 && b.rs.BytesInFlight <= b.probeRTTCwnd() {
+		b.probeRTTDoneStamp = now.Add(bbrProbeRTTDuration)
+		b.probeRTTRoundDone = false
+		b.startRound()
+	} else if b.probeRTTDoneStamp != 0 {
+		if b.roundStart {
+			b.probeRTTRoundDone = true
+		}
+		if b.probeRTTRoundDone {
+			b.checkProbeRTTDone(now)
+		}
+	}
+}
+
+func (b *bbrSender) checkProbeRTTDone(now monotime.Time) {
+	if b.probeRTTDoneStamp != 0 && now.After(b.probeRTTDoneStamp) {
+		// wait a while until the next ProbeRTT
+		b.probeRTTMinStamp = now
+		b.restoreCwnd()
+		b.exitProbeRTT(now)
+	}
+}
+
+func (b *bbrSender) exitProbeRTT(now monotime.Time) {
+	b.resetShortTermModel()
+	if b.fullBwReached {
+		b.startProbeBWDown(now)
+		b.startProbeBWCruise()
+	} else {
+		b.enterStartup()
+	}
+}
+
+func (b *bbrSender) probeRTTCwnd() protocol.ByteCount {
+	return max(b.bdpMultiple(b.bw, bbrProbeRTTCwndGain), b.minPipeCwnd())
+}
+
+// Pacing rate, send quantum and congestion window
+
+func (b *bbrSender) initPacingRate() {
+	rtt := b.rttStats.SmoothedRTT()
+	if rtt == 0 {
+		rtt = time.Millisecond
+	}
+	nominalBandwidth := BandwidthFromDelta(b.cwnd, rtt)
+	b.pacingRate = Bandwidth(bbrStartupPacingGain * float64(nominalBandwidth))
+}
+
+func (b *bbrSender) setPacingRateWithGain(gain float64) {
+	rate := Bandwidth(gain * float64(b.bw) * (100 - bbrPacingMarginPercent) / 100)
+	if b.fullBwReached || rate > b.pacingRate {
+		b.pacingRate = rate
+	}
+}
+
+func (b *bbrSender) setPacingRate() {
+	b.setPacingRateWithGain(b.pacingGain)
+}
+
+// setSendQuantum sets the largest burst, 1ms at the pacing rate.
+func (b *bbrSender) setSendQuantum() {
+	quantum := protocol.ByteCount(b.pacingRate / BytesPerSecond / 1000)
+	b.sendQuantum = min(max(quantum, 2*b.maxDatagramSize), 64*1024)
+}
+
+func (b *bbrSender) bdpMultiple(bw Bandwidth, gain float64) protocol.ByteCount {
+	if b.minRTT == math.MaxInt64 {
+		return initialCongestionWindow * b.maxDatagramSize
+	}
+	b.bdp = protocol.ByteCount(uint64(bw/BytesPerSecond) * uint64(b.minRTT) / uint64(time.Second))
+	return protocol.ByteCount(gain * float64(b.bdp))
+}
+
+func (b *bbrSender) quantizationBudget(inflight protocol.ByteCount) protocol.ByteCount {
+	b.offloadBudget = 3 * b.sendQuantum
+	inflight = max(inflight, b.offloadBudget, b.minPipeCwnd())
+	if b.state == bbrStateProbeBWUp {
+		inflight += 2 * b.maxDatagramSize
+	}
+	return inflight
+}
+
+func (b *bbrSender) inflight(bw Bandwidth, gain float64) protocol.ByteCount {
+	return b.quantizationBudget(b.bdpMultiple(bw, gain))
+}
+
+func (b *bbrSender) updateMaxInflight() {
+	inflight := b.bdpMultiple(b.bw, b.cwndGain) + b.extraAcked
+	b.maxInflight = b.quantizationBudget(inflight)
+}
+
+func (b *bbrSender) setCwnd() {
+	b.updateMaxInflight()
+	if b.fullBwReached {
+		b.cwnd = min(b.cwnd+b.rs.NewlyAcked, b.maxInflight)
+	} else if b.cwnd < b.maxInflight || b.rs.TotalDelivered < initialCongestionWindow*b.maxDatagramSize {
+		b.cwnd += b.rs.NewlyAcked
+	}
+	b.cwnd = max(b.cwnd, b.minPipeCwnd())
+	b.boundCwndForProbeRTT()
+	b.boundCwndForModel()
+}
+
+func (b *bbrSender) boundCwndForProbeRTT() {
+	if b.state == bbrStateProbeRTT {
+		b.cwnd = min(b.cwnd, b.probeRTTCwnd())
+	}
+}
+
+func (b *bbrSender) boundCwndForModel() {
+	limit := protocol.MaxByteCount
+	if b.isInAProbeBWState() && b.state != bbrStateProbeBWCruise {
+		limit = b.inflightHi
+	} else if b.state == bbrStateProbeRTT || b.state == bbrStateProbeBWCruise {
+		limit = b.inflightWithHeadroom()
+	}
+	limit = max(min(limit, b.inflightLo), b.minPipeCwnd())
+	b.cwnd = min(b.cwnd, limit)
+}
+
+func (b *bbrSender) saveCwnd() {
+	if b.state != bbrStateProbeRTT {
+		b.priorCwnd = b.cwnd
+	} else {
+		b.priorCwnd = max(b.priorCwnd, b.cwnd)
+	}
+}
+
+func (b *bbrSender) restoreCwnd() {
+	b.cwnd = max(b.cwnd, b.priorCwnd)
+}
@@ -0,0 +1,175 @@
+package congestion
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/monotime"
+	"github.com/Noooste/uquic-go/internal/protocol"
+	"github.com/Noooste/uquic-go/internal/utils"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWindowedMaxFilter(t *testing.T) {
+	f := newWindowedMaxFilter[Bandwidth](10)
+	require.Equal(t, Bandwidth(100), f.Update(100, 0))
+	require.Equal(t, Bandwidth(100), f.Update(50, 3))
+	require.Equal(t, Bandwidth(100), f.Update(80, 6))
+	require.Equal(t, Bandwidth(120), f.Update(120, 8))
+	require.Equal(t, Bandwidth(120), f.Update(90, 12))
+	// the maximum expires, the best value of the window is used
+	require.Equal(t, Bandwidth(90), f.Update(70, 19))
+	require.Equal(t, Bandwidth(70), f.Update(60, 23))
+	require.Equal(t, Bandwidth(70), f.Get())
+	// nothing left in the window
+	require.Equal(t, Bandwidth(10), f.Update(10, 100))
+}
+
+// bbrTestPath feeds a bbrSender with the rate samples of a path.
+type bbrTestPath struct {
+	b         *bbrSender
+	now       monotime.Time
+	delivered protocol.ByteCount
+	// the delivered count when the first packet of the current round trip was sent
+	roundDelivered protocol.ByteCount
+}
+
+func newBBRTestPath(t *testing.T) *bbrTestPath {
+	t.Helper()
+	var rttStats utils.RTTStats
+	return &bbrTestPath{
+		b:   NewBBRSender(DefaultClock{}, &rttStats, initialMaxDatagramSize),
+		now: monotime.Now(),
+	}
+}
+
+// ackRound acknowledges one round trip of data, delivered at rate in acks ACK frames.
+// lossRate is the fraction of the bytes in flight lost during the round trip.
+func (p *bbrTestPath) ackRound(rate Bandwidth, rtt time.Duration, acks int, lossRate float64) {
+	roundBytes := protocol.ByteCount(uint64(rate/BytesPerSecond) * uint64(rtt) / uint64(time.Second))
+	acked := roundBytes / protocol.ByteCount(acks)
+	// All packets acknowledged during this round trip were sent during the previous
+	// one, after its first packet was acknowledged.
+	prior := p.roundDelivered
+	for i := range acks {
+		p.now = p.now.Add(rtt / time.Duration(acks))
+		txInFlight := min(p.b.cwnd, 2*roundBytes)
+		newlyLost := protocol.ByteCount(lossRate * float64(acked))
+		p.delivered += acked
+		if i == 0 {
+			p.roundDelivered = p.delivered
+		}
+		p.b.OnRateSample(&RateSample{
+			DeliveryRate:   Bandwidth((1 - lossRate) * float64(rate)),
+			Interval:       rtt,
+			Delivered:      roundBytes,
+			PriorDelivered: prior,
+			TotalDelivered: p.delivered,
+			TxInFlight:     txInFlight,
+			Lost:           protocol.ByteCount(lossRate * float64(txInFlight)),
+			RTT:            rtt,
+			NewlyAcked:     acked,
+			NewlyLost:      newlyLost,
+			BytesInFlight:  min(p.b.cwnd, roundBytes),
+		}, p.now)
+	}
+}
+
+const (
+	bbrTestBandwidth = 10_000_000 * BitsPerSecond
+	bbrTestRTT       = 50 * time.Millisecond
+	bbrTestBDP       = protocol.ByteCount(bbrTestBandwidth / BytesPerSecond / 20)
+)
+
+// startBBR runs BBR through Startup on a path with a bandwidth of bbrTestBandwidth.
+func startBBR(t *testing.T) *bbrTestPath {
+	t.Helper()
+	p := newBBRTestPath(t)
+	require.True(t, p.b.InSlowStart())
+	rate := bbrTestBandwidth / 16
+	for rate < bbrTestBandwidth {
+		p.ackRound(rate, bbrTestRTT, 10, 0)
+		require.True(t, p.b.InSlowStart())
+		rate *= 2
+	}
+	// the bandwidth doesn't grow any more
+	for range 4 {
+		p.ackRound(bbrTestBandwidth, bbrTestRTT, 10, 0)
+	}
+	require.False(t, p.b.InSlowStart())
+	require.True(t, p.b.fullBwReached)
+	return p
+}
+
+func TestBBRStartup(t *testing.T) {
+	p := startBBR(t)
+	require.Equal(t, bbrTestBandwidth, p.b.maxBw)
+	require.Equal(t, bbrTestRTT, p.b.minRTT)
+	// Drain and ProbeBW_DOWN end immediately, since no queue was built during Startup
+	require.Equal(t, bbrStateProbeBWCruise, p.b.state)
+	require.Less(t, p.b.pacingRate, bbrTestBandwidth)
+	require.Greater(t, p.b.pacingRate, bbrTestBandwidth*9/10)
+	// the congestion window allows for twice the bandwidth-delay product
+	require.Greater(t, p.b.GetCongestionWindow(), 2*bbrTestBDP)
+	require.LessOrEqual(t, p.b.GetCongestionWindow(), 3*bbrTestBDP)
+}
+
+func TestBBRStartupHighLoss(t *testing.T) {
+	p := newBBRTestPath(t)
+	p.ackRound(bbrTestBandwidth/8, bbrTestRTT, 10, 0)
+	p.ackRound(bbrTestBandwidth/4, bbrTestRTT, 10, 0.1)
+	p.ackRound(bbrTestBandwidth/2, bbrTestRTT, 10, 0.1)
+	require.False(t, p.b.InSlowStart())
+	require.NotEqual(t, protocol.MaxByteCount, p.b.inflightHi)
+}
+
+func TestBBRLoss(t *testing.T) {
+	p := startBBR(t)
+	// 1% loss is below the loss threshold: the model is not bounded
+	for range 100 {
+		p.ackRound(bbrTestBandwidth, bbrTestRTT, 10, 0.01)
+		require.GreaterOrEqual(t, p.b.maxBw, bbrTestBandwidth*99/100)
+		require.Equal(t, protocol.MaxByteCount, p.b.inflightHi)
+		if p.b.state != bbrStateProbeRTT {
+			require.GreaterOrEqual(t, p.b.GetCongestionWindow(), bbrTestBDP)
+		}
+	}
+
+	// 10% loss bounds the data in flight when probing for more bandwidth
+	for range 100 {
+		p.ackRound(bbrTestBandwidth, bbrTestRTT, 10, 0.1)
+	}
+	for p.b.state != bbrStateProbeBWCruise {
+		p.ackRound(bbrTestBandwidth, bbrTestRTT, 10, 0.1)
+	}
+	require.LessOrEqual(t, p.b.inflightHi, 2*bbrTestBDP)
+	// leave some headroom for other flows
+	require.Less(t, p.b.GetCongestionWindow(), p.b.inflightHi*9/10)
+}
+
+func TestBBRProbeRTT(t *testing.T) {
+	p := startBBR(t)
+	// the RTT grows, and the minimum RTT is not observed again
+	var cwnd protocol.ByteCount
+	for p.b.state != bbrStateProbeRTT {
+		require.Less(t, p.now.Sub(p.b.probeRTTMinStamp), bbrProbeRTTInterval+time.Second)
+		cwnd = p.b.GetCongestionWindow()
+		p.ackRound(bbrTestBandwidth, 2*bbrTestRTT, 10, 0)
+	}
+	require.LessOrEqual(t, p.b.GetCongestionWindow(), bbrTestBDP/2)
+	// ProbeRTT lasts for at least 200ms and one round trip
+	for p.b.state == bbrStateProbeRTT {
+		p.ackRound(bbrTestBandwidth, 2*bbrTestRTT, 10, 0)
+	}
+	require.True(t, p.b.isInAProbeBWState(), "state: %s", p.b.state)
+	require.Equal(t, cwnd, p.b.GetCongestionWindow())
+}
+
+func TestBBRMaxDatagramSize(t *testing.T) {
+	p := newBBRTestPath(t)
+	require.Equal(t, initialCongestionWindow*initialMaxDatagramSize, p.b.GetCongestionWindow())
+	p.b.SetMaxDatagramSize(1400)
+	require.Equal(t, initialCongestionWindow*protocol.ByteCount(1400), p.b.GetCongestionWindow())
+	require.Panics(t, func() { p.b.SetMaxDatagramSize(1300) })
+}
@@ -0,0 +1,55 @@
+package congestion
+
+import (
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/monotime"
+	"github.com/Noooste/uquic-go/internal/protocol"
+)
+
+// [UQUIC]
+// A RateSample is a delivery rate sample, as described in
+// draft-cheng-iccrg-delivery-rate-estimation. It is generated for every ACK frame that
+// acknowledges new packets.
+type RateSample struct {
+	// DeliveryRate is the delivery rate measured over the sampling interval,
+	// or 0 if the interval was too short for a valid sample.
+	DeliveryRate Bandwidth
+	// IsAppLimited is set if the application didn't send enough data to fill the
+	// congestion window at some point during the sampling interval.
+	IsAppLimited bool
+	// Interval is the length of the sampling interval.
+	Interval time.Duration
+	// Delivered is the number of bytes delivered during the sampling interval.
+	Delivered protocol.ByteCount
+	// PriorDelivered is the number of bytes delivered when the most recently sent
+	// of the acknowledged packets was sent.
+	PriorDelivered protocol.ByteCount
+	// TotalDelivered is the number of bytes delivered over the lifetime of the path.
+	TotalDelivered protocol.ByteCount
+	// TxInFlight is the number of bytes in flight when the most recently sent of the
+	// acknowledged packets was sent, including this packet.
+	TxInFlight protocol.ByteCount
+	// Lost is the number of bytes declared lost between the sending of the most
+	// recently sent of the acknowledged packets and this ACK.
+	Lost protocol.ByteCount
+	// RTT is the RTT measured for the most recently sent of the acknowledged packets.
+	RTT time.Duration
+
+	// NewlyAcked and NewlyLost are the number of bytes acknowledged and declared lost
+	// since the last RateSample.
+	NewlyAcked, NewlyLost protocol.ByteCount
+	// BytesInFlight is the number of bytes in flight after processing the ACK frame.
+	BytesInFlight protocol.ByteCount
+}
+
+// [UQUIC]
+// A RateSampleConsumer is a SendAlgorithm that uses delivery rate samples. The
+// SentPacketHandler estimates the delivery rate for the congestion controllers that
+// implement this interface.
+type RateSampleConsumer interface {
+	SendAlgorithmWithDebugInfos
+	// OnRateSample is called after the packets acknowledged by an ACK frame were passed
+	// to OnPacketAcked, and the packets declared lost to OnCongestionEvent.
+	OnRateSample(sample *RateSample, now monotime.Time)
+}
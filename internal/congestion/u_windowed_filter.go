@@ -0,0 +1,71 @@
+package congestion
+
+// [UQUIC]
+// windowedMaxFilter tracks the maximum value seen during a window of time, measured in
+// rounds or cycles, using Kathleen Nichols' algorithm: it keeps the best, second best and
+// third best values of the window, so that older values can expire without keeping every
+// sample.
+type windowedMaxFilter[T ~uint64 | ~int64] struct {
+	window  uint64
+	samples [3]windowedSample[T]
+}
+
+type windowedSample[T ~uint64 | ~int64] struct {
+	value T
+	time  uint64
+}
+
+func newWindowedMaxFilter[T ~uint64 | ~int64](window uint64) windowedMaxFilter[T] {
+	return windowedMaxFilter[T]{window: window}
+}
+
+// Get returns the maximum value of the window.
+func (f *windowedMaxFilter[T]) Get() T {
+	return f.samples[0].value
+}
+
+// Reset discards all samples, and starts a new window with the given value.
+func (f *windowedMaxFilter[T]) Reset(value T, time uint64) {
+	f.samples = [3]windowedSample[T]{{value, time}, {value, time}, {value, time}}
+}
+
+// Update adds a sample taken at time, and returns the new maximum.
+func (f *windowedMaxFilter[T]) Update(value T, time uint64) T {
+	s := windowedSample[T]{value: value, time: time}
+	// a new maximum, or nothing left in the window
+	if value >= f.samples[0].value || time-f.samples[2].time > f.window {
+		f.Reset(value, time)
+		return value
+	}
+	if value >= f.samples[1].value {
+		f.samples[1] = s
+		f.samples[2] = s
+	} else if value >= f.samples[2].value {
+		f.samples[2] = s
+	}
+
+	// expire and update the best values
+	if time-f.samples[0].time > f.window {
+		f.samples[0] = f.samples[1]
+		f.samples[1] = f.samples[2]
+		f.samples[2] = s
+		if time-f.samples[0].time > f.window {
+			f.samples[0] = f.samples[1]
+			f.samples[1] = f.samples[2]
+		}
+		return f.samples[0].value
+	}
+	// Passed a quarter of the window without a better sample: take a second best value
+	// from the second quarter of the window.
+	if f.samples[1].value == f.samples[0].value && time-f.samples[1].time > f.window/4 {
+		f.samples[1] = s
+		f.samples[2] = s
+		return f.samples[0].value
+	}
+	// Passed half of the window without a better sample: take a third best value from
+	// the second half of the window.
+	if f.samples[2].value == f.samples[1].value && time-f.samples[2].time > f.window/2 {
+		f.samples[2] = s
+	}
+	return f.samples[0].value
+}
@@ -53,6 +53,9 @@ type cryptoSetup struct {
 
 	initialOpener LongHeaderOpener
 	initialSealer LongHeaderSealer
+	initialConnID protocol.ConnectionID // [UQUIC] the connection ID the Initial keys are derived from
+
+	originalInitialOpener LongHeaderOpener // [UQUIC] see SwitchVersion
 
 	handshakeOpener LongHeaderOpener
 	handshakeSealer LongHeaderSealer
@@ -121,27 +124,25 @@ func NewCryptoSetupServer(
 	logger utils.Logger,
 	version protocol.Version,
 ) CryptoSetup {
-	panic("NewCryptoSetupServer is not yet implemented for github.com/Noooste/uquic-go")
-
-	//cs := newCryptoSetup(
-	//	connID,
-	//	tp,
-	//	rttStats,
-	//	tracer,
-	//	logger,
-	//	protocol.PerspectiveServer,
-	//	version,
-	//)
-	//cs.allow0RTT = allow0RTT
-	//
-	//tlsConf = setupConfigForServer(tlsConf, localAddr, remoteAddr)
-	//
-	//cs.tlsConf = tlsConf
-	//cs.conn = tls.QUICServer(&tls.QUICConfig{
-	//	TLSConfig:           tlsConf,
-	//	EnableSessionEvents: true,
-	//})
-	//return cs
+	cs := newCryptoSetup(
+		connID,
+		tp,
+		rttStats,
+		qlogger,
+		logger,
+		protocol.PerspectiveServer,
+		version,
+	)
+	cs.allow0RTT = allow0RTT
+
+	tlsConf = setupConfigForServer(tlsConf, localAddr, remoteAddr)
+
+	cs.tlsConf = tlsConf
+	cs.conn = tls.QUICServer(&tls.QUICConfig{
+		TLSConfig:           tlsConf,
+		EnableSessionEvents: true,
+	})
+	return cs
 }
 
 func newCryptoSetup(
@@ -167,6 +168,7 @@ func newCryptoSetup(
 	return &cryptoSetup{
 		initialSealer: initialSealer,
 		initialOpener: initialOpener,
+		initialConnID: connID, // [UQUIC]
 		aead:          newUpdatableAEAD(rttStats, qlogger, logger, version),
 		events:        make([]Event, 0, 16),
 		ourParams:     tp,
@@ -182,6 +184,7 @@ func (h *cryptoSetup) ChangeConnectionID(id protocol.ConnectionID) {
 	initialSealer, initialOpener := NewInitialAEAD(id, h.perspective, h.version)
 	h.initialSealer = initialSealer
 	h.initialOpener = initialOpener
+	h.initialConnID = id // [UQUIC]
 	if h.qlogger != nil {
 		h.qlogger.RecordEvent(qlog.KeyUpdated{
 			Trigger: qlog.KeyUpdateTLS,
@@ -328,6 +331,12 @@ func (h *cryptoSetup) handleTransportParameters(data []byte) error {
 	if err := tp.Unmarshal(data, h.perspective.Opposite()); err != nil {
 		return err
 	}
+	// [UQUIC]
+	if h.perspective == protocol.PerspectiveServer && h.ourParams.VersionInformation != nil {
+		if err := h.negotiateCompatibleVersion(tp.VersionInformation); err != nil {
+			return err
+		}
+	}
 	h.peerParams = &tp
 	h.events = append(h.events, Event{Kind: EventReceivedTransportParameters, TransportParameters: h.peerParams})
 	return nil
@@ -579,6 +588,7 @@ func (h *cryptoSetup) DiscardInitialKeys() {
 	dropped := h.initialOpener != nil
 	h.initialOpener = nil
 	h.initialSealer = nil
+	h.originalInitialOpener = nil // [UQUIC]
 	if dropped {
 		h.logger.Debugf("Dropping Initial keys.")
 		if h.qlogger != nil {
@@ -44,6 +44,9 @@ type uCryptoSetup struct {
 
 	initialOpener LongHeaderOpener
 	initialSealer LongHeaderSealer
+	initialConnID protocol.ConnectionID // [UQUIC] the connection ID the Initial keys are derived from
+
+	originalInitialOpener LongHeaderOpener // [UQUIC] see SwitchVersion
 
 	handshakeOpener LongHeaderOpener
 	handshakeSealer LongHeaderSealer
@@ -58,7 +61,8 @@ type uCryptoSetup struct {
 var _ CryptoSetup = &uCryptoSetup{}
 
 // [UQUIC]
-// NewUCryptoSetupClient creates a new crypto setup for the client with UTLS
+// NewUCryptoSetupClient creates a new crypto setup for the client with UTLS.
+// It returns an error if uTLS fails to apply the ClientHelloSpec.
 func NewUCryptoSetupClient(
 	connID protocol.ConnectionID,
 	tp *wire.TransportParameters,
@@ -69,7 +73,7 @@ func NewUCryptoSetupClient(
 	logger utils.Logger,
 	version protocol.Version,
 	chs *tls.ClientHelloSpec,
-) CryptoSetup {
+) (CryptoSetup, error) {
 	cs := newUCryptoSetup(
 		connID,
 		tp,
@@ -82,6 +86,10 @@ func NewUCryptoSetupClient(
 
 	tlsConf = tlsConf.Clone()
 	tlsConf.MinVersion = tls.VersionTLS13
+	tlsConf.OmitEmptyPsk = true // [UQUIC] the pre_shared_key extension is only sent when resuming
+	if tlsConf.ClientSessionCache != nil {
+		tlsConf.ClientSessionCache = &uClientSessionCache{ClientSessionCache: tlsConf.ClientSessionCache, cs: cs} // [UQUIC]
+	}
 	cs.tlsConf = tlsConf
 	cs.allow0RTT = enable0RTT
 
@@ -91,12 +99,12 @@ func NewUCryptoSetupClient(
 		EnableSessionEvents: true,
 	}, tls.HelloCustom)
 	if err := cs.conn.ApplyPreset(chs); err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// cs.conn.SetTransportParameters(cs.ourParams.Marshal(protocol.PerspectiveClient)) // [UQUIC] doesn't require this
 
-	return cs
+	return cs, nil
 }
 
 func newUCryptoSetup(
@@ -113,6 +121,7 @@ func newUCryptoSetup(
 	return &uCryptoSetup{
 		initialSealer: initialSealer,
 		initialOpener: initialOpener,
+		initialConnID: connID,
 		aead:          newUpdatableAEAD(rttStats, qlogger, logger, version),
 		events:        make([]Event, 0, 16),
 		ourParams:     tp,
@@ -128,6 +137,7 @@ func (h *uCryptoSetup) ChangeConnectionID(id protocol.ConnectionID) {
 	initialSealer, initialOpener := NewInitialAEAD(id, h.perspective, h.version)
 	h.initialSealer = initialSealer
 	h.initialOpener = initialOpener
+	h.initialConnID = id
 }
 
 func (h *uCryptoSetup) SetLargest1RTTAcked(pn protocol.PacketNumber) error {
@@ -274,7 +284,6 @@ func (h *uCryptoSetup) handleTransportParameters(data []byte) error {
 func (h *uCryptoSetup) marshalDataForSessionState(earlyData bool) []byte {
 	b := make([]byte, 0, 256)
 	b = quicvarint.Append(b, clientSessionStateRevision)
-	b = quicvarint.Append(b, uint64(h.rttStats.SmoothedRTT().Microseconds()))
 	if earlyData {
 		// only save the transport parameters for 0-RTT enabled session tickets
 		return h.peerParams.MarshalForSessionTicket(b)
@@ -467,6 +476,7 @@ func (h *uCryptoSetup) DiscardInitialKeys() {
 	dropped := h.initialOpener != nil
 	h.initialOpener = nil
 	h.initialSealer = nil
+	h.originalInitialOpener = nil // [UQUIC]
 	if dropped {
 		h.logger.Debugf("Dropping Initial keys.")
 	}
@@ -0,0 +1,50 @@
+package handshake
+
+import (
+	tls "github.com/Noooste/utls"
+)
+
+// [UQUIC]
+// uClientSessionCache wraps the ClientSessionCache of a client using uTLS.
+//
+// A tls.UQUICConn never emits the QUICStoreSession and QUICResumeSession events,
+// uTLS uses the ClientSessionCache directly. uClientSessionCache does what the
+// crypto setup does when handling these events: it saves the transport parameters
+// along with sessions allowing 0-RTT, and restores them when resuming a session,
+// disabling early data unless 0-RTT is used on this connection.
+type uClientSessionCache struct {
+	tls.ClientSessionCache
+	cs *uCryptoSetup
+}
+
+func (c *uClientSessionCache) Put(sessionKey string, cs *tls.ClientSessionState) {
+	if _, state, err := cs.ResumptionState(); err == nil && state != nil {
+		state.Extra = append(
+			state.Extra,
+			addSessionStateExtraPrefix(c.cs.marshalDataForSessionState(state.EarlyData)),
+		)
+	}
+	c.ClientSessionCache.Put(sessionKey, cs)
+}
+
+func (c *uClientSessionCache) Get(sessionKey string) (*tls.ClientSessionState, bool) {
+	cs, ok := c.ClientSessionCache.Get(sessionKey)
+	if !ok {
+		return cs, ok
+	}
+	ticket, state, err := cs.ResumptionState()
+	if err != nil || state == nil {
+		return cs, ok
+	}
+	// the cached session might be resumed by other connections
+	session := *state
+	allowEarlyData := c.cs.handleDataFromSessionState(findSessionStateExtraData(session.Extra), session.EarlyData)
+	if session.EarlyData {
+		session.EarlyData = allowEarlyData
+	}
+	cs, err = tls.NewResumptionState(ticket, &session)
+	if err != nil {
+		return nil, false
+	}
+	return cs, true
+}
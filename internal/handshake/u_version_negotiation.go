@@ -0,0 +1,129 @@
+package handshake
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/Noooste/uquic-go/internal/protocol"
+	"github.com/Noooste/uquic-go/internal/qerr"
+	"github.com/Noooste/uquic-go/internal/wire"
+)
+
+// [UQUIC]
+// Version returns the QUIC version of the connection.
+// It differs from the version the CryptoSetup was created with once the connection
+// switched to a compatible version, see SwitchVersion.
+func (h *cryptoSetup) Version() protocol.Version { return h.version }
+
+// [UQUIC]
+// SwitchVersion switches the connection to another version, compatible with the one
+// it was started with, after compatible version negotiation (RFC 9368).
+// The Initial keys are derived again for the new version, as are the keys of the
+// encryption levels that are not yet installed. It must be called before the
+// Handshake keys are installed.
+//
+// The peer keeps sending Initial packets of the original version until it learns
+// about the switch, so the Initial opener of the original version is kept until
+// DropOriginalInitialOpener is called, see GetOriginalInitialOpener.
+func (h *cryptoSetup) SwitchVersion(v protocol.Version) {
+	if v == h.version {
+		return
+	}
+	if h.originalInitialOpener == nil {
+		h.originalInitialOpener = h.initialOpener
+	}
+	h.version = v
+	h.aead.version = v
+	h.initialSealer, h.initialOpener = NewInitialAEAD(h.initialConnID, h.perspective, v)
+}
+
+// [UQUIC]
+// GetOriginalInitialOpener returns the Initial opener of the version the connection
+// was started with, after switching to a compatible version.
+func (h *cryptoSetup) GetOriginalInitialOpener() (LongHeaderOpener, error) {
+	if h.originalInitialOpener == nil {
+		return nil, ErrKeysDropped
+	}
+	return h.originalInitialOpener, nil
+}
+
+// [UQUIC]
+// DropOriginalInitialOpener drops the Initial opener of the version the connection
+// was started with. It is called when receiving the first packet of the peer using
+// the negotiated version (RFC 9368, section 4).
+func (h *cryptoSetup) DropOriginalInitialOpener() {
+	if h.originalInitialOpener != nil {
+		h.logger.Debugf("Dropping the Initial opener of the original version.")
+	}
+	h.originalInitialOpener = nil
+}
+
+// [UQUIC]
+// Version returns the QUIC version of the connection, see cryptoSetup.Version.
+func (h *uCryptoSetup) Version() protocol.Version { return h.version }
+
+// [UQUIC]
+// SwitchVersion switches the connection to another version, see cryptoSetup.SwitchVersion.
+// The client calls it when the server's first Initial packet uses a version that
+// the client offered in its version_information transport parameter.
+func (h *uCryptoSetup) SwitchVersion(v protocol.Version) {
+	if v == h.version {
+		return
+	}
+	if h.originalInitialOpener == nil {
+		h.originalInitialOpener = h.initialOpener
+	}
+	h.version = v
+	h.aead.version = v
+	h.initialSealer, h.initialOpener = NewInitialAEAD(h.initialConnID, h.perspective, v)
+}
+
+// [UQUIC]
+// GetOriginalInitialOpener returns the Initial opener of the original version, see
+// cryptoSetup.GetOriginalInitialOpener.
+func (h *uCryptoSetup) GetOriginalInitialOpener() (LongHeaderOpener, error) {
+	if h.originalInitialOpener == nil {
+		return nil, ErrKeysDropped
+	}
+	return h.originalInitialOpener, nil
+}
+
+// [UQUIC]
+// DropOriginalInitialOpener drops the Initial opener of the original version, see
+// cryptoSetup.DropOriginalInitialOpener.
+func (h *uCryptoSetup) DropOriginalInitialOpener() {
+	if h.originalInitialOpener != nil {
+		h.logger.Debugf("Dropping the Initial opener of the original version.")
+	}
+	h.originalInitialOpener = nil
+}
+
+// [UQUIC]
+// negotiateCompatibleVersion is called by the server when receiving the client's
+// transport parameters, if it announces its versions in its own version_information
+// transport parameter. If the client sent a version_information transport parameter,
+// the server switches to the version it prefers among the client's available versions
+// that are compatible with the current version. Otherwise the server doesn't send its
+// version_information transport parameter.
+func (h *cryptoSetup) negotiateCompatibleVersion(client *wire.VersionInformation) error {
+	if client == nil {
+		h.ourParams.VersionInformation = nil
+		return nil
+	}
+	if client.ChosenVersion != h.version {
+		return &qerr.TransportError{
+			ErrorCode:    qerr.VersionNegotiationErrorCode,
+			ErrorMessage: fmt.Sprintf("client's chosen version %s doesn't match the version of its Initial packets (%s)", client.ChosenVersion, h.version),
+		}
+	}
+	ours := h.ourParams.VersionInformation.AvailableVersions
+	if v := protocol.ChooseCompatibleVersion(h.version, ours, client.AvailableVersions); v != h.version {
+		h.logger.Infof("Switching to QUIC version %s (compatible version negotiation).", v)
+		h.SwitchVersion(v)
+	}
+	h.ourParams.VersionInformation = &wire.VersionInformation{
+		ChosenVersion:     h.version,
+		AvailableVersions: slices.Clone(ours),
+	}
+	return nil
+}
@@ -1,10 +1,27 @@
 package protocol
 
+import "io"
+
 // [UQUIC]
 func GenerateConnectionIDForInitialWithLen(l int) (ConnectionID, error) {
 	return GenerateConnectionID(l)
 }
 
+// [UQUIC]
+// ReadConnectionIDForInitial reads a connection ID for the Initial packet from r.
+// If l is 0, it uses a length randomly chosen between 8 and 20 bytes, like
+// GenerateConnectionIDForInitial.
+func ReadConnectionIDForInitial(r io.Reader, l int) (ConnectionID, error) {
+	if l == 0 {
+		b := make([]byte, 1)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return ConnectionID{}, err
+		}
+		l = MinConnectionIDLenInitial + int(b[0])%(maxConnectionIDLen-MinConnectionIDLenInitial+1)
+	}
+	return ReadConnectionID(r, l)
+}
+
 type ExpEmptyConnectionIDGenerator struct{}
 
 func (g *ExpEmptyConnectionIDGenerator) GenerateConnectionID() (ConnectionID, error) {
@@ -14,3 +31,19 @@ func (g *ExpEmptyConnectionIDGenerator) GenerateConnectionID() (ConnectionID, er
 func (g *ExpEmptyConnectionIDGenerator) ConnectionIDLen() int {
 	return 0
 }
+
+// [UQUIC]
+// ReaderConnectionIDGenerator generates connection IDs of length ConnLen, reading
+// them from Rand.
+type ReaderConnectionIDGenerator struct {
+	Rand    io.Reader
+	ConnLen int
+}
+
+func (g *ReaderConnectionIDGenerator) GenerateConnectionID() (ConnectionID, error) {
+	return ReadConnectionID(g.Rand, g.ConnLen)
+}
+
+func (g *ReaderConnectionIDGenerator) ConnectionIDLen() int {
+	return g.ConnLen
+}
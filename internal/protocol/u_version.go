@@ -0,0 +1,32 @@
+package protocol
+
+import "slices"
+
+// [UQUIC]
+// IsReservedVersion says if the version is reserved to exercise version negotiation
+// (v & 0x0f0f0f0f == 0x0a0a0a0a), see GetGreasedVersions.
+func IsReservedVersion(v Version) bool {
+	return v&0x0f0f0f0f == 0x0a0a0a0a
+}
+
+// [UQUIC]
+// AreCompatibleVersions says if a connection started with version from can be switched
+// to version to using compatible version negotiation (RFC 9368).
+// QUIC v1 and QUIC v2 are compatible with each other (RFC 9369, Section 4).
+func AreCompatibleVersions(from, to Version) bool {
+	return (from == Version1 || from == Version2) && (to == Version1 || to == Version2)
+}
+
+// [UQUIC]
+// ChooseCompatibleVersion chooses the version of a connection using compatible version
+// negotiation (RFC 9368): the first of ours, in order of preference, that is among the
+// versions offered by the peer and compatible with the original version.
+// If there is none, the original version is kept.
+func ChooseCompatibleVersion(original Version, ours, theirs []Version) Version {
+	for _, v := range ours {
+		if slices.Contains(theirs, v) && (v == original || AreCompatibleVersions(original, v)) {
+			return v
+		}
+	}
+	return original
+}
@@ -0,0 +1,40 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsReservedVersion(t *testing.T) {
+	require.True(t, IsReservedVersion(0x0a0a0a0a))
+	require.True(t, IsReservedVersion(0x1a2a3a4a))
+	for _, v := range GetGreasedVersions(SupportedVersions) {
+		require.Equal(t, !IsSupportedVersion(SupportedVersions, v), IsReservedVersion(v))
+	}
+	require.False(t, IsReservedVersion(Version1))
+	require.False(t, IsReservedVersion(Version2))
+}
+
+func TestChooseCompatibleVersion(t *testing.T) {
+	require.True(t, AreCompatibleVersions(Version1, Version2))
+	require.True(t, AreCompatibleVersions(Version2, Version1))
+	require.False(t, AreCompatibleVersions(Version1, 0x1a2a3a4a))
+
+	for _, tc := range []struct {
+		name         string
+		original     Version
+		ours, theirs []Version
+		expected     Version
+	}{
+		{name: "switch to v2", original: Version1, ours: []Version{Version2, Version1}, theirs: []Version{Version1, Version2}, expected: Version2},
+		{name: "prefer the original version", original: Version1, ours: []Version{Version1, Version2}, theirs: []Version{Version2, Version1}, expected: Version1},
+		{name: "not offered by the peer", original: Version1, ours: []Version{Version2, Version1}, theirs: []Version{Version1}, expected: Version1},
+		{name: "incompatible version", original: Version1, ours: []Version{0x1a2a3a4a, Version1}, theirs: []Version{0x1a2a3a4a}, expected: Version1},
+		{name: "no versions", original: Version2, expected: Version2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, ChooseCompatibleVersion(tc.original, tc.ours, tc.theirs))
+		})
+	}
+}
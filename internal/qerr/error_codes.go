@@ -27,6 +27,8 @@ const (
 	KeyUpdateError            TransportErrorCode = 0xe
 	AEADLimitReached          TransportErrorCode = 0xf
 	NoViablePathError         TransportErrorCode = 0x10
+	// [UQUIC] RFC 9368
+	VersionNegotiationErrorCode TransportErrorCode = 0x11
 )
 
 func (e TransportErrorCode) IsCryptoError() bool {
@@ -78,6 +80,8 @@ func (e TransportErrorCode) String() string {
 		return "AEAD_LIMIT_REACHED"
 	case NoViablePathError:
 		return "NO_VIABLE_PATH"
+	case VersionNegotiationErrorCode:
+		return "VERSION_NEGOTIATION_ERROR"
 	default:
 		if e.IsCryptoError() {
 			return fmt.Sprintf("CRYPTO_ERROR %#x", uint16(e))
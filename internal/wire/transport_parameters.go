@@ -51,6 +51,8 @@ const (
 	resetStreamAtParameterID transportParameterID = 0x17f7586d2cb571
 	// https://datatracker.ietf.org/doc/draft-ietf-quic-ack-frequency/11/
 	minAckDelayParameterID transportParameterID = 0xff04de1b
+	// RFC 9368
+	versionInformationParameterID transportParameterID = 0x11 // [UQUIC]
 )
 
 // PreferredAddress is the value encoding in the preferred_address transport parameter
@@ -60,6 +62,14 @@ type PreferredAddress struct {
 	StatelessResetToken protocol.StatelessResetToken
 }
 
+// [UQUIC]
+// VersionInformation is the value of the version_information transport parameter,
+// used for compatible version negotiation (RFC 9368).
+type VersionInformation struct {
+	ChosenVersion     protocol.Version
+	AvailableVersions []protocol.Version
+}
+
 // TransportParameters are parameters sent to the peer during the handshake
 type TransportParameters struct {
 	InitialMaxStreamDataBidiLocal  protocol.ByteCount
@@ -92,8 +102,11 @@ type TransportParameters struct {
 	EnableResetStreamAt  bool               // https://datatracker.ietf.org/doc/draft-ietf-quic-reliable-stream-reset/06/
 	MinAckDelay          *time.Duration
 
+	VersionInformation *VersionInformation // [UQUIC] RFC 9368
+
 	// only used internally
-	ClientOverride tls.TransportParameters // [UQUIC]
+	ClientOverride tls.TransportParameters    // [UQUIC]
+	ServerLayout   *TransportParametersLayout // [UQUIC]
 }
 
 // Unmarshal the transport parameters
@@ -214,6 +227,11 @@ func (p *TransportParameters) unmarshal(b []byte, sentBy protocol.Perspective, f
 				return fmt.Errorf("wrong length for reset_stream_at: %d (expected empty)", paramLen)
 			}
 			p.EnableResetStreamAt = true
+		case versionInformationParameterID: // [UQUIC]
+			if err := p.readVersionInformation(b, int(paramLen)); err != nil {
+				return err
+			}
+			b = b[paramLen:]
 		default:
 			b = b[paramLen:]
 		}
@@ -290,6 +308,26 @@ func (p *TransportParameters) readPreferredAddress(b []byte, expectedLen int) er
 	return nil
 }
 
+// [UQUIC]
+func (p *TransportParameters) readVersionInformation(b []byte, l int) error {
+	if l == 0 || l%4 != 0 {
+		return fmt.Errorf("invalid length for version_information: %d", l)
+	}
+	vi := &VersionInformation{ChosenVersion: protocol.Version(binary.BigEndian.Uint32(b))}
+	if vi.ChosenVersion == 0 {
+		return errors.New("version_information with a zero chosen version")
+	}
+	for i := 4; i < l; i += 4 {
+		v := protocol.Version(binary.BigEndian.Uint32(b[i:]))
+		if v == 0 {
+			return errors.New("version_information with a zero available version")
+		}
+		vi.AvailableVersions = append(vi.AvailableVersions, v)
+	}
+	p.VersionInformation = vi
+	return nil
+}
+
 func (p *TransportParameters) readNumericTransportParameter(b []byte, paramID transportParameterID, expectedLen int) error {
 	val, l, err := quicvarint.Parse(b)
 	if err != nil {
@@ -468,6 +506,15 @@ func (p *TransportParameters) Marshal(pers protocol.Perspective) []byte {
 	if p.MinAckDelay != nil {
 		b = p.marshalVarintParam(b, minAckDelayParameterID, uint64(*p.MinAckDelay/time.Microsecond))
 	}
+	// [UQUIC] version_information
+	if p.VersionInformation != nil {
+		b = quicvarint.Append(b, uint64(versionInformationParameterID))
+		b = quicvarint.Append(b, uint64(4+4*len(p.VersionInformation.AvailableVersions)))
+		b = binary.BigEndian.AppendUint32(b, uint32(p.VersionInformation.ChosenVersion))
+		for _, v := range p.VersionInformation.AvailableVersions {
+			b = binary.BigEndian.AppendUint32(b, uint32(v))
+		}
+	}
 
 	if pers == protocol.PerspectiveClient && len(AdditionalTransportParametersClient) > 0 {
 		for k, v := range AdditionalTransportParametersClient {
@@ -476,6 +523,10 @@ func (p *TransportParameters) Marshal(pers protocol.Perspective) []byte {
 			b = append(b, v...)
 		}
 	}
+	// [UQUIC]
+	if pers == protocol.PerspectiveServer && p.ServerLayout != nil {
+		return p.ServerLayout.apply(b)
+	}
 
 	return b
 }
@@ -594,6 +645,10 @@ func (p *TransportParameters) String() string {
 		logString += ", MinAckDelay: %s"
 		logParams = append(logParams, *p.MinAckDelay)
 	}
+	if p.VersionInformation != nil { // [UQUIC]
+		logString += ", VersionInformation: {ChosenVersion: %s, AvailableVersions: %s}"
+		logParams = append(logParams, p.VersionInformation.ChosenVersion, p.VersionInformation.AvailableVersions)
+	}
 	logString += "}"
 	return fmt.Sprintf(logString, logParams...)
 }
@@ -0,0 +1,161 @@
+package wire
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/protocol"
+	"github.com/Noooste/uquic-go/quicvarint"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportParameterVersionInformation(t *testing.T) {
+	params := &TransportParameters{
+		StatelessResetToken:     &protocol.StatelessResetToken{},
+		ActiveConnectionIDLimit: 2,
+		VersionInformation: &VersionInformation{
+			ChosenVersion:     protocol.Version2,
+			AvailableVersions: []protocol.Version{protocol.Version2, 0x1a2a3a4a, protocol.Version1},
+		},
+	}
+	data := params.Marshal(protocol.PerspectiveServer)
+	var p TransportParameters
+	require.NoError(t, p.Unmarshal(data, protocol.PerspectiveServer))
+	require.Equal(t, params.VersionInformation, p.VersionInformation)
+	require.Contains(t, p.String(), "VersionInformation: {ChosenVersion: v2, AvailableVersions: [v2 0x1a2a3a4a v1]}")
+
+	// the client may not list any available versions
+	params = &TransportParameters{
+		ActiveConnectionIDLimit: 2,
+		VersionInformation:      &VersionInformation{ChosenVersion: protocol.Version1},
+	}
+	data = params.Marshal(protocol.PerspectiveClient)
+	p = TransportParameters{}
+	require.NoError(t, p.Unmarshal(data, protocol.PerspectiveClient))
+	require.Equal(t, params.VersionInformation, p.VersionInformation)
+
+	// not sent if not set
+	p = TransportParameters{}
+	data = (&TransportParameters{ActiveConnectionIDLimit: 2}).Marshal(protocol.PerspectiveClient)
+	require.NoError(t, p.Unmarshal(data, protocol.PerspectiveClient))
+	require.Nil(t, p.VersionInformation)
+}
+
+func TestTransportParameterVersionInformationErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		value          []byte
+		expectedErrMsg string
+	}{
+		{name: "empty", value: nil, expectedErrMsg: "invalid length for version_information: 0"},
+		{name: "truncated version", value: []byte{0, 0, 0, 1, 0x6b, 0x33}, expectedErrMsg: "invalid length for version_information: 6"},
+		{name: "zero chosen version", value: []byte{0, 0, 0, 0}, expectedErrMsg: "version_information with a zero chosen version"},
+		{name: "zero available version", value: []byte{0, 0, 0, 1, 0, 0, 0, 0}, expectedErrMsg: "version_information with a zero available version"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := quicvarint.Append(nil, uint64(versionInformationParameterID))
+			b = quicvarint.Append(b, uint64(len(tc.value)))
+			b = append(b, tc.value...)
+			b = appendInitialSourceConnectionID(b)
+			var p TransportParameters
+			require.ErrorContains(t, p.Unmarshal(b, protocol.PerspectiveClient), tc.expectedErrMsg)
+		})
+	}
+}
+
+// transportParameterIDs returns the IDs of marshaled transport parameters, in order.
+func transportParameterIDs(t *testing.T, b []byte) []uint64 {
+	t.Helper()
+	var ids []uint64
+	for len(b) > 0 {
+		id, n, err := quicvarint.Parse(b)
+		require.NoError(t, err)
+		length, m, err := quicvarint.Parse(b[n:])
+		require.NoError(t, err)
+		ids = append(ids, id)
+		b = b[n+m+int(length):]
+	}
+	return ids
+}
+
+func TestTransportParametersServerLayout(t *testing.T) {
+	params := &TransportParameters{
+		InitialMaxData:                  0x1337,
+		MaxIdleTimeout:                  30 * time.Second,
+		MaxAckDelay:                     protocol.DefaultMaxAckDelay,
+		AckDelayExponent:                protocol.DefaultAckDelayExponent,
+		StatelessResetToken:             &protocol.StatelessResetToken{1, 2, 3},
+		OriginalDestinationConnectionID: protocol.ParseConnectionID([]byte{1, 2, 3, 4}),
+		InitialSourceConnectionID:       protocol.ParseConnectionID([]byte{5, 6, 7, 8}),
+		ActiveConnectionIDLimit:         4,
+		MaxDatagramFrameSize:            protocol.InvalidByteCount,
+	}
+	defaultIDs := transportParameterIDs(t, params.Marshal(protocol.PerspectiveServer))
+	require.True(t, isGREASETransportParameterID(defaultIDs[0]))
+
+	params.ServerLayout = &TransportParametersLayout{
+		Order: []uint64{
+			uint64(initialSourceConnectionIDParameterID),
+			0x4752,
+			27, // the GREASE transport parameter
+			uint64(maxIdleTimeoutParameterID),
+		},
+		Additional: map[uint64][]byte{
+			0x4752:                            {0xff, 0, 0, 1},
+			0x3128:                            {},
+			uint64(maxIdleTimeoutParameterID): {42}, // ignored, quic-go sends it
+		},
+	}
+	data := params.Marshal(protocol.PerspectiveServer)
+	ids := transportParameterIDs(t, data)
+	require.Len(t, ids, len(defaultIDs)+2)
+	require.Equal(t, uint64(initialSourceConnectionIDParameterID), ids[0])
+	require.Equal(t, uint64(0x4752), ids[1])
+	require.True(t, isGREASETransportParameterID(ids[2]))
+	require.Equal(t, uint64(maxIdleTimeoutParameterID), ids[3])
+	require.Equal(t, uint64(0x3128), ids[len(ids)-1])
+	// the parameters that aren't listed keep their order
+	var rest []uint64
+	for _, id := range defaultIDs[1:] {
+		if id != uint64(initialSourceConnectionIDParameterID) && id != uint64(maxIdleTimeoutParameterID) {
+			rest = append(rest, id)
+		}
+	}
+	require.Equal(t, rest, ids[4:len(ids)-1])
+
+	var p TransportParameters
+	require.NoError(t, p.Unmarshal(data, protocol.PerspectiveServer))
+	require.Equal(t, 30*time.Second, p.MaxIdleTimeout)
+	require.Equal(t, params.InitialSourceConnectionID, p.InitialSourceConnectionID)
+
+	params.ServerLayout.OmitGREASE = true
+	ids = transportParameterIDs(t, params.Marshal(protocol.PerspectiveServer))
+	require.Len(t, ids, len(defaultIDs)+1)
+	for _, id := range ids {
+		require.False(t, isGREASETransportParameterID(id))
+	}
+	// the layout only applies to the server's transport parameters
+	ids = transportParameterIDs(t, params.Marshal(protocol.PerspectiveClient))
+	require.True(t, isGREASETransportParameterID(ids[0]))
+	require.NotContains(t, ids, uint64(0x4752))
+}
+
+func TestTransportParametersServerLayoutMalformed(t *testing.T) {
+	layout := &TransportParametersLayout{Order: []uint64{uint64(maxIdleTimeoutParameterID)}}
+	valid := quicvarint.Append(nil, uint64(initialMaxDataParameterID))
+	valid = quicvarint.Append(valid, 2)
+	valid = quicvarint.Append(valid, 0x1337)
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{name: "truncated ID", data: append(slices.Clone(valid), 0x40)},
+		{name: "truncated value", data: append(slices.Clone(valid), byte(maxIdleTimeoutParameterID), 10, 1)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.data, layout.apply(slices.Clone(tc.data)))
+		})
+	}
+}
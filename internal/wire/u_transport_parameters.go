@@ -0,0 +1,87 @@
+package wire
+
+import (
+	"slices"
+
+	"github.com/Noooste/uquic-go/quicvarint"
+)
+
+// [UQUIC]
+// TransportParametersLayout controls the layout of the transport parameters sent by the
+// server.
+type TransportParametersLayout struct {
+	// Order lists the IDs of the transport parameters sent first, in this order. Any
+	// GREASE ID stands for the GREASE transport parameter. The transport parameters that
+	// aren't listed follow in the default order.
+	Order []uint64
+	// Additional are transport parameters sent in addition to those of quic-go, by ID.
+	// Additional transport parameters that aren't listed in Order are sent last, by
+	// increasing ID. They are ignored if quic-go sends a transport parameter with the
+	// same ID.
+	Additional map[uint64][]byte
+	// OmitGREASE omits the GREASE transport parameter.
+	OmitGREASE bool
+}
+
+type transportParameter struct {
+	id  uint64
+	raw []byte // the entire encoding, including the ID and the length
+}
+
+func isGREASETransportParameterID(id uint64) bool {
+	return id >= 27 && (id-27)%31 == 0
+}
+
+// apply rearranges the transport parameters marshaled by TransportParameters.Marshal.
+// If b can't be parsed, it is returned unchanged.
+func (l *TransportParametersLayout) apply(data []byte) []byte {
+	params := make([]transportParameter, 0, 24)
+	seen := make(map[uint64]bool, 24)
+	b := data
+	for len(b) > 0 {
+		id, n, err := quicvarint.Parse(b)
+		if err != nil {
+			return data
+		}
+		length, m, err := quicvarint.Parse(b[n:])
+		if err != nil || uint64(len(b)-n-m) < length {
+			return data
+		}
+		end := n + m + int(length)
+		if isGREASETransportParameterID(id) && l.OmitGREASE {
+			b = b[end:]
+			continue
+		}
+		params = append(params, transportParameter{id: id, raw: b[:end]})
+		seen[id] = true
+		b = b[end:]
+	}
+	additional := make([]uint64, 0, len(l.Additional))
+	for id := range l.Additional {
+		if !seen[id] {
+			additional = append(additional, id)
+		}
+	}
+	slices.Sort(additional)
+	for _, id := range additional {
+		raw := quicvarint.Append(nil, id)
+		raw = quicvarint.Append(raw, uint64(len(l.Additional[id])))
+		params = append(params, transportParameter{id: id, raw: append(raw, l.Additional[id]...)})
+	}
+
+	rank := func(id uint64) int {
+		for i, o := range l.Order {
+			if o == id || (isGREASETransportParameterID(o) && isGREASETransportParameterID(id)) {
+				return i
+			}
+		}
+		return len(l.Order)
+	}
+	slices.SortStableFunc(params, func(a, b transportParameter) int { return rank(a.id) - rank(b.id) })
+
+	out := make([]byte, 0, 256)
+	for _, p := range params {
+		out = append(out, p.raw...)
+	}
+	return out
+}
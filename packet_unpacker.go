@@ -60,7 +60,7 @@ func (u *packetUnpacker) UnpackLongHeader(hdr *wire.Header, data []byte) (*unpac
 	switch hdr.Type {
 	case protocol.PacketTypeInitial:
 		encLevel = protocol.EncryptionInitial
-		opener, err := u.cs.GetInitialOpener()
+		opener, err := u.initialOpener(hdr) // [UQUIC]
 		if err != nil {
 			return nil, err
 		}
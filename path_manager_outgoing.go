@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/rand"
 	"errors"
+	"net"
 	"slices"
 	"sync"
 	"sync/atomic"
@@ -24,10 +25,13 @@ var (
 var errPathDoesNotExist = errors.New("path does not exist")
 
 // Path is a network path.
+// [UQUIC] A connection only sends on one path at a time: the multipath extension
+// (draft-ietf-quic-multipath) is not implemented.
 type Path struct {
 	id          pathID
 	pathManager *pathManagerOutgoing
 	tr          *Transport
+	remoteAddr  net.Addr // [UQUIC] nil if the path uses the remote address of the connection
 	initialRTT  time.Duration
 
 	enablePath func()
@@ -106,6 +110,7 @@ func (p *Path) Close() error {
 type pathOutgoing struct {
 	pathChallenges [][8]byte // length is implicitly limited by exponential backoff
 	tr             *Transport
+	remoteAddr     net.Addr // [UQUIC]
 	isValidated    bool
 	probeSent      chan struct{} // receives when a PATH_CHALLENGE is sent
 	validated      chan struct{} // closed when the path the corresponding PATH_RESPONSE is received
@@ -158,6 +163,7 @@ func (pm *pathManagerOutgoing) addPath(p *Path, enablePath func()) *pathOutgoing
 
 	path := &pathOutgoing{
 		tr:         p.tr,
+		remoteAddr: p.remoteAddr,
 		probeSent:  make(chan struct{}, 1),
 		validated:  make(chan struct{}),
 		enablePath: enablePath,
@@ -215,7 +221,9 @@ func (pm *pathManagerOutgoing) switchToPath(id pathID) error {
 	return nil
 }
 
-func (pm *pathManagerOutgoing) NewPath(t *Transport, initialRTT time.Duration, enablePath func()) *Path {
+// NewPath creates a new path. If remoteAddr is nil, the path uses the remote address of
+// the connection.
+func (pm *pathManagerOutgoing) NewPath(t *Transport, remoteAddr net.Addr, initialRTT time.Duration, enablePath func()) *Path {
 	pm.mx.Lock()
 	defer pm.mx.Unlock()
 
@@ -225,13 +233,16 @@ func (pm *pathManagerOutgoing) NewPath(t *Transport, initialRTT time.Duration, e
 		pathManager: pm,
 		id:          id,
 		tr:          t,
+		remoteAddr:  remoteAddr,
 		enablePath:  enablePath,
 		initialRTT:  initialRTT,
 		abandon:     make(chan struct{}),
 	}
 }
 
-func (pm *pathManagerOutgoing) NextPathToProbe() (_ protocol.ConnectionID, _ ackhandler.Frame, _ *Transport, hasPath bool) {
+// NextPathToProbe returns the path probe packet to send next.
+// The remote address is nil if the path uses the remote address of the connection.
+func (pm *pathManagerOutgoing) NextPathToProbe() (_ protocol.ConnectionID, _ ackhandler.Frame, _ *Transport, remoteAddr net.Addr, hasPath bool) {
 	pm.mx.Lock()
 	defer pm.mx.Unlock()
 
@@ -248,12 +259,12 @@ func (pm *pathManagerOutgoing) NextPathToProbe() (_ protocol.ConnectionID, _ ack
 		pm.pathsToProbe = pm.pathsToProbe[1:]
 	}
 	if id == invalidPathID {
-		return protocol.ConnectionID{}, ackhandler.Frame{}, nil, false
+		return protocol.ConnectionID{}, ackhandler.Frame{}, nil, nil, false
 	}
 
 	connID, ok := pm.getConnID(id)
 	if !ok {
-		return protocol.ConnectionID{}, ackhandler.Frame{}, nil, false
+		return protocol.ConnectionID{}, ackhandler.Frame{}, nil, nil, false
 	}
 
 	var b [8]byte
@@ -270,7 +281,7 @@ func (pm *pathManagerOutgoing) NextPathToProbe() (_ protocol.ConnectionID, _ ack
 		Frame:   &wire.PathChallengeFrame{Data: b},
 		Handler: (*pathManagerOutgoingAckHandler)(pm),
 	}
-	return connID, frame, p.tr, true
+	return connID, frame, p.tr, p.remoteAddr, true
 }
 
 func (pm *pathManagerOutgoing) HandlePathResponseFrame(f *wire.PathResponseFrame) {
@@ -291,16 +302,18 @@ func (pm *pathManagerOutgoing) HandlePathResponseFrame(f *wire.PathResponseFrame
 	}
 }
 
-func (pm *pathManagerOutgoing) ShouldSwitchPath() (*Transport, bool) {
+// ShouldSwitchPath returns the path to switch to, if any.
+// The remote address is nil if the path uses the remote address of the connection.
+func (pm *pathManagerOutgoing) ShouldSwitchPath() (_ *Transport, remoteAddr net.Addr, _ bool) {
 	pm.mx.Lock()
 	defer pm.mx.Unlock()
 
 	if pm.pathToSwitchTo == nil {
-		return nil, false
+		return nil, nil, false
 	}
 	p := pm.pathToSwitchTo
 	pm.pathToSwitchTo = nil
-	return p.tr, true
+	return p.tr, p.remoteAddr, true
 }
 
 type pathManagerOutgoingAckHandler pathManagerOutgoing
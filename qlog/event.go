@@ -186,6 +186,8 @@ func (e ConnectionClosed) Encode(enc *jsontext.Encoder, _ time.Time) error {
 				h.WriteToken(jsontext.String("aead_limit_reached"))
 			case qerr.NoViablePathError:
 				h.WriteToken(jsontext.String("no_viable_path"))
+			case qerr.VersionNegotiationErrorCode:
+				h.WriteToken(jsontext.String("version_negotiation_error"))
 			default:
 				h.WriteToken(jsontext.String("unknown"))
 				h.WriteToken(jsontext.String("error_code"))
@@ -826,6 +828,39 @@ func (e ALPNInformation) Encode(enc *jsontext.Encoder, _ time.Time) error {
 	return h.err
 }
 
+// [UQUIC]
+// MigrationStateUpdated is logged when the state of a connection migration changes.
+// Local and Remote are the endpoints of the path being probed or migrated to.
+type MigrationStateUpdated struct {
+	State   MigrationState
+	Local   PathEndpointInfo
+	Remote  PathEndpointInfo
+	Trigger string
+}
+
+func (e MigrationStateUpdated) Name() string { return "transport:migration_state_updated" }
+
+func (e MigrationStateUpdated) Encode(enc *jsontext.Encoder, _ time.Time) error {
+	h := encoderHelper{enc: enc}
+	h.WriteToken(jsontext.BeginObject)
+	h.WriteToken(jsontext.String("new"))
+	h.WriteToken(jsontext.String(string(e.State)))
+	h.WriteToken(jsontext.String("path_local"))
+	if err := e.Local.encode(enc); err != nil {
+		return err
+	}
+	h.WriteToken(jsontext.String("path_remote"))
+	if err := e.Remote.encode(enc); err != nil {
+		return err
+	}
+	if e.Trigger != "" {
+		h.WriteToken(jsontext.String("trigger"))
+		h.WriteToken(jsontext.String(e.Trigger))
+	}
+	h.WriteToken(jsontext.EndObject)
+	return h.err
+}
+
 // DebugEvent is a generic event that can be used to log arbitrary messages.
 type DebugEvent struct {
 	EventName string
@@ -878,6 +878,28 @@ func TestALPNInformation(t *testing.T) {
 	require.Equal(t, "h3", ev["chosen_alpn"])
 }
 
+func TestMigrationStateUpdated(t *testing.T) {
+	name, ev := testEventEncoding(t, &MigrationStateUpdated{
+		State:   MigrationStateProbingStarted,
+		Local:   PathEndpointInfo{IPv4: netip.MustParseAddrPort("192.168.13.37:42")},
+		Remote:  PathEndpointInfo{IPv6: netip.MustParseAddrPort("[2001:db8::1]:443")},
+		Trigger: "pto_count",
+	})
+
+	require.Equal(t, "transport:migration_state_updated", name)
+	require.Len(t, ev, 4)
+	require.Equal(t, "probing_started", ev["new"])
+	require.Equal(t, "pto_count", ev["trigger"])
+	local, ok := ev["path_local"].(map[string]any)
+	require.True(t, ok)
+	require.Equal(t, "192.168.13.37", local["ip_v4"])
+	require.Equal(t, float64(42), local["port_v4"])
+	remote, ok := ev["path_remote"].(map[string]any)
+	require.True(t, ok)
+	require.Equal(t, "2001:db8::1", remote["ip_v6"])
+	require.Equal(t, float64(443), remote["port_v6"])
+}
+
 func TestDebugEvent(t *testing.T) {
 	t.Run("default name", func(t *testing.T) {
 		name, ev := testEventEncoding(t, &DebugEvent{Message: "hello world"})
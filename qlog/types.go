@@ -155,6 +155,8 @@ func (e transportError) String() string {
 		return "aead_limit_reached"
 	case qerr.NoViablePathError:
 		return "no_viable_path"
+	case qerr.VersionNegotiationErrorCode:
+		return "version_negotiation_error"
 	default:
 		return ""
 	}
@@ -282,6 +284,23 @@ const (
 	ECNStateCapable ECNState = "capable"
 )
 
+// [UQUIC]
+// MigrationState is the state of a connection migration
+type MigrationState string
+
+const (
+	// MigrationStateProbingStarted is the state when probing of a new path started
+	MigrationStateProbingStarted MigrationState = "probing_started"
+	// MigrationStateProbingAbandoned is the state when probing of a path was abandoned
+	MigrationStateProbingAbandoned MigrationState = "probing_abandoned"
+	// MigrationStateProbingSuccessful is the state when a path was validated
+	MigrationStateProbingSuccessful MigrationState = "probing_successful"
+	// MigrationStateMigrationStarted is the state when the connection started switching to a new path
+	MigrationStateMigrationStarted MigrationState = "migration_started"
+	// MigrationStateMigrationComplete is the state when the connection switched to a new path
+	MigrationStateMigrationComplete MigrationState = "migration_complete"
+)
+
 type ConnectionCloseTrigger string
 
 const (
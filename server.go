@@ -764,6 +764,12 @@ func (s *baseServer) handleInitialImpl(p receivedPacket, hdr *wire.Header) error
 		RemoteAddr:   p.remoteAddr,
 		AddrVerified: clientAddrVerified,
 	}
+	// [UQUIC]
+	var fingerprinter *clientFingerprinter
+	if s.config.EnableClientFingerprint {
+		fingerprinter = newClientFingerprinter(p.data)
+		clientInfo.ClientFingerprint = fingerprinter.fingerprint()
+	}
 	if s.config.GetConfigForClient != nil {
 		conf, err := s.config.GetConfigForClient(clientInfo)
 		if err != nil {
@@ -772,6 +778,17 @@ func (s *baseServer) handleInitialImpl(p receivedPacket, hdr *wire.Header) error
 			return nil
 		}
 		config = populateConfig(conf)
+		// [UQUIC]
+		if err := validateServerSpec(config.ServerSpec, s.connIDGenerator.ConnectionIDLen()); err != nil {
+			s.logger.Debugf("Rejecting new connection due to the ServerSpec returned by GetConfigForClient: %s", err)
+			s.refuseNewConn(p, hdr)
+			return nil
+		}
+		if err := validatePreferredAddress(config.PreferredAddress, s.connIDGenerator.ConnectionIDLen()); err != nil {
+			s.logger.Debugf("Rejecting new connection due to the PreferredAddress returned by GetConfigForClient: %s", err)
+			s.refuseNewConn(p, hdr)
+			return nil
+		}
 	}
 
 	var conn *wrappedConn
@@ -836,6 +853,9 @@ func (s *baseServer) handleInitialImpl(p receivedPacket, hdr *wire.Header) error
 		s.logger,
 		hdr.Version,
 	)
+	if fingerprinter != nil { // [UQUIC]
+		conn.setClientFingerprinter(fingerprinter)
+	}
 	conn.handlePacket(p)
 	// Adding the connection will fail if the client's chosen Destination Connection ID is already in use.
 	// This is very unlikely: Even if an attacker chooses a connection ID that's already in use,
@@ -1,6 +1,7 @@
 package simnet
 
 import (
+	"math/rand/v2"
 	"net"
 	"sync"
 	"time"
@@ -16,6 +17,48 @@ type packetWithDeliveryTime struct {
 type LinkSettings struct {
 	// MTU (Maximum Transmission Unit) specifies the maximum packet size in bytes
 	MTU int
+
+	// [UQUIC]
+	// BitsPerSecond limits the bandwidth of the link direction.
+	// Packets are queued until the link is free to transmit them.
+	// If 0, the bandwidth is unlimited.
+	BitsPerSecond int
+
+	// [UQUIC]
+	// LossRate is the probability of a packet being dropped, between 0 and 1.
+	LossRate float64
+}
+
+// [UQUIC]
+// linkDirection holds the state of one direction of a link
+type linkDirection struct {
+	mu        sync.Mutex
+	settings  *LinkSettings
+	rand      *rand.Rand
+	busyUntil time.Time
+}
+
+// transmit returns when a packet of the given size, arriving at now, is fully transmitted.
+// It returns false if the packet is dropped.
+func (d *linkDirection) transmit(size int, now time.Time) (time.Time, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if size > d.settings.MTU {
+		// Drop packet if it's too large
+		return time.Time{}, false
+	}
+	if d.settings.LossRate > 0 && d.rand.Float64() < d.settings.LossRate {
+		return time.Time{}, false
+	}
+	if d.settings.BitsPerSecond <= 0 {
+		return now, true
+	}
+	start := now
+	if d.busyUntil.After(start) {
+		start = d.busyUntil
+	}
+	d.busyUntil = start.Add(time.Duration(8 * size * int(time.Second) / d.settings.BitsPerSecond))
+	return d.busyUntil, true
 }
 
 // SimulatedLink simulates a bidirectional network link with variable latency and MTU constraints
@@ -27,6 +70,9 @@ type SimulatedLink struct {
 	downstreamQueue *queue
 	upstreamQueue   *queue
 
+	// [UQUIC] bandwidth and loss state
+	downlink, uplink linkDirection
+
 	// Configuration for link characteristics
 	UplinkSettings   LinkSettings
 	DownlinkSettings LinkSettings
@@ -65,6 +111,10 @@ func (l *SimulatedLink) Start() {
 	l.downstreamQueue = newQueue()
 	l.upstreamQueue = newQueue()
 
+	// [UQUIC] use a fixed seed, such that losses are reproducible
+	l.downlink = linkDirection{settings: &l.DownlinkSettings, rand: rand.New(rand.NewPCG(1, 2))}
+	l.uplink = linkDirection{settings: &l.UplinkSettings, rand: rand.New(rand.NewPCG(3, 4))}
+
 	l.wg.Add(2)
 	go l.backgroundDownlink()
 	go l.backgroundUplink()
@@ -110,14 +160,12 @@ func (l *SimulatedLink) backgroundUplink() {
 }
 
 func (l *SimulatedLink) SendPacket(p Packet) error {
-	if len(p.Data) > l.UplinkSettings.MTU {
-		// Drop packet if it's too large
+	// Uplink has no latency - packets are delivered once transmitted
+	deliveryTime, ok := l.uplink.transmit(len(p.Data), time.Now())
+	if !ok {
 		return nil
 	}
 
-	// Uplink has no latency - packets are delivered immediately
-	deliveryTime := time.Now()
-
 	// Enqueue packet with delivery time
 	l.upstreamQueue.Enqueue(&packetWithDeliveryTime{
 		Packet:       p,
@@ -128,8 +176,8 @@ func (l *SimulatedLink) SendPacket(p Packet) error {
 }
 
 func (l *SimulatedLink) RecvPacket(p Packet) {
-	if len(p.Data) > l.DownlinkSettings.MTU {
-		// Drop packet if it's too large
+	transmitted, ok := l.downlink.transmit(len(p.Data), time.Now())
+	if !ok {
 		return
 	}
 
@@ -140,7 +188,7 @@ func (l *SimulatedLink) RecvPacket(p Packet) {
 	} else {
 		latency = l.Latency
 	}
-	deliveryTime := time.Now().Add(latency)
+	deliveryTime := transmitted.Add(latency)
 
 	// Enqueue packet with delivery time
 	l.downstreamQueue.Enqueue(&packetWithDeliveryTime{
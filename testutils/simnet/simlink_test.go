@@ -154,3 +154,60 @@ func TestMTUEnforcement(t *testing.T) {
 		}
 	})
 }
+
+func TestBandwidth(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		const MTU = 1250
+		const bitsPerSecond = 1e6 // 10 packets per 100ms
+		const latency = 10 * time.Millisecond
+
+		var recvTimes []time.Time
+		router := &testRouter{onRecv: func(p Packet) { recvTimes = append(recvTimes, time.Now()) }}
+		link := SimulatedLink{
+			UplinkSettings:   LinkSettings{MTU: MTU},
+			DownlinkSettings: LinkSettings{MTU: MTU, BitsPerSecond: bitsPerSecond},
+			LatencyFunc:      func(p Packet) time.Duration { return latency },
+			UploadPacket:     router,
+			downloadPacket:   router,
+		}
+		link.Start()
+
+		start := time.Now()
+		for range 10 {
+			link.RecvPacket(Packet{Data: make([]byte, MTU)})
+		}
+		time.Sleep(time.Second)
+		link.Close()
+
+		require.Len(t, recvTimes, 10)
+		// packets are serialized one after the other
+		for i, recvTime := range recvTimes {
+			require.Equal(t, latency+time.Duration(i+1)*10*time.Millisecond, recvTime.Sub(start))
+		}
+	})
+}
+
+func TestLossRate(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		const MTU = 1400
+		const numPackets = 10000
+
+		var packetsReceived int
+		router := &testRouter{onRecv: func(p Packet) { packetsReceived++ }}
+		link := SimulatedLink{
+			UplinkSettings:   LinkSettings{MTU: MTU},
+			DownlinkSettings: LinkSettings{MTU: MTU, LossRate: 0.1},
+			UploadPacket:     router,
+			downloadPacket:   router,
+		}
+		link.Start()
+
+		for range numPackets {
+			link.RecvPacket(Packet{Data: make([]byte, 100)})
+		}
+		time.Sleep(10 * time.Millisecond)
+		link.Close()
+
+		require.InDelta(t, 0.9*numPackets, packetsReceived, 0.02*numPackets)
+	})
+}
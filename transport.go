@@ -138,11 +138,17 @@ type Transport struct {
 	handlers    map[protocol.ConnectionID]packetHandler
 	resetTokens map[protocol.StatelessResetToken]packetHandler
 
+	// [UQUIC] connections dialed with a QUICSpec, see u_packet_handler_map.go
+	uConnIDLens      map[int]int
+	uZeroLenHandlers map[string]packetHandler
+
 	initOnce sync.Once
 	initErr  error
 
 	// If no ConnectionIDGenerator is set, this is the ConnectionIDLength.
 	connIDLen int
+	// [UQUIC] the ServerSpec.ConnIDLength of the Listener, used if ConnectionIDLength is not set
+	serverConnIDLen int
 	// Set in init.
 	// If no ConnectionIDGenerator is set, this is set to a default.
 	connIDGenerator   ConnectionIDGenerator
@@ -206,9 +212,19 @@ func (t *Transport) createServer(tlsConf *tls.Config, conf *Config, allow0RTT bo
 		return nil, errListenerAlreadySet
 	}
 	conf = populateConfig(conf)
+	// [UQUIC] see ServerSpec.ConnIDLength
+	if spec := conf.ServerSpec; spec != nil {
+		t.serverConnIDLen = spec.ConnIDLength
+	}
 	if err := t.init(false); err != nil {
 		return nil, err
 	}
+	if err := validateServerSpec(conf.ServerSpec, t.connIDLen); err != nil { // [UQUIC]
+		return nil, err
+	}
+	if err := validatePreferredAddress(conf.PreferredAddress, t.connIDLen); err != nil { // [UQUIC]
+		return nil, err
+	}
 	maxTokenAge := t.MaxTokenAge
 	if maxTokenAge == 0 {
 		maxTokenAge = 24 * time.Hour
@@ -408,7 +424,10 @@ func (t *Transport) init(allowZeroLengthConnIDs bool) error {
 			t.connIDLen = t.ConnectionIDGenerator.ConnectionIDLen()
 		} else {
 			connIDLen := t.ConnectionIDLength
-			if t.ConnectionIDLength == 0 && !allowZeroLengthConnIDs {
+			if connIDLen == 0 {
+				connIDLen = t.serverConnIDLen // [UQUIC]
+			}
+			if connIDLen == 0 && !allowZeroLengthConnIDs {
 				connIDLen = protocol.DefaultConnectionIDLength
 			}
 			t.connIDLen = connIDLen
@@ -517,6 +536,14 @@ func (t *Transport) close(e error) {
 			wg.Done()
 		}(handler)
 	}
+	// [UQUIC]
+	for _, handler := range t.uZeroLenHandlers {
+		wg.Add(1)
+		go func(handler packetHandler) {
+			handler.destroy(e)
+			wg.Done()
+		}(handler)
+	}
 	t.mutex.Unlock() // closing connections requires releasing transport mutex
 	wg.Wait()
 
@@ -589,6 +616,11 @@ func (t *Transport) handlePacket(p receivedPacket) {
 		handler.handlePacket(p)
 		return
 	}
+	// [UQUIC] connections dialed with a QUICSpec may use connection IDs of a different length
+	if handler, ok := (*packetHandlerMap)(t).getUConn(p); ok {
+		handler.handlePacket(p)
+		return
+	}
 	// RFC 9000 section 10.3.1 requires that the stateless reset detection logic is run for both
 	// packets that cannot be associated with any connections, and for packets that can't be decrypted.
 	// We deviate from the RFC and ignore the latter: If a packet's connection ID is associated with an
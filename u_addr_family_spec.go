@@ -0,0 +1,58 @@
+package quic
+
+import "net"
+
+// [UQUIC]
+// AddrFamilySpec overrides parts of a QUICSpec when dialing an address of a given
+// family. Clients pad their Initial packets such that the IP packet has a fixed size,
+// so the UDP payload is 20 bytes shorter over IPv6, whose header is 20 bytes longer.
+//
+// Zero values keep the value of the QUICSpec.
+type AddrFamilySpec struct {
+	// UDPDatagramMinSize overrides QUICSpec.UDPDatagramMinSize.
+	UDPDatagramMinSize int
+
+	// RandomFramesLength overrides the Length of the InitialPacketSpec's FrameBuilder,
+	// if it is a QUICRandomFrames, or of its Layout if it is a QUICScrambledFrames.
+	RandomFramesLength uint16
+}
+
+func (afs *AddrFamilySpec) apply(s *QUICSpec) {
+	if afs.UDPDatagramMinSize != 0 {
+		s.UDPDatagramMinSize = afs.UDPDatagramMinSize
+	}
+	if afs.RandomFramesLength != 0 {
+		if qrf, ok := s.InitialPacketSpec.FrameBuilder.(*QUICRandomFrames); ok {
+			frames := *qrf
+			frames.Length = afs.RandomFramesLength
+			s.InitialPacketSpec.FrameBuilder = &frames
+		}
+		if sf, ok := s.InitialPacketSpec.FrameBuilder.(*QUICScrambledFrames); ok && sf.Layout != nil {
+			frames := *sf
+			layout := *sf.Layout
+			layout.Length = afs.RandomFramesLength
+			frames.Layout = &layout
+			s.InitialPacketSpec.FrameBuilder = &frames
+		}
+	}
+}
+
+// forAddr returns the QUICSpec used for dialing addr, with the overrides for its
+// address family applied. The QUICSpec itself is never modified, since it may be
+// used for dialing addresses of both families concurrently.
+func (s *QUICSpec) forAddr(addr net.Addr) *QUICSpec {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return s
+	}
+	afs := s.IPv4
+	if udpAddr.IP.To4() == nil {
+		afs = s.IPv6
+	}
+	if afs == nil {
+		return s
+	}
+	spec := *s
+	afs.apply(&spec)
+	return &spec
+}
@@ -0,0 +1,74 @@
+package quic
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	tls "github.com/Noooste/utls"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQUICSpecForAddr(t *testing.T) {
+	spec, err := testQUICID2Spec(testQUICIDPQ)
+	require.NoError(t, err)
+	ipv6Spec, err := testQUICID2Spec(testQUICIDPQIPv6)
+	require.NoError(t, err)
+	require.Nil(t, ipv6Spec.IPv6)
+
+	require.Same(t, &spec, spec.forAddr(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1)}))
+	// IPv4-mapped IPv6 addresses are IPv4 addresses, and non-UDP addresses are ignored
+	require.Same(t, &spec, spec.forAddr(&net.UDPAddr{IP: net.ParseIP("::ffff:192.0.2.1")}))
+	require.Same(t, &spec, spec.forAddr(&net.TCPAddr{IP: net.ParseIP("2001:db8::1")}))
+	s := spec.forAddr(&net.UDPAddr{IP: net.ParseIP("2001:db8::1")})
+	require.Equal(t, ipv6Spec.UDPDatagramMinSize, s.UDPDatagramMinSize)
+	require.Equal(t, ipv6Spec.InitialPacketSpec.FrameBuilder, s.InitialPacketSpec.FrameBuilder)
+	// the QUICSpec is not modified
+	require.Equal(t, 1250, spec.UDPDatagramMinSize)
+	require.Equal(t, uint16(1231-16), spec.InitialPacketSpec.FrameBuilder.(*QUICRandomFrames).Length)
+
+	// the Layout of a QUICScrambledFrames
+	layout := spec.InitialPacketSpec.FrameBuilder.(*QUICRandomFrames)
+	spec.InitialPacketSpec.FrameBuilder = &QUICScrambledFrames{SplitSNI: true, Layout: layout}
+	s = spec.forAddr(&net.UDPAddr{IP: net.ParseIP("2001:db8::1")})
+	require.Equal(t, ipv6Spec.InitialPacketSpec.FrameBuilder, s.InitialPacketSpec.FrameBuilder.(*QUICScrambledFrames).Layout)
+	require.Equal(t, uint16(1231-16), layout.Length)
+}
+
+func TestUTransportAddrFamilySpec(t *testing.T) {
+	dryRun := func(t *testing.T, tr *UTransport, addr net.Addr) *DryRunResult {
+		t.Helper()
+		res, err := tr.DryRun(
+			context.Background(),
+			addr,
+			&tls.Config{ServerName: "example.com", NextProtos: []string{"h3"}},
+			nil,
+		)
+		require.NoError(t, err)
+		require.Len(t, res.Datagrams, 1)
+		return res
+	}
+	ipv4Addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 443}
+	ipv6Addr := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}
+
+	t.Run("padding", func(t *testing.T) {
+		spec, err := QUICID2Spec(QUICChrome_115)
+		require.NoError(t, err)
+		tr := newUTransportWithSpecForTest(t, &spec)
+		ipv4Packet := dryRun(t, tr, ipv4Addr).Datagrams[0].Packets[0]
+		ipv6Packet := dryRun(t, tr, ipv6Addr).Datagrams[0].Packets[0]
+		require.Equal(t, ipv4Packet.Length-20, ipv6Packet.Length)
+	})
+
+	t.Run("UDP datagram size", func(t *testing.T) {
+		spec, err := QUICID2Spec(QUICChrome_115)
+		require.NoError(t, err)
+		spec.IPv4 = &AddrFamilySpec{UDPDatagramMinSize: 1300}
+		spec.IPv6 = &AddrFamilySpec{UDPDatagramMinSize: 1280}
+		// a single UTransport dials addresses of both families
+		tr := newUTransportWithSpecForTest(t, &spec)
+		require.Equal(t, 1300, dryRun(t, tr, ipv4Addr).Datagrams[0].Size)
+		require.Equal(t, 1280, dryRun(t, tr, ipv6Addr).Datagrams[0].Size)
+	})
+}
@@ -0,0 +1,272 @@
+package quic
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Noooste/uquic-go/internal/protocol"
+	"github.com/Noooste/uquic-go/internal/wire"
+	"github.com/Noooste/uquic-go/quicvarint"
+	"github.com/gaukas/clienthellod"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+const extTypeQUICTransportParameters = 0x39
+
+// [UQUIC]
+// ClientFingerprint describes how a client built the Initial packets carrying its
+// ClientHello, see Config.EnableClientFingerprint. Clients parroting a browser with a
+// QUICSpec may reproduce its ClientHello, but often differ in the QUIC layer: the
+// length of the connection IDs, the frames of the Initial packets, the padding, or the
+// order of the transport parameters.
+//
+// The fields describing the first Initial packet refer to the packet carrying the
+// beginning of the ClientHello, and are zero until it is received.
+type ClientFingerprint struct {
+	// Version is the QUIC version of the first Initial packet.
+	Version Version
+	// DestConnIDLen and SrcConnIDLen are the lengths of the connection IDs of the
+	// first Initial packet.
+	DestConnIDLen, SrcConnIDLen int
+	// TokenLen is the length of the token of the first Initial packet.
+	TokenLen int
+	// PacketNumber and PacketNumberLen are the packet number of the first Initial
+	// packet, and the length of its encoding.
+	PacketNumber    int64
+	PacketNumberLen int
+	// DatagramSize is the size of the UDP payload carrying the first Initial packet,
+	// including any padding after the QUIC packets.
+	DatagramSize int
+	// PayloadLen is the length of the decrypted payload of the first Initial packet.
+	PayloadLen int
+	// Frames are the frames of the first Initial packet, in the order they were sent.
+	// Consecutive PADDING bytes are reported as a single PADDING frame.
+	Frames QUICFrames
+
+	// InitialPackets is the number of Initial packets received from the client, up to
+	// the one completing the ClientHello.
+	InitialPackets int
+	// ClientHello is the ClientHello handshake message. Until ClientHelloComplete is
+	// set, it is the part of the ClientHello received so far, if any.
+	ClientHello         []byte
+	ClientHelloComplete bool
+	// TransportParameters are the IDs of the transport parameters sent by the client,
+	// in the order they were sent. It is nil if the quic_transport_parameters extension
+	// wasn't received yet.
+	TransportParameters []uint64
+
+	// JA4 is the JA4 fingerprint of the ClientHello, using the "q" prefix for QUIC.
+	// It is empty until the ClientHello is complete.
+	JA4 string
+	// QUICHash is a JA4-style fingerprint of the QUIC layer, "a_b", where a describes
+	// the first Initial packet (the version, the lengths of the connection IDs and of
+	// the packet number, the presence of a token, and the size of the UDP datagram),
+	// e.g. "q0108001n1250" for Chrome, and b is a truncated SHA-256 hash of the IDs of
+	// the transport parameters in the order they were sent, GREASE IDs included as
+	// "grease". It is empty until the first Initial packet and the transport parameters
+	// are received.
+	QUICHash string
+}
+
+// clientFingerprinter builds the ClientFingerprint of a client from the datagrams
+// carrying its Initial packets.
+type clientFingerprinter struct {
+	capture initialCapture
+	// skipFirst is set until the connection received the datagram that was already
+	// added when the server accepted the connection
+	skipFirst bool
+	done      bool
+}
+
+// newClientFingerprinter creates a clientFingerprinter for a connection accepted
+// when receiving datagram.
+func newClientFingerprinter(datagram []byte) *clientFingerprinter {
+	// The client keeps using the Initial keys derived from the original Destination
+	// Connection ID after switching to the connection ID chosen by the server.
+	f := &clientFingerprinter{capture: initialCapture{anyDestConnID: true}, skipFirst: true}
+	f.add(datagram)
+	return f
+}
+
+// add adds a datagram received from the client. It returns true if the fingerprint
+// changed.
+func (f *clientFingerprinter) add(datagram []byte) bool {
+	if f.done || len(datagram) == 0 || !wire.IsLongHeaderPacket(datagram[0]) {
+		return false
+	}
+	numPackets := f.capture.numPackets
+	// The fingerprint is left incomplete if an Initial packet can't be parsed, e.g.
+	// because it acknowledges packets of the server.
+	if err := f.capture.add(datagram); err != nil || f.capture.complete() {
+		f.done = true
+	}
+	return f.capture.numPackets != numPackets
+}
+
+func (f *clientFingerprinter) fingerprint() *ClientFingerprint {
+	return f.capture.fingerprint()
+}
+
+// fingerprint returns the ClientFingerprint of the client that sent the Initial
+// packets added so far.
+func (c *initialCapture) fingerprint() *ClientFingerprint {
+	fp := &ClientFingerprint{
+		InitialPackets:      c.numPackets,
+		ClientHelloComplete: c.complete(),
+	}
+	if hdr := c.firstHdr; hdr != nil {
+		fp.Version = hdr.Version
+		fp.DestConnIDLen = hdr.DestConnectionID.Len()
+		fp.SrcConnIDLen = hdr.SrcConnectionID.Len()
+		fp.TokenLen = len(hdr.Token)
+		fp.PacketNumber = int64(hdr.PacketNumber)
+		fp.PacketNumberLen = int(hdr.PacketNumberLen)
+		fp.DatagramSize = c.firstDatagramSize
+		fp.PayloadLen = c.firstPayloadLen
+		fp.Frames = dryRunFrames(c.firstFrames)
+	}
+	if fp.ClientHelloComplete {
+		fp.ClientHello = c.clientHello
+		fp.JA4, _ = ja4(c.clientHello, 'q')
+	} else {
+		fp.ClientHello = cryptoDataPrefix(c.cryptoFrames)
+	}
+	fp.TransportParameters = transportParameterIDs(fp.ClientHello)
+	if c.firstHdr != nil && fp.TransportParameters != nil {
+		fp.QUICHash = quicHash(fp)
+	}
+	return fp
+}
+
+// cryptoDataPrefix returns the crypto data carried by the CRYPTO frames, from offset 0
+// up to the first gap.
+func cryptoDataPrefix(frames []clienthellod.Frame) []byte {
+	cryptoFrames := make([]*clienthellod.CRYPTO, 0, len(frames))
+	for _, frame := range frames {
+		if cf, ok := frame.(*clienthellod.CRYPTO); ok {
+			cryptoFrames = append(cryptoFrames, cf)
+		}
+	}
+	slices.SortFunc(cryptoFrames, func(a, b *clienthellod.CRYPTO) int { return cmp.Compare(a.Offset, b.Offset) })
+	var data []byte
+	for _, cf := range cryptoFrames {
+		if cf.Offset > uint64(len(data)) {
+			break
+		}
+		if end := cf.Offset + uint64(len(cf.Data)); end > uint64(len(data)) {
+			data = append(data, cf.Data[uint64(len(data))-cf.Offset:]...)
+		}
+	}
+	return data
+}
+
+// transportParameterIDs returns the IDs of the transport parameters of a ClientHello,
+// in the order they were sent. The ClientHello may be truncated, it returns nil if it
+// doesn't contain the entire quic_transport_parameters extension.
+func transportParameterIDs(clientHello []byte) []uint64 {
+	s := cryptobyte.String(clientHello)
+	var (
+		msgType, legacySessionIDLen uint8
+		msgLen                      uint32
+		cipherSuites, compression   cryptobyte.String
+	)
+	if !s.ReadUint8(&msgType) || msgType != 1 || // ClientHello
+		!s.ReadUint24(&msgLen) ||
+		!s.Skip(2+32) || // legacy_version and random
+		!s.ReadUint8(&legacySessionIDLen) || !s.Skip(int(legacySessionIDLen)) ||
+		!s.ReadUint16LengthPrefixed(&cipherSuites) ||
+		!s.ReadUint8LengthPrefixed(&compression) ||
+		!s.Skip(2) { // length of the extensions
+		return nil
+	}
+	for {
+		var extType uint16
+		var ext cryptobyte.String
+		if !s.ReadUint16(&extType) || !s.ReadUint16LengthPrefixed(&ext) {
+			return nil
+		}
+		if extType != extTypeQUICTransportParameters {
+			continue
+		}
+		ids := []uint64{}
+		b := []byte(ext)
+		for len(b) > 0 {
+			id, l, err := quicvarint.Parse(b)
+			if err != nil {
+				return ids
+			}
+			b = b[l:]
+			length, l, err := quicvarint.Parse(b)
+			if err != nil || uint64(len(b)-l) < length {
+				return ids
+			}
+			b = b[uint64(l)+length:]
+			ids = append(ids, id)
+		}
+		return ids
+	}
+}
+
+// quicHash computes the QUICHash of a ClientFingerprint.
+func quicHash(fp *ClientFingerprint) string {
+	var b strings.Builder
+	b.WriteByte('q')
+	switch fp.Version {
+	case protocol.Version1:
+		b.WriteString("01")
+	case protocol.Version2:
+		b.WriteString("02")
+	default:
+		b.WriteString("00")
+	}
+	fmt.Fprintf(&b, "%02d%02d%d", fp.DestConnIDLen, fp.SrcConnIDLen, fp.PacketNumberLen)
+	if fp.TokenLen > 0 {
+		b.WriteByte('t')
+	} else {
+		b.WriteByte('n')
+	}
+	fmt.Fprintf(&b, "%04d", min(fp.DatagramSize, 9999))
+
+	ids := make([]string, 0, len(fp.TransportParameters))
+	for _, id := range fp.TransportParameters {
+		if id >= 27 && (id-27)%31 == 0 {
+			ids = append(ids, "grease")
+		} else {
+			ids = append(ids, fmt.Sprintf("%04x", id))
+		}
+	}
+	b.WriteByte('_')
+	b.WriteString(ja4Hash(strings.Join(ids, ",")))
+	return b.String()
+}
+
+// [UQUIC]
+// setClientFingerprinter makes the server's connection complete the ClientFingerprint
+// with the Initial packets it receives.
+func (c *Conn) setClientFingerprinter(f *clientFingerprinter) {
+	c.connState.ClientFingerprint = f.fingerprint()
+	if !f.done {
+		c.clientFingerprinter = f
+	}
+}
+
+// [UQUIC]
+// updateClientFingerprint adds a datagram received by the server to the ClientFingerprint.
+func (c *Conn) updateClientFingerprint(datagram []byte) {
+	f := c.clientFingerprinter
+	if f.skipFirst {
+		f.skipFirst = false
+		return
+	}
+	if f.add(datagram) {
+		fp := f.fingerprint()
+		c.connStateMutex.Lock()
+		c.connState.ClientFingerprint = fp
+		c.connStateMutex.Unlock()
+	}
+	if f.done {
+		c.clientFingerprinter = nil
+	}
+}
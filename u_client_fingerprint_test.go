@@ -0,0 +1,172 @@
+package quic
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/testdata"
+	tls "github.com/Noooste/utls"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fingerprintTestServer struct {
+	ln          *Listener
+	clientInfos chan *ClientInfo
+	accepted    chan *Conn
+}
+
+func newFingerprintTestServer(t *testing.T, enable bool) *fingerprintTestServer {
+	tlsConf := testdata.GetTLSConfig()
+	tlsConf.NextProtos = []string{"h3"}
+	s := &fingerprintTestServer{clientInfos: make(chan *ClientInfo, 1), accepted: make(chan *Conn, 1)}
+	ln, err := ListenAddr("127.0.0.1:0", tlsConf, &Config{
+		EnableClientFingerprint: enable,
+		GetConfigForClient: func(info *ClientInfo) (*Config, error) {
+			s.clientInfos <- info
+			return nil, nil
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	s.ln = ln
+	go func() {
+		for {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			s.accepted <- conn
+		}
+	}()
+	return s
+}
+
+// dial dials the server, and returns the ClientInfo and the connection accepted by the
+// server, and the datagrams sent by the client.
+func (s *fingerprintTestServer) dial(t *testing.T, id QUICID) (*ClientInfo, *Conn, [][]byte) {
+	t.Helper()
+	tr := newUTransportForTest(t, id)
+	recorder := newInitialRecorder(t, s.ln.Addr())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := tr.Dial(
+		ctx,
+		recorder.conn.LocalAddr(),
+		&tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA(), NextProtos: []string{"h3"}},
+		nil,
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.CloseWithError(0, "") })
+
+	var info *ClientInfo
+	select {
+	case info = <-s.clientInfos:
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	select {
+	case serverConn := <-s.accepted:
+		return info, serverConn, recorder.clientDatagrams()
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	return nil, nil, nil
+}
+
+func TestClientFingerprint(t *testing.T) {
+	s := newFingerprintTestServer(t, true)
+
+	t.Run("ClientHello spanning two packets", func(t *testing.T) {
+		info, serverConn, datagrams := s.dial(t, testQUICIDPQ)
+
+		// the ClientInfo only describes the first Initial packet
+		fp := info.ClientFingerprint
+		require.NotNil(t, fp)
+		require.Equal(t, Version1, fp.Version)
+		require.Equal(t, 8, fp.DestConnIDLen)
+		require.Zero(t, fp.SrcConnIDLen)
+		require.Zero(t, fp.TokenLen)
+		require.Equal(t, len(datagrams[0]), fp.DatagramSize)
+		require.NotEmpty(t, fp.Frames)
+		require.Equal(t, 1, fp.InitialPackets)
+		require.False(t, fp.ClientHelloComplete)
+		require.Empty(t, fp.JA4)
+
+		// the ConnectionState is completed with the second Initial packet
+		clientHello := reassembleClientHello(t, datagrams)
+		complete := serverConn.ConnectionState().ClientFingerprint
+		require.NotNil(t, complete)
+		require.True(t, complete.ClientHelloComplete)
+		require.Equal(t, 2, complete.InitialPackets)
+		require.Equal(t, clientHello, complete.ClientHello)
+		expectedJA4, err := ja4(clientHello, 'q')
+		require.NoError(t, err)
+		require.Equal(t, expectedJA4, complete.JA4)
+		require.Equal(t, fp.Frames, complete.Frames)
+		require.Equal(t, transportParameterIDs(clientHello), complete.TransportParameters)
+		require.NotEmpty(t, complete.TransportParameters)
+		require.True(t, strings.HasPrefix(complete.QUICHash, "q0108001n"), complete.QUICHash)
+	})
+
+	t.Run("ClientHello in a single packet", func(t *testing.T) {
+		info, serverConn, datagrams := s.dial(t, QUICFirefox_116)
+
+		fp := info.ClientFingerprint
+		require.NotNil(t, fp)
+		require.True(t, fp.ClientHelloComplete)
+		require.Equal(t, 1, fp.InitialPackets)
+		require.Equal(t, reassembleClientHello(t, datagrams), fp.ClientHello)
+		require.NotEmpty(t, fp.JA4)
+		require.NotEmpty(t, fp.QUICHash)
+		require.Equal(t, fp, serverConn.ConnectionState().ClientFingerprint)
+	})
+}
+
+func TestClientFingerprintDisabled(t *testing.T) {
+	s := newFingerprintTestServer(t, false)
+	info, serverConn, _ := s.dial(t, testQUICIDPQ)
+	require.Nil(t, info.ClientFingerprint)
+	require.Nil(t, serverConn.ConnectionState().ClientFingerprint)
+}
+
+func TestClientFingerprintTransportParameters(t *testing.T) {
+	spec, err := testQUICID2Spec(testQUICIDPQ)
+	require.NoError(t, err)
+	res, err := spec.DryRun(
+		context.Background(),
+		&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 443},
+		&tls.Config{ServerName: "localhost"},
+		nil,
+	)
+	require.NoError(t, err)
+	clientHello := res.ClientHello
+
+	ids := transportParameterIDs(clientHello)
+	require.NotEmpty(t, ids)
+	// a truncated ClientHello only yields transport parameters if it contains the entire extension
+	for l := len(clientHello) - 1; l >= 0; l-- {
+		if truncated := transportParameterIDs(clientHello[:l]); truncated != nil {
+			require.Equal(t, ids, truncated)
+		}
+	}
+	require.Nil(t, transportParameterIDs(clientHello[:50]))
+	require.Nil(t, transportParameterIDs(nil))
+
+	fp := &ClientFingerprint{
+		Version:             Version1,
+		DestConnIDLen:       8,
+		PacketNumberLen:     1,
+		DatagramSize:        1250,
+		TransportParameters: []uint64{0x1, 27 + 31*3, 0x4},
+	}
+	hash := quicHash(fp)
+	require.Equal(t, "q0108001n1250_"+ja4Hash("0001,grease,0004"), hash)
+	fp.TransportParameters = []uint64{0x1, 27 + 31*5, 0x4}
+	require.Equal(t, hash, quicHash(fp))
+	fp.TokenLen = 10
+	require.True(t, strings.HasPrefix(quicHash(fp), "q0108001t1250_"))
+}
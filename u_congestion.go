@@ -0,0 +1,211 @@
+package quic
+
+import (
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/ackhandler"
+	"github.com/Noooste/uquic-go/internal/congestion"
+	"github.com/Noooste/uquic-go/internal/monotime"
+	"github.com/Noooste/uquic-go/internal/protocol"
+	"github.com/Noooste/uquic-go/internal/utils"
+)
+
+// [UQUIC]
+// A ByteCount is a number of bytes.
+type ByteCount = protocol.ByteCount
+
+// [UQUIC]
+// A PacketNumber is the number of a QUIC packet.
+type PacketNumber = protocol.PacketNumber
+
+// [UQUIC]
+// A CongestionController performs congestion control and pacing for a connection, see
+// Config.CongestionControl. Its methods are called from the connection's run loop, and
+// are never called concurrently.
+type CongestionController interface {
+	// TimeUntilSend returns when the next packet may be sent by the pacer. The zero
+	// time.Time allows sending immediately.
+	TimeUntilSend(bytesInFlight ByteCount) time.Time
+	// HasPacingBudget says if the pacer allows sending a packet at now.
+	HasPacingBudget(now time.Time) bool
+	// OnPacketSent is called for every packet sent. Only retransmittable packets count
+	// towards the bytes in flight.
+	OnPacketSent(sentTime time.Time, bytesInFlight ByteCount, packetNumber PacketNumber, bytes ByteCount, isRetransmittable bool)
+	// CanSend says if the congestion window allows sending more data.
+	CanSend(bytesInFlight ByteCount) bool
+	// MaybeExitSlowStart is called when an ACK is received, before the acknowledged
+	// packets are passed to OnPacketAcked.
+	MaybeExitSlowStart()
+	// OnPacketAcked is called for every packet acknowledged.
+	OnPacketAcked(number PacketNumber, ackedBytes ByteCount, priorInFlight ByteCount, eventTime time.Time)
+	// OnCongestionEvent is called for every packet declared lost, and with zero lostBytes
+	// when the peer reports ECN congestion marks.
+	OnCongestionEvent(number PacketNumber, lostBytes ByteCount, priorInFlight ByteCount)
+	// OnRetransmissionTimeout is called when the retransmission timer fires.
+	OnRetransmissionTimeout(packetsRetransmitted bool)
+	// SetMaxDatagramSize is called when path MTU discovery increases the size of the
+	// datagrams.
+	SetMaxDatagramSize(ByteCount)
+	// GetCongestionWindow returns the congestion window, used for logging and qlog.
+	GetCongestionWindow() ByteCount
+}
+
+// [UQUIC]
+// RTTStats provides the RTT measurements of a connection to a CongestionController.
+type RTTStats interface {
+	// MinRTT returns the minimum RTT observed, or 0 if there was no measurement yet.
+	MinRTT() time.Duration
+	// LatestRTT returns the most recent RTT sample.
+	LatestRTT() time.Duration
+	// SmoothedRTT returns the smoothed RTT, see RFC 9002, section 5.3.
+	SmoothedRTT() time.Duration
+	// MeanDeviation returns the RTT variation.
+	MeanDeviation() time.Duration
+	// MaxAckDelay returns the max_ack_delay of the peer.
+	MaxAckDelay() time.Duration
+}
+
+// [UQUIC]
+// A CongestionClock returns the current time.
+type CongestionClock interface {
+	Now() time.Time
+}
+
+// [UQUIC]
+// A CongestionControllerFactory creates the CongestionController of a connection. It is
+// called when the connection is created, and again when the connection migrates to a new
+// path, since the congestion state of a path doesn't apply to another one.
+// initialMaxDatagramSize is the size of the datagrams until path MTU discovery increases
+// it, see CongestionController.SetMaxDatagramSize.
+type CongestionControllerFactory func(rttStats RTTStats, initialMaxDatagramSize ByteCount, clock CongestionClock) CongestionController
+
+type congestionClock struct{}
+
+func (congestionClock) Now() time.Time { return time.Now() }
+
+// congestionController adapts a CongestionController to the internal interface.
+type congestionController struct {
+	cc CongestionController
+}
+
+var _ congestion.SendAlgorithmWithDebugInfos = &congestionController{}
+
+func (c *congestionController) TimeUntilSend(bytesInFlight protocol.ByteCount) monotime.Time {
+	return monotime.FromTime(c.cc.TimeUntilSend(bytesInFlight))
+}
+
+func (c *congestionController) HasPacingBudget(now monotime.Time) bool {
+	return c.cc.HasPacingBudget(now.ToTime())
+}
+
+func (c *congestionController) OnPacketSent(sentTime monotime.Time, bytesInFlight protocol.ByteCount, pn protocol.PacketNumber, bytes protocol.ByteCount, isRetransmittable bool) {
+	c.cc.OnPacketSent(sentTime.ToTime(), bytesInFlight, pn, bytes, isRetransmittable)
+}
+
+func (c *congestionController) CanSend(bytesInFlight protocol.ByteCount) bool {
+	return c.cc.CanSend(bytesInFlight)
+}
+
+func (c *congestionController) MaybeExitSlowStart() { c.cc.MaybeExitSlowStart() }
+
+func (c *congestionController) OnPacketAcked(pn protocol.PacketNumber, ackedBytes, priorInFlight protocol.ByteCount, eventTime monotime.Time) {
+	c.cc.OnPacketAcked(pn, ackedBytes, priorInFlight, eventTime.ToTime())
+}
+
+func (c *congestionController) OnCongestionEvent(pn protocol.PacketNumber, lostBytes, priorInFlight protocol.ByteCount) {
+	c.cc.OnCongestionEvent(pn, lostBytes, priorInFlight)
+}
+
+func (c *congestionController) OnRetransmissionTimeout(packetsRetransmitted bool) {
+	c.cc.OnRetransmissionTimeout(packetsRetransmitted)
+}
+
+func (c *congestionController) SetMaxDatagramSize(s protocol.ByteCount) { c.cc.SetMaxDatagramSize(s) }
+
+func (c *congestionController) GetCongestionWindow() protocol.ByteCount {
+	return c.cc.GetCongestionWindow()
+}
+
+// InSlowStart and InRecovery are only used for debugging.
+func (c *congestionController) InSlowStart() bool { return false }
+func (c *congestionController) InRecovery() bool  { return false }
+
+// [UQUIC]
+// setCongestionControl makes the connection use the congestion controllers created by
+// the CongestionControllerFactory of the Config, if any.
+func (c *Conn) setCongestionControl() {
+	factory := c.config.CongestionControl
+	if factory == nil {
+		return
+	}
+	ackhandler.SetCongestionControl(
+		c.sentPacketHandler,
+		protocol.ByteCount(c.config.InitialPacketSize),
+		func(initialMaxDatagramSize protocol.ByteCount) congestion.SendAlgorithmWithDebugInfos {
+			cc := factory(c.rttStats, initialMaxDatagramSize, congestionClock{})
+			// use the BBR sender directly, so that it receives the delivery rate samples
+			if b, ok := cc.(*bbrController); ok {
+				return b.sender
+			}
+			return &congestionController{cc: cc}
+		},
+	)
+}
+
+var _ RTTStats = &utils.RTTStats{}
+
+// [UQUIC]
+// NewBBR creates a CongestionController implementing BBRv3. It is a
+// CongestionControllerFactory, used by setting Config.CongestionControl to NewBBR.
+//
+// Unlike the Cubic sender, BBR doesn't reduce its sending rate on random packet loss,
+// and achieves a higher throughput on lossy paths with a large bandwidth-delay product.
+func NewBBR(rttStats RTTStats, initialMaxDatagramSize ByteCount, clock CongestionClock) CongestionController {
+	return &bbrController{
+		sender: congestion.NewBBRSender(bbrClock{clock}, rttStats, initialMaxDatagramSize),
+	}
+}
+
+var _ CongestionControllerFactory = NewBBR
+
+type bbrClock struct{ clock CongestionClock }
+
+func (c bbrClock) Now() monotime.Time { return monotime.FromTime(c.clock.Now()) }
+
+// bbrController exposes the BBR sender as a CongestionController. Connections use the
+// BBR sender directly, see setCongestionControl.
+type bbrController struct {
+	sender congestion.SendAlgorithmWithDebugInfos
+}
+
+var _ CongestionController = &bbrController{}
+
+func (b *bbrController) TimeUntilSend(bytesInFlight ByteCount) time.Time {
+	return b.sender.TimeUntilSend(bytesInFlight).ToTime()
+}
+
+func (b *bbrController) HasPacingBudget(now time.Time) bool {
+	return b.sender.HasPacingBudget(monotime.FromTime(now))
+}
+
+func (b *bbrController) OnPacketSent(sentTime time.Time, bytesInFlight ByteCount, pn PacketNumber, bytes ByteCount, isRetransmittable bool) {
+	b.sender.OnPacketSent(monotime.FromTime(sentTime), bytesInFlight, pn, bytes, isRetransmittable)
+}
+
+func (b *bbrController) CanSend(bytesInFlight ByteCount) bool { return b.sender.CanSend(bytesInFlight) }
+func (b *bbrController) MaybeExitSlowStart()                  { b.sender.MaybeExitSlowStart() }
+
+func (b *bbrController) OnPacketAcked(pn PacketNumber, ackedBytes, priorInFlight ByteCount, eventTime time.Time) {
+	b.sender.OnPacketAcked(pn, ackedBytes, priorInFlight, monotime.FromTime(eventTime))
+}
+
+func (b *bbrController) OnCongestionEvent(pn PacketNumber, lostBytes, priorInFlight ByteCount) {
+	b.sender.OnCongestionEvent(pn, lostBytes, priorInFlight)
+}
+
+func (b *bbrController) OnRetransmissionTimeout(packetsRetransmitted bool) {
+	b.sender.OnRetransmissionTimeout(packetsRetransmitted)
+}
+
+func (b *bbrController) SetMaxDatagramSize(s ByteCount) { b.sender.SetMaxDatagramSize(s) }
+func (b *bbrController) GetCongestionWindow() ByteCount { return b.sender.GetCongestionWindow() }
@@ -0,0 +1,217 @@
+package quic
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/protocol"
+	"github.com/Noooste/uquic-go/internal/synctest"
+	"github.com/Noooste/uquic-go/internal/testdata"
+	"github.com/Noooste/uquic-go/testutils/simnet"
+	tls "github.com/Noooste/utls"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fixedWindowController is a CongestionController with a fixed congestion window,
+// and without pacing.
+type fixedWindowController struct {
+	window ByteCount
+
+	mx             sync.Mutex
+	rttStats       RTTStats
+	maxInFlight    ByteCount
+	sent, acked    int
+	maxDatagramSet ByteCount
+}
+
+var _ CongestionController = &fixedWindowController{}
+
+func (c *fixedWindowController) TimeUntilSend(ByteCount) time.Time { return time.Time{} }
+func (c *fixedWindowController) HasPacingBudget(time.Time) bool    { return true }
+
+func (c *fixedWindowController) OnPacketSent(_ time.Time, bytesInFlight ByteCount, _ PacketNumber, bytes ByteCount, isRetransmittable bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.sent++
+	if isRetransmittable {
+		c.maxInFlight = max(c.maxInFlight, bytesInFlight+bytes)
+	}
+}
+
+func (c *fixedWindowController) CanSend(bytesInFlight ByteCount) bool {
+	return bytesInFlight < c.window
+}
+func (c *fixedWindowController) MaybeExitSlowStart() {}
+
+func (c *fixedWindowController) OnPacketAcked(PacketNumber, ByteCount, ByteCount, time.Time) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.acked++
+}
+
+func (c *fixedWindowController) OnCongestionEvent(PacketNumber, ByteCount, ByteCount) {}
+func (c *fixedWindowController) OnRetransmissionTimeout(bool)                         {}
+
+func (c *fixedWindowController) SetMaxDatagramSize(s ByteCount) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.maxDatagramSet = s
+}
+
+func (c *fixedWindowController) GetCongestionWindow() ByteCount { return c.window }
+
+func TestCongestionControl(t *testing.T) {
+	const window = 8 * 1400
+	var mx sync.Mutex
+	var controllers []*fixedWindowController
+	var initialMaxDatagramSizes []ByteCount
+	factory := func(rttStats RTTStats, initialMaxDatagramSize ByteCount, clock CongestionClock) CongestionController {
+		require.WithinDuration(t, time.Now(), clock.Now(), time.Second)
+		c := &fixedWindowController{window: window, rttStats: rttStats}
+		mx.Lock()
+		controllers = append(controllers, c)
+		initialMaxDatagramSizes = append(initialMaxDatagramSizes, initialMaxDatagramSize)
+		mx.Unlock()
+		return c
+	}
+
+	tlsConf := testdata.GetTLSConfig()
+	tlsConf.NextProtos = []string{"h3"}
+	ln, err := ListenAddr("127.0.0.1:0", tlsConf, &Config{CongestionControl: factory, DisablePathMTUDiscovery: true})
+	require.NoError(t, err)
+	defer ln.Close()
+
+	data := make([]byte, 1<<20)
+	go func() {
+		conn, err := ln.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		str, err := conn.OpenUniStream()
+		if err != nil {
+			return
+		}
+		str.Write(data)
+		str.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := DialAddr(
+		ctx,
+		ln.Addr().String(),
+		&tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA(), NextProtos: []string{"h3"}},
+		&Config{CongestionControl: factory},
+	)
+	require.NoError(t, err)
+	defer conn.CloseWithError(0, "")
+	str, err := conn.AcceptUniStream(ctx)
+	require.NoError(t, err)
+	received, err := io.ReadAll(str)
+	require.NoError(t, err)
+	require.Equal(t, data, received)
+
+	mx.Lock()
+	defer mx.Unlock()
+	// one controller for the client, one for the server
+	require.Len(t, controllers, 2)
+	require.Equal(t, []ByteCount{1280, 1280}, initialMaxDatagramSizes)
+	for _, c := range controllers {
+		c.mx.Lock()
+		require.NotZero(t, c.sent)
+		require.NotZero(t, c.acked)
+		require.NotZero(t, c.rttStats.SmoothedRTT())
+		c.mx.Unlock()
+	}
+	// The server sending the data is limited by the congestion window.
+	// Every packet sent below the window may exceed it, as may a probe packet.
+	server := controllers[0]
+	if server.sent < controllers[1].sent {
+		server = controllers[1]
+	}
+	server.mx.Lock()
+	defer server.mx.Unlock()
+	require.LessOrEqual(t, server.maxInFlight, ByteCount(window+2*protocol.MaxPacketBufferSize))
+}
+
+// measureThroughput downloads size bytes over a simulated 20 Mbps link with a RTT of 50ms
+// and random packet loss, and returns the throughput in bits per second.
+func measureThroughput(t *testing.T, size int, lossRate float64, cc CongestionControllerFactory) float64 {
+	var throughput float64
+	synctest.Test(t, func(t *testing.T) {
+		n := &simnet.Simnet{Router: &simnet.PerfectRouter{}}
+		settings := simnet.NodeBiDiLinkSettings{
+			Downlink: simnet.LinkSettings{BitsPerSecond: 20e6, LossRate: lossRate},
+			Uplink:   simnet.LinkSettings{BitsPerSecond: 20e6},
+			Latency:  25 * time.Millisecond,
+		}
+		clientPacketConn := n.NewEndpoint(&net.UDPAddr{IP: net.ParseIP("1.0.0.1"), Port: 9001}, settings)
+		serverPacketConn := n.NewEndpoint(&net.UDPAddr{IP: net.ParseIP("1.0.0.2"), Port: 9002}, settings)
+		require.NoError(t, n.Start())
+		defer n.Close()
+
+		serverTr := &Transport{Conn: serverPacketConn}
+		defer serverTr.Close()
+		tlsConf := testdata.GetTLSConfig()
+		tlsConf.NextProtos = []string{"h3"}
+		ln, err := serverTr.Listen(tlsConf, &Config{CongestionControl: cc})
+		require.NoError(t, err)
+		defer ln.Close()
+		go func() {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			str, err := conn.OpenUniStream()
+			if err != nil {
+				return
+			}
+			str.Write(make([]byte, size))
+			str.Close()
+		}()
+
+		clientTr := &Transport{Conn: clientPacketConn}
+		defer clientTr.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		conn, err := clientTr.Dial(
+			ctx,
+			serverPacketConn.LocalAddr(),
+			// the certificate is not valid yet at the start of the synctest bubble
+			&tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h3"}},
+			&Config{MaxIdleTimeout: time.Minute},
+		)
+		require.NoError(t, err)
+		defer conn.CloseWithError(0, "")
+		str, err := conn.AcceptUniStream(ctx)
+		require.NoError(t, err)
+		start := time.Now()
+		n2, err := io.Copy(io.Discard, str)
+		require.NoError(t, err)
+		require.Equal(t, int64(size), n2)
+		throughput = float64(size) * 8 / time.Since(start).Seconds()
+	})
+	return throughput
+}
+
+func TestBBRThroughput(t *testing.T) {
+	const size = 10 << 20
+	var renoThroughput, bbrThroughput float64
+	t.Run("Reno", func(t *testing.T) {
+		// the default congestion controller
+		renoThroughput = measureThroughput(t, size, 0.01, nil)
+		t.Logf("Reno: %.2f Mbps", renoThroughput/1e6)
+	})
+	t.Run("BBR", func(t *testing.T) {
+		bbrThroughput = measureThroughput(t, size, 0.01, NewBBR)
+		t.Logf("BBR: %.2f Mbps", bbrThroughput/1e6)
+	})
+	// BBR doesn't treat random packet loss as a congestion signal
+	require.Greater(t, bbrThroughput, 10e6)
+	require.Greater(t, bbrThroughput, 2*renoThroughput)
+}
@@ -0,0 +1,33 @@
+package quic
+
+import "github.com/Noooste/uquic-go/internal/protocol"
+
+// [UQUIC]
+// SetMaxIssuedConnIDs limits the number of connection IDs issued at the same time,
+// including the one used during the handshake. If 0, protocol.MaxIssuedConnectionIDs is used.
+func (m *connIDGenerator) SetMaxIssuedConnIDs(limit uint64) {
+	m.maxIssuedConnIDs = limit
+}
+
+// [UQUIC]
+func (m *connIDGenerator) maxIssued() uint64 {
+	if m.maxIssuedConnIDs > 0 {
+		return m.maxIssuedConnIDs
+	}
+	return protocol.MaxIssuedConnectionIDs
+}
+
+// [UQUIC]
+// IssuePreferredAddressConnID issues the connection ID sent in the preferred_address
+// transport parameter. It has the sequence number 1, and isn't sent in a
+// NEW_CONNECTION_ID frame. It must be called before SetMaxActiveConnIDs.
+func (m *connIDGenerator) IssuePreferredAddressConnID() (protocol.ConnectionID, protocol.StatelessResetToken, error) {
+	connID, err := m.generator.GenerateConnectionID()
+	if err != nil {
+		return protocol.ConnectionID{}, protocol.StatelessResetToken{}, err
+	}
+	m.highestSeq++
+	m.activeSrcConnIDs[m.highestSeq] = connID
+	m.connRunners.AddConnectionID(connID)
+	return connID, m.statelessResetter.GetStatelessResetToken(connID), nil
+}
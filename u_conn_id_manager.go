@@ -1,6 +1,17 @@
 package quic
 
+import "github.com/Noooste/uquic-go/internal/protocol"
+
 // [UQUIC]
 func (h *connIDManager) SetConnectionIDLimit(limit uint64) {
 	h.connectionIDLimit = limit
 }
+
+// [UQUIC]
+// activeConnectionIDLimit is the active_connection_id_limit sent to the peer.
+func (h *connIDManager) activeConnectionIDLimit() int {
+	if h.connectionIDLimit > 0 {
+		return int(h.connectionIDLimit)
+	}
+	return protocol.MaxActiveConnectionIDs
+}
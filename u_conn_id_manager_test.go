@@ -0,0 +1,47 @@
+package quic
+
+import (
+	"testing"
+
+	"github.com/Noooste/uquic-go/internal/protocol"
+	"github.com/Noooste/uquic-go/internal/qerr"
+	"github.com/Noooste/uquic-go/internal/wire"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnIDManagerActiveConnectionIDLimit(t *testing.T) {
+	// addConnIDs adds NEW_CONNECTION_ID frames until one is rejected, and returns how many were accepted
+	addConnIDs := func(t *testing.T, m *connIDManager) int {
+		t.Helper()
+		for i := range 100 {
+			err := m.Add(&wire.NewConnectionIDFrame{
+				SequenceNumber:      uint64(i + 1),
+				ConnectionID:        protocol.ParseConnectionID([]byte{byte(i + 1), 2, 3, 4}),
+				StatelessResetToken: protocol.StatelessResetToken{byte(i + 1)},
+			})
+			if err != nil {
+				require.Equal(t, &qerr.TransportError{ErrorCode: qerr.ConnectionIDLimitError}, err)
+				return i
+			}
+		}
+		t.Fatal("no NEW_CONNECTION_ID frame was rejected")
+		return 0
+	}
+	newManager := func() *connIDManager {
+		return newConnIDManager(
+			protocol.ParseConnectionID([]byte{1, 2, 3, 4}),
+			func(protocol.StatelessResetToken) {},
+			func(protocol.StatelessResetToken) {},
+			func(wire.Frame) {},
+		)
+	}
+
+	// the active connection ID counts towards the limit
+	require.Equal(t, protocol.MaxActiveConnectionIDs-1, addConnIDs(t, newManager()))
+
+	// the active_connection_id_limit of the QUICSpec, e.g. 8 for Firefox
+	m := newManager()
+	m.SetConnectionIDLimit(8)
+	require.Equal(t, 7, addConnIDs(t, m))
+}
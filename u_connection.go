@@ -2,6 +2,8 @@ package quic
 
 import (
 	"context"
+	"fmt"
+	"net"
 
 	"github.com/Noooste/uquic-go/internal/ackhandler"
 	"github.com/Noooste/uquic-go/internal/handshake"
@@ -30,7 +32,8 @@ var newUClientConnection = func(
 	logger utils.Logger,
 	v protocol.Version,
 	uSpec *QUICSpec, // [UQUIC]
-) *wrappedConn {
+	uRand *connRand, // [UQUIC]
+) (*wrappedConn, error) {
 	s := &wrappedConn{
 		Conn: &Conn{
 			conn:                conn,
@@ -67,8 +70,19 @@ var newUClientConnection = func(
 		s.queueControlFrame,
 		connIDGenerator,
 	)
+	switch r := runner.(type) {
+	case *packetHandlerMap:
+		s.transport = (*Transport)(r)
+	case *uConnRunner:
+		s.transport = (*Transport)(r.packetHandlerMap)
+	}
 	s.ctx, s.ctxCancel = context.WithCancelCause(ctx)
 	s.preSetup() // Creates s.receivedPacketHandler (via upstream preSetup at connection.go:558)
+	// [UQUIC] A uConnection always has a QUICSpec, whose FrameBuilder defines how the
+	// ClientHello is cut into CRYPTO frames: a single one if it is nil or empty, as sent
+	// by Firefox. quic-go's scrambling would break that layout, it is available as
+	// QUICScrambledFrames instead, so QUIC_GO_DISABLE_CLIENTHELLO_SCRAMBLING doesn't apply.
+	s.initialStream.scramble = false
 	s.sentPacketHandler = ackhandler.NewUSentPacketHandler(
 		initialPacketNumber,
 		protocol.ByteCount(s.config.InitialPacketSize),
@@ -83,19 +97,28 @@ var newUClientConnection = func(
 	)
 	s.currentMTUEstimate.Store(uint32(estimateMaxPayloadSize(protocol.ByteCount(s.config.InitialPacketSize))))
 	// [UQUIC]
+	s.setCongestionControl()
 	if uSpec.InitialPacketSpec.InitPacketNumberLength != 0 {
 		ackhandler.SetInitialPacketNumberLength(s.sentPacketHandler, uSpec.InitialPacketSpec.InitPacketNumberLength)
 	}
+	if uSpec.PostHandshakeSpec.DisablePacketNumberSkipping {
+		ackhandler.DisablePacketNumberSkipping(s.sentPacketHandler)
+	}
+	s.connIDGenerator.SetMaxIssuedConnIDs(uSpec.PostHandshakeSpec.maxIssuedConnIDs())
 
 	oneRTTStream := newCryptoStream()
 
 	var params *wire.TransportParameters
 
-	if uSpec.ClientHelloSpec != nil {
+	chs := uSpec.clientHelloSpecForConn(uRand, tlsConf.EncryptedClientHelloConfigList != nil) // [UQUIC]
+	if chs != nil {
+		// [UQUIC] the version_information transport parameter matches the version of the connection
+		s.compatibleVersions = applyVersionInformation(chs, v, s.config.Versions)
+
 		// iterate over all Extensions to set the TransportParameters
 		var tpSet bool
 	FOR_EACH_TLS_EXTENSION:
-		for _, ext := range uSpec.ClientHelloSpec.Extensions {
+		for _, ext := range chs.Extensions {
 			switch ext := ext.(type) {
 			case *tls.QUICTransportParametersExtension:
 				params = &wire.TransportParameters{
@@ -110,7 +133,7 @@ var newUClientConnection = func(
 			}
 		}
 		if !tpSet {
-			panic("applied ClientHelloSpec must contain a QUICTransportParametersExtension to proceed")
+			return nil, &QUICSpecError{Field: "ClientHelloSpec.Extensions", Err: ErrMissingTransportParameters}
 		}
 	} else {
 		// use default TransportParameters
@@ -142,7 +165,19 @@ var newUClientConnection = func(
 		}
 	}
 
-	cs := handshake.NewUCryptoSetupClient(
+	// [UQUIC] ACKs are delayed by up to the max_ack_delay announced in the ClientHello
+	maxAckDelay := uSpec.PostHandshakeSpec.MaxAckDelay
+	if maxAckDelay == 0 && chs != nil {
+		maxAckDelay = params.MaxAckDelay
+	}
+	s.receivedPacketHandler.SetAckPolicy(uSpec.PostHandshakeSpec.AckElicitingThreshold, maxAckDelay)
+
+	// [UQUIC] uTLS uses the randomness of the QUICSpec, unless the tls.Config has its own
+	if uSpec.Rand != nil && tlsConf.Rand == nil {
+		tlsConf = tlsConf.Clone()
+		tlsConf.Rand = tlsRand{r: uRand}
+	}
+	cs, err := handshake.NewUCryptoSetupClient(
 		destConnID,
 		params,
 		tlsConf,
@@ -151,8 +186,11 @@ var newUClientConnection = func(
 		s.qlogger,
 		logger,
 		s.version,
-		uSpec.ClientHelloSpec,
+		chs,
 	)
+	if err != nil {
+		return nil, &QUICSpecError{Field: "ClientHelloSpec", Err: fmt.Errorf("%w: %w", ErrInvalidClientHelloSpec, err)}
+	}
 	s.cryptoStreamHandler = cs
 	s.cryptoStreamManager = newCryptoStreamManager(s.initialStream, s.handshakeStream, oneRTTStream)
 	s.unpacker = newPacketUnpacker(cs, s.srcConnIDLen)
@@ -169,6 +207,7 @@ var newUClientConnection = func(
 			s.datagramQueue, s.perspective,
 		),
 		uSpec,
+		uRand,
 	)
 	if len(tlsConf.ServerName) > 0 {
 		s.tokenStoreKey = tlsConf.ServerName
@@ -180,5 +219,36 @@ var newUClientConnection = func(
 			s.packer.SetToken(token.data)
 		}
 	}
-	return s
+	return s, nil
+}
+
+// [UQUIC]
+type uPathRunnerKey struct {
+	t    *Transport
+	addr string
+}
+
+// pathConnRunner returns the connRunner for a path from the Transport to remoteAddr.
+// If the connection IDs of the connection don't have the length used by the Transport,
+// as for most connections dialed with a QUICSpec, its packets need to be routed by a
+// uConnRunner. The uConnRunner is reused when migrating back to a path, such that
+// connIDGenerator.AddConnRunner doesn't add it twice.
+// It must only be called from the run loop.
+func (c *Conn) pathConnRunner(t *Transport, remoteAddr net.Addr) connRunner {
+	if c.srcConnIDLen != 0 && c.srcConnIDLen == t.connIDLen {
+		return (*packetHandlerMap)(t)
+	}
+	if remoteAddr == nil {
+		remoteAddr = c.RemoteAddr()
+	}
+	key := uPathRunnerKey{t: t, addr: remoteAddr.String()}
+	if r, ok := c.uPathRunners[key]; ok {
+		return r
+	}
+	if c.uPathRunners == nil {
+		c.uPathRunners = make(map[uPathRunnerKey]*uConnRunner)
+	}
+	r := newUConnRunner((*packetHandlerMap)(t), remoteAddr)
+	c.uPathRunners[key] = r
+	return r
 }
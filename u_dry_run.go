@@ -0,0 +1,386 @@
+package quic
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	tls "github.com/Noooste/utls"
+	"github.com/gaukas/clienthellod"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// [UQUIC]
+// DryRunResult describes the first flight of packets sent when dialing with a QUICSpec,
+// i.e. all datagrams up to the one completing the ClientHello.
+type DryRunResult struct {
+	Datagrams []DryRunDatagram
+
+	// ClientHello is the ClientHello handshake message, reassembled from the CRYPTO frames.
+	ClientHello []byte
+
+	// JA4 is the JA4 fingerprint of the ClientHello, using the "q" prefix for QUIC.
+	JA4 string
+
+	// QUICHash is the JA4-style fingerprint of the QUIC layer, as computed by a server
+	// for ClientFingerprint.QUICHash. It covers the header of the first Initial packet,
+	// the size of its datagram and the order of the transport parameters. The frames
+	// may be picked randomly by the FrameBuilder, they are only listed in the Datagrams.
+	QUICHash string
+}
+
+// DryRunDatagram is a UDP datagram of the first flight.
+type DryRunDatagram struct {
+	// Size is the size of the UDP payload, including the padding after the QUIC packets.
+	Size int
+	// Packets are the (coalesced) QUIC packets in the datagram.
+	Packets []DryRunPacket
+}
+
+// DryRunPacket is a long header packet of the first flight.
+// Only Initial packets are decrypted: the packet number and the frames of other
+// packets are left empty.
+type DryRunPacket struct {
+	Type             string // "Initial", "0-RTT Protected", ...
+	Version          Version
+	DestConnectionID ConnectionID
+	SrcConnectionID  ConnectionID
+	Token            []byte
+	PacketNumber     int64
+	PacketNumberLen  int
+	// Length is the size of the packet, including the header and the AEAD tag.
+	Length int
+	// Frames are the decrypted frames. Each PADDING frame covers consecutive
+	// padding bytes.
+	Frames QUICFrames
+}
+
+var errDryRunDone = errors.New("dry run done")
+
+// DryRun builds the packets that would be sent when dialing addr using the QUICSpec,
+// without sending them. 0-RTT packets are built if tlsConf allows resuming a session
+// supporting early data, as with DialEarly.
+//
+// Tokens are read from the TokenStore, but not consumed.
+func (s *QUICSpec) DryRun(ctx context.Context, addr net.Addr, tlsConf *tls.Config, conf *Config) (*DryRunResult, error) {
+	return dryRun(ctx, *s, addr, tlsConf, conf)
+}
+
+// DryRun is like QUICSpec.DryRun, using the QUICSpec of the UTransport.
+// Nothing is sent on the UTransport's connection.
+func (t *UTransport) DryRun(ctx context.Context, addr net.Addr, tlsConf *tls.Config, conf *Config) (*DryRunResult, error) {
+	if t.QUICSpec == nil {
+		return nil, errors.New("DryRun requires a QUICSpec")
+	}
+	return dryRun(ctx, *t.QUICSpec, addr, tlsConf, conf)
+}
+
+func dryRun(ctx context.Context, spec QUICSpec, addr net.Addr, tlsConf *tls.Config, conf *Config) (*DryRunResult, error) {
+	if conf != nil {
+		conf = conf.Clone()
+	} else {
+		conf = &Config{}
+	}
+	if tokenStore := spec.InitialPacketSpec.getTokenStore(spec.rand()); tokenStore != nil {
+		conf.TokenStore = tokenStore
+	}
+	if conf.TokenStore != nil {
+		spec.InitialPacketSpec.TokenStore = &peekTokenStore{TokenStore: conf.TokenStore}
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	var (
+		mx sync.Mutex
+		c  initialCapture
+	)
+	pconn := newDryRunConn(addr, func(datagram []byte) {
+		mx.Lock()
+		defer mx.Unlock()
+		if c.complete() {
+			return
+		}
+		if err := c.add(datagram); err != nil {
+			cancel(err)
+		} else if c.complete() {
+			cancel(errDryRunDone)
+		}
+	})
+	tr := &UTransport{
+		Transport: &Transport{Conn: pconn},
+		QUICSpec:  &spec,
+	}
+	defer tr.Close()
+	defer pconn.Close() // unblocks the Transport's read loop
+
+	qconn, err := tr.DialEarly(ctx, addr, tlsConf, conf)
+	if err == nil { // 0-RTT
+		<-ctx.Done()
+		qconn.CloseWithError(0, "")
+		err = context.Cause(ctx)
+	}
+	if !errors.Is(err, errDryRunDone) {
+		return nil, err
+	}
+
+	mx.Lock()
+	defer mx.Unlock()
+	res := &DryRunResult{
+		Datagrams:   make([]DryRunDatagram, len(c.datagramSizes)),
+		ClientHello: c.clientHello,
+	}
+	for i, size := range c.datagramSizes {
+		res.Datagrams[i].Size = size
+	}
+	for _, p := range c.packets {
+		packet := DryRunPacket{
+			Type:             p.hdr.Type.String(),
+			Version:          p.hdr.Version,
+			DestConnectionID: p.hdr.DestConnectionID,
+			SrcConnectionID:  p.hdr.SrcConnectionID,
+			Token:            p.hdr.Token,
+			Length:           p.length,
+		}
+		if p.extHdr != nil {
+			packet.PacketNumber = int64(p.extHdr.PacketNumber)
+			packet.PacketNumberLen = int(p.extHdr.PacketNumberLen)
+			packet.Frames = dryRunFrames(p.frames)
+		}
+		res.Datagrams[p.datagram].Packets = append(res.Datagrams[p.datagram].Packets, packet)
+	}
+	res.JA4, err = ja4(c.clientHello, 'q')
+	if err != nil {
+		return nil, err
+	}
+	res.QUICHash = c.fingerprint().QUICHash
+	return res, nil
+}
+
+func dryRunFrames(frames []clienthellod.Frame) QUICFrames {
+	qfs := make(QUICFrames, 0, len(frames))
+	for _, frame := range frames {
+		switch frame := frame.(type) {
+		case *clienthellod.CRYPTO:
+			qfs = append(qfs, QUICFrameCrypto{Offset: int(frame.Offset), Length: int(frame.Length)})
+		case *clienthellod.PADDING:
+			qfs = append(qfs, QUICFramePadding{Length: int(frame.Length)})
+		case *clienthellod.PING:
+			qfs = append(qfs, QUICFramePing{})
+		}
+	}
+	return qfs
+}
+
+// peekTokenStore returns tokens without removing them from the underlying TokenStore.
+type peekTokenStore struct {
+	TokenStore
+}
+
+func (s *peekTokenStore) Pop(key string) *ClientToken {
+	token := s.TokenStore.Pop(key)
+	if token != nil {
+		s.TokenStore.Put(key, token)
+	}
+	return token
+}
+
+func (s *peekTokenStore) Put(string, *ClientToken) {}
+
+// dryRunConn is a net.PacketConn which never sends nor receives anything.
+type dryRunConn struct {
+	localAddr net.Addr
+	onWrite   func([]byte)
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+var _ net.PacketConn = &dryRunConn{}
+
+func newDryRunConn(remoteAddr net.Addr, onWrite func([]byte)) *dryRunConn {
+	localAddr := &net.UDPAddr{IP: net.IPv4zero}
+	if addr, ok := remoteAddr.(*net.UDPAddr); ok && addr.IP.To4() == nil {
+		localAddr.IP = net.IPv6zero
+	}
+	return &dryRunConn{localAddr: localAddr, onWrite: onWrite, closed: make(chan struct{})}
+}
+
+func (c *dryRunConn) ReadFrom([]byte) (int, net.Addr, error) {
+	<-c.closed
+	return 0, nil, net.ErrClosed
+}
+
+func (c *dryRunConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	c.onWrite(b)
+	return len(b), nil
+}
+
+func (c *dryRunConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *dryRunConn) LocalAddr() net.Addr              { return c.localAddr }
+func (c *dryRunConn) SetDeadline(time.Time) error      { return nil }
+func (c *dryRunConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *dryRunConn) SetWriteDeadline(time.Time) error { return nil }
+func (c *dryRunConn) SetReadBuffer(int) error          { return nil }
+func (c *dryRunConn) SetWriteBuffer(int) error         { return nil }
+
+// ja4 computes the JA4 fingerprint of a ClientHello handshake message,
+// see https://github.com/FoxIO-LLC/ja4/blob/main/technical_details/JA4.md.
+func ja4(clientHello []byte, transport byte) (string, error) {
+	var (
+		s                cryptobyte.String = clientHello
+		msgType          uint8
+		body             cryptobyte.String
+		legacyVersion    uint16
+		sessionID        cryptobyte.String
+		cipherSuitesData cryptobyte.String
+		compression      cryptobyte.String
+		extensionsData   cryptobyte.String
+	)
+	if !s.ReadUint8(&msgType) || msgType != 1 || !s.ReadUint24LengthPrefixed(&body) ||
+		!body.ReadUint16(&legacyVersion) || !body.Skip(32) || !body.ReadUint8LengthPrefixed(&sessionID) ||
+		!body.ReadUint16LengthPrefixed(&cipherSuitesData) || !body.ReadUint8LengthPrefixed(&compression) {
+		return "", errors.New("malformed ClientHello")
+	}
+	if !body.Empty() && !body.ReadUint16LengthPrefixed(&extensionsData) {
+		return "", errors.New("malformed ClientHello extensions")
+	}
+
+	var cipherSuites []string
+	for !cipherSuitesData.Empty() {
+		var suite uint16
+		if !cipherSuitesData.ReadUint16(&suite) {
+			return "", errors.New("malformed ClientHello cipher suites")
+		}
+		if !isGREASEUint16(suite) {
+			cipherSuites = append(cipherSuites, fmt.Sprintf("%04x", suite))
+		}
+	}
+
+	var (
+		extensions []string
+		numExts    int
+		hasSNI     bool
+		alpn       []byte
+		version    = legacyVersion
+		sigAlgs    []string
+	)
+	for !extensionsData.Empty() {
+		var (
+			id   uint16
+			data cryptobyte.String
+		)
+		if !extensionsData.ReadUint16(&id) || !extensionsData.ReadUint16LengthPrefixed(&data) {
+			return "", errors.New("malformed ClientHello extensions")
+		}
+		if isGREASEUint16(id) {
+			continue
+		}
+		numExts++
+		switch id {
+		case 0x0000: // server_name
+			hasSNI = true
+			continue
+		case 0x0010: // application_layer_protocol_negotiation
+			var protos, proto cryptobyte.String
+			if data.ReadUint16LengthPrefixed(&protos) && protos.ReadUint8LengthPrefixed(&proto) {
+				alpn = proto
+			}
+			continue
+		case 0x000d: // signature_algorithms
+			var algs cryptobyte.String
+			if !data.ReadUint16LengthPrefixed(&algs) {
+				return "", errors.New("malformed signature_algorithms extension")
+			}
+			for !algs.Empty() {
+				var alg uint16
+				if !algs.ReadUint16(&alg) {
+					return "", errors.New("malformed signature_algorithms extension")
+				}
+				sigAlgs = append(sigAlgs, fmt.Sprintf("%04x", alg))
+			}
+		case 0x002b: // supported_versions
+			var versions cryptobyte.String
+			if !data.ReadUint8LengthPrefixed(&versions) {
+				return "", errors.New("malformed supported_versions extension")
+			}
+			version = 0
+			for !versions.Empty() {
+				var v uint16
+				if !versions.ReadUint16(&v) {
+					return "", errors.New("malformed supported_versions extension")
+				}
+				if !isGREASEUint16(v) && v > version {
+					version = v
+				}
+			}
+		}
+		extensions = append(extensions, fmt.Sprintf("%04x", id))
+	}
+
+	var b strings.Builder
+	b.WriteByte(transport)
+	switch version {
+	case tls.VersionTLS13:
+		b.WriteString("13")
+	case tls.VersionTLS12:
+		b.WriteString("12")
+	case tls.VersionTLS11:
+		b.WriteString("11")
+	case tls.VersionTLS10:
+		b.WriteString("10")
+	default:
+		b.WriteString("00")
+	}
+	if hasSNI {
+		b.WriteByte('d')
+	} else {
+		b.WriteByte('i')
+	}
+	fmt.Fprintf(&b, "%02d%02d", min(len(cipherSuites), 99), min(numExts, 99))
+	switch {
+	case len(alpn) == 0:
+		b.WriteString("00")
+	case isAlphanumeric(alpn[0]) && isAlphanumeric(alpn[len(alpn)-1]):
+		b.WriteByte(alpn[0])
+		b.WriteByte(alpn[len(alpn)-1])
+	default:
+		h := hex.EncodeToString(alpn)
+		b.WriteByte(h[0])
+		b.WriteByte(h[len(h)-1])
+	}
+
+	slices.Sort(cipherSuites)
+	slices.Sort(extensions)
+	b.WriteByte('_')
+	b.WriteString(ja4Hash(strings.Join(cipherSuites, ",")))
+	b.WriteByte('_')
+	exts := strings.Join(extensions, ",")
+	if len(sigAlgs) > 0 {
+		exts += "_" + strings.Join(sigAlgs, ",")
+	}
+	b.WriteString(ja4Hash(exts))
+	return b.String(), nil
+}
+
+func ja4Hash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:6])
+}
+
+func isAlphanumeric(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+}
@@ -0,0 +1,141 @@
+package quic
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/protocol"
+	"github.com/Noooste/uquic-go/internal/testdata"
+	tls "github.com/Noooste/utls"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRunJA4(t *testing.T) {
+	spec, err := QUICID2Spec(QUICChrome_115_IPv4)
+	require.NoError(t, err)
+	res, err := spec.DryRun(
+		context.Background(),
+		&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 443},
+		&tls.Config{ServerName: "example.com", NextProtos: []string{"h3"}},
+		nil,
+	)
+	require.NoError(t, err)
+	require.Equal(t, "q13d0310h3_55b375c5d22e_cd85d2d88918", res.JA4)
+	// the transport parameters are shuffled
+	require.True(t, strings.HasPrefix(res.QUICHash, "q0108001n1250_"), res.QUICHash)
+
+	// a change of the QUIC layer only changes the QUICHash
+	spec.InitialPacketSpec.DestConnIDLength = 12
+	drifted, err := spec.DryRun(
+		context.Background(),
+		&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 443},
+		&tls.Config{ServerName: "example.com", NextProtos: []string{"h3"}},
+		nil,
+	)
+	require.NoError(t, err)
+	require.Equal(t, res.JA4, drifted.JA4)
+	require.True(t, strings.HasPrefix(drifted.QUICHash, "q0112001n"), drifted.QUICHash)
+}
+
+func TestDryRunMatchesWire(t *testing.T) {
+	ln := newUTransportTestServer(t)
+
+	for _, id := range testQUICIDs {
+		t.Run(id.Client+"_"+id.Version, func(t *testing.T) {
+			spec, err := testQUICID2Spec(id)
+			require.NoError(t, err)
+			tr := newUTransportWithSpecForTest(t, &spec)
+			tlsConf := &tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA(), NextProtos: []string{"h3"}}
+			res, err := tr.DryRun(context.Background(), ln.Addr(), tlsConf, nil)
+			require.NoError(t, err)
+
+			var c initialCapture
+			for _, d := range captureInitialPackets(t, ln, &spec) {
+				require.NoError(t, c.add(d))
+				if c.complete() {
+					break
+				}
+			}
+			require.True(t, c.complete())
+			wireJA4, err := ja4(c.clientHello, 'q')
+			require.NoError(t, err)
+			require.Equal(t, wireJA4, res.JA4)
+			require.Equal(t, c.fingerprint().QUICHash, res.QUICHash)
+
+			require.Len(t, res.Datagrams, len(c.datagramSizes))
+			require.Equal(t, c.datagramSizes[0], res.Datagrams[0].Size)
+			require.GreaterOrEqual(t, res.Datagrams[0].Size, spec.UDPDatagramMinSize)
+			var numPackets int
+			for _, d := range res.Datagrams {
+				for _, p := range d.Packets {
+					expected := c.packets[numPackets]
+					require.Equal(t, protocol.PacketTypeInitial.String(), p.Type)
+					require.Equal(t, expected.hdr.DestConnectionID.Len(), p.DestConnectionID.Len())
+					require.Equal(t, expected.hdr.SrcConnectionID.Len(), p.SrcConnectionID.Len())
+					require.Equal(t, int64(expected.extHdr.PacketNumber), p.PacketNumber)
+					require.Equal(t, int(expected.extHdr.PacketNumberLen), p.PacketNumberLen)
+					require.NotEmpty(t, p.Frames)
+					numPackets++
+				}
+			}
+			require.Len(t, c.packets, numPackets)
+			require.Equal(t, int64(spec.InitialPacketSpec.InitPacketNumber), res.Datagrams[0].Packets[0].PacketNumber)
+			require.Equal(t, len(res.ClientHello), cryptoDataLength(res))
+		})
+	}
+}
+
+// cryptoDataLength returns the length of the crypto data sent in the dry run.
+func cryptoDataLength(res *DryRunResult) int {
+	var l int
+	for _, d := range res.Datagrams {
+		for _, p := range d.Packets {
+			for _, frame := range p.Frames {
+				if offset, length, ok := frame.CryptoFrameInfo(); ok {
+					l = max(l, offset+length)
+				}
+			}
+		}
+	}
+	return l
+}
+
+func TestDryRunToken(t *testing.T) {
+	tokenStore := NewLRUTokenStore(1, 1)
+	tokenStore.Put("example.com", &ClientToken{data: []byte("token")})
+	spec, err := testQUICID2Spec(testQUICIDPQConnID3)
+	require.NoError(t, err)
+	spec.InitialPacketSpec.TokenStore = tokenStore
+
+	for range 2 {
+		res, err := spec.DryRun(
+			context.Background(),
+			&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 443},
+			&tls.Config{ServerName: "example.com", NextProtos: []string{"h3"}},
+			nil,
+		)
+		require.NoError(t, err)
+		require.Equal(t, []byte("token"), res.Datagrams[0].Packets[0].Token)
+	}
+	require.NotNil(t, tokenStore.Pop("example.com"))
+}
+
+func TestDryRunDoesntSend(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+	spec, err := QUICID2Spec(QUICFirefox_116A)
+	require.NoError(t, err)
+	tr := newUTransportWithSpecForTest(t, &spec)
+	_, err = tr.DryRun(context.Background(), conn.LocalAddr(), &tls.Config{ServerName: "localhost"}, nil)
+	require.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, _, err = conn.ReadFrom(make([]byte, protocol.MaxPacketBufferSize))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
@@ -0,0 +1,60 @@
+package quic
+
+import (
+	"io"
+
+	tls "github.com/Noooste/utls"
+)
+
+// [UQUIC]
+// earlyDataExtension is the early_data extension (RFC 8446, Section 4.2.10) of a
+// ClientHelloSpec. Browsers only send it when resuming a session that allows 0-RTT,
+// so it is empty unless the earlyDataPSKExtension of the connection enables it.
+type earlyDataExtension struct {
+	tls.GenericExtension
+	enabled bool
+}
+
+func newEarlyDataExtension() *earlyDataExtension {
+	return &earlyDataExtension{GenericExtension: tls.GenericExtension{Id: 42}}
+}
+
+func (e *earlyDataExtension) Len() int {
+	if !e.enabled {
+		return 0
+	}
+	return 4
+}
+
+func (e *earlyDataExtension) Read(b []byte) (int, error) {
+	if !e.enabled {
+		return 0, io.EOF
+	}
+	return e.GenericExtension.Read(b)
+}
+
+// earlyDataPSKExtension is the pre_shared_key extension of a ClientHelloSpec that
+// also contains an earlyDataExtension.
+//
+// uTLS loads the session for a custom ClientHelloSpec on a copy of the ClientHello,
+// so it never offers 0-RTT for a resumed session. uTLS initializes the pre_shared_key
+// extension with the session after the QUICResumeSession event, which disables early
+// data unless 0-RTT is used on this connection. The earlyDataPSKExtension enables the
+// early_data extension accordingly, and marks the built ClientHello as offering early
+// data for uTLS to install the 0-RTT keys.
+type earlyDataPSKExtension struct {
+	*tls.UtlsPreSharedKeyExtension
+	earlyData *earlyDataExtension
+}
+
+var _ tls.PreSharedKeyExtension = &earlyDataPSKExtension{}
+
+func (e *earlyDataPSKExtension) InitializeByUtls(session *tls.SessionState, earlySecret, binderKey []byte, identities []tls.PskIdentity) {
+	e.UtlsPreSharedKeyExtension.InitializeByUtls(session, earlySecret, binderKey, identities)
+	e.earlyData.enabled = session.EarlyData
+}
+
+func (e *earlyDataPSKExtension) PatchBuiltHello(hello *tls.PubClientHelloMsg) error {
+	hello.EarlyData = e.earlyData.enabled
+	return e.UtlsPreSharedKeyExtension.PatchBuiltHello(hello)
+}
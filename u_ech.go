@@ -0,0 +1,211 @@
+package quic
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	tls "github.com/Noooste/utls"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// [UQUIC]
+// errNoECHExtension is returned when dialing with an ECHConfigList using a QUICSpec
+// whose ClientHelloSpec doesn't contain an encrypted_client_hello extension.
+var errNoECHExtension = errors.New("quic: the ClientHelloSpec has no encrypted_client_hello extension, it can't be used with an ECHConfigList")
+
+// [UQUIC]
+// hasECHExtension says if uTLS can use the ClientHelloSpec for Encrypted Client Hello.
+// uTLS replaces the (GREASE) ECH extension of the ClientHelloSpec with the real one.
+func hasECHExtension(chs *tls.ClientHelloSpec) bool {
+	for _, ext := range chs.Extensions {
+		if _, ok := ext.(tls.EncryptedClientHelloExtension); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// [UQUIC]
+// dialECH dials a connection using Encrypted Client Hello, if an ECHConfigList is
+// configured, or returned by the UTransport's GetECHConfigList.
+//
+// If the server rejects ECH and sends retry_configs, the connection is dialed once
+// more using the ECHConfigList from the retry_configs, as recommended by the
+// Encrypted Client Hello specification.
+// The server's certificate for the public name must have been verified by uTLS, see
+// tls.Config.EncryptedClientHelloRejectionVerify.
+func (t *UTransport) dialECH(ctx context.Context, tlsConf *tls.Config, uSpec *QUICSpec, dial func(*tls.Config) (*Conn, error)) (*Conn, error) {
+	if tlsConf.EncryptedClientHelloConfigList == nil && t.GetECHConfigList != nil {
+		echConfigList, err := t.GetECHConfigList(ctx, tlsConf.ServerName)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.EncryptedClientHelloConfigList = echConfigList
+	}
+	if tlsConf.EncryptedClientHelloConfigList == nil {
+		return dial(tlsConf)
+	}
+	if uSpec != nil && uSpec.ClientHelloSpec != nil && !hasECHExtension(uSpec.ClientHelloSpec) {
+		return nil, errNoECHExtension
+	}
+
+	conn, err := dial(tlsConf)
+	var echErr *tls.ECHRejectionError
+	if err == nil || !errors.As(err, &echErr) || len(echErr.RetryConfigList) == 0 {
+		return conn, err
+	}
+	tlsConf = tlsConf.Clone()
+	tlsConf.EncryptedClientHelloConfigList = echErr.RetryConfigList
+	return dial(tlsConf)
+}
+
+// [UQUIC]
+// LookupECHConfigList looks up the ECHConfigList of a server in its HTTPS DNS record
+// (RFC 9460), by sending a DNS query over UDP to the DNS server at dnsServer, e.g.
+// "1.1.1.1:53". It returns nil if the HTTPS record doesn't contain an ECHConfigList.
+//
+// If the name has multiple HTTPS records, the ECHConfigList of the record with the
+// lowest priority is returned. Alias records are not followed.
+//
+// The DNS response is not authenticated. Use DNS over HTTPS or DNSSEC validation
+// where this matters, and set the UTransport's GetECHConfigList accordingly.
+func LookupECHConfigList(ctx context.Context, dnsServer, name string) ([]byte, error) {
+	qname, err := dnsmessage.NewName(dnsFQDN(name))
+	if err != nil {
+		return nil, err
+	}
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:]), RecursionDesired: true})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: qname, Type: dnsmessage.TypeHTTPS, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	query, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "udp", dnsServer)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { c.Close() })
+	defer stop()
+	if _, err := c.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := c.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, context.Cause(ctx)
+			}
+			return nil, err
+		}
+		echConfigList, err := parseECHConfigListResponse(buf[:n], binary.BigEndian.Uint16(id[:]), qname)
+		if errors.Is(err, errUnexpectedDNSResponse) {
+			continue // not the response to our query
+		}
+		return echConfigList, err
+	}
+}
+
+var errUnexpectedDNSResponse = errors.New("unexpected DNS response")
+
+func dnsFQDN(name string) string {
+	if len(name) == 0 || name[len(name)-1] != '.' {
+		return name + "."
+	}
+	return name
+}
+
+// parseECHConfigListResponse parses the response to an HTTPS query for qname,
+// and returns the ECHConfigList of the record with the lowest priority.
+func parseECHConfigListResponse(msg []byte, id uint16, qname dnsmessage.Name) ([]byte, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil || !h.Response || h.ID != id {
+		return nil, errUnexpectedDNSResponse
+	}
+	q, err := p.Question()
+	if err != nil || q.Type != dnsmessage.TypeHTTPS || !equalDNSNames(q.Name, qname) {
+		return nil, errUnexpectedDNSResponse
+	}
+	if h.Truncated {
+		return nil, errors.New("quic: truncated DNS response")
+	}
+	switch h.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("quic: DNS lookup failed: %s", h.RCode)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, err
+	}
+
+	var (
+		echConfigList []byte
+		priority      uint16
+	)
+	for {
+		ah, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			return echConfigList, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ah.Type != dnsmessage.TypeHTTPS {
+			if err := p.SkipAnswer(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		r, err := p.HTTPSResource()
+		if err != nil {
+			return nil, err
+		}
+		if r.Priority == 0 { // AliasMode
+			continue
+		}
+		if ech, ok := r.GetParam(dnsmessage.SVCParamECH); ok && (echConfigList == nil || r.Priority < priority) {
+			echConfigList, priority = ech, r.Priority
+		}
+	}
+}
+
+func equalDNSNames(a, b dnsmessage.Name) bool {
+	if a.Length != b.Length {
+		return false
+	}
+	for i := range int(a.Length) {
+		ca, cb := a.Data[i], b.Data[i]
+		if 'A' <= ca && ca <= 'Z' {
+			ca += 'a' - 'A'
+		}
+		if 'A' <= cb && cb <= 'Z' {
+			cb += 'a' - 'A'
+		}
+		if ca != cb {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,303 @@
+package quic
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	mrand "math/rand/v2"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/testdata"
+	tls "github.com/Noooste/utls"
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newECHKey generates an ECH key using X25519, HKDF-SHA256 and AES-128-GCM.
+func newECHKey(t *testing.T, configID uint8, publicName string) tls.EncryptedClientHelloKey {
+	t.Helper()
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	var b cryptobyte.Builder
+	b.AddUint16(extTypeECH) // version
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint8(configID)
+		b.AddUint16(0x0020) // DHKEM(X25519, HKDF-SHA256)
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(key.PublicKey().Bytes()) })
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint16(0x0001) // HKDF-SHA256
+			b.AddUint16(0x0001) // AES-128-GCM
+		})
+		b.AddUint8(0) // maximum_name_length
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes([]byte(publicName)) })
+		b.AddUint16(0) // extensions
+	})
+	return tls.EncryptedClientHelloKey{Config: b.BytesOrPanic(), PrivateKey: key.Bytes(), SendAsRetry: true}
+}
+
+func echConfigList(keys ...tls.EncryptedClientHelloKey) []byte {
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, k := range keys {
+			b.AddBytes(k.Config)
+		}
+	})
+	return b.BytesOrPanic()
+}
+
+// newECHTestServer starts a server using the ECH keys, and reports for every
+// connection it accepts if ECH was accepted.
+func newECHTestServer(t *testing.T, keys ...tls.EncryptedClientHelloKey) (*Listener, <-chan bool) {
+	t.Helper()
+	tlsConf := testdata.GetTLSConfig()
+	tlsConf.NextProtos = []string{"h3"}
+	tlsConf.EncryptedClientHelloKeys = keys
+	ln, err := ListenAddr("127.0.0.1:0", tlsConf, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	accepted := make(chan bool, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			accepted <- conn.ConnectionState().TLS.ECHAccepted
+		}
+	}()
+	return ln, accepted
+}
+
+func newECHTLSConfig(echConfigList []byte) *tls.Config {
+	return &tls.Config{
+		ServerName:                     "localhost",
+		RootCAs:                        testdata.GetRootCA(),
+		NextProtos:                     []string{"h3"},
+		EncryptedClientHelloConfigList: echConfigList,
+	}
+}
+
+func requireECHAccepted(t *testing.T, conn *Conn, serverAccepted <-chan bool) {
+	t.Helper()
+	require.True(t, conn.ConnectionState().TLS.ECHAccepted)
+	select {
+	case accepted := <-serverAccepted:
+		require.True(t, accepted)
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+// reassembleClientHello reassembles the ClientHello sent in the client's Initial packets.
+func reassembleClientHello(t *testing.T, datagrams [][]byte) []byte {
+	t.Helper()
+	var clientHello []byte
+	for _, p := range decryptClientInitials(t, datagrams) {
+		for _, f := range p.cryptoFrames {
+			if end := int(f.Offset) + len(f.Data); end > len(clientHello) {
+				clientHello = append(clientHello, make([]byte, end-len(clientHello))...)
+			}
+			copy(clientHello[f.Offset:], f.Data)
+		}
+	}
+	require.NotEmpty(t, clientHello)
+	return clientHello
+}
+
+func TestUTransportECH(t *testing.T) {
+	key := newECHKey(t, 1, "public.example")
+	ln, serverAccepted := newECHTestServer(t, key)
+
+	for _, id := range []QUICID{testQUICIDPQ, testQUICIDPQConnID3} {
+		t.Run(id.Client, func(t *testing.T) {
+			tr := newUTransportForTest(t, id)
+			recorder := newInitialRecorder(t, ln.Addr())
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, err := tr.Dial(ctx, recorder.conn.LocalAddr(), newECHTLSConfig(echConfigList(key)), nil)
+			require.NoError(t, err)
+			defer conn.CloseWithError(0, "")
+			requireECHAccepted(t, conn, serverAccepted)
+
+			// the outer ClientHello is sent to the public name
+			clientHello := reassembleClientHello(t, recorder.clientDatagrams())
+			sniPos, sniLen, _ := locateSNIAndECH(clientHello)
+			require.Equal(t, "public.example", string(clientHello[sniPos:sniPos+sniLen]))
+			require.False(t, bytes.Contains(clientHello, []byte("localhost")))
+		})
+	}
+
+	t.Run("with a seeded Rand", func(t *testing.T) {
+		spec, err := testQUICID2SpecWithRand(testQUICIDPQ, mrand.NewChaCha8([32]byte{42}))
+		require.NoError(t, err)
+		tr := newUTransportWithSpecForTest(t, &spec)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := tr.Dial(ctx, ln.Addr(), newECHTLSConfig(echConfigList(key)), nil)
+		require.NoError(t, err)
+		defer conn.CloseWithError(0, "")
+		requireECHAccepted(t, conn, serverAccepted)
+	})
+}
+
+func TestUTransportECHRetryConfigs(t *testing.T) {
+	staleKey := newECHKey(t, 1, "public.example")
+	key := newECHKey(t, 2, "public.example")
+	ln, serverAccepted := newECHTestServer(t, key)
+
+	tr := newUTransportForTest(t, testQUICIDPQConnID3)
+	tlsConf := newECHTLSConfig(echConfigList(staleKey))
+	var rejections int
+	// The test certificate is not valid for the public name.
+	// Real clients must not skip this verification.
+	tlsConf.EncryptedClientHelloRejectionVerify = func(tls.ConnectionState) error {
+		rejections++
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := tr.Dial(ctx, ln.Addr(), tlsConf, nil)
+	require.NoError(t, err)
+	defer conn.CloseWithError(0, "")
+	require.Equal(t, 1, rejections)
+	requireECHAccepted(t, conn, serverAccepted)
+}
+
+func TestUTransportECHWithoutECHExtension(t *testing.T) {
+	key := newECHKey(t, 1, "public.example")
+	ln, _ := newECHTestServer(t, key)
+	tr := newUTransportForTest(t, QUICChrome_115)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := tr.Dial(ctx, ln.Addr(), newECHTLSConfig(echConfigList(key)), nil)
+	require.ErrorIs(t, err, errNoECHExtension)
+}
+
+func TestUTransportGREASEECH(t *testing.T) {
+	// without an ECHConfigList, the parrot sends its GREASE ECH extension
+	key := newECHKey(t, 1, "public.example")
+	ln, serverAccepted := newECHTestServer(t, key)
+	tr := newUTransportForTest(t, testQUICIDPQ)
+	tr.GetECHConfigList = func(context.Context, string) ([]byte, error) { return nil, nil }
+	recorder := newInitialRecorder(t, ln.Addr())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := tr.Dial(ctx, recorder.conn.LocalAddr(), newECHTLSConfig(nil), nil)
+	require.NoError(t, err)
+	defer conn.CloseWithError(0, "")
+	require.False(t, conn.ConnectionState().TLS.ECHAccepted)
+	require.False(t, <-serverAccepted)
+
+	clientHello := reassembleClientHello(t, recorder.clientDatagrams())
+	sniPos, sniLen, echPos := locateSNIAndECH(clientHello)
+	require.Equal(t, "localhost", string(clientHello[sniPos:sniPos+sniLen]))
+	require.NotEqual(t, -1, echPos)
+}
+
+// runDNSTestServer answers HTTPS queries with the given records.
+func runDNSTestServer(t *testing.T, rcode dnsmessage.RCode, records ...dnsmessage.HTTPSResource) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var p dnsmessage.Parser
+			h, err := p.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			q, err := p.Question()
+			if err != nil {
+				continue
+			}
+			b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, RCode: rcode})
+			b.StartQuestions()
+			b.Question(q)
+			b.StartAnswers()
+			for _, r := range records {
+				b.HTTPSResource(dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}, r)
+			}
+			msg, err := b.Finish()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(msg, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestLookupECHConfigList(t *testing.T) {
+	httpsRecord := func(priority uint16, echConfigList []byte) dnsmessage.HTTPSResource {
+		r := dnsmessage.HTTPSResource{SVCBResource: dnsmessage.SVCBResource{
+			Priority: priority,
+			Target:   dnsmessage.MustNewName("."),
+		}}
+		if echConfigList != nil {
+			r.SetParam(dnsmessage.SVCParamECH, echConfigList)
+		}
+		return r
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("lowest priority", func(t *testing.T) {
+		dnsServer := runDNSTestServer(t, dnsmessage.RCodeSuccess,
+			httpsRecord(2, []byte("second")),
+			httpsRecord(0, nil), // AliasMode
+			httpsRecord(1, []byte("first")),
+			httpsRecord(3, []byte("third")),
+		)
+		echConfigList, err := LookupECHConfigList(ctx, dnsServer, "localhost")
+		require.NoError(t, err)
+		require.Equal(t, []byte("first"), echConfigList)
+	})
+
+	t.Run("no ECHConfigList", func(t *testing.T) {
+		dnsServer := runDNSTestServer(t, dnsmessage.RCodeSuccess, httpsRecord(1, nil))
+		echConfigList, err := LookupECHConfigList(ctx, dnsServer, "localhost")
+		require.NoError(t, err)
+		require.Nil(t, echConfigList)
+	})
+
+	t.Run("NXDOMAIN", func(t *testing.T) {
+		dnsServer := runDNSTestServer(t, dnsmessage.RCodeNameError)
+		echConfigList, err := LookupECHConfigList(ctx, dnsServer, "localhost")
+		require.NoError(t, err)
+		require.Nil(t, echConfigList)
+	})
+
+	t.Run("server failure", func(t *testing.T) {
+		dnsServer := runDNSTestServer(t, dnsmessage.RCodeServerFailure)
+		_, err := LookupECHConfigList(ctx, dnsServer, "localhost")
+		require.ErrorContains(t, err, "DNS lookup failed")
+	})
+
+	t.Run("dialing", func(t *testing.T) {
+		key := newECHKey(t, 1, "public.example")
+		ln, serverAccepted := newECHTestServer(t, key)
+		dnsServer := runDNSTestServer(t, dnsmessage.RCodeSuccess, httpsRecord(1, echConfigList(key)))
+		tr := newUTransportForTest(t, testQUICIDPQConnID3)
+		var serverName string
+		tr.GetECHConfigList = func(ctx context.Context, name string) ([]byte, error) {
+			serverName = name
+			return LookupECHConfigList(ctx, dnsServer, name)
+		}
+		conn, err := tr.Dial(ctx, ln.Addr(), newECHTLSConfig(nil), nil)
+		require.NoError(t, err)
+		defer conn.CloseWithError(0, "")
+		require.Equal(t, "localhost", serverName)
+		requireECHAccepted(t, conn, serverAccepted)
+	})
+}
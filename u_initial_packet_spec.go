@@ -2,6 +2,8 @@ package quic
 
 import (
 	"crypto/rand"
+	"io"
+
 	"github.com/Noooste/uquic-go/internal/protocol"
 )
 
@@ -37,14 +39,74 @@ type InitialPacketSpec struct {
 	// packet.
 	//
 	// If nil, there will be only one single Crypto frame in the first Initial packet.
+	// quic-go doesn't scramble the ClientHello of a QUICSpec, regardless of the
+	// QUIC_GO_DISABLE_CLIENTHELLO_SCRAMBLING environment variable, see QUICScrambledFrames.
+	FrameBuilder QUICFrameBuilder
+
+	// ClientHelloPackets specifies the Initial packets carrying the remainder of a
+	// ClientHello that doesn't fit into the first Initial packet, e.g. because of a
+	// post-quantum key share: ClientHelloPackets[0] specifies the second packet,
+	// ClientHelloPackets[1] the third one, and so on. If the ClientHello needs more
+	// packets, the last ClientHelloPacketSpec is used for the remaining ones.
+	//
+	// If empty, the remainder of the ClientHello is packed by quic-go, in packets no
+	// larger than the first one, and padded to the QUICSpec's UDPDatagramMinSize.
+	ClientHelloPackets []ClientHelloPacketSpec // [UQUIC]
+}
+
+// [UQUIC]
+// ClientHelloPacketSpec specifies an Initial packet carrying the remainder of a
+// ClientHello, see InitialPacketSpec.ClientHelloPackets. It applies to the
+// retransmissions of the packet, and to the packets carrying the remainder of the
+// ClientHello sent after a HelloRetryRequest.
+type ClientHelloPacketSpec struct {
+	// FrameBuilder builds the frames of the packet, from the crypto data it carries.
+	// The offsets of the CRYPTO frames of QUICFrames are relative to the beginning of
+	// this crypto data. The Length of a QUICRandomFrames (or of the Layout of a
+	// QUICScrambledFrames) limits the crypto data carried by the packet, as it does for
+	// the first Initial packet. Other QUICFrameBuilders only apply to the first packet.
+	//
+	// If nil, the crypto data is sent in a single CRYPTO frame, unless the FrameBuilder
+	// of the InitialPacketSpec is a QUICScrambledFrames, which then cuts the crypto data
+	// without adding the frames of its Layout.
 	FrameBuilder QUICFrameBuilder
+
+	// UDPDatagramMinSize specifies the minimum size of the UDP datagram carrying the
+	// packet. If 0, the QUICSpec's UDPDatagramMinSize is used.
+	UDPDatagramMinSize int
+
+	// PaddingFrames pads the datagram to UDPDatagramMinSize with PADDING frames at the
+	// end of the packet, as Chrome does, instead of zeros after the QUIC packets.
+	PaddingFrames bool
+
+	// Coalesce allows quic-go to coalesce a 0-RTT packet into the datagram carrying the
+	// packet. Otherwise, the packet is sent in its own datagram.
+	Coalesce bool
 }
 
 func (ps *InitialPacketSpec) UpdateConfig(conf *Config) {
-	conf.TokenStore = ps.getTokenStore()
+	ps.updateConfig(conf, rand.Reader)
+}
+
+// [UQUIC]
+// updateConfig is like UpdateConfig, generating tokens using r.
+func (ps *InitialPacketSpec) updateConfig(conf *Config, r io.Reader) {
+	if tokenStore := ps.getTokenStore(r); tokenStore != nil {
+		conf.TokenStore = tokenStore
+	}
+}
+
+// [UQUIC]
+// connIDGenerator returns the generator of the connection IDs of a connection dialed
+// with the InitialPacketSpec, reading them from r.
+func (ps *InitialPacketSpec) connIDGenerator(r io.Reader) ConnectionIDGenerator {
+	if ps.SrcConnIDLength == 0 {
+		return &protocol.ExpEmptyConnectionIDGenerator{}
+	}
+	return &protocol.ReaderConnectionIDGenerator{Rand: r, ConnLen: ps.SrcConnIDLength}
 }
 
-func (ps *InitialPacketSpec) getTokenStore() TokenStore {
+func (ps *InitialPacketSpec) getTokenStore(r io.Reader) TokenStore {
 	if ps.TokenStore != nil {
 		return ps.TokenStore
 	}
@@ -52,6 +114,7 @@ func (ps *InitialPacketSpec) getTokenStore() TokenStore {
 	if ps.ClientTokenLength > 0 {
 		return &dummyTokenStore{
 			tokenLength: ps.ClientTokenLength,
+			rand:        r, // [UQUIC]
 		}
 	}
 
@@ -60,11 +123,12 @@ func (ps *InitialPacketSpec) getTokenStore() TokenStore {
 
 type dummyTokenStore struct {
 	tokenLength int
+	rand        io.Reader // [UQUIC]
 }
 
 func (d *dummyTokenStore) Pop(key string) (token *ClientToken) {
 	var data []byte = make([]byte, d.tokenLength)
-	rand.Read(data)
+	io.ReadFull(d.rand, data)
 
 	return &ClientToken{
 		data: data,
@@ -0,0 +1,256 @@
+package quic
+
+import (
+	"net"
+	"slices"
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/ackhandler"
+	"github.com/Noooste/uquic-go/internal/monotime"
+	"github.com/Noooste/uquic-go/qlog"
+)
+
+// [UQUIC]
+// A MigrationPolicy makes a client migrate its connection to a backup Transport when the
+// current path fails, for example because the network interface it uses went down.
+//
+// The path is considered failed after PTOCount consecutive probe timeouts, or if no
+// packet was received for SilenceTimeout after sending an ack-eliciting packet. The
+// connection then probes a path from the next Transport, and switches to it once it is
+// validated. If the path can't be validated within ProbeTimeout, it is abandoned and the
+// next Transport is tried. The Transports are tried in order, followed by the Transport
+// the connection was dialed on. Probing stops if the current path recovers.
+//
+// The policy only applies once the handshake is confirmed, and if the server didn't
+// disable active migration. Every step is logged as a qlog.MigrationStateUpdated event.
+type MigrationPolicy struct {
+	// Transports are the backup Transports, typically bound to other network interfaces
+	// of the client.
+	Transports []*Transport
+	// PTOCount is the number of consecutive probe timeouts after which the path is
+	// considered failed. If zero, 3 is used.
+	PTOCount int
+	// SilenceTimeout is the time after which the path is considered failed if no packet
+	// was received since sending an ack-eliciting packet. If zero, only the PTO count is
+	// used to detect path failures.
+	SilenceTimeout time.Duration
+	// ProbeTimeout is the time after which probing a path is abandoned. If zero, the
+	// path validation timeout of section 8.2.4 of RFC 9000 is used.
+	ProbeTimeout time.Duration
+}
+
+const defaultMigrationPTOCount = 3
+
+// minPathValidationTimeout is six times the initial RTT of RFC 9002
+const minPathValidationTimeout = 6 * 333 * time.Millisecond
+
+const (
+	migrationTriggerPTOCount  = "pto_count_exceeded"
+	migrationTriggerSilence   = "no_packets_received"
+	migrationTriggerTimeout   = "probe_timeout"
+	migrationTriggerRecovered = "path_recovered"
+)
+
+// connMigrator is the state of the MigrationPolicy of a client connection.
+type connMigrator struct {
+	policy *MigrationPolicy
+	// the backup Transports, followed by the Transport the connection was dialed on
+	candidates []*Transport
+	next       int
+
+	// the Transport of the current path, nil if unknown
+	current *Transport
+	// the current path, nil for the path the connection was dialed on
+	currentPath *Path
+	pathStart   monotime.Time
+
+	// the path being probed, if any
+	probing       *Path
+	probingOut    *pathOutgoing
+	probeInterval time.Duration
+	nextProbe     monotime.Time
+	probeDeadline monotime.Time
+}
+
+// setupMigrationPolicy enables the MigrationPolicy of the Config.
+// It is called when the client completes the handshake.
+func (c *Conn) setupMigrationPolicy(now monotime.Time) {
+	if c.peerParams.DisableActiveMigration {
+		c.logger.Debugf("Not using the migration policy, the server disabled connection migration")
+		return
+	}
+	policy := c.config.MigrationPolicy
+	candidates := slices.Clone(policy.Transports)
+	if c.transport != nil && !slices.Contains(candidates, c.transport) {
+		candidates = append(candidates, c.transport)
+	}
+	c.migrator = &connMigrator{
+		policy:     policy,
+		candidates: candidates,
+		current:    c.transport,
+		pathStart:  now,
+	}
+}
+
+// runMigrationPolicy detects path failures, and probes and switches paths according to
+// the MigrationPolicy. It is called from the run loop, before switching paths.
+func (c *Conn) runMigrationPolicy(now monotime.Time) {
+	m := c.migrator
+	if !c.handshakeConfirmed {
+		return
+	}
+	trigger, failed := c.pathFailed(now)
+	if m.probing == nil {
+		if !failed {
+			return
+		}
+		tr := m.nextCandidate()
+		if tr == nil {
+			return
+		}
+		if err := tr.init(false); err != nil {
+			c.logger.Debugf("Failed to initialize the Transport for connection migration: %s", err)
+			return
+		}
+		c.startProbing(tr, trigger, now)
+		return
+	}
+
+	select {
+	case <-m.probingOut.Validated():
+		localAddr := m.probing.tr.Conn.LocalAddr()
+		c.recordMigrationState(qlog.MigrationStateProbingSuccessful, localAddr, c.RemoteAddr(), "")
+		if err := m.probing.Switch(); err != nil {
+			c.logger.Debugf("Failed to switch to the new path: %s", err)
+			c.abandonProbing("")
+			return
+		}
+		c.recordMigrationState(qlog.MigrationStateMigrationStarted, localAddr, c.RemoteAddr(), "")
+		if m.currentPath != nil {
+			m.currentPath.Close()
+		}
+		m.current = m.probing.tr
+		m.currentPath = m.probing
+		m.pathStart = now
+		m.probing = nil
+		m.probingOut = nil
+		return
+	default:
+	}
+
+	switch {
+	case !failed:
+		c.abandonProbing(migrationTriggerRecovered)
+	case !now.Before(m.probeDeadline):
+		c.abandonProbing(migrationTriggerTimeout)
+	case !now.Before(m.nextProbe):
+		m.probeInterval *= 2 // exponential backoff
+		m.nextProbe = now.Add(m.probeInterval)
+		c.getPathManager().enqueueProbe(m.probing)
+	}
+}
+
+// nextMigrationTime returns the time when runMigrationPolicy needs to run next.
+// PTOs are detected when the loss detection timer fires.
+func (c *Conn) nextMigrationTime() monotime.Time {
+	m := c.migrator
+	if m == nil || !c.handshakeConfirmed {
+		return 0
+	}
+	if m.probing != nil {
+		if m.nextProbe.Before(m.probeDeadline) {
+			return m.nextProbe
+		}
+		return m.probeDeadline
+	}
+	if start := c.silenceStartTime(); !start.IsZero() {
+		return start.Add(m.policy.SilenceTimeout)
+	}
+	return 0
+}
+
+// silenceStartTime returns the time since when the peer didn't send any packet on the
+// current path, although an ack-eliciting packet was sent.
+// It returns zero if the SilenceTimeout is not used.
+func (c *Conn) silenceStartTime() monotime.Time {
+	m := c.migrator
+	if m.policy.SilenceTimeout == 0 || c.firstAckElicitingPacketAfterIdleSentTime.IsZero() {
+		return 0
+	}
+	if m.pathStart.After(c.firstAckElicitingPacketAfterIdleSentTime) {
+		return m.pathStart
+	}
+	return c.firstAckElicitingPacketAfterIdleSentTime
+}
+
+// pathFailed says if the current path failed, and returns the reason.
+func (c *Conn) pathFailed(now monotime.Time) (trigger string, failed bool) {
+	m := c.migrator
+	ptoCount := m.policy.PTOCount
+	if ptoCount <= 0 {
+		ptoCount = defaultMigrationPTOCount
+	}
+	if ackhandler.PTOCount(c.sentPacketHandler) >= uint32(ptoCount) {
+		return migrationTriggerPTOCount, true
+	}
+	if start := c.silenceStartTime(); !start.IsZero() && now.Sub(start) >= m.policy.SilenceTimeout {
+		return migrationTriggerSilence, true
+	}
+	return "", false
+}
+
+// nextCandidate returns the next Transport to probe a path from.
+// It returns nil if there's no Transport other than the one of the current path.
+func (m *connMigrator) nextCandidate() *Transport {
+	for i := range m.candidates {
+		idx := (m.next + i) % len(m.candidates)
+		if tr := m.candidates[idx]; tr != m.current {
+			m.next = idx + 1
+			return tr
+		}
+	}
+	return nil
+}
+
+func (c *Conn) startProbing(tr *Transport, trigger string, now monotime.Time) {
+	m := c.migrator
+	pm := c.getPathManager()
+	path := c.addPath(tr, nil)
+	m.probing = path
+	m.probingOut = pm.addPath(path, path.enablePath)
+	m.probeInterval = path.initialRTT
+	m.nextProbe = now.Add(m.probeInterval)
+	probeTimeout := m.policy.ProbeTimeout
+	if probeTimeout == 0 {
+		probeTimeout = max(3*c.rttStats.PTO(false), minPathValidationTimeout)
+	}
+	m.probeDeadline = now.Add(probeTimeout)
+	c.logger.Debugf("Path failed (%s), probing a path from %s", trigger, tr.Conn.LocalAddr())
+	c.recordMigrationState(qlog.MigrationStateProbingStarted, tr.Conn.LocalAddr(), c.RemoteAddr(), trigger)
+	pm.enqueueProbe(path)
+}
+
+func (c *Conn) abandonProbing(trigger string) {
+	m := c.migrator
+	c.logger.Debugf("Abandoning the path from %s", m.probing.tr.Conn.LocalAddr())
+	c.recordMigrationState(qlog.MigrationStateProbingAbandoned, m.probing.tr.Conn.LocalAddr(), c.RemoteAddr(), trigger)
+	m.probing.Close()
+	m.probing = nil
+	m.probingOut = nil
+}
+
+// recordMigrationState logs a qlog.MigrationStateUpdated event for the path from
+// localAddr to remoteAddr.
+func (c *Conn) recordMigrationState(state qlog.MigrationState, localAddr, remoteAddr net.Addr, trigger string) {
+	if c.qlogger == nil {
+		return
+	}
+	local, _ := localAddr.(*net.UDPAddr)
+	remote, _ := remoteAddr.(*net.UDPAddr)
+	c.qlogger.RecordEvent(qlog.MigrationStateUpdated{
+		State:   state,
+		Local:   toPathEndpointInfo(local),
+		Remote:  toPathEndpointInfo(remote),
+		Trigger: trigger,
+	})
+}
@@ -0,0 +1,239 @@
+package quic
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/monotime"
+	"github.com/Noooste/uquic-go/internal/synctest"
+	"github.com/Noooste/uquic-go/internal/testdata"
+	"github.com/Noooste/uquic-go/internal/utils"
+	"github.com/Noooste/uquic-go/internal/wire"
+	"github.com/Noooste/uquic-go/qlog"
+	"github.com/Noooste/uquic-go/qlogwriter"
+	"github.com/Noooste/uquic-go/testutils/events"
+	"github.com/Noooste/uquic-go/testutils/simnet"
+	tls "github.com/Noooste/utls"
+
+	"github.com/stretchr/testify/require"
+)
+
+// blackholeRouter drops all packets sent from and to the blackholed addresses.
+type blackholeRouter struct {
+	simnet.PerfectRouter
+
+	mx         sync.Mutex
+	blackholed []*net.UDPAddr
+}
+
+func (r *blackholeRouter) SendPacket(p simnet.Packet) error {
+	r.mx.Lock()
+	for _, addr := range r.blackholed {
+		if addrsEqual(p.From, addr) || addrsEqual(p.To, addr) {
+			r.mx.Unlock()
+			return nil
+		}
+	}
+	r.mx.Unlock()
+	return r.PerfectRouter.SendPacket(p)
+}
+
+func (r *blackholeRouter) blackhole(addr *net.UDPAddr) {
+	r.mx.Lock()
+	r.blackholed = append(r.blackholed, addr)
+	r.mx.Unlock()
+}
+
+// runMigrationPolicyTest runs a connection with a MigrationPolicy using backup Transports
+// at backupAddrs, blackholes the path the connection was dialed on, and checks that the
+// connection can still be used. The blackholed addresses are unreachable from the start.
+// It returns the logged qlog.MigrationStateUpdated events, and the final local address.
+// If spec is set, the connection is dialed with a UTransport.
+func runMigrationPolicyTest(
+	t *testing.T,
+	spec *QUICSpec,
+	policy MigrationPolicy,
+	backupAddrs []*net.UDPAddr,
+	blackholed []*net.UDPAddr,
+) (_ []qlog.MigrationStateUpdated, localAddr net.Addr) {
+	var migrationEvents []qlog.MigrationStateUpdated
+	synctest.Test(t, func(t *testing.T) {
+		router := &blackholeRouter{}
+		for _, addr := range blackholed {
+			router.blackhole(addr)
+		}
+		n := &simnet.Simnet{Router: router}
+		settings := simnet.NodeBiDiLinkSettings{Latency: 10 * time.Millisecond}
+		clientAddr := &net.UDPAddr{IP: net.IPv4(1, 0, 0, 1), Port: 9001}
+		serverAddr := &net.UDPAddr{IP: net.IPv4(1, 0, 0, 2), Port: 9002}
+		clientPacketConn := n.NewEndpoint(clientAddr, settings)
+		serverPacketConn := n.NewEndpoint(serverAddr, settings)
+		for _, addr := range backupAddrs {
+			tr := &Transport{Conn: n.NewEndpoint(addr, settings)}
+			defer tr.Close()
+			policy.Transports = append(policy.Transports, tr)
+		}
+		require.NoError(t, n.Start())
+		defer n.Close()
+
+		serverTr := &Transport{Conn: serverPacketConn}
+		defer serverTr.Close()
+		tlsConf := testdata.GetTLSConfig()
+		tlsConf.NextProtos = []string{"h3"}
+		ln, err := serverTr.Listen(tlsConf, nil)
+		require.NoError(t, err)
+		defer ln.Close()
+		go func() {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			for {
+				str, err := conn.AcceptStream(context.Background())
+				if err != nil {
+					return
+				}
+				go func() {
+					io.Copy(str, str)
+					str.Close()
+				}()
+			}
+		}()
+
+		var recorder events.Recorder
+		clientTr := &Transport{Conn: clientPacketConn}
+		defer clientTr.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		// the certificate is not valid yet at the start of the synctest bubble
+		clientTLSConf := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h3"}}
+		clientConf := &Config{
+			MigrationPolicy: &policy,
+			Tracer: func(context.Context, bool, ConnectionID) qlogwriter.Trace {
+				return &events.Trace{Recorder: &recorder}
+			},
+		}
+		var conn *Conn
+		if spec != nil {
+			conn, err = (&UTransport{Transport: clientTr, QUICSpec: spec}).Dial(ctx, serverAddr, clientTLSConf, clientConf)
+		} else {
+			conn, err = clientTr.Dial(ctx, serverAddr, clientTLSConf, clientConf)
+		}
+		require.NoError(t, err)
+		defer conn.CloseWithError(0, "")
+
+		echo := func() {
+			str, err := conn.OpenStream()
+			require.NoError(t, err)
+			data := make([]byte, 50_000)
+			_, err = str.Write(data)
+			require.NoError(t, err)
+			require.NoError(t, str.Close())
+			echoed, err := io.ReadAll(str)
+			require.NoError(t, err)
+			require.Equal(t, data, echoed)
+		}
+		echo()
+		router.blackhole(clientAddr)
+		echo()
+
+		localAddr = conn.LocalAddr()
+		for _, ev := range recorder.Events(qlog.MigrationStateUpdated{}) {
+			migrationEvents = append(migrationEvents, ev.(qlog.MigrationStateUpdated))
+		}
+	})
+	return migrationEvents, localAddr
+}
+
+func migrationStates(evs []qlog.MigrationStateUpdated) []qlog.MigrationState {
+	states := make([]qlog.MigrationState, 0, len(evs))
+	for _, ev := range evs {
+		states = append(states, ev.State)
+	}
+	return states
+}
+
+func TestMigrationPolicyPTOCount(t *testing.T) {
+	backupAddr := &net.UDPAddr{IP: net.IPv4(1, 0, 0, 3), Port: 9001}
+	evs, localAddr := runMigrationPolicyTest(t, nil, MigrationPolicy{}, []*net.UDPAddr{backupAddr}, nil)
+
+	require.Equal(t, backupAddr.String(), localAddr.String())
+	require.Equal(t, []qlog.MigrationState{
+		qlog.MigrationStateProbingStarted,
+		qlog.MigrationStateProbingSuccessful,
+		qlog.MigrationStateMigrationStarted,
+		qlog.MigrationStateMigrationComplete,
+	}, migrationStates(evs))
+	require.Equal(t, "pto_count_exceeded", evs[0].Trigger)
+	for _, ev := range evs {
+		require.Equal(t, backupAddr.String(), ev.Local.IPv4.String())
+		require.Equal(t, "1.0.0.2:9002", ev.Remote.IPv4.String())
+	}
+}
+
+func TestMigrationPolicyUTransport(t *testing.T) {
+	// The Transports use connection IDs of a different length than the parrots.
+	for _, id := range []QUICID{testQUICIDPQConnID3, testQUICIDConnID8} {
+		t.Run(id.Client+"_"+id.Version, func(t *testing.T) {
+			spec, err := testQUICID2Spec(id)
+			require.NoError(t, err)
+			backupAddr := &net.UDPAddr{IP: net.IPv4(1, 0, 0, 3), Port: 9001}
+			_, localAddr := runMigrationPolicyTest(t, &spec, MigrationPolicy{}, []*net.UDPAddr{backupAddr}, nil)
+			require.Equal(t, backupAddr.String(), localAddr.String())
+		})
+	}
+}
+
+func TestMigrationPolicySilence(t *testing.T) {
+	backupAddr := &net.UDPAddr{IP: net.IPv4(1, 0, 0, 3), Port: 9001}
+	evs, localAddr := runMigrationPolicyTest(
+		t,
+		nil,
+		MigrationPolicy{PTOCount: 100, SilenceTimeout: 200 * time.Millisecond},
+		[]*net.UDPAddr{backupAddr},
+		nil,
+	)
+
+	require.Equal(t, backupAddr.String(), localAddr.String())
+	require.NotEmpty(t, evs)
+	require.Equal(t, qlog.MigrationStateProbingStarted, evs[0].State)
+	require.Equal(t, "no_packets_received", evs[0].Trigger)
+	require.Equal(t, qlog.MigrationStateMigrationComplete, evs[len(evs)-1].State)
+}
+
+func TestMigrationPolicyProbeTimeout(t *testing.T) {
+	deadAddr := &net.UDPAddr{IP: net.IPv4(1, 0, 0, 3), Port: 9001}
+	backupAddr := &net.UDPAddr{IP: net.IPv4(1, 0, 0, 4), Port: 9001}
+	evs, localAddr := runMigrationPolicyTest(
+		t,
+		nil,
+		MigrationPolicy{ProbeTimeout: 500 * time.Millisecond},
+		[]*net.UDPAddr{deadAddr, backupAddr},
+		[]*net.UDPAddr{deadAddr},
+	)
+
+	require.Equal(t, backupAddr.String(), localAddr.String())
+	require.Equal(t, []qlog.MigrationState{
+		qlog.MigrationStateProbingStarted,
+		qlog.MigrationStateProbingAbandoned,
+		qlog.MigrationStateProbingStarted,
+		qlog.MigrationStateProbingSuccessful,
+		qlog.MigrationStateMigrationStarted,
+		qlog.MigrationStateMigrationComplete,
+	}, migrationStates(evs))
+	require.Equal(t, deadAddr.String(), evs[0].Local.IPv4.String())
+	require.Equal(t, "probe_timeout", evs[1].Trigger)
+	require.Equal(t, backupAddr.String(), evs[2].Local.IPv4.String())
+}
+
+func TestMigrationPolicyDisabledActiveMigration(t *testing.T) {
+	conn := &Conn{config: &Config{MigrationPolicy: &MigrationPolicy{}}}
+	conn.peerParams = &wire.TransportParameters{DisableActiveMigration: true}
+	conn.logger = utils.DefaultLogger
+	conn.setupMigrationPolicy(monotime.Now())
+	require.Nil(t, conn.migrator)
+}
@@ -0,0 +1,218 @@
+package quic
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/protocol"
+	"github.com/Noooste/uquic-go/internal/wire"
+)
+
+// [UQUIC]
+// Connections dialed with a QUICSpec use connection IDs of the length specified by
+// their InitialPacketSpec, which may differ from the length used by the Transport
+// (and by a server listening on it). Short header packets don't encode the length of
+// the Destination Connection ID, so all lengths in use are tried when demultiplexing.
+//
+// Connections using zero-length connection IDs can't be told apart by their
+// connection ID. As recommended by RFC 9000 section 5.2, their packets are routed by
+// the remote address instead, so there can only be one such connection per server
+// address at a time.
+
+// ErrZeroLengthConnIDInUse is returned when dialing a server address with a QUICSpec
+// that uses zero-length connection IDs, while the Transport already has a connection
+// to this address that uses zero-length connection IDs.
+var ErrZeroLengthConnIDInUse = errors.New("quic: a connection using zero-length connection IDs to this address already exists on the Transport")
+
+// zeroLenConnInUse says if there's a connection using zero-length connection IDs to
+// the address that hasn't been closed yet.
+// It must be called with the mutex held.
+func (h *packetHandlerMap) zeroLenConnInUse(addr string) bool {
+	switch h.uZeroLenHandlers[addr].(type) {
+	case nil, *closedLocalConn, *closedRemoteConn:
+		return false
+	default:
+		return true
+	}
+}
+
+// addUConn registers a connection dialed with a QUICSpec.
+// It must be called with the mutex held.
+func (h *packetHandlerMap) addUConn(srcConnID protocol.ConnectionID, r *uConnRunner, handler packetHandler) {
+	if r == nil {
+		h.handlers[srcConnID] = handler
+		return
+	}
+	r.handler = handler
+	if srcConnID.Len() == 0 {
+		if h.uZeroLenHandlers == nil {
+			h.uZeroLenHandlers = make(map[string]packetHandler)
+		}
+		h.uZeroLenHandlers[r.addr] = handler
+		return
+	}
+	h.handlers[srcConnID] = handler
+	h.retainUConnIDLen(srcConnID.Len())
+}
+
+// retainUConnIDLen and releaseUConnIDLen count the connection IDs of every length other
+// than the Transport's, such that getUConn only tries the lengths still in use.
+// They must be called with the mutex held.
+func (h *packetHandlerMap) retainUConnIDLen(l int) {
+	if l == 0 || l == h.connIDLen {
+		return
+	}
+	if h.uConnIDLens == nil {
+		h.uConnIDLens = make(map[int]int)
+	}
+	h.uConnIDLens[l]++
+}
+
+func (h *packetHandlerMap) releaseUConnIDLen(l int) {
+	if l == 0 || l == h.connIDLen {
+		return
+	}
+	if h.uConnIDLens[l] <= 1 {
+		delete(h.uConnIDLens, l)
+		return
+	}
+	h.uConnIDLens[l]--
+}
+
+// getUConn returns the connection dialed with a QUICSpec that a packet belongs to,
+// for packets that couldn't be associated with a connection using the connection ID
+// length of the Transport.
+func (h *packetHandlerMap) getUConn(p receivedPacket) (packetHandler, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if wire.IsLongHeaderPacket(p.data[0]) {
+		// the length of the connection ID is encoded in long header packets
+		if connID, err := wire.ParseConnectionID(p.data, 0); err != nil || connID.Len() != 0 {
+			return nil, false
+		}
+	} else {
+		for l := range h.uConnIDLens {
+			connID, err := wire.ParseConnectionID(p.data, l)
+			if err != nil {
+				continue
+			}
+			if handler, ok := h.handlers[connID]; ok {
+				return handler, true
+			}
+		}
+	}
+	handler, ok := h.uZeroLenHandlers[p.remoteAddr.String()]
+	return handler, ok
+}
+
+// uConnRunner is the connRunner of a connection dialed with a QUICSpec whose connection
+// IDs don't have the length used by the Transport. It keeps track of the lengths in use,
+// and maps a zero-length connection ID to the remote address.
+type uConnRunner struct {
+	*packetHandlerMap
+
+	addr    string
+	handler packetHandler // set by addUConn
+}
+
+var _ connRunner = &uConnRunner{}
+
+func newUConnRunner(h *packetHandlerMap, addr net.Addr) *uConnRunner {
+	return &uConnRunner{packetHandlerMap: h, addr: addr.String()}
+}
+
+// Add adds a connection ID. The zero-length connection ID is added when a path to a new
+// remote address is used, see Conn.pathConnRunner.
+func (r *uConnRunner) Add(id protocol.ConnectionID, handler packetHandler) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if id.Len() == 0 {
+		if r.zeroLenConnInUse(r.addr) {
+			return false
+		}
+		if r.uZeroLenHandlers == nil {
+			r.uZeroLenHandlers = make(map[string]packetHandler)
+		}
+		r.uZeroLenHandlers[r.addr] = handler
+		r.handler = handler
+		return true
+	}
+	if _, ok := r.handlers[id]; ok {
+		return false
+	}
+	r.handlers[id] = handler
+	r.retainUConnIDLen(id.Len())
+	return true
+}
+
+func (r *uConnRunner) Remove(id protocol.ConnectionID) {
+	if id.Len() == 0 {
+		r.removeIfCurrent(r.handler)
+		return
+	}
+	r.mutex.Lock()
+	if _, ok := r.handlers[id]; ok {
+		delete(r.handlers, id)
+		r.releaseUConnIDLen(id.Len())
+	}
+	r.mutex.Unlock()
+}
+
+// removeIfCurrent removes the handler for the remote address, unless the address
+// has been dialed again in the meantime.
+func (r *uConnRunner) removeIfCurrent(handler packetHandler) {
+	r.mutex.Lock()
+	if r.uZeroLenHandlers[r.addr] == handler {
+		delete(r.uZeroLenHandlers, r.addr)
+	}
+	r.mutex.Unlock()
+}
+
+func (r *uConnRunner) ReplaceWithClosed(ids []protocol.ConnectionID, connClosePacket []byte, expiry time.Duration) {
+	other := make([]protocol.ConnectionID, 0, len(ids))
+	var zeroLen bool
+	for _, id := range ids {
+		if id.Len() == 0 {
+			zeroLen = true
+		} else {
+			other = append(other, id)
+		}
+	}
+	if len(other) > 0 {
+		r.packetHandlerMap.ReplaceWithClosed(other, connClosePacket, expiry)
+		time.AfterFunc(expiry, func() {
+			r.mutex.Lock()
+			for _, id := range other {
+				r.releaseUConnIDLen(id.Len())
+			}
+			r.mutex.Unlock()
+		})
+	}
+	if !zeroLen {
+		return
+	}
+
+	var handler packetHandler
+	if connClosePacket != nil {
+		handler = newClosedLocalConn(
+			func(addr net.Addr, info packetInfo) {
+				select {
+				case r.closeQueue <- closePacket{payload: connClosePacket, addr: addr, info: info}:
+				default:
+				}
+			},
+			r.logger,
+		)
+	} else {
+		handler = newClosedRemoteConn()
+	}
+	r.mutex.Lock()
+	if r.uZeroLenHandlers[r.addr] == r.handler {
+		r.uZeroLenHandlers[r.addr] = handler
+	}
+	r.mutex.Unlock()
+	time.AfterFunc(expiry, func() { r.removeIfCurrent(handler) })
+}
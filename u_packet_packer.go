@@ -1,15 +1,17 @@
 package quic
 
 import (
-	"bytes"
+	"cmp"
 	"errors"
 	"fmt"
+	"math/rand/v2"
+	"slices"
 
+	"github.com/Noooste/uquic-go/internal/ackhandler"
 	"github.com/Noooste/uquic-go/internal/handshake"
 	"github.com/Noooste/uquic-go/internal/monotime"
 	"github.com/Noooste/uquic-go/internal/protocol"
 	"github.com/Noooste/uquic-go/internal/wire"
-	"github.com/gaukas/clienthellod"
 )
 
 // uPacketPacker is an extended packetPacker which is used
@@ -22,15 +24,26 @@ type uPacketPacker struct {
 	// qfs                QUICFrames // [UQUIC] uses QUICFrames to customize encrypted frames
 	// udpDatagramMinSize int
 	uSpec *QUICSpec // [UQUIC]
+	uRand *connRand // [UQUIC]
+
+	// [UQUIC] the offset of the ClientHello sent after a HelloRetryRequest
+	secondClientHelloOffset protocol.ByteCount
+	// [UQUIC] the ClientHellos sent, by offset, used to locate the cut points of a
+	// QUICScrambledFrames and the packets carrying the remainder of a ClientHello
+	clientHellos map[protocol.ByteCount]*sentClientHello
 }
 
 func newUPacketPacker(
 	packetPacker *packetPacker,
 	uSpec *QUICSpec, // [UQUIC]
+	uRand *connRand, // [UQUIC]
 ) *uPacketPacker {
+	// [UQUIC] the order of the frames in the packets is chosen using the connection's randomness
+	packetPacker.rand = *rand.New(rand.NewPCG(uRand.Uint64(), uRand.Uint64()))
 	return &uPacketPacker{
 		packetPacker: packetPacker,
 		uSpec:        uSpec, // [UQUIC]
+		uRand:        uRand, // [UQUIC]
 	}
 }
 
@@ -52,7 +65,7 @@ func (p *uPacketPacker) PackCoalescedPacket(onlyAck bool, maxSize protocol.ByteC
 	var size protocol.ByteCount
 	if initialSealer != nil {
 		initialHdr, initialPayload = p.maybeGetCryptoPacket(
-			maxSize-protocol.ByteCount(initialSealer.Overhead()),
+			p.maxInitialPacketSize(maxSize-protocol.ByteCount(initialSealer.Overhead()), v), // [UQUIC]
 			protocol.EncryptionInitial,
 			now,
 			false,
@@ -69,9 +82,15 @@ func (p *uPacketPacker) PackCoalescedPacket(onlyAck bool, maxSize protocol.ByteC
 		// }
 	}
 
+	// [UQUIC] see ClientHelloPacketSpec.Coalesce
+	coalesce := true
+	if chp := p.initialPacketSpec(initialPayload); chp != nil && !chp.Coalesce {
+		coalesce = false
+	}
+
 	// Add a Handshake packet.
 	var handshakeSealer sealer
-	if (onlyAck && size == 0) || (!onlyAck && size < maxSize-protocol.MinCoalescedPacketSize) {
+	if coalesce && ((onlyAck && size == 0) || (!onlyAck && size < maxSize-protocol.MinCoalescedPacketSize)) { // [UQUIC]
 		var err error
 		handshakeSealer, err = p.cryptoSetup.GetHandshakeSealer()
 		if err != nil && err != handshake.ErrKeysDropped && err != handshake.ErrKeysNotYetAvailable {
@@ -98,7 +117,7 @@ func (p *uPacketPacker) PackCoalescedPacket(onlyAck bool, maxSize protocol.ByteC
 	var oneRTTSealer handshake.ShortHeaderSealer
 	var connID protocol.ConnectionID
 	var kp protocol.KeyPhaseBit
-	if (onlyAck && size == 0) || (!onlyAck && size < maxSize-protocol.MinCoalescedPacketSize) {
+	if coalesce && ((onlyAck && size == 0) || (!onlyAck && size < maxSize-protocol.MinCoalescedPacketSize)) { // [UQUIC]
 		var err error
 		oneRTTSealer, err = p.cryptoSetup.Get1RTTSealer()
 		if err != nil && !errors.Is(err, handshake.ErrKeysDropped) && !errors.Is(err, handshake.ErrKeysNotYetAvailable) {
@@ -177,10 +196,58 @@ func (p *uPacketPacker) PackCoalescedPacket(onlyAck bool, maxSize protocol.ByteC
 		}
 		packet.shortHdrPacket = &shp
 	}
+	// [UQUIC]
+	if initialPayload.length > 0 && !onlyAck && len(initialPayload.frames) > 0 {
+		p.padDatagram(buffer, initialPayload)
+	}
 	return packet, nil
 }
 
 // [UQUIC]
+// maxInitialPacketSize limits the size of Initial packets, such that the frames rebuilt
+// by a QUICRandomFrames still fit into its Length. This also applies to retransmissions,
+// which could otherwise combine the crypto data of several packets.
+// The frames added by a QUICScrambledFrames must also fit into the packet.
+// The FrameBuilder of the next packet carrying a ClientHello applies, see
+// InitialPacketSpec.ClientHelloPackets.
+func (p *uPacketPacker) maxInitialPacketSize(maxSize protocol.ByteCount, v protocol.Version) protocol.ByteCount {
+	fb := p.uSpec.InitialPacketSpec.FrameBuilder
+	if offset, ok := p.nextInitialCryptoOffset(); ok {
+		if chp := p.clientHelloPacketSpec(offset); chp != nil {
+			fb = chp.FrameBuilder
+		}
+	}
+	var qrf *QUICRandomFrames
+	var overhead int
+	switch fb := fb.(type) {
+	case *QUICRandomFrames:
+		qrf, overhead = fb, fb.maxFrameOverhead()
+	case *QUICScrambledFrames:
+		qrf, overhead = fb.Layout, fb.maxFrameOverhead()
+		maxSize -= protocol.ByteCount(overhead)
+	default:
+		return maxSize
+	}
+	if qrf == nil || qrf.Length == 0 {
+		return maxSize
+	}
+	hdrLen := p.getLongHeader(protocol.EncryptionInitial, v).GetLength(v)
+	budget := hdrLen + protocol.ByteCount(qrf.Length) - protocol.ByteCount(overhead)
+	return min(maxSize, budget)
+}
+
+// nextInitialCryptoOffset returns the offset of the crypto data that the next Initial
+// packet will carry, retransmissions first.
+func (p *uPacketPacker) nextInitialCryptoOffset() (protocol.ByteCount, bool) {
+	if q := p.retransmissionQueue.initial; q != nil && len(q.crypto) > 0 {
+		return q.crypto[0].Offset, true
+	}
+	if p.initialStream.HasData() {
+		return p.initialStream.writeOffset, true
+	}
+	return 0, false
+}
+
 func (p *uPacketPacker) appendInitialPacket(buffer *packetBuffer, header *wire.ExtendedHeader, pl payload, encLevel protocol.EncryptionLevel, sealer sealer, v protocol.Version) (*longHeaderPacket, error) {
 	// Shouldn't need this?
 	// if p.uSpec.InitialPacketSpec.InitPacketNumberLength > 0 {
@@ -192,6 +259,14 @@ func (p *uPacketPacker) appendInitialPacket(buffer *packetBuffer, header *wire.E
 		return nil, err
 	}
 
+	// [UQUIC] pad the datagram with PADDING frames, see ClientHelloPacketSpec.PaddingFrames
+	if chp := p.initialPacketSpec(pl); chp != nil && chp.PaddingFrames {
+		size := len(buffer.Data) + int(header.GetLength(v)) + len(uPayload) + sealer.Overhead()
+		if minSize := p.udpDatagramMinSize(chp); size < minSize {
+			uPayload = append(uPayload, make([]byte, minSize-size)...)
+		}
+	}
+
 	pnLen := protocol.ByteCount(header.PacketNumberLen)
 	header.Length = pnLen + protocol.ByteCount(sealer.Overhead()) + protocol.ByteCount(len(uPayload))
 
@@ -214,16 +289,6 @@ func (p *uPacketPacker) appendInitialPacket(buffer *packetBuffer, header *wire.E
 
 	// fmt.Printf("Post-Encryption: %x\n", raw)
 
-	// [UQUIC]
-	// append zero to buffer.Data until min size is reached
-	minUDPSize := p.uSpec.UDPDatagramMinSize
-	if minUDPSize == 0 {
-		minUDPSize = DefaultUDPDatagramMinSize
-	}
-	if len(buffer.Data) < minUDPSize {
-		buffer.Data = append(buffer.Data, make([]byte, minUDPSize-len(buffer.Data))...)
-	}
-
 	if pn := p.pnManager.PopPacketNumber(encLevel); pn != header.PacketNumber {
 		return nil, fmt.Errorf("packetPacker BUG: Peeked and Popped packet numbers do not match: expected %d, got %d", pn, header.PacketNumber)
 	}
@@ -236,79 +301,306 @@ func (p *uPacketPacker) appendInitialPacket(buffer *packetBuffer, header *wire.E
 	}, nil
 }
 
+// [UQUIC]
+// padDatagram appends zeros to the datagram carrying the Initial packet until the
+// minimum size of the UDP datagram is reached. It is called once all packets have been
+// coalesced into the datagram, since the receiver discards everything after the zeros.
+func (p *uPacketPacker) padDatagram(buffer *packetBuffer, pl payload) {
+	minUDPSize := p.udpDatagramMinSize(p.initialPacketSpec(pl))
+	if len(buffer.Data) < minUDPSize {
+		buffer.Data = append(buffer.Data, make([]byte, minUDPSize-len(buffer.Data))...)
+	}
+}
+
+// udpDatagramMinSize returns the minimum size of the UDP datagram carrying an Initial
+// packet, given its ClientHelloPacketSpec, which is nil for other packets.
+func (p *uPacketPacker) udpDatagramMinSize(chp *ClientHelloPacketSpec) int {
+	if chp != nil && chp.UDPDatagramMinSize != 0 {
+		return chp.UDPDatagramMinSize
+	}
+	if p.uSpec.UDPDatagramMinSize != 0 {
+		return p.uSpec.UDPDatagramMinSize
+	}
+	return DefaultUDPDatagramMinSize
+}
+
+// MarshalInitialPacketPayload marshals the frames of a client Initial packet.
+//
+// [UQUIC] Every Initial packet carrying the beginning of a ClientHello is built by the
+// QUICSpec's FrameBuilder: the first Initial packet, its retransmissions (after a loss,
+// as a PTO probe or after a Retry), and the ClientHello sent after a HelloRetryRequest.
+// Since the FrameBuilder is invoked for every packet, a QUICRandomFrames picks a new
+// layout each time, as browsers do. The packets carrying the remainder of a ClientHello
+// are built by the FrameBuilders of the InitialPacketSpec's ClientHelloPackets. If
+// they have none, a QUICScrambledFrames also cuts the CRYPTO frames of these packets.
+// Other Initial packets are sent as built by quic-go.
 func (p *uPacketPacker) MarshalInitialPacketPayload(pl payload, v protocol.Version) ([]byte, error) {
-	var originalFrameBytes []byte
+	var b []byte
+	if pl.ack != nil {
+		var err error
+		b, err = pl.ack.Append(b, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ackLen := len(b)
 
-	// Collect ALL frame types, not just crypto frames
+	if offset, cryptoData, ok := contiguousCryptoData(pl.frames); ok {
+		var build func(cryptoData []byte) ([]byte, error)
+		if p.startsClientHello(offset, cryptoData) {
+			if ch := p.clientHello(offset, cryptoData); ch != nil {
+				ch.sent(offset, cryptoData)
+			}
+			build = p.frameBuilder(p.uSpec.InitialPacketSpec.FrameBuilder, offset, offset, ackLen)
+		} else if chOffset, ch, _ := p.locateClientHello(offset); ch != nil {
+			index := ch.sent(offset, cryptoData)
+			if chp := p.clientHelloPacketSpecAt(index); chp != nil && chp.FrameBuilder != nil {
+				build = p.frameBuilder(chp.FrameBuilder, chOffset, offset, ackLen)
+			} else {
+				build = p.continuationFrameBuilder(chOffset, offset)
+			}
+		}
+		if build != nil {
+			frames, err := build(cryptoData)
+			if err != nil {
+				return nil, err
+			}
+			return append(b, frames...), nil
+		}
+	}
 	for _, f := range pl.frames {
 		var err error
-		originalFrameBytes, err = f.Frame.Append(originalFrameBytes, v)
+		b, err = f.Frame.Append(b, v)
 		if err != nil {
 			return nil, err
 		}
 	}
+	return b, nil
+}
 
-	// Check if we need to do custom frame building
-	// Only do custom frame building for the FIRST packet (when we have the complete ClientHello)
-
-	// Parse frames to check if this looks like a first packet or continuation
-	r := bytes.NewReader(originalFrameBytes)
-	qchframes, err := clienthellod.ReadAllFrames(r)
-	if err != nil {
-		return nil, err
+// contiguousCryptoData returns the crypto data carried by the frames if it is contiguous.
+// Packets that carry other frames than CRYPTO frames are not rebuilt.
+func contiguousCryptoData(frames []ackhandler.Frame) (offset protocol.ByteCount, data []byte, ok bool) {
+	cryptoFrames := make([]*wire.CryptoFrame, 0, len(frames))
+	for _, f := range frames {
+		cf, isCrypto := f.Frame.(*wire.CryptoFrame)
+		if !isCrypto {
+			return 0, nil, false
+		}
+		cryptoFrames = append(cryptoFrames, cf)
+	}
+	if len(cryptoFrames) == 0 {
+		return 0, nil, false
+	}
+	slices.SortFunc(cryptoFrames, func(a, b *wire.CryptoFrame) int { return cmp.Compare(a.Offset, b.Offset) })
+	offset = cryptoFrames[0].Offset
+	for _, cf := range cryptoFrames {
+		if cf.Offset != offset+protocol.ByteCount(len(data)) {
+			return 0, nil, false
+		}
+		data = append(data, cf.Data...)
 	}
+	return offset, data, true
+}
 
-	// Check if this is the first crypto packet (starts at offset 0)
-	isFirstPacket := false
-	for _, frame := range qchframes {
-		if cryptoFrame, ok := frame.(*clienthellod.CRYPTO); ok {
-			if cryptoFrame.Offset == 0 {
-				isFirstPacket = true
-				break
-			}
+// startsClientHello says if the crypto data at the given offset starts a ClientHello.
+func (p *uPacketPacker) startsClientHello(offset protocol.ByteCount, data []byte) bool {
+	switch {
+	case offset == 0:
+		// The ClientHello sent after a HelloRetryRequest directly follows the first one.
+		if len(data) >= 4 && data[0] == 1 { // handshake message type ClientHello
+			p.secondClientHelloOffset = 4 + clientHelloLen(data)
 		}
+		return true
+	case offset == p.secondClientHelloOffset:
+		return true
+	default:
+		return false
+	}
+}
+
+func clientHelloLen(data []byte) protocol.ByteCount {
+	return protocol.ByteCount(data[1])<<16 | protocol.ByteCount(data[2])<<8 | protocol.ByteCount(data[3])
+}
+
+// sentClientHello is a ClientHello sent in one or more Initial packets.
+type sentClientHello struct {
+	offset protocol.ByteCount
+	data   []byte
+	// the offsets at which the packets carrying the remainder of the ClientHello start
+	packetStarts []protocol.ByteCount
+	// the end of the crypto data of the ClientHello sent so far
+	sentEnd protocol.ByteCount
+}
+
+// packetIndex returns the index of the packet carrying the crypto data at offset among
+// the packets carrying the ClientHello: 0 for the first packet, 1 for the second one.
+// Retransmitted crypto data keeps the index of the packet it was first sent in.
+func (ch *sentClientHello) packetIndex(offset protocol.ByteCount) int {
+	if offset == ch.offset {
+		return 0
+	}
+	if offset >= ch.sentEnd {
+		return len(ch.packetStarts) + 1
+	}
+	i, found := slices.BinarySearch(ch.packetStarts, offset)
+	if found {
+		i++
+	}
+	return i
+}
+
+// sent records that the crypto data at offset is sent, and returns the index of the
+// packet carrying it.
+func (ch *sentClientHello) sent(offset protocol.ByteCount, data []byte) int {
+	index := ch.packetIndex(offset)
+	if index > len(ch.packetStarts) {
+		ch.packetStarts = append(ch.packetStarts, offset)
+	}
+	ch.sentEnd = max(ch.sentEnd, offset+protocol.ByteCount(len(data)))
+	return index
+}
+
+// clientHello returns the ClientHello starting at offset, given the crypto data of a
+// packet starting it. When the first packet carrying a ClientHello is packed, the
+// remainder of the ClientHello is still queued in the initial crypto stream. The
+// ClientHello is kept for retransmissions and for the packets carrying its remainder.
+// It returns nil if the ClientHello can't be reassembled.
+func (p *uPacketPacker) clientHello(offset protocol.ByteCount, data []byte) *sentClientHello {
+	if ch, ok := p.clientHellos[offset]; ok {
+		return ch
+	}
+	if len(data) < 4 {
+		return nil
+	}
+	l := 4 + clientHelloLen(data)
+	chData := data
+	if protocol.ByteCount(len(chData)) < l && p.initialStream.writeOffset == offset+protocol.ByteCount(len(data)) {
+		chData = append(slices.Clip(chData), p.initialStream.writeBuf...)
 	}
+	if protocol.ByteCount(len(chData)) < l {
+		return nil
+	}
+	ch := &sentClientHello{offset: offset, data: slices.Clone(chData[:l]), sentEnd: offset}
+	if p.clientHellos == nil {
+		p.clientHellos = make(map[protocol.ByteCount]*sentClientHello)
+	}
+	p.clientHellos[offset] = ch
+	return ch
+}
 
-	// If this is not the first packet, just return the original frames
-	// This preserves PADDING/PING frames in continuation packets
-	if !isFirstPacket {
-		return originalFrameBytes, nil
+// locateClientHello returns the ClientHello containing the crypto data at offset, its
+// offset, and the index of the packet carrying the data, see sentClientHello.packetIndex.
+// It returns a nil sentClientHello if the offset isn't part of a ClientHello that was
+// sent.
+func (p *uPacketPacker) locateClientHello(offset protocol.ByteCount) (chOffset protocol.ByteCount, ch *sentClientHello, index int) {
+	for chOffset, ch := range p.clientHellos {
+		if offset >= chOffset && offset < chOffset+protocol.ByteCount(len(ch.data)) {
+			return chOffset, ch, ch.packetIndex(offset)
+		}
 	}
+	return 0, nil, 0
+}
 
-	// Only for the first packet, check if we need custom frame building
-	if p.uSpec.InitialPacketSpec.FrameBuilder == nil {
-		// No custom frame builder, return original frames
-		return originalFrameBytes, nil
+// clientHelloPacketSpec returns the ClientHelloPacketSpec of the packet carrying the
+// crypto data at offset, or nil if it is not a packet carrying the remainder of a
+// ClientHello, or if the InitialPacketSpec has no ClientHelloPackets.
+func (p *uPacketPacker) clientHelloPacketSpec(offset protocol.ByteCount) *ClientHelloPacketSpec {
+	if _, ch, index := p.locateClientHello(offset); ch != nil {
+		return p.clientHelloPacketSpecAt(index)
 	}
+	return nil
+}
 
-	// Check if it's empty QUICFrames (default behavior)
-	if qf, ok := p.uSpec.InitialPacketSpec.FrameBuilder.(QUICFrames); ok && len(qf) == 0 {
-		// Empty QUICFrames means default behavior - return original frames
-		return originalFrameBytes, nil
+// clientHelloPacketSpecAt returns the ClientHelloPacketSpec of the packet with the
+// given index among the packets carrying a ClientHello.
+func (p *uPacketPacker) clientHelloPacketSpecAt(index int) *ClientHelloPacketSpec {
+	specs := p.uSpec.InitialPacketSpec.ClientHelloPackets
+	if index == 0 || len(specs) == 0 {
+		return nil
 	}
+	return &specs[min(index, len(specs))-1]
+}
 
-	// For custom frame builders (QUICRandomFrames or specific QUICFrames),
-	// extract only crypto data and rebuild frames according to spec
+// initialPacketSpec returns the ClientHelloPacketSpec of an Initial packet, see
+// clientHelloPacketSpec.
+func (p *uPacketPacker) initialPacketSpec(pl payload) *ClientHelloPacketSpec {
+	if offset, _, ok := contiguousCryptoData(pl.frames); ok {
+		return p.clientHelloPacketSpec(offset)
+	}
+	return nil
+}
 
-	// Filter out only CRYPTO frames for reassembly
-	var cryptoFrames []clienthellod.Frame
-	for _, frame := range qchframes {
-		if frame.FrameType() == clienthellod.QUICFrame_CRYPTO {
-			cryptoFrames = append(cryptoFrames, frame)
+// clientHelloCutPoints returns the offsets in the crypto stream at which a
+// QUICScrambledFrames cuts the ClientHello starting at offset.
+func clientHelloCutPoints(sf *QUICScrambledFrames, offset protocol.ByteCount, clientHello []byte) []int {
+	var cuts []int
+	for _, cut := range sf.cutPoints(clientHello) {
+		if cut > 0 && cut < len(clientHello) {
+			cuts = append(cuts, int(offset)+cut)
 		}
 	}
+	return cuts
+}
 
-	// Extract crypto data
-	cryptoData, err := clienthellod.ReassembleCRYPTOFrames(qchframes)
-	if err != nil {
-		// If we can't reassemble (due to fragmentation), just return original
-		// This preserves the existing frame structure including PADDING/PING
-		return originalFrameBytes, nil
+// frameBuilder returns the function building the frames of an Initial packet carrying
+// the crypto data at the given offset of the ClientHello starting at chOffset, or nil if
+// the FrameBuilder doesn't apply. The length of the ACK frame sent in the same packet is
+// deducted from the padding of a QUICRandomFrames (or the Layout of a
+// QUICScrambledFrames), such that the packet size doesn't change.
+func (p *uPacketPacker) frameBuilder(fb QUICFrameBuilder, chOffset, offset protocol.ByteCount, ackLen int) func(cryptoData []byte) ([]byte, error) {
+	switch fb := fb.(type) {
+	case nil:
+		return nil
+	case QUICFrames:
+		if len(fb) == 0 { // empty QUICFrames means the default behavior
+			return nil
+		}
+		return fb.withCryptoOffset(int(offset)).Build
+	case *QUICRandomFrames:
+		qrf := *fb
+		if int(qrf.Length) > ackLen {
+			qrf.Length -= uint16(ackLen)
+		}
+		return func(cryptoData []byte) ([]byte, error) { return qrf.buildAt(p.uRand.Rand, int(offset), cryptoData) }
+	case *QUICScrambledFrames:
+		sf := *fb
+		if sf.Layout != nil && int(sf.Layout.Length) > ackLen {
+			layout := *sf.Layout
+			layout.Length -= uint16(ackLen)
+			sf.Layout = &layout
+		}
+		return func(cryptoData []byte) ([]byte, error) {
+			clientHello := cryptoData
+			if ch := p.clientHellos[chOffset]; ch != nil {
+				clientHello = ch.data
+			}
+			return sf.buildAt(p.uRand.Rand, int(offset), cryptoData, clientHelloCutPoints(fb, chOffset, clientHello))
+		}
+	default:
+		// custom QUICFrameBuilders only build the first ClientHello
+		if offset != 0 {
+			return nil
+		}
+		return fb.Build
 	}
+}
 
-	// Use custom frame builder to create new frame structure
-	// This will include CRYPTO, PING, and PADDING frames as specified
-	return p.uSpec.InitialPacketSpec.FrameBuilder.Build(cryptoData)
+// continuationFrameBuilder returns the function building the frames of an Initial packet
+// carrying the remainder of the ClientHello starting at chOffset, from the given offset,
+// if its ClientHelloPacketSpec has no FrameBuilder and the FrameBuilder of the
+// InitialPacketSpec is a QUICScrambledFrames. It returns nil otherwise.
+func (p *uPacketPacker) continuationFrameBuilder(chOffset, offset protocol.ByteCount) func(cryptoData []byte) ([]byte, error) {
+	fb, ok := p.uSpec.InitialPacketSpec.FrameBuilder.(*QUICScrambledFrames)
+	if !ok {
+		return nil
+	}
+	sf := *fb
+	sf.Layout = nil // only the first packet is padded
+	cuts := clientHelloCutPoints(fb, chOffset, p.clientHellos[chOffset].data)
+	return func(cryptoData []byte) ([]byte, error) {
+		return sf.buildAt(p.uRand.Rand, int(offset), cryptoData, cuts)
+	}
 }
 
 func (p *uPacketPacker) PackPTOProbePacket(
@@ -381,6 +673,7 @@ func (p *uPacketPacker) PackPTOProbePacket(
 			if err != nil {
 				return nil, err
 			}
+			p.padDatagram(buffer, pl)
 
 			packet.longHdrPackets = []*longHeaderPacket{initPkt}
 			return packet, nil
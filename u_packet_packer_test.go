@@ -0,0 +1,419 @@
+package quic
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/handshake"
+	"github.com/Noooste/uquic-go/internal/protocol"
+	"github.com/Noooste/uquic-go/internal/testdata"
+	"github.com/Noooste/uquic-go/internal/wire"
+	tls "github.com/Noooste/utls"
+
+	"github.com/stretchr/testify/require"
+)
+
+// sentInitialPacket is a decrypted Initial packet sent by the client.
+type sentInitialPacket struct {
+	datagram     int // the index of the datagram carrying the packet
+	hdr          *wire.ExtendedHeader
+	payloadLen   int
+	numPING      int
+	numPADDING   int // adjacent PADDING frames are counted as one
+	ack          *wire.AckFrame
+	cryptoFrames []*wire.CryptoFrame
+}
+
+func (p *sentInitialPacket) startsClientHello() bool {
+	for _, f := range p.cryptoFrames {
+		if f.Offset == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// decryptClientInitials decrypts the Initial packets in the datagrams sent by a client.
+// The Initial keys are derived from the Destination Connection ID of the first packet,
+// and derived again when the token changes, i.e. after a Retry.
+func decryptClientInitials(t *testing.T, datagrams [][]byte) []sentInitialPacket {
+	t.Helper()
+	parser := wire.NewFrameParser(false, false, false)
+	var (
+		packets []sentInitialPacket
+		opener  handshake.LongHeaderOpener
+		token   []byte
+		version protocol.Version
+	)
+	for i, datagram := range datagrams {
+		data := append([]byte(nil), datagram...)
+		for len(data) > 0 && wire.IsLongHeaderPacket(data[0]) {
+			hdr, packetData, rest, err := wire.ParsePacket(data)
+			require.NoError(t, err)
+			data = rest
+			if hdr.Type != protocol.PacketTypeInitial {
+				continue
+			}
+			if opener == nil || !bytes.Equal(hdr.Token, token) || hdr.Version != version {
+				_, opener = handshake.NewInitialAEAD(hdr.DestConnectionID, protocol.PerspectiveServer, hdr.Version)
+				token = hdr.Token
+				version = hdr.Version
+			}
+			extHdr, err := unpackLongHeader(opener, hdr, packetData)
+			require.NoError(t, err)
+			extHdrLen := extHdr.ParsedLen()
+			extHdr.PacketNumber = opener.DecodePacketNumber(extHdr.PacketNumber, extHdr.PacketNumberLen)
+			payload, err := opener.Open(nil, packetData[extHdrLen:], extHdr.PacketNumber, packetData[:extHdrLen])
+			require.NoError(t, err)
+
+			p := sentInitialPacket{datagram: i, hdr: extHdr, payloadLen: len(payload)}
+			for b := payload; len(b) > 0; {
+				if b[0] == 0 {
+					for len(b) > 0 && b[0] == 0 {
+						b = b[1:]
+					}
+					p.numPADDING++
+					continue
+				}
+				typ, l, err := parser.ParseType(b, protocol.EncryptionInitial)
+				require.NoError(t, err)
+				b = b[l:]
+				if typ.IsAckFrameType() {
+					ack, l, err := parser.ParseAckFrame(typ, b, protocol.EncryptionInitial, hdr.Version)
+					require.NoError(t, err)
+					p.ack = &wire.AckFrame{AckRanges: append([]wire.AckRange(nil), ack.AckRanges...)}
+					b = b[l:]
+					continue
+				}
+				frame, l, err := parser.ParseLessCommonFrame(typ, b, hdr.Version)
+				require.NoError(t, err)
+				b = b[l:]
+				switch frame := frame.(type) {
+				case *wire.PingFrame:
+					p.numPING++
+				case *wire.CryptoFrame:
+					p.cryptoFrames = append(p.cryptoFrames, frame)
+				default:
+					t.Fatalf("unexpected frame in Initial packet: %#v", frame)
+				}
+			}
+			packets = append(packets, p)
+		}
+	}
+	return packets
+}
+
+// deterministicFramesSpec returns a QUICSpec whose frame builder always produces
+// one PING frame, two CRYPTO frames and one PADDING frame.
+func deterministicFramesSpec(t *testing.T) *QUICSpec {
+	spec, err := QUICID2Spec(QUICChrome_115)
+	require.NoError(t, err)
+	spec.InitialPacketSpec.FrameBuilder = &QUICRandomFrames{
+		MinPING: 1, MaxPING: 2,
+		MinCRYPTO: 2, MaxCRYPTO: 3,
+		MinPADDING: 1, MaxPADDING: 2,
+		Length: 1231 - 16,
+	}
+	return &spec
+}
+
+func requireDeterministicFrames(t *testing.T, p sentInitialPacket) {
+	t.Helper()
+	require.Equal(t, 1231-16, p.payloadLen)
+	require.Equal(t, 1, p.numPING)
+	require.Len(t, p.cryptoFrames, 2)
+	require.Equal(t, 1, p.numPADDING)
+}
+
+func TestUInitialRetransmission(t *testing.T) {
+	multiPacket, err := testQUICID2Spec(testQUICIDPQ)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name          string
+		spec          *QUICSpec
+		deterministic bool
+	}{
+		{name: "single packet", spec: deterministicFramesSpec(t), deterministic: true},
+		{name: "ClientHello spanning two packets", spec: &multiPacket},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ln := newUTransportTestServer(t)
+			tr := newUTransportWithSpecForTest(t, tc.spec)
+			// drop the first datagram, carrying the beginning of the ClientHello
+			recorder := newLossyInitialRecorder(t, ln.Addr(), func(n int) bool { return n == 0 })
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			tlsConf := &tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA(), NextProtos: []string{"h3"}}
+			conn, err := tr.Dial(ctx, recorder.conn.LocalAddr(), tlsConf, nil)
+			require.NoError(t, err)
+			conn.CloseWithError(0, "")
+
+			var first []sentInitialPacket // packets starting the ClientHello
+			for _, p := range decryptClientInitials(t, recorder.clientDatagrams()) {
+				if p.startsClientHello() {
+					first = append(first, p)
+				}
+			}
+			require.GreaterOrEqual(t, len(first), 2)
+			lost, retransmitted := first[0], first[1]
+			require.Zero(t, lost.datagram)
+			require.Greater(t, retransmitted.hdr.PacketNumber, lost.hdr.PacketNumber)
+			require.Equal(t, lost.payloadLen, retransmitted.payloadLen)
+			require.NotZero(t, retransmitted.numPADDING)
+			require.Equal(t, cryptoDataLen(lost.cryptoFrames), cryptoDataLen(retransmitted.cryptoFrames))
+			if tc.deterministic {
+				requireDeterministicFrames(t, lost)
+				requireDeterministicFrames(t, retransmitted)
+			}
+		})
+	}
+}
+
+func cryptoDataLen(frames []*wire.CryptoFrame) int {
+	var l int
+	for _, f := range frames {
+		l += len(f.Data)
+	}
+	return l
+}
+
+func TestUInitialAfterRetry(t *testing.T) {
+	tlsServerConf := testdata.GetTLSConfig()
+	tlsServerConf.NextProtos = []string{"h3"}
+	serverConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	server := &Transport{Conn: serverConn, VerifySourceAddress: func(net.Addr) bool { return true }}
+	t.Cleanup(func() { server.Close() })
+	ln, err := server.Listen(tlsServerConf, nil)
+	require.NoError(t, err)
+	go func() {
+		for {
+			if _, err := ln.Accept(context.Background()); err != nil {
+				return
+			}
+		}
+	}()
+
+	tr := newUTransportWithSpecForTest(t, deterministicFramesSpec(t))
+	recorder := newInitialRecorder(t, ln.Addr())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tlsConf := &tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA(), NextProtos: []string{"h3"}}
+	conn, err := tr.Dial(ctx, recorder.conn.LocalAddr(), tlsConf, nil)
+	require.NoError(t, err)
+	defer conn.CloseWithError(0, "")
+
+	datagrams := recorder.clientDatagrams()
+	packets := decryptClientInitials(t, datagrams)
+	require.GreaterOrEqual(t, len(packets), 2)
+	beforeRetry, afterRetry := packets[0], packets[1]
+	require.Empty(t, beforeRetry.hdr.Token)
+	require.NotEmpty(t, afterRetry.hdr.Token)
+	require.NotEqual(t, beforeRetry.hdr.DestConnectionID, afterRetry.hdr.DestConnectionID)
+	requireDeterministicFrames(t, beforeRetry)
+	requireDeterministicFrames(t, afterRetry)
+	require.True(t, afterRetry.startsClientHello())
+	require.Nil(t, afterRetry.ack)
+	// the Initial packet grows by the size of the token
+	require.Greater(t, len(datagrams[1]), len(datagrams[0]))
+}
+
+func TestUInitialAfterHelloRetryRequest(t *testing.T) {
+	tlsServerConf := testdata.GetTLSConfig()
+	tlsServerConf.NextProtos = []string{"h3"}
+	tlsServerConf.CurvePreferences = []tls.CurveID{tls.CurveP384} // not offered in the key shares
+	ln, err := ListenAddr("127.0.0.1:0", tlsServerConf, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			if _, err := ln.Accept(context.Background()); err != nil {
+				return
+			}
+		}
+	}()
+
+	tr := newUTransportWithSpecForTest(t, deterministicFramesSpec(t))
+	recorder := newInitialRecorder(t, ln.Addr())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tlsConf := &tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA(), NextProtos: []string{"h3"}}
+	conn, err := tr.Dial(ctx, recorder.conn.LocalAddr(), tlsConf, nil)
+	require.NoError(t, err)
+	defer conn.CloseWithError(0, "")
+
+	packets := decryptClientInitials(t, recorder.clientDatagrams())
+	require.GreaterOrEqual(t, len(packets), 2)
+	first, second := packets[0], packets[1]
+	requireDeterministicFrames(t, first)
+	// the second ClientHello follows the first one in the crypto stream
+	firstLen := cryptoDataLen(first.cryptoFrames)
+	for _, f := range second.cryptoFrames {
+		require.GreaterOrEqual(t, f.Offset, protocol.ByteCount(firstLen))
+	}
+	require.NotNil(t, second.ack)
+	require.Equal(t, 1, second.numPING)
+	require.Len(t, second.cryptoFrames, 2)
+	require.Equal(t, 1, second.numPADDING)
+	require.Equal(t, 1231-16, second.payloadLen)
+}
+
+// clientHelloPackets returns the Initial packets carrying the first ClientHello, in the
+// order of their crypto data, and the sizes of the datagrams carrying them.
+func clientHelloPackets(t *testing.T, datagrams [][]byte) ([]sentInitialPacket, []int) {
+	t.Helper()
+	var (
+		packets []sentInitialPacket
+		sizes   []int
+		covered int
+	)
+	for _, p := range decryptClientInitials(t, datagrams) {
+		if len(p.cryptoFrames) == 0 || p.ack != nil {
+			continue
+		}
+		start := p.cryptoFrames[0].Offset
+		for _, f := range p.cryptoFrames {
+			start = min(start, f.Offset)
+		}
+		if int(start) != covered {
+			continue // a retransmission
+		}
+		covered += cryptoDataLen(p.cryptoFrames)
+		packets = append(packets, p)
+		sizes = append(sizes, len(datagrams[p.datagram]))
+	}
+	return packets, sizes
+}
+
+func TestUClientHelloPackets(t *testing.T) {
+	ln := newUTransportTestServer(t)
+	dialLossy := func(t *testing.T, spec *QUICSpec, drop func(n int) bool) [][]byte {
+		t.Helper()
+		tr := newUTransportWithSpecForTest(t, spec)
+		recorder := newLossyInitialRecorder(t, ln.Addr(), drop)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		tlsConf := &tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA(), NextProtos: []string{"h3"}}
+		conn, err := tr.Dial(ctx, recorder.conn.LocalAddr(), tlsConf, nil)
+		require.NoError(t, err)
+		conn.CloseWithError(0, "")
+		return recorder.clientDatagrams()
+	}
+	dial := func(t *testing.T, spec *QUICSpec) ([]sentInitialPacket, []int) {
+		t.Helper()
+		return clientHelloPackets(t, dialLossy(t, spec, func(int) bool { return false }))
+	}
+	threePacketsSpec := func(t *testing.T) *QUICSpec {
+		spec, err := testQUICID2Spec(testQUICIDPQ)
+		require.NoError(t, err)
+		spec.InitialPacketSpec.FrameBuilder = &QUICRandomFrames{
+			MinPING: 1, MaxPING: 2,
+			MinCRYPTO: 2, MaxCRYPTO: 3,
+			MinPADDING: 1, MaxPADDING: 2,
+			Length: 700,
+		}
+		spec.InitialPacketSpec.ClientHelloPackets = []ClientHelloPacketSpec{
+			{
+				FrameBuilder: &QUICRandomFrames{
+					MinPING: 0, MaxPING: 1,
+					MinCRYPTO: 3, MaxCRYPTO: 4,
+					MinPADDING: 1, MaxPADDING: 2,
+					Length: 600,
+				},
+				UDPDatagramMinSize: 900,
+			},
+			{UDPDatagramMinSize: 1100},
+		}
+		return &spec
+	}
+
+	t.Run("PADDING frames", func(t *testing.T) {
+		for _, id := range []QUICID{testQUICIDPQ, testQUICIDPQIPv6} {
+			t.Run(id.Version, func(t *testing.T) {
+				spec, err := testQUICID2Spec(id)
+				require.NoError(t, err)
+				datagramSize := spec.UDPDatagramMinSize
+				require.Equal(t, []ClientHelloPacketSpec{{PaddingFrames: true}}, spec.InitialPacketSpec.ClientHelloPackets)
+				packets, sizes := dial(t, &spec)
+				require.Equal(t, []int{datagramSize, datagramSize}, sizes)
+				// both packets are padded with PADDING frames, without zeros after them
+				for _, p := range packets {
+					require.Equal(t, datagramSize, int(p.hdr.ParsedLen())+p.payloadLen+16)
+					require.NotZero(t, p.numPADDING)
+				}
+				second := packets[1]
+				require.Len(t, second.cryptoFrames, 1)
+				require.Zero(t, second.numPING)
+				require.Equal(t, 1, second.numPADDING)
+			})
+		}
+	})
+
+	t.Run("zeros", func(t *testing.T) {
+		spec, err := testQUICID2Spec(testQUICIDPQConnID3)
+		require.NoError(t, err)
+		datagramSize := spec.InitialPacketSpec.ClientHelloPackets[0].UDPDatagramMinSize
+		require.Equal(t, spec.UDPDatagramMinSize, datagramSize)
+		packets, sizes := dial(t, &spec)
+		require.Equal(t, []int{datagramSize, datagramSize}, sizes)
+		// both packets are padded with zeros after the packet
+		for _, p := range packets {
+			require.Less(t, int(p.hdr.ParsedLen())+p.payloadLen+16, datagramSize)
+			require.Zero(t, p.numPADDING)
+		}
+		second := packets[1]
+		require.Len(t, second.cryptoFrames, 1)
+		require.Zero(t, second.numPING)
+	})
+
+	t.Run("three packets", func(t *testing.T) {
+		packets, sizes := dial(t, threePacketsSpec(t))
+		require.Equal(t, []int{1250, 900, 1100}, sizes)
+
+		first, second, third := packets[0], packets[1], packets[2]
+		require.Equal(t, 700, first.payloadLen)
+		require.Equal(t, 1, first.numPING)
+		require.Len(t, first.cryptoFrames, 2)
+		require.Equal(t, 600, second.payloadLen)
+		require.Zero(t, second.numPING)
+		require.Len(t, second.cryptoFrames, 3)
+		require.Equal(t, 1, second.numPADDING)
+		// the last packet is padded with zeros after the packet
+		require.Len(t, third.cryptoFrames, 1)
+		require.Zero(t, third.numPADDING)
+		require.Less(t, int(third.hdr.ParsedLen())+third.payloadLen+16, 1100)
+	})
+	t.Run("retransmission", func(t *testing.T) {
+		// drop the datagram carrying the second packet
+		datagrams := dialLossy(t, threePacketsSpec(t), func(n int) bool { return n == 1 })
+		packets, _ := clientHelloPackets(t, datagrams)
+		lost := packets[1]
+		require.Equal(t, 1, lost.datagram)
+		lostOffset := lost.cryptoFrames[0].Offset
+		for _, f := range lost.cryptoFrames {
+			lostOffset = min(lostOffset, f.Offset)
+		}
+
+		var retransmitted []sentInitialPacket
+		for _, p := range decryptClientInitials(t, datagrams) {
+			if p.hdr.PacketNumber <= lost.hdr.PacketNumber {
+				continue
+			}
+			for _, f := range p.cryptoFrames {
+				if f.Offset == lostOffset {
+					retransmitted = append(retransmitted, p)
+				}
+			}
+		}
+		require.NotEmpty(t, retransmitted)
+		// the retransmission is built as the second packet, an ACK frame replaces some padding
+		require.Equal(t, 600, retransmitted[0].payloadLen)
+		require.Equal(t, cryptoDataLen(lost.cryptoFrames), cryptoDataLen(retransmitted[0].cryptoFrames))
+		require.Equal(t, 900, len(datagrams[retransmitted[0].datagram]))
+	})
+}
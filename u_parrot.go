@@ -1,10 +1,11 @@
 package quic
 
 import (
-	"crypto/rand"
+	crand "crypto/rand"
 	"fmt"
+	"io"
 	"math/big"
-	mrand "math/rand"
+	mrand "math/rand/v2"
 
 	tls "github.com/Noooste/utls"
 )
@@ -34,17 +35,44 @@ var (
 	QUICFirefox_116A = QUICID{quicFirefox, "116", "31ea0e4ffd75b477"} // DCID.len = 8
 	QUICFirefox_116B = QUICID{quicFirefox, "116", "d07d3c9152fbc5e0"} // DCID.len = 9
 	QUICFirefox_116C = QUICID{quicFirefox, "116", "c74f87b2a9ccc006"} // DCID.len = 15
-	// TODO: add Firefox fingerprints with Token and PSK extension
 
+	// The IPv4 QUICIDs of Chrome switch to the IPv6 padding when dialing an IPv6 address,
+	// see QUICSpec.IPv6. The IPv6 QUICIDs always use the IPv6 padding.
 	QUICChrome_115      = QUICChrome_115_IPv4                               // IPv4 is still more popular
 	QUICChrome_115_IPv4 = QUICID{quicChrome, "115", "beeb454235791d5c"}     // IPv4: UDP payload 20-byte longer than IPv6 due to padding
 	QUICChrome_115_IPv6 = QUICID{quicChrome, "115_ip6", "beeb454235791d5c"} // IPv6
-	// TODO: add Chrome fingerprints with Token and PSK extension
 
-	// TODO: add more QUIC clients and versions
+	// TODO: add more QUIC clients and versions, checked against captures in testdata/captures
 )
 
 func QUICID2Spec(id QUICID) (QUICSpec, error) {
+	return QUICID2SpecWithRand(id, nil)
+}
+
+// [UQUIC]
+// QUICID2SpecWithRand is like QUICID2Spec, but the order of the TLS extensions and
+// of the transport parameters, and the length of the GREASE transport parameters, are
+// chosen using r. r is also set as the Rand of the returned QUICSpec, so that a
+// given seed yields byte-identical Initial packets, see QUICSpec.Rand.
+// If r is nil, crypto/rand is used.
+func QUICID2SpecWithRand(id QUICID, r io.Reader) (QUICSpec, error) {
+	var seedReader io.Reader = crand.Reader
+	if r != nil {
+		seedReader = lockedReader{r: r}
+	}
+	chacha, err := newChaCha8(seedReader)
+	if err != nil {
+		return QUICSpec{}, err
+	}
+	spec, err := quicID2Spec(id, mrand.New(chacha))
+	if err != nil {
+		return QUICSpec{}, err
+	}
+	spec.Rand = r
+	return spec, nil
+}
+
+func quicID2Spec(id QUICID, rnd *mrand.Rand) (QUICSpec, error) {
 	switch id {
 	case QUICChrome_115_IPv4:
 		return QUICSpec{
@@ -75,8 +103,8 @@ func QUICID2Spec(id QUICID) (QUICSpec, error) {
 				CompressionMethods: []uint8{
 					0x0, // no compression
 				},
-				Extensions: tls.ShuffleChromeTLSExtensions([]tls.TLSExtension{
-					ShuffleQUICTransportParameters(&tls.QUICTransportParametersExtension{ // Order of QTPs are always shuffled
+				Extensions: shuffleChromeTLSExtensions(rnd, []tls.TLSExtension{
+					shuffleQUICTransportParameters(rnd, &tls.QUICTransportParametersExtension{ // Order of QTPs are always shuffled
 						TransportParameters: tls.TransportParameters{
 							tls.InitialMaxStreamsUni(103),
 							tls.MaxIdleTimeout(30000),
@@ -101,7 +129,7 @@ func QUICID2Spec(id QUICID) (QUICSpec, error) {
 							tls.MaxDatagramFrameSize(65536),
 							tls.InitialMaxStreamsBidi(100),
 							tls.InitialMaxStreamDataBidiLocal(6291456),
-							VariableLengthGREASEQTP(0x10), // Random length for GREASE QTP
+							variableLengthGREASEQTP(rnd, 0x10), // Random length for GREASE QTP
 							tls.InitialSourceConnectionID([]byte{}),
 							tls.MaxUDPPayloadSize(1472),
 							tls.InitialMaxStreamDataBidiRemote(6291456),
@@ -150,6 +178,7 @@ func QUICID2Spec(id QUICID) (QUICSpec, error) {
 							tls.PskModeDHE,
 						},
 					},
+					newEarlyDataExtension(), // only sent when resuming a session that allows 0-RTT
 					&tls.ALPNExtension{
 						AlpnProtocols: []string{
 							"h3",
@@ -160,8 +189,12 @@ func QUICID2Spec(id QUICID) (QUICSpec, error) {
 							tls.VersionTLS13,
 						},
 					},
+					&tls.UtlsPreSharedKeyExtension{}, // only sent when resuming a session
 				}),
 			},
+			IPv6: &AddrFamilySpec{
+				RandomFramesLength: 1211 - 16, // IPv6 pads to a length that is 20-byte shorter than IPv4's version
+			},
 		}, nil
 	case QUICChrome_115_IPv6:
 		return QUICSpec{
@@ -192,8 +225,8 @@ func QUICID2Spec(id QUICID) (QUICSpec, error) {
 				CompressionMethods: []uint8{
 					0x0,
 				},
-				Extensions: tls.ShuffleChromeTLSExtensions([]tls.TLSExtension{
-					ShuffleQUICTransportParameters(&tls.QUICTransportParametersExtension{ // Order of QTPs are always shuffled
+				Extensions: shuffleChromeTLSExtensions(rnd, []tls.TLSExtension{
+					shuffleQUICTransportParameters(rnd, &tls.QUICTransportParametersExtension{ // Order of QTPs are always shuffled
 						TransportParameters: tls.TransportParameters{
 							tls.InitialMaxStreamsUni(103),
 							tls.MaxIdleTimeout(30000),
@@ -218,7 +251,7 @@ func QUICID2Spec(id QUICID) (QUICSpec, error) {
 							tls.MaxDatagramFrameSize(65536),
 							tls.InitialMaxStreamsBidi(100),
 							tls.InitialMaxStreamDataBidiLocal(6291456),
-							VariableLengthGREASEQTP(0x10), // Random length for GREASE QTP
+							variableLengthGREASEQTP(rnd, 0x10), // Random length for GREASE QTP
 							tls.InitialSourceConnectionID([]byte{}),
 							tls.MaxUDPPayloadSize(1472),
 							tls.InitialMaxStreamDataBidiRemote(6291456),
@@ -267,6 +300,7 @@ func QUICID2Spec(id QUICID) (QUICSpec, error) {
 							tls.PskModeDHE,
 						},
 					},
+					newEarlyDataExtension(), // only sent when resuming a session that allows 0-RTT
 					&tls.ALPNExtension{
 						AlpnProtocols: []string{
 							"h3",
@@ -277,6 +311,7 @@ func QUICID2Spec(id QUICID) (QUICSpec, error) {
 							tls.VersionTLS13,
 						},
 					},
+					&tls.UtlsPreSharedKeyExtension{}, // only sent when resuming a session
 				}),
 			},
 		}, nil
@@ -341,6 +376,7 @@ func QUICID2Spec(id QUICID) (QUICSpec, error) {
 							},
 						},
 					},
+					newEarlyDataExtension(), // only sent when resuming a session that allows 0-RTT
 					&tls.SupportedVersionsExtension{
 						Versions: []uint16{
 							tls.VersionTLS13,
@@ -369,7 +405,7 @@ func QUICID2Spec(id QUICID) (QUICSpec, error) {
 					&tls.FakeRecordSizeLimitExtension{
 						Limit: 0x4001,
 					},
-					ShuffleQUICTransportParameters(&tls.QUICTransportParametersExtension{
+					shuffleQUICTransportParameters(rnd, &tls.QUICTransportParametersExtension{
 						TransportParameters: tls.TransportParameters{
 							tls.InitialMaxStreamDataBidiRemote(0x100000),
 							tls.InitialMaxStreamsBidi(16),
@@ -400,6 +436,7 @@ func QUICID2Spec(id QUICID) (QUICSpec, error) {
 					&tls.UtlsPaddingExtension{
 						GetPaddingLen: tls.BoringPaddingStyle,
 					},
+					&tls.UtlsPreSharedKeyExtension{}, // only sent when resuming a session
 				},
 			},
 			UDPDatagramMinSize: 1357, // Firefox pads with zeroes at the end of UDP datagrams
@@ -465,6 +502,7 @@ func QUICID2Spec(id QUICID) (QUICSpec, error) {
 							},
 						},
 					},
+					newEarlyDataExtension(), // only sent when resuming a session that allows 0-RTT
 					&tls.SupportedVersionsExtension{
 						Versions: []uint16{
 							tls.VersionTLS13,
@@ -493,7 +531,7 @@ func QUICID2Spec(id QUICID) (QUICSpec, error) {
 					&tls.FakeRecordSizeLimitExtension{
 						Limit: 0x4001,
 					},
-					ShuffleQUICTransportParameters(&tls.QUICTransportParametersExtension{
+					shuffleQUICTransportParameters(rnd, &tls.QUICTransportParametersExtension{
 						TransportParameters: tls.TransportParameters{
 							tls.InitialMaxStreamDataBidiRemote(0x100000),
 							tls.InitialMaxStreamsBidi(16),
@@ -524,6 +562,7 @@ func QUICID2Spec(id QUICID) (QUICSpec, error) {
 					&tls.UtlsPaddingExtension{
 						GetPaddingLen: tls.BoringPaddingStyle,
 					},
+					&tls.UtlsPreSharedKeyExtension{}, // only sent when resuming a session
 				},
 			},
 			UDPDatagramMinSize: 1357,
@@ -589,6 +628,7 @@ func QUICID2Spec(id QUICID) (QUICSpec, error) {
 							},
 						},
 					},
+					newEarlyDataExtension(), // only sent when resuming a session that allows 0-RTT
 					&tls.SupportedVersionsExtension{
 						Versions: []uint16{
 							tls.VersionTLS13,
@@ -617,7 +657,7 @@ func QUICID2Spec(id QUICID) (QUICSpec, error) {
 					&tls.FakeRecordSizeLimitExtension{
 						Limit: 0x4001,
 					},
-					ShuffleQUICTransportParameters(&tls.QUICTransportParametersExtension{
+					shuffleQUICTransportParameters(rnd, &tls.QUICTransportParametersExtension{
 						TransportParameters: tls.TransportParameters{
 							tls.InitialMaxStreamDataBidiRemote(0x100000),
 							tls.InitialMaxStreamsBidi(16),
@@ -648,6 +688,7 @@ func QUICID2Spec(id QUICID) (QUICSpec, error) {
 					&tls.UtlsPaddingExtension{
 						GetPaddingLen: tls.BoringPaddingStyle,
 					},
+					&tls.UtlsPreSharedKeyExtension{}, // only sent when resuming a session
 				},
 			},
 			UDPDatagramMinSize: 1357,
@@ -675,7 +716,7 @@ func ShuffleQUICTransportParameters(qtp *tls.QUICTransportParametersExtension) *
 func VariableLengthGREASEQTP(maxLen int) *tls.GREASETransportParameter {
 	// get random length for GREASE
 	greaseMaxLen := big.NewInt(0x10)
-	greaseLen, err := rand.Int(rand.Reader, greaseMaxLen)
+	greaseLen, err := crand.Int(crand.Reader, greaseMaxLen)
 	if err != nil {
 		panic(err)
 	}
@@ -684,3 +725,42 @@ func VariableLengthGREASEQTP(maxLen int) *tls.GREASETransportParameter {
 		Length: uint16(greaseLen.Uint64()),
 	}
 }
+
+// [UQUIC]
+// shuffleChromeTLSExtensions is like tls.ShuffleChromeTLSExtensions, using rnd.
+// The GREASE, padding and pre_shared_key extensions keep their position.
+func shuffleChromeTLSExtensions(rnd *mrand.Rand, exts []tls.TLSExtension) []tls.TLSExtension {
+	skipShuffle := func(ext tls.TLSExtension) bool {
+		switch ext.(type) {
+		case *tls.UtlsGREASEExtension, *tls.UtlsPaddingExtension, tls.PreSharedKeyExtension:
+			return true
+		default:
+			return false
+		}
+	}
+	rnd.Shuffle(len(exts), func(i, j int) {
+		if skipShuffle(exts[i]) || skipShuffle(exts[j]) {
+			return
+		}
+		exts[i], exts[j] = exts[j], exts[i]
+	})
+	return exts
+}
+
+// [UQUIC]
+// shuffleQUICTransportParameters is like ShuffleQUICTransportParameters, using rnd.
+func shuffleQUICTransportParameters(rnd *mrand.Rand, qtp *tls.QUICTransportParametersExtension) *tls.QUICTransportParametersExtension {
+	rnd.Shuffle(len(qtp.TransportParameters), func(i, j int) {
+		qtp.TransportParameters[i], qtp.TransportParameters[j] = qtp.TransportParameters[j], qtp.TransportParameters[i]
+	})
+	return qtp
+}
+
+// [UQUIC]
+// variableLengthGREASEQTP returns a GREASE transport parameter with a random length
+// lower than maxLen, chosen using rnd.
+func variableLengthGREASEQTP(rnd *mrand.Rand, maxLen int) *tls.GREASETransportParameter {
+	return &tls.GREASETransportParameter{
+		Length: uint16(rnd.IntN(maxLen)),
+	}
+}
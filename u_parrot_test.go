@@ -0,0 +1,585 @@
+package quic
+
+import (
+	crand "crypto/rand"
+	"flag"
+	"io"
+	mrand "math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Noooste/uquic-go/internal/testdata"
+	tls "github.com/Noooste/utls"
+
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata/parrots")
+
+// clientFingerprintOf returns the ClientFingerprint of the client that sent the datagrams.
+func clientFingerprintOf(datagrams [][]byte) *ClientFingerprint {
+	f := &clientFingerprinter{capture: initialCapture{anyDestConnID: true}}
+	for _, d := range datagrams {
+		f.add(d)
+	}
+	return f.fingerprint()
+}
+
+// withoutGREASETransportParameters returns the IDs of the transport parameters,
+// without the GREASE IDs (RFC 9000, Section 18.1) which are picked randomly.
+func withoutGREASETransportParameters(ids []uint64) []uint64 {
+	var res []uint64
+	for _, id := range ids {
+		if id < 27 || (id-27)%31 != 0 {
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+// withoutExtension returns the IDs of the extensions, without the extension id.
+func withoutExtension(exts []uint16, id uint16) []uint16 {
+	return slices.DeleteFunc(slices.Clone(exts), func(ext uint16) bool { return ext == id })
+}
+
+// TestParrotGolden checks the Initial packets of every parrot against the QUICSpec
+// recovered from them when the golden file was written, see testdata/README.md.
+// The QUICSpec is seeded, so that the order of the extensions and of the transport
+// parameters doesn't change between runs. Every run uses the same connection IDs,
+// so every run needs a new server. Run with -update to regenerate the golden files.
+func TestParrotGolden(t *testing.T) {
+	for _, id := range builtinQUICIDs {
+		t.Run(id.Client+"_"+id.Version, func(t *testing.T) {
+			ln := newUTransportTestServer(t)
+			spec, err := QUICID2SpecWithRand(id, mrand.NewChaCha8([32]byte{1}))
+			require.NoError(t, err)
+			captured, err := QUICSpecFromInitialPackets(captureInitialPackets(t, ln, &spec)...)
+			require.NoError(t, err)
+			data, err := MarshalQUICSpec(&captured)
+			require.NoError(t, err)
+
+			golden := filepath.Join("testdata", "parrots", strings.ToLower(id.Client+"_"+id.Version+"_"+id.Fingerprint)+".json")
+			if *updateGolden {
+				require.NoError(t, os.WriteFile(golden, append(data, '\n'), 0o644))
+			}
+			expected, err := os.ReadFile(golden)
+			require.NoError(t, err)
+			require.Equal(t, string(expected), string(data)+"\n")
+		})
+	}
+}
+
+// TestParrotMatchesCapture compares the Initial packets of a parrot with Initial
+// packets captured from the browser, see testdata/README.md.
+func TestParrotMatchesCapture(t *testing.T) {
+	ln := newUTransportTestServer(t)
+
+	t.Run("Chrome 115, IPv6", func(t *testing.T) {
+		capture, err := os.ReadFile(filepath.Join("testdata", "captures", "chrome_115_ipv6.bin"))
+		require.NoError(t, err)
+		expected := clientFingerprintOf([][]byte{capture})
+
+		spec, err := QUICID2Spec(QUICChrome_115_IPv6)
+		require.NoError(t, err)
+		fp := clientFingerprintOf(captureInitialPackets(t, ln, &spec))
+		require.Equal(t, expected.JA4, fp.JA4)
+		// the transport parameters are shuffled for every connection
+		require.Equal(t, strings.Split(expected.QUICHash, "_")[0], strings.Split(fp.QUICHash, "_")[0])
+		require.ElementsMatch(t, withoutGREASETransportParameters(expected.TransportParameters), withoutGREASETransportParameters(fp.TransportParameters))
+		require.Equal(t, expected.DatagramSize, fp.DatagramSize)
+		require.Equal(t, expected.PayloadLen, fp.PayloadLen)
+	})
+
+	// The capture resumes a session, with a token from a NEW_TOKEN frame.
+	t.Run("Firefox 116, resumed", func(t *testing.T) {
+		capture, err := os.ReadFile(filepath.Join("testdata", "captures", "firefox_116_resumed.bin"))
+		require.NoError(t, err)
+		expected := clientFingerprintOf([][]byte{capture})
+
+		// Firefox picks the length of the Destination Connection ID randomly,
+		// the QUICFirefox_116 variants only differ in this length
+		spec, err := QUICID2Spec(QUICFirefox_116)
+		require.NoError(t, err)
+		recorder := newInitialRecorder(t, ln.Addr())
+		tr := newUTransportWithSpecForTest(t, &spec)
+		tlsConf := &tls.Config{
+			ServerName:         "localhost",
+			RootCAs:            testdata.GetRootCA(),
+			NextProtos:         []string{"h3"},
+			ClientSessionCache: tls.NewLRUClientSessionCache(1),
+		}
+		tokenStore := newTokenStoreWithNotify(NewLRUTokenStore(1, 1))
+		conf := &Config{TokenStore: tokenStore}
+		conn, err := tr.Dial(t.Context(), recorder.conn.LocalAddr(), tlsConf, conf)
+		require.NoError(t, err)
+		<-tokenStore.received
+		conn.CloseWithError(0, "")
+		recorder.clientDatagrams()
+
+		conn, err = tr.Dial(t.Context(), recorder.conn.LocalAddr(), tlsConf, conf)
+		require.NoError(t, err)
+		defer conn.CloseWithError(0, "")
+		require.True(t, conn.ConnectionState().TLS.DidResume)
+		fp := clientFingerprintOf(recorder.clientDatagrams())
+
+		// The session tickets of the test server are larger than the one in the capture,
+		// so the padding extension isn't needed to pad the ClientHello to 512 bytes
+		// (tls.BoringPaddingStyle). Firefox sends it before the transport parameters,
+		// the parrot after them.
+		const extPadding = 21
+		require.Equal(t,
+			withoutExtension(clientHelloExtensions(t, expected.ClientHello), extPadding),
+			withoutExtension(clientHelloExtensions(t, fp.ClientHello), extPadding),
+		)
+		require.Equal(t, strings.Split(expected.JA4, "_")[1], strings.Split(fp.JA4, "_")[1]) // cipher suites
+		require.Equal(t, expected.SrcConnIDLen, fp.SrcConnIDLen)
+		require.Equal(t, expected.PacketNumber, fp.PacketNumber)
+		require.Equal(t, expected.PacketNumberLen, fp.PacketNumberLen)
+		require.NotZero(t, expected.TokenLen)
+		require.NotZero(t, fp.TokenLen)
+		require.ElementsMatch(t, withoutGREASETransportParameters(expected.TransportParameters), withoutGREASETransportParameters(fp.TransportParameters))
+		require.Equal(t, expected.DatagramSize, fp.DatagramSize)
+		// a single CRYPTO frame, followed by PADDING frames
+		require.Len(t, fp.Frames, len(expected.Frames))
+		for i := range expected.Frames {
+			require.IsType(t, expected.Frames[i], fp.Frames[i])
+		}
+	})
+}
+
+// QUICIDs of the QUICSpecs used to test features that the built-in QUICIDs don't use,
+// such as a ClientHello spanning 2 Initial packets. They aren't checked against
+// captures of any browser, so they are not offered as parrots, and the layout of
+// their Initial packets is specified by the QUICSpec rather than by a browser. Use
+// testQUICID2Spec to get their QUICSpec.
+var (
+	testQUICIDPQ        = QUICID{"test", "pq", ""}         // zero-length connection IDs, the ClientHello spans 2 Initial packets
+	testQUICIDPQIPv6    = QUICID{"test", "pq_ip6", ""}     // testQUICIDPQ with the IPv6 padding
+	testQUICIDPQConnID3 = QUICID{"test", "pq_connid3", ""} // 3-byte connection IDs, the ClientHello spans 2 Initial packets
+	testQUICIDConnID8   = QUICID{"test", "connid8", ""}    // 8-byte connection IDs, QUIC v1 only
+)
+
+var testQUICSpecs = map[QUICID]func(rnd *mrand.Rand) QUICSpec{
+	testQUICIDPQ: testQUICSpecPQ,
+	testQUICIDPQIPv6: func(rnd *mrand.Rand) QUICSpec {
+		spec := testQUICSpecPQ(rnd)
+		spec.IPv6.apply(&spec)
+		spec.IPv6 = nil
+		return spec
+	},
+	testQUICIDPQConnID3: testQUICSpecPQConnID3,
+	testQUICIDConnID8:   testQUICSpecConnID8,
+}
+
+// testQUICID2Spec is like QUICID2Spec, also accepting the QUICIDs of testQUICSpecs.
+func testQUICID2Spec(id QUICID) (QUICSpec, error) {
+	return testQUICID2SpecWithRand(id, nil)
+}
+
+// testQUICID2SpecWithRand is like QUICID2SpecWithRand, also accepting the QUICIDs of
+// testQUICSpecs.
+func testQUICID2SpecWithRand(id QUICID, r io.Reader) (QUICSpec, error) {
+	build, ok := testQUICSpecs[id]
+	if !ok {
+		return QUICID2SpecWithRand(id, r)
+	}
+	var seedReader io.Reader = crand.Reader
+	if r != nil {
+		seedReader = lockedReader{r: r}
+	}
+	chacha, err := newChaCha8(seedReader)
+	if err != nil {
+		return QUICSpec{}, err
+	}
+	spec := build(mrand.New(chacha))
+	spec.Rand = r
+	return spec, nil
+}
+
+// testQUICSpecPQ sends an X25519MLKEM768 key share, so that the ClientHello spans
+// 2 Initial packets, padded with PADDING frames.
+func testQUICSpecPQ(rnd *mrand.Rand) QUICSpec {
+	return QUICSpec{
+		InitialPacketSpec: InitialPacketSpec{
+			SrcConnIDLength:        0,
+			DestConnIDLength:       8,
+			InitPacketNumberLength: 1,
+			InitPacketNumber:       1,
+			ClientTokenLength:      0,
+			FrameBuilder: &QUICRandomFrames{
+				MinPING:    0,
+				MaxPING:    10,
+				MinCRYPTO:  1,
+				MaxCRYPTO:  10,
+				MinPADDING: 3,
+				MaxPADDING: 6,
+				Length:     1231 - 16,
+			},
+			// the second packet carries a single CRYPTO frame, padded with PADDING frames
+			ClientHelloPackets: []ClientHelloPacketSpec{{PaddingFrames: true}},
+		},
+		ClientHelloSpec: &tls.ClientHelloSpec{
+			TLSVersMin: tls.VersionTLS13,
+			TLSVersMax: tls.VersionTLS13,
+			CipherSuites: []uint16{
+				tls.TLS_AES_128_GCM_SHA256,
+				tls.TLS_AES_256_GCM_SHA384,
+				tls.TLS_CHACHA20_POLY1305_SHA256,
+			},
+			CompressionMethods: []uint8{
+				0x0, // no compression
+			},
+			Extensions: shuffleChromeTLSExtensions(rnd, []tls.TLSExtension{
+				shuffleQUICTransportParameters(rnd, &tls.QUICTransportParametersExtension{
+					TransportParameters: tls.TransportParameters{
+						tls.InitialMaxStreamsUni(103),
+						tls.MaxIdleTimeout(30000),
+						tls.InitialMaxData(15728640),
+						tls.InitialMaxStreamDataUni(6291456),
+						&tls.VersionInformation{
+							ChoosenVersion: tls.VERSION_1,
+							AvailableVersions: []uint32{
+								tls.VERSION_GREASE,
+								tls.VERSION_1,
+							},
+							LegacyID: true,
+						},
+						&tls.FakeQUICTransportParameter{ // google_quic_version
+							Id:  0x4752,
+							Val: []byte{00, 00, 00, 01},
+						},
+						&tls.FakeQUICTransportParameter{ // google_connection_options
+							Id:  0x3128,
+							Val: []byte{0x42, 0x36, 0x57, 0x41}, // "B6WA"
+						},
+						tls.MaxDatagramFrameSize(65536),
+						tls.InitialMaxStreamsBidi(100),
+						tls.InitialMaxStreamDataBidiLocal(6291456),
+						variableLengthGREASEQTP(rnd, 0x10),
+						tls.InitialSourceConnectionID([]byte{}),
+						tls.MaxUDPPayloadSize(1472),
+						tls.InitialMaxStreamDataBidiRemote(6291456),
+					},
+				}),
+				&tls.ApplicationSettingsExtensionNew{ // ALPS on the new codepoint
+					SupportedProtocols: []string{
+						"h3",
+					},
+				},
+				&tls.UtlsCompressCertExtension{
+					Algorithms: []tls.CertCompressionAlgo{
+						tls.CertCompressionBrotli,
+					},
+				},
+				&tls.KeyShareExtension{
+					KeyShares: []tls.KeyShare{
+						{
+							Group: tls.X25519MLKEM768,
+						},
+						{
+							Group: tls.X25519,
+						},
+					},
+				},
+				&tls.SignatureAlgorithmsExtension{
+					SupportedSignatureAlgorithms: []tls.SignatureScheme{
+						tls.ECDSAWithP256AndSHA256,
+						tls.PSSWithSHA256,
+						tls.PKCS1WithSHA256,
+						tls.ECDSAWithP384AndSHA384,
+						tls.PSSWithSHA384,
+						tls.PKCS1WithSHA384,
+						tls.PSSWithSHA512,
+						tls.PKCS1WithSHA512,
+					},
+				},
+				&tls.SNIExtension{},
+				&tls.SupportedCurvesExtension{
+					Curves: []tls.CurveID{
+						tls.X25519MLKEM768,
+						tls.CurveX25519,
+						tls.CurveSECP256R1,
+						tls.CurveSECP384R1,
+					},
+				},
+				&tls.PSKKeyExchangeModesExtension{
+					Modes: []uint8{
+						tls.PskModeDHE,
+					},
+				},
+				newEarlyDataExtension(), // only sent when resuming a session that allows 0-RTT
+				&tls.ALPNExtension{
+					AlpnProtocols: []string{
+						"h3",
+					},
+				},
+				&tls.SupportedVersionsExtension{
+					Versions: []uint16{
+						tls.VersionTLS13,
+					},
+				},
+				tls.BoringGREASEECH(),
+				&tls.UtlsPreSharedKeyExtension{}, // only sent when resuming a session
+			}),
+		},
+		UDPDatagramMinSize: 1250, // the ClientHello spans 2 Initial packets, both are padded
+		IPv6: &AddrFamilySpec{
+			UDPDatagramMinSize: 1230,
+			RandomFramesLength: 1211 - 16, // IPv6 pads to a length that is 20-byte shorter than IPv4's version
+		},
+	}
+}
+
+// testQUICSpecPQConnID3 sends an X25519MLKEM768 key share, so that the ClientHello
+// spans 2 Initial packets, padded with zeros.
+func testQUICSpecPQConnID3(rnd *mrand.Rand) QUICSpec {
+	return QUICSpec{
+		InitialPacketSpec: InitialPacketSpec{
+			SrcConnIDLength:        3,
+			DestConnIDLength:       8,
+			InitPacketNumberLength: 1,
+			InitPacketNumber:       0,
+			ClientTokenLength:      0,
+			FrameBuilder:           QUICFrames{},
+			// the second packet carries a single CRYPTO frame, padded with zeros
+			ClientHelloPackets: []ClientHelloPacketSpec{{UDPDatagramMinSize: 1357}},
+		},
+		ClientHelloSpec: &tls.ClientHelloSpec{
+			TLSVersMin: tls.VersionTLS13,
+			TLSVersMax: tls.VersionTLS13,
+			CipherSuites: []uint16{
+				tls.TLS_AES_128_GCM_SHA256,
+				tls.TLS_CHACHA20_POLY1305_SHA256,
+				tls.TLS_AES_256_GCM_SHA384,
+			},
+			CompressionMethods: []uint8{
+				0x0,
+			},
+			Extensions: []tls.TLSExtension{
+				&tls.SNIExtension{},
+				&tls.ExtendedMasterSecretExtension{},
+				&tls.RenegotiationInfoExtension{
+					Renegotiation: tls.RenegotiateOnceAsClient,
+				},
+				&tls.SupportedCurvesExtension{
+					Curves: []tls.CurveID{
+						tls.X25519MLKEM768,
+						tls.CurveX25519,
+						tls.CurveSECP256R1,
+						tls.CurveSECP384R1,
+						tls.CurveSECP521R1,
+						tls.FakeCurveFFDHE2048,
+						tls.FakeCurveFFDHE3072,
+					},
+				},
+				&tls.ALPNExtension{
+					AlpnProtocols: []string{
+						"h3",
+					},
+				},
+				&tls.StatusRequestExtension{},
+				&tls.FakeDelegatedCredentialsExtension{
+					SupportedSignatureAlgorithms: []tls.SignatureScheme{
+						tls.ECDSAWithP256AndSHA256,
+						tls.ECDSAWithP384AndSHA384,
+						tls.ECDSAWithP521AndSHA512,
+						tls.ECDSAWithSHA1,
+					},
+				},
+				&tls.KeyShareExtension{
+					KeyShares: []tls.KeyShare{
+						{
+							Group: tls.X25519MLKEM768,
+						},
+						{
+							Group: tls.X25519,
+						},
+						{
+							Group: tls.CurveSECP256R1,
+						},
+					},
+				},
+				newEarlyDataExtension(), // only sent when resuming a session that allows 0-RTT
+				&tls.SupportedVersionsExtension{
+					Versions: []uint16{
+						tls.VersionTLS13,
+					},
+				},
+				&tls.SignatureAlgorithmsExtension{
+					SupportedSignatureAlgorithms: []tls.SignatureScheme{
+						tls.ECDSAWithP256AndSHA256,
+						tls.ECDSAWithP384AndSHA384,
+						tls.ECDSAWithP521AndSHA512,
+						tls.PSSWithSHA256,
+						tls.PSSWithSHA384,
+						tls.PSSWithSHA512,
+						tls.PKCS1WithSHA256,
+						tls.PKCS1WithSHA384,
+						tls.PKCS1WithSHA512,
+						tls.ECDSAWithSHA1,
+						tls.PKCS1WithSHA1,
+					},
+				},
+				&tls.PSKKeyExchangeModesExtension{
+					Modes: []uint8{
+						tls.PskModeDHE,
+					},
+				},
+				&tls.FakeRecordSizeLimitExtension{
+					Limit: 0x4001,
+				},
+				&tls.UtlsCompressCertExtension{
+					Algorithms: []tls.CertCompressionAlgo{
+						tls.CertCompressionZlib,
+						tls.CertCompressionBrotli,
+						tls.CertCompressionZstd,
+					},
+				},
+				shuffleQUICTransportParameters(rnd, &tls.QUICTransportParametersExtension{
+					TransportParameters: tls.TransportParameters{
+						tls.InitialMaxStreamDataBidiRemote(0x100000),
+						tls.InitialMaxStreamsBidi(16),
+						tls.MaxDatagramFrameSize(1200),
+						tls.MaxIdleTimeout(30000),
+						tls.ActiveConnectionIDLimit(8),
+						&tls.GREASEQUICBit{},
+						&tls.VersionInformation{
+							ChoosenVersion: tls.VERSION_1,
+							AvailableVersions: []uint32{
+								tls.VERSION_GREASE,
+								tls.VERSION_1,
+							},
+							LegacyID: true,
+						},
+						tls.InitialMaxStreamsUni(16),
+						&tls.GREASETransportParameter{
+							Length: 2,
+						},
+						tls.InitialMaxStreamDataBidiLocal(0xc00000),
+						tls.InitialMaxStreamDataUni(0x100000),
+						tls.InitialSourceConnectionID([]byte{}),
+						tls.MaxAckDelay(20),
+						tls.InitialMaxData(0x1800000),
+						&tls.DisableActiveMigration{},
+					},
+				}),
+				tls.BoringGREASEECH(),
+				&tls.UtlsPreSharedKeyExtension{}, // only sent when resuming a session
+			},
+		},
+		UDPDatagramMinSize: 1357,
+	}
+}
+
+// testQUICSpecConnID8 uses 8-byte connection IDs and QUIC v1 only.
+func testQUICSpecConnID8(rnd *mrand.Rand) QUICSpec {
+	return QUICSpec{
+		InitialPacketSpec: InitialPacketSpec{
+			SrcConnIDLength:        8,
+			DestConnIDLength:       8,
+			InitPacketNumberLength: 1,
+			InitPacketNumber:       0,
+			ClientTokenLength:      0,
+			FrameBuilder:           QUICFrames{},
+		},
+		ClientHelloSpec: &tls.ClientHelloSpec{
+			TLSVersMin: tls.VersionTLS13,
+			TLSVersMax: tls.VersionTLS13,
+			CipherSuites: []uint16{
+				tls.GREASE_PLACEHOLDER,
+				tls.TLS_AES_128_GCM_SHA256,
+				tls.TLS_AES_256_GCM_SHA384,
+				tls.TLS_CHACHA20_POLY1305_SHA256,
+			},
+			CompressionMethods: []uint8{
+				0x0,
+			},
+			Extensions: []tls.TLSExtension{
+				&tls.UtlsGREASEExtension{},
+				&tls.SNIExtension{},
+				&tls.SupportedCurvesExtension{
+					Curves: []tls.CurveID{
+						tls.CurveID(tls.GREASE_PLACEHOLDER),
+						tls.CurveX25519,
+						tls.CurveSECP256R1,
+						tls.CurveSECP384R1,
+						tls.CurveSECP521R1,
+					},
+				},
+				&tls.ALPNExtension{
+					AlpnProtocols: []string{
+						"h3",
+					},
+				},
+				&tls.StatusRequestExtension{},
+				&tls.SignatureAlgorithmsExtension{
+					SupportedSignatureAlgorithms: []tls.SignatureScheme{
+						tls.ECDSAWithP256AndSHA256,
+						tls.PSSWithSHA256,
+						tls.PKCS1WithSHA256,
+						tls.ECDSAWithP384AndSHA384,
+						tls.ECDSAWithSHA1,
+						tls.PSSWithSHA384,
+						tls.PSSWithSHA384, // listed twice
+						tls.PKCS1WithSHA384,
+						tls.PSSWithSHA512,
+						tls.PKCS1WithSHA512,
+						tls.PKCS1WithSHA1,
+					},
+				},
+				&tls.SCTExtension{},
+				&tls.KeyShareExtension{
+					KeyShares: []tls.KeyShare{
+						{
+							Group: tls.CurveID(tls.GREASE_PLACEHOLDER),
+							Data:  []byte{0},
+						},
+						{
+							Group: tls.X25519,
+						},
+					},
+				},
+				&tls.PSKKeyExchangeModesExtension{
+					Modes: []uint8{
+						tls.PskModeDHE,
+					},
+				},
+				newEarlyDataExtension(), // only sent when resuming a session that allows 0-RTT
+				&tls.SupportedVersionsExtension{
+					Versions: []uint16{
+						tls.GREASE_PLACEHOLDER,
+						tls.VersionTLS13,
+					},
+				},
+				&tls.UtlsCompressCertExtension{
+					Algorithms: []tls.CertCompressionAlgo{
+						tls.CertCompressionZlib,
+					},
+				},
+				&tls.QUICTransportParametersExtension{
+					TransportParameters: tls.TransportParameters{
+						tls.MaxIdleTimeout(30000),
+						tls.MaxUDPPayloadSize(1472),
+						tls.InitialMaxData(2097152),
+						tls.InitialMaxStreamDataBidiLocal(2097152),
+						tls.InitialMaxStreamDataBidiRemote(2097152),
+						tls.InitialMaxStreamDataUni(2097152),
+						tls.InitialMaxStreamsBidi(100),
+						tls.InitialMaxStreamsUni(100),
+						tls.ActiveConnectionIDLimit(8),
+						tls.InitialSourceConnectionID([]byte{}),
+						tls.MaxDatagramFrameSize(65527),
+					},
+				},
+				&tls.UtlsGREASEExtension{},
+				&tls.UtlsPreSharedKeyExtension{}, // only sent when resuming a session
+			},
+		},
+		UDPDatagramMinSize: 1200,
+		// no version_information, only QUIC v1
+		VersionSpec: VersionSpec{Versions: []Version{Version1}},
+	}
+}
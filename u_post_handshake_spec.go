@@ -0,0 +1,54 @@
+package quic
+
+import "time"
+
+// [UQUIC]
+// PostHandshakeSpec specifies how the client behaves once the connection is established.
+// Browsers differ in how often they acknowledge packets, how many connection IDs they
+// issue and whether they send keep-alive PINGs, which is observable by the server.
+//
+// The zero value keeps the default behavior of quic-go. The built-in QUICIDs use the
+// zero value, since there are no captures of the browsers' post-handshake behavior yet.
+type PostHandshakeSpec struct {
+	// AckElicitingThreshold is the number of ack-eliciting packets received after which
+	// an ACK is sent immediately. If unset, an ACK is sent every 2 ack-eliciting packets.
+	AckElicitingThreshold int
+
+	// MaxAckDelay is the maximum time an ACK is delayed for. If unset, the value of the
+	// max_ack_delay transport parameter of the ClientHelloSpec is used, or 25ms if it
+	// isn't present. It should not exceed the max_ack_delay sent to the server.
+	MaxAckDelay time.Duration
+
+	// NewConnectionIDs is the number of connection IDs issued in NEW_CONNECTION_ID frames,
+	// in addition to the one used during the handshake. The number is further limited by
+	// the active_connection_id_limit of the server. If unset, up to 5 connection IDs are
+	// issued. If negative, no connection IDs are issued.
+	NewConnectionIDs int
+
+	// KeepAlivePeriod is used as the Config.KeepAlivePeriod if the Config doesn't set
+	// one. If negative, no keep-alive PINGs are sent, unless the Config sets a period.
+	KeepAlivePeriod time.Duration
+
+	// DisablePacketNumberSkipping makes the client use consecutive packet numbers for
+	// Application Data packets. By default, packet numbers are skipped randomly to detect
+	// optimistic ACK attacks, as quic-go does.
+	DisablePacketNumberSkipping bool
+}
+
+func (ps *PostHandshakeSpec) UpdateConfig(conf *Config) {
+	if conf.KeepAlivePeriod == 0 && ps.KeepAlivePeriod > 0 {
+		conf.KeepAlivePeriod = ps.KeepAlivePeriod
+	}
+}
+
+// maxIssuedConnIDs returns the limit for the connIDGenerator, or 0 for the default.
+func (ps *PostHandshakeSpec) maxIssuedConnIDs() uint64 {
+	switch {
+	case ps.NewConnectionIDs > 0:
+		return uint64(ps.NewConnectionIDs) + 1
+	case ps.NewConnectionIDs < 0:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,66 @@
+package quic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/protocol"
+	"github.com/Noooste/uquic-go/internal/wire"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostHandshakeSpecKeepAlive(t *testing.T) {
+	conf := &Config{}
+	(&PostHandshakeSpec{}).UpdateConfig(conf)
+	require.Zero(t, conf.KeepAlivePeriod)
+	(&PostHandshakeSpec{KeepAlivePeriod: -1}).UpdateConfig(conf)
+	require.Zero(t, conf.KeepAlivePeriod)
+	(&PostHandshakeSpec{KeepAlivePeriod: 15 * time.Second}).UpdateConfig(conf)
+	require.Equal(t, 15*time.Second, conf.KeepAlivePeriod)
+
+	// the period set by the application is kept
+	conf = &Config{KeepAlivePeriod: time.Minute}
+	(&PostHandshakeSpec{KeepAlivePeriod: 15 * time.Second}).UpdateConfig(conf)
+	require.Equal(t, time.Minute, conf.KeepAlivePeriod)
+	(&PostHandshakeSpec{KeepAlivePeriod: -1}).UpdateConfig(conf)
+	require.Equal(t, time.Minute, conf.KeepAlivePeriod)
+}
+
+func TestPostHandshakeSpecNewConnectionIDs(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		newConnectionIDs int
+		limit            uint64
+		expected         int
+	}{
+		{name: "default", newConnectionIDs: 0, limit: 8, expected: protocol.MaxIssuedConnectionIDs - 1},
+		{name: "set", newConnectionIDs: 2, limit: 8, expected: 2},
+		{name: "limited by the peer", newConnectionIDs: 7, limit: 4, expected: 3},
+		{name: "none", newConnectionIDs: -1, limit: 8, expected: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var queued []wire.Frame
+			g := newConnIDGenerator(
+				nil,
+				protocol.ParseConnectionID([]byte{1, 2, 3, 4}),
+				nil,
+				newStatelessResetter(nil),
+				connRunnerCallbacks{
+					AddConnectionID:    func(protocol.ConnectionID) {},
+					RemoveConnectionID: func(protocol.ConnectionID) {},
+					ReplaceWithClosed:  func([]protocol.ConnectionID, []byte, time.Duration) {},
+				},
+				func(f wire.Frame) { queued = append(queued, f) },
+				&protocol.DefaultConnectionIDGenerator{ConnLen: 4},
+			)
+			ps := PostHandshakeSpec{NewConnectionIDs: tc.newConnectionIDs}
+			g.SetMaxIssuedConnIDs(ps.maxIssuedConnIDs())
+			require.NoError(t, g.SetMaxActiveConnIDs(tc.limit))
+			require.Len(t, queued, tc.expected)
+			for _, f := range queued {
+				require.IsType(t, &wire.NewConnectionIDFrame{}, f)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package quic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/Noooste/uquic-go/internal/ackhandler"
+	"github.com/Noooste/uquic-go/internal/protocol"
+	"github.com/Noooste/uquic-go/internal/wire"
+	"github.com/Noooste/uquic-go/qlog"
+)
+
+// [UQUIC]
+// A PreferredAddress is an address that the server asks the clients to migrate to after
+// the handshake, see section 9.6 of RFC 9000. It is sent in the preferred_address
+// transport parameter, together with a connection ID that the clients use for the
+// migration.
+//
+// The packets sent to the preferred address must be received by the Transport that
+// accepted the connection, for example because it is bound to an unspecified address,
+// or because a load balancer forwards them.
+//
+// If the Transport is bound to an unspecified address, on platforms reporting the local
+// address packets are received on, the server responds to the client's probes of the
+// preferred address from the preferred address, as required by section 8.2.2 of
+// RFC 9000, and sends from it once the client migrated to it. Otherwise the server
+// keeps sending from the local address the connection was accepted on, and clients
+// that check the source address of the responses only migrate if a load balancer or
+// NAT rewrites it to the preferred address.
+type PreferredAddress struct {
+	// IPv4 is the preferred IPv4 address. It is optional if IPv6 is set.
+	// IPv4-mapped IPv6 addresses are accepted.
+	IPv4 netip.AddrPort
+	// IPv6 is the preferred IPv6 address. It is optional if IPv4 is set.
+	IPv6 netip.AddrPort
+}
+
+// validatePreferredAddress validates the PreferredAddress of a Config used by a server,
+// whose Transport uses connection IDs of connIDLen bytes.
+func validatePreferredAddress(a *PreferredAddress, connIDLen int) error {
+	if a == nil {
+		return nil
+	}
+	if !a.IPv4.IsValid() && !a.IPv6.IsValid() {
+		return errors.New("invalid preferred address: no address set")
+	}
+	if a.IPv4.IsValid() && (!a.IPv4.Addr().Unmap().Is4() || a.IPv4.Addr().IsUnspecified() || a.IPv4.Port() == 0) {
+		return fmt.Errorf("invalid preferred IPv4 address: %s", a.IPv4)
+	}
+	if a.IPv6.IsValid() && (!a.IPv6.Addr().Is6() || a.IPv6.Addr().Is4In6() || a.IPv6.Addr().IsUnspecified() || a.IPv6.Port() == 0) {
+		return fmt.Errorf("invalid preferred IPv6 address: %s", a.IPv6)
+	}
+	// see section 18.2 of RFC 9000
+	if connIDLen == 0 {
+		return errors.New("a server using zero-length connection IDs can't send a preferred address")
+	}
+	return nil
+}
+
+// setPreferredAddress adds the preferred address to the server's transport parameters.
+func (c *Conn) setPreferredAddress(params *wire.TransportParameters) {
+	connID, resetToken, err := c.connIDGenerator.IssuePreferredAddressConnID()
+	if err != nil {
+		c.logger.Errorf("Not sending the preferred address, failed to generate a connection ID: %s", err)
+		return
+	}
+	params.PreferredAddress = &wire.PreferredAddress{
+		IPv4:                netip.AddrPortFrom(c.config.PreferredAddress.IPv4.Addr().Unmap(), c.config.PreferredAddress.IPv4.Port()),
+		IPv6:                c.config.PreferredAddress.IPv6,
+		ConnectionID:        connID,
+		StatelessResetToken: resetToken,
+	}
+}
+
+// AddPreferredAddressPath adds a path from the Transport to the preferred address of the
+// server, see PreferredAddress. As with AddPath, the path needs to be probed before the
+// connection can switch to it.
+// The address of the same family as the current remote address is used, if the server
+// sent one.
+// It returns an error if the server didn't send a preferred address, or if the handshake
+// is not complete yet. Servers disabling active migration still allow the clients to
+// migrate to their preferred address.
+func (c *Conn) AddPreferredAddressPath(t *Transport) (*Path, error) {
+	if c.perspective == protocol.PerspectiveServer {
+		return nil, errors.New("server cannot initiate connection migration")
+	}
+	select {
+	case <-c.HandshakeComplete():
+	default:
+		return nil, errors.New("handshake not complete")
+	}
+	addr, ok := c.preferredAddress()
+	if !ok {
+		return nil, errors.New("server didn't send a preferred address")
+	}
+	if err := t.init(false); err != nil {
+		return nil, err
+	}
+	return c.addPath(t, addr), nil
+}
+
+// preferredAddress returns the preferred address sent by the server, with the address
+// family of the current remote address, if possible.
+func (c *Conn) preferredAddress() (*net.UDPAddr, bool) {
+	pa := c.peerParams.PreferredAddress
+	if pa == nil {
+		return nil, false
+	}
+	addrs := []netip.AddrPort{pa.IPv4, pa.IPv6}
+	if addr, ok := c.RemoteAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+		addrs[0], addrs[1] = addrs[1], addrs[0]
+	}
+	for _, addr := range addrs {
+		if addr.IsValid() {
+			return net.UDPAddrFromAddrPort(addr), true
+		}
+	}
+	return nil, false
+}
+
+// migrateToPreferredAddress probes the preferred address addr from the Transport
+// the connection was dialed on, and switches to it once it is validated, see
+// Config.MigrateToPreferredAddress. If the path can't be validated within the handshake
+// idle timeout, the connection keeps using the original address.
+func (c *Conn) migrateToPreferredAddress(addr net.Addr) {
+	path := c.addPath(c.transport, addr)
+	ctx, cancel := context.WithTimeout(c.Context(), c.config.HandshakeIdleTimeout)
+	defer cancel()
+	if err := path.Probe(ctx); err != nil {
+		c.logger.Debugf("Failed to validate the preferred address %s: %s", addr, err)
+		path.Close()
+		return
+	}
+	if err := path.Switch(); err != nil {
+		c.logger.Debugf("Failed to switch to the preferred address %s: %s", addr, err)
+		return
+	}
+	c.logger.Debugf("Migrated to the preferred address %s", addr)
+}
+
+// localAddrConn is implemented by the sendConn of a connection, see sconn.
+type localAddrConn interface {
+	// WriteToFrom sends a packet to addr, from the local address of info.
+	WriteToFrom(b []byte, addr net.Addr, info packetInfo) error
+	// SendsFrom says if the connection sends from the local address of info.
+	SendsFrom(info packetInfo) bool
+}
+
+var _ localAddrConn = &sconn{}
+
+func (c *sconn) WriteToFrom(b []byte, addr net.Addr, info packetInfo) error {
+	_, err := c.WritePacket(b, addr, info.OOB(), 0, protocol.ECNUnsupported)
+	return err
+}
+
+func (c *sconn) SendsFrom(info packetInfo) bool {
+	return bytes.Equal(c.remoteAddrInfo.Load().oob, info.OOB())
+}
+
+// sendProbeFrom sends a path probe packet to the remote address of p, from the local
+// address p was received on. Section 8.2.2 of RFC 9000 requires PATH_RESPONSE frames
+// to be sent on the path the PATH_CHALLENGE was received on. The local address is only
+// known if the Transport is bound to an unspecified address.
+func (c *Conn) sendProbeFrom(buf *packetBuffer, p receivedPacket) {
+	lc, ok := c.conn.(localAddrConn)
+	if !ok || !p.info.addr.IsValid() {
+		c.sendQueue.SendProbe(buf, p.remoteAddr)
+		return
+	}
+	if err := lc.WriteToFrom(buf.Data, p.remoteAddr, p.info); err != nil {
+		c.logger.Debugf("failed to send path probe packet from %s: %s", p.info.addr, err)
+	}
+}
+
+// handlePacketOnLocalAddr handles a packet received by the server from the current
+// remote address, possibly on another local address: the client probes the preferred
+// address, and migrates to it, from its current address.
+// The PATH_RESPONSE frames are sent from the local address the PATH_CHALLENGE was
+// received on. Section 9.6.2 of RFC 9000 requires the server to keep sending from its
+// original address until it receives a non-probing packet on its preferred address.
+// The path doesn't need to be validated again then, since the client's address doesn't
+// change.
+func (c *Conn) handlePacketOnLocalAddr(
+	p receivedPacket,
+	pn protocol.PacketNumber,
+	pathChallenge *wire.PathChallengeFrame,
+	isNonProbing bool,
+	datagramID qlog.DatagramID, // only for logging
+) error {
+	if lc, ok := c.conn.(localAddrConn); !ok || !p.info.addr.IsValid() || lc.SendsFrom(p.info) {
+		if pathChallenge != nil {
+			c.queueControlFrame(&wire.PathResponseFrame{Data: pathChallenge.Data})
+		}
+		return nil
+	}
+
+	if pathChallenge != nil {
+		frames := []ackhandler.Frame{{Frame: &wire.PathResponseFrame{Data: pathChallenge.Data}}}
+		probe, buf, err := c.packer.PackPathProbePacket(c.connIDManager.Get(), frames, c.version)
+		if err != nil {
+			return err
+		}
+		c.logger.Debugf("sending path probe packet from %s to %s", p.info.addr, p.remoteAddr)
+		c.logShortHeaderPacketWithDatagramID(probe, protocol.ECNNon, buf.Len(), false, datagramID)
+		c.registerPackedShortHeaderPacket(probe, protocol.ECNNon, p.rcvTime)
+		c.sendProbeFrom(buf, p)
+	}
+	// as for migrations to a new remote address, only switch in response to the
+	// highest-numbered non-probing packet
+	if isNonProbing && pn == c.largestRcvdAppData {
+		c.logger.Debugf("sending from %s", p.info.addr)
+		c.conn.ChangeRemoteAddr(p.remoteAddr, p.info)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+//go:build linux
+
+package quic
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/testdata"
+	tls "github.com/Noooste/utls"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The tests in this file use real sockets: the server needs to learn the local address
+// the packets are received on, and every address of 127.0.0.0/8 is a loopback address.
+
+// sourceFilterConn drops the packets that are not sent from src, once enabled.
+type sourceFilterConn struct {
+	net.PacketConn
+	src     *net.UDPAddr
+	enabled atomic.Bool
+}
+
+func (c *sourceFilterConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err != nil || !c.enabled.Load() || addrsEqual(addr, c.src) {
+			return n, addr, err
+		}
+	}
+}
+
+func TestPreferredAddressPathResponseSource(t *testing.T) {
+	// bound to an unspecified address, the server receives the packets sent to the preferred address
+	serverConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	require.NoError(t, err)
+	port := serverConn.LocalAddr().(*net.UDPAddr).Port
+	serverAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+	preferred := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: port}
+	serverTr := &Transport{Conn: serverConn}
+	defer serverTr.Close()
+	tlsConf := testdata.GetTLSConfig()
+	tlsConf.NextProtos = []string{"h3"}
+	ln, err := serverTr.Listen(tlsConf, &Config{
+		PreferredAddress: &PreferredAddress{IPv4: preferred.AddrPort()},
+	})
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			go func() {
+				for {
+					str, err := conn.AcceptStream(context.Background())
+					if err != nil {
+						return
+					}
+					go func() {
+						io.Copy(str, str)
+						str.Close()
+					}()
+				}
+			}()
+		}
+	}()
+
+	newClientTransport := func(t *testing.T) (*Transport, *sourceFilterConn) {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.NoError(t, err)
+		filter := &sourceFilterConn{PacketConn: conn, src: preferred}
+		tr := &Transport{Conn: filter}
+		t.Cleanup(func() { tr.Close() })
+		return tr, filter
+	}
+	dial := func(t *testing.T, tr *Transport) *Conn {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		conn, err := tr.Dial(
+			ctx,
+			serverAddr,
+			&tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA(), NextProtos: []string{"h3"}},
+			nil,
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.CloseWithError(0, "") })
+		return conn
+	}
+	probe := func(t *testing.T, conn *Conn, tr *Transport) {
+		path, err := conn.AddPreferredAddressPath(tr)
+		require.NoError(t, err)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		require.NoError(t, path.Probe(ctx))
+		require.NoError(t, path.Switch())
+		(&preferredAddressTest{conn: conn}).echo(t)
+	}
+
+	t.Run("from the current address", func(t *testing.T) {
+		tr, filter := newClientTransport(t)
+		conn := dial(t, tr)
+		// make sure that the handshake is confirmed
+		(&preferredAddressTest{conn: conn}).echo(t)
+		// from now on, only accept the packets sent from the preferred address
+		filter.enabled.Store(true)
+		probe(t, conn, tr)
+	})
+
+	t.Run("from a new address", func(t *testing.T) {
+		tr, _ := newClientTransport(t)
+		conn := dial(t, tr)
+		newTr, filter := newClientTransport(t)
+		filter.enabled.Store(true)
+		probe(t, conn, newTr)
+	})
+}
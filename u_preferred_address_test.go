@@ -0,0 +1,229 @@
+package quic
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/synctest"
+	"github.com/Noooste/uquic-go/internal/testdata"
+	"github.com/Noooste/uquic-go/testutils/simnet"
+	tls "github.com/Noooste/utls"
+
+	"github.com/stretchr/testify/require"
+)
+
+// preferredAddressRouter delivers the packets sent to the preferred address to the server,
+// and counts the packets sent to each of the server's addresses.
+type preferredAddressRouter struct {
+	simnet.PerfectRouter
+	server, preferred *net.UDPAddr
+
+	mx                    sync.Mutex
+	toServer, toPreferred int
+	preferredSrc          []net.Addr
+}
+
+func (r *preferredAddressRouter) SendPacket(p simnet.Packet) error {
+	r.mx.Lock()
+	switch {
+	case addrsEqual(p.To, r.preferred):
+		r.toPreferred++
+		r.preferredSrc = append(r.preferredSrc, p.From)
+		p.To = r.server
+	case addrsEqual(p.To, r.server):
+		r.toServer++
+	}
+	r.mx.Unlock()
+	return r.PerfectRouter.SendPacket(p)
+}
+
+func (r *preferredAddressRouter) counts() (toServer, toPreferred int) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.toServer, r.toPreferred
+}
+
+type preferredAddressTest struct {
+	router *preferredAddressRouter
+	conn   *Conn
+	// a second endpoint of the client, at newClientAddr
+	newClientPacketConn net.PacketConn
+}
+
+var newClientAddr = &net.UDPAddr{IP: net.IPv4(1, 0, 0, 4), Port: 9001}
+
+// runPreferredAddressTest runs f with a connection to a server sending a preferred address.
+// The server echoes the data sent on the streams opened by the client.
+// If spec is set, the connection is dialed with a UTransport.
+func runPreferredAddressTest(t *testing.T, clientConf *Config, spec *QUICSpec, f func(t *testing.T, test *preferredAddressTest)) {
+	synctest.Test(t, func(t *testing.T) {
+		router := &preferredAddressRouter{
+			server:    &net.UDPAddr{IP: net.IPv4(1, 0, 0, 2), Port: 9002},
+			preferred: &net.UDPAddr{IP: net.IPv4(1, 0, 0, 3), Port: 443},
+		}
+		n := &simnet.Simnet{Router: router}
+		settings := simnet.NodeBiDiLinkSettings{Latency: 10 * time.Millisecond}
+		clientPacketConn := n.NewEndpoint(&net.UDPAddr{IP: net.IPv4(1, 0, 0, 1), Port: 9001}, settings)
+		serverPacketConn := n.NewEndpoint(router.server, settings)
+		newClientPacketConn := n.NewEndpoint(newClientAddr, settings)
+		require.NoError(t, n.Start())
+		defer n.Close()
+
+		serverTr := &Transport{Conn: serverPacketConn}
+		defer serverTr.Close()
+		tlsConf := testdata.GetTLSConfig()
+		tlsConf.NextProtos = []string{"h3"}
+		ln, err := serverTr.Listen(tlsConf, &Config{
+			PreferredAddress: &PreferredAddress{IPv4: router.preferred.AddrPort()},
+		})
+		require.NoError(t, err)
+		defer ln.Close()
+		go func() {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			for {
+				str, err := conn.AcceptStream(context.Background())
+				if err != nil {
+					return
+				}
+				go func() {
+					io.Copy(str, str)
+					str.Close()
+				}()
+			}
+		}()
+
+		clientTr := &Transport{Conn: clientPacketConn}
+		defer clientTr.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		// the certificate is not valid yet at the start of the synctest bubble
+		clientTLSConf := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h3"}}
+		var conn *Conn
+		if spec != nil {
+			conn, err = (&UTransport{Transport: clientTr, QUICSpec: spec}).Dial(ctx, router.server, clientTLSConf, clientConf)
+		} else {
+			conn, err = clientTr.Dial(ctx, router.server, clientTLSConf, clientConf)
+		}
+		require.NoError(t, err)
+		defer conn.CloseWithError(0, "")
+
+		f(t, &preferredAddressTest{router: router, conn: conn, newClientPacketConn: newClientPacketConn})
+	})
+}
+
+// echo sends data on a new stream, and checks that the server echoes it.
+func (test *preferredAddressTest) echo(t *testing.T) {
+	str, err := test.conn.OpenStream()
+	require.NoError(t, err)
+	data := make([]byte, 10_000)
+	_, err = str.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, str.Close())
+	echoed, err := io.ReadAll(str)
+	require.NoError(t, err)
+	require.Equal(t, data, echoed)
+}
+
+func TestPreferredAddressMigration(t *testing.T) {
+	runPreferredAddressTest(t, &Config{MigrateToPreferredAddress: true}, nil, func(t *testing.T, test *preferredAddressTest) {
+		// wait for the path to be validated
+		time.Sleep(time.Second)
+		toServer, toPreferred := test.router.counts()
+		require.NotZero(t, toPreferred)
+
+		test.echo(t)
+		toServerAfter, toPreferredAfter := test.router.counts()
+		require.Equal(t, toServer, toServerAfter)
+		require.Greater(t, toPreferredAfter, toPreferred)
+	})
+}
+
+func TestPreferredAddressMigrationUTransport(t *testing.T) {
+	for _, id := range []QUICID{
+		testQUICIDPQ,        // zero-length connection IDs
+		testQUICIDPQConnID3, // 3 bytes
+	} {
+		t.Run(id.Client+"_"+id.Version, func(t *testing.T) {
+			spec, err := testQUICID2Spec(id)
+			require.NoError(t, err)
+			runPreferredAddressTest(t, &Config{MigrateToPreferredAddress: true}, &spec, func(t *testing.T, test *preferredAddressTest) {
+				time.Sleep(time.Second)
+				toServer, toPreferred := test.router.counts()
+				require.NotZero(t, toPreferred)
+
+				test.echo(t)
+				toServerAfter, toPreferredAfter := test.router.counts()
+				require.Equal(t, toServer, toServerAfter)
+				require.Greater(t, toPreferredAfter, toPreferred)
+			})
+		})
+	}
+}
+
+func TestPreferredAddressPath(t *testing.T) {
+	runPreferredAddressTest(t, nil, nil, func(t *testing.T, test *preferredAddressTest) {
+		// the client doesn't migrate by itself
+		time.Sleep(time.Second)
+		_, toPreferred := test.router.counts()
+		require.Zero(t, toPreferred)
+
+		tr := &Transport{Conn: test.newClientPacketConn}
+		defer tr.Close()
+		path, err := test.conn.AddPreferredAddressPath(tr)
+		require.NoError(t, err)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, path.Probe(ctx))
+		require.NoError(t, path.Switch())
+
+		toServer, _ := test.router.counts()
+		test.echo(t)
+		toServerAfter, toPreferred := test.router.counts()
+		require.Equal(t, toServer, toServerAfter)
+		require.NotZero(t, toPreferred)
+		test.router.mx.Lock()
+		defer test.router.mx.Unlock()
+		for _, addr := range test.router.preferredSrc {
+			require.Equal(t, newClientAddr.String(), addr.String())
+		}
+	})
+}
+
+func TestPreferredAddressValidation(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		addr      PreferredAddress
+		connIDLen int
+	}{
+		{name: "no address", connIDLen: 4},
+		{name: "IPv6 address as IPv4", addr: PreferredAddress{IPv4: netip.MustParseAddrPort("[::1]:443")}, connIDLen: 4},
+		{name: "IPv4 address as IPv6", addr: PreferredAddress{IPv6: netip.MustParseAddrPort("[::ffff:1.2.3.4]:443")}, connIDLen: 4},
+		{name: "unspecified address", addr: PreferredAddress{IPv4: netip.MustParseAddrPort("0.0.0.0:443")}, connIDLen: 4},
+		{name: "zero port", addr: PreferredAddress{IPv4: netip.MustParseAddrPort("1.2.3.4:0")}, connIDLen: 4},
+		{name: "zero-length connection IDs", addr: PreferredAddress{IPv4: netip.MustParseAddrPort("1.2.3.4:443")}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Error(t, validatePreferredAddress(&tc.addr, tc.connIDLen))
+		})
+	}
+	require.NoError(t, validatePreferredAddress(nil, 0))
+	require.NoError(t, validatePreferredAddress(&PreferredAddress{
+		IPv4: netip.MustParseAddrPort("1.2.3.4:443"),
+		IPv6: netip.MustParseAddrPort("[2001:db8::1]:443"),
+	}, 4))
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	tr := &Transport{Conn: conn}
+	defer tr.Close()
+	_, err = tr.Listen(testdata.GetTLSConfig(), &Config{PreferredAddress: &PreferredAddress{}})
+	require.Error(t, err)
+}
@@ -5,8 +5,7 @@ import (
 	"crypto/rand"
 	"errors"
 	"math"
-	"math/big"
-	mrand "math/rand"
+	mrand "math/rand/v2"
 
 	"github.com/Noooste/uquic-go/quicvarint"
 	"github.com/gaukas/clienthellod"
@@ -31,9 +30,9 @@ func (qfs QUICFrames) Build(cryptoData []byte) (payload []byte, err error) {
 		return qfsCryptoOnly.Build(cryptoData)
 	}
 
-	lowestOffset := math.MaxUint16
+	lowestOffset := math.MaxInt
 	for _, frame := range qfs {
-		if offset, _, _ := frame.CryptoFrameInfo(); offset < lowestOffset {
+		if offset, _, cryptoOK := frame.CryptoFrameInfo(); cryptoOK && offset < lowestOffset {
 			lowestOffset = offset
 		}
 	}
@@ -63,6 +62,24 @@ func (qfs QUICFrames) Build(cryptoData []byte) (payload []byte, err error) {
 	return payload, nil
 }
 
+// [UQUIC]
+// withCryptoOffset returns a copy of the QUICFrames with the offsets of the crypto
+// frames shifted by offset, for a ClientHello that doesn't start at offset 0.
+func (qfs QUICFrames) withCryptoOffset(offset int) QUICFrames {
+	if offset == 0 {
+		return qfs
+	}
+	shifted := make(QUICFrames, 0, len(qfs))
+	for _, frame := range qfs {
+		if cf, ok := frame.(QUICFrameCrypto); ok {
+			cf.Offset += offset
+			frame = cf
+		}
+		shifted = append(shifted, frame)
+	}
+	return shifted
+}
+
 // BuildFromFrames ingests data from all input frames and returns the byte representation
 // of all frames as specified in the slice.
 func (qfs QUICFrames) BuildFromFrames(frames []byte) (payload []byte, err error) {
@@ -195,54 +212,54 @@ type QUICRandomFrames struct {
 	Length uint16 // 2 bytes, max 65535
 }
 
+// maxFrameOverhead returns the maximum number of bytes added to a single CRYPTO
+// frame carrying the same data when it is split and mixed with PING frames.
+func (qrf *QUICRandomFrames) maxFrameOverhead() int {
+	var overhead int
+	if qrf.MaxPING > 1 {
+		overhead += int(qrf.MaxPING) - 1 // 1 byte per PING frame
+	}
+	if qrf.MaxCRYPTO > 1 {
+		overhead += (int(qrf.MaxCRYPTO) - 1) * 5 // type, 2-byte offset and 2-byte length
+	}
+	return overhead
+}
+
 // Build ingests data from crypto frames without the crypto frame header
 // and returns the byte representation of all frames as specified in
 // the slice.
 func (qrf *QUICRandomFrames) Build(cryptoData []byte) (payload []byte, err error) {
-	// check all bounds
-	if qrf.MinPING > qrf.MaxPING {
-		return nil, errors.New("MinPING must be less than or equal to MaxPING")
-	}
-	if qrf.MinCRYPTO < 1 {
-		return nil, errors.New("MinCRYPTO must be at least 1")
-	}
-	if qrf.MinCRYPTO > qrf.MaxCRYPTO {
-		return nil, errors.New("MinCRYPTO must be less than or equal to MaxCRYPTO")
-	}
-	if qrf.MinPADDING < 1 && qrf.Length != 0 {
-		return nil, errors.New("MinPADDING must be at least 1 if Length is not 0")
+	chacha, err := newChaCha8(rand.Reader)
+	if err != nil {
+		return nil, err
 	}
-	if qrf.MinPADDING > qrf.MaxPADDING && qrf.Length != 0 {
-		return nil, errors.New("MinPADDING must be less than or equal to MaxPADDING if Length is not 0")
+	return qrf.buildAt(mrand.New(chacha), 0, cryptoData)
+}
+
+// buildAt is like Build, for crypto data starting at the given offset of the crypto
+// stream. The number of frames, the split of the CRYPTO and PADDING frames and the
+// order of the frames are chosen using rnd.
+func (qrf *QUICRandomFrames) buildAt(rnd *mrand.Rand, cryptoOffset int, cryptoData []byte) (payload []byte, err error) {
+	if err := qrf.validate(); err != nil {
+		return nil, err
 	}
 
 	var frameList QUICFrames = make([]QUICFrame, 0)
 
-	var cryptoSafeRandUint64 = func(min, max uint64) (uint64, error) {
-		minMaxDiff := big.NewInt(int64(max - min))
-		offset, err := rand.Int(rand.Reader, minMaxDiff)
-		if err != nil {
-			return 0, err
-		}
-		return min + offset.Uint64(), nil
+	var randUint64 = func(min, max uint64) uint64 {
+		return min + rnd.Uint64N(max-min)
 	}
 
-	// determine number of PING frames with crypto.rand
-	numPING, err := cryptoSafeRandUint64(uint64(qrf.MinPING), uint64(qrf.MaxPING))
-	if err != nil {
-		return nil, err
-	}
+	// determine number of PING frames
+	numPING := randUint64(uint64(qrf.MinPING), uint64(qrf.MaxPING))
 
 	// append PING frames
 	for i := uint64(0); i < numPING; i++ {
 		frameList = append(frameList, QUICFramePing{})
 	}
 
-	// determine number of CRYPTO frames with crypto.rand
-	numCRYPTO, err := cryptoSafeRandUint64(uint64(qrf.MinCRYPTO), uint64(qrf.MaxCRYPTO))
-	if err != nil {
-		return nil, err
-	}
+	// determine number of CRYPTO frames
+	numCRYPTO := randUint64(uint64(qrf.MinCRYPTO), uint64(qrf.MaxCRYPTO))
 
 	lenCryptoData := uint64(len(cryptoData))
 	offsetCryptoData := uint64(0)
@@ -250,17 +267,14 @@ func (qrf *QUICRandomFrames) Build(cryptoData []byte) (payload []byte, err error
 		// randomly select length of CRYPTO frame.
 		// Length must be at least 1 byte and at most the remaining length of cryptoData minus the remaining number of CRYPTO frames.
 		// i.e. len in [1, len(cryptoData)-offsetCryptoData-(numCRYPTO-i-2))
-		lenCRYPTO, err := cryptoSafeRandUint64(1, lenCryptoData-(numCRYPTO-i-2))
-		if err != nil {
-			return nil, err
-		}
-		frameList = append(frameList, QUICFrameCrypto{Offset: int(offsetCryptoData), Length: int(lenCRYPTO)})
+		lenCRYPTO := randUint64(1, lenCryptoData-(numCRYPTO-i-2))
+		frameList = append(frameList, QUICFrameCrypto{Offset: cryptoOffset + int(offsetCryptoData), Length: int(lenCRYPTO)})
 		offsetCryptoData += lenCRYPTO
 		lenCryptoData -= lenCRYPTO
 	}
 
 	// append the last CRYPTO frame
-	frameList = append(frameList, QUICFrameCrypto{Offset: int(offsetCryptoData), Length: 0}) // 0 means the remaining
+	frameList = append(frameList, QUICFrameCrypto{Offset: cryptoOffset + int(offsetCryptoData), Length: 0}) // 0 means the remaining
 
 	// dry-run to determine the total length of all frames so far
 	dryrunPayload, err := frameList.Build(cryptoData)
@@ -271,34 +285,58 @@ func (qrf *QUICRandomFrames) Build(cryptoData []byte) (payload []byte, err error
 	// determine length of PADDING frames to append
 	lenPADDINGsigned := int64(qrf.Length) - int64(len(dryrunPayload))
 	if lenPADDINGsigned > 0 {
-		lenPADDING := uint64(lenPADDINGsigned)
-		// determine number of PADDING frames to append
-		numPADDING, err := cryptoSafeRandUint64(uint64(qrf.MinPADDING), uint64(qrf.MaxPADDING))
-		if err != nil {
-			return nil, err
-		}
-
-		for i := uint64(0); i < numPADDING-1; i++ { // select n-1 times, since the last one must be the remaining
-			// randomly select length of PADDING frame.
-			// Length must be at least 1 byte and at most the remaining length of cryptoData minus the remaining number of CRYPTO frames.
-			// i.e. len in [1, lenPADDING-(numPADDING-i-2))
-			lenPADDINGFrame, err := cryptoSafeRandUint64(1, lenPADDING-(numPADDING-i-2))
-			if err != nil {
-				return nil, err
-			}
-			frameList = append(frameList, QUICFramePadding{Length: int(lenPADDINGFrame)})
-			lenPADDING -= lenPADDINGFrame
-		}
-
-		// append the last CRYPTO frame
-		frameList = append(frameList, QUICFramePadding{Length: int(lenPADDING)}) // 0 means the remaining
+		frameList = append(frameList, qrf.paddingFrames(rnd, uint64(lenPADDINGsigned))...)
 	}
 
 	// shuffle the frameList
-	mrand.Shuffle(len(frameList), func(i, j int) {
+	rnd.Shuffle(len(frameList), func(i, j int) {
 		frameList[i], frameList[j] = frameList[j], frameList[i]
 	})
 
 	// build the payload
 	return frameList.Build(cryptoData)
 }
+
+func (qrf *QUICRandomFrames) validate() error {
+	// [UQUIC] the upper bounds are exclusive, so they must exceed the lower bounds
+	if qrf.MinPING >= qrf.MaxPING {
+		return errors.New("MaxPING must be greater than MinPING")
+	}
+	if qrf.MinCRYPTO < 1 {
+		return errors.New("MinCRYPTO must be at least 1")
+	}
+	if qrf.MinCRYPTO >= qrf.MaxCRYPTO {
+		return errors.New("MaxCRYPTO must be greater than MinCRYPTO")
+	}
+	if qrf.MinPADDING < 1 && qrf.Length != 0 {
+		return errors.New("MinPADDING must be at least 1 if Length is not 0")
+	}
+	if qrf.MinPADDING >= qrf.MaxPADDING && qrf.Length != 0 {
+		return errors.New("MaxPADDING must be greater than MinPADDING if Length is not 0")
+	}
+	return nil
+}
+
+// paddingFrames splits lenPADDING bytes of padding into a random number of PADDING frames.
+func (qrf *QUICRandomFrames) paddingFrames(rnd *mrand.Rand, lenPADDING uint64) []QUICFrame {
+	var randUint64 = func(min, max uint64) uint64 {
+		return min + rnd.Uint64N(max-min)
+	}
+
+	// determine number of PADDING frames to append
+	numPADDING := randUint64(uint64(qrf.MinPADDING), uint64(qrf.MaxPADDING))
+
+	frames := make([]QUICFrame, 0, numPADDING)
+	for i := uint64(0); i < numPADDING-1; i++ { // select n-1 times, since the last one must be the remaining
+		// randomly select length of PADDING frame.
+		// Length must be at least 1 byte and at most the remaining length of cryptoData minus the remaining number of CRYPTO frames.
+		// i.e. len in [1, lenPADDING-(numPADDING-i-2))
+		lenPADDINGFrame := randUint64(1, lenPADDING-(numPADDING-i-2))
+		frames = append(frames, QUICFramePadding{Length: int(lenPADDINGFrame)})
+		lenPADDING -= lenPADDINGFrame
+	}
+
+	// append the last PADDING frame
+	frames = append(frames, QUICFramePadding{Length: int(lenPADDING)})
+	return frames
+}
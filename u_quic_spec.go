@@ -1,6 +1,10 @@
 package quic
 
-import tls "github.com/Noooste/utls"
+import (
+	"io"
+
+	tls "github.com/Noooste/utls"
+)
 
 const (
 	DefaultUDPDatagramMinSize = 1200
@@ -20,8 +24,150 @@ type QUICSpec struct {
 	// If the UDP Datagram is smaller than this size, zeros will be padded to the end
 	// of the UDP Datagram until this size is reached.
 	UDPDatagramMinSize int
+
+	// PostHandshakeSpec specifies the behavior of the client once the connection is
+	// established, such as the ACK frequency and the connection IDs issued.
+	PostHandshakeSpec PostHandshakeSpec // [UQUIC]
+
+	// VersionSpec specifies the QUIC versions offered by the client, and the version
+	// it starts the connection with.
+	VersionSpec VersionSpec // [UQUIC]
+
+	// IPv4 and IPv6 override parts of the QUICSpec when dialing an IPv4 or an IPv6
+	// address respectively, see AddrFamilySpec.
+	IPv4, IPv6 *AddrFamilySpec // [UQUIC]
+
+	// Rand provides the randomness of the connections dialed with the QUICSpec: the
+	// connection IDs, the tokens generated for ClientTokenLength, the values of GREASE
+	// transport parameters, the frames built by QUICRandomFrames, and, unless the
+	// tls.Config sets its own Rand, the randomness used by uTLS (the ClientHello's
+	// random, the key shares and the GREASE values). If nil, crypto/rand is used.
+	//
+	// Dialing with a Rand returning the same bytes, e.g. a math/rand/v2 ChaCha8 with a
+	// fixed seed, produces byte-identical Initial packets. This is meant for tests:
+	// anyone knowing the seed can derive the key shares, and thus decrypt the connection.
+	// The order of the TLS extensions and of the transport parameters is set when the
+	// QUICSpec is created, see QUICID2SpecWithRand.
+	Rand io.Reader // [UQUIC]
 }
 
 func (s *QUICSpec) UpdateConfig(config *Config) {
-	s.InitialPacketSpec.UpdateConfig(config)
+	s.InitialPacketSpec.updateConfig(config, s.rand()) // [UQUIC]
+	s.PostHandshakeSpec.UpdateConfig(config)           // [UQUIC]
+	s.updateVersions(config)                           // [UQUIC]
+}
+
+// [UQUIC]
+// clientHelloSpecForConn returns a copy of the ClientHelloSpec for a single connection.
+//
+// uTLS fills in key shares, GREASE values and session state while applying a
+// ClientHelloSpec, so every connection must start from a fresh copy. Otherwise
+// reusing the QUICSpec for another dial would reuse the key shares of the
+// previous connection.
+//
+// If useECH is set, the GREASE ECH extension is kept, to be replaced with the real
+// ECH extension by uTLS.
+func (s *QUICSpec) clientHelloSpecForConn(r *connRand, useECH bool) *tls.ClientHelloSpec {
+	if s.ClientHelloSpec == nil {
+		return nil
+	}
+	chs := *s.ClientHelloSpec
+	chs.CipherSuites = append([]uint16(nil), s.ClientHelloSpec.CipherSuites...)
+	chs.CompressionMethods = append([]uint8(nil), s.ClientHelloSpec.CompressionMethods...)
+	chs.Extensions = make([]tls.TLSExtension, 0, len(s.ClientHelloSpec.Extensions))
+	var earlyData *earlyDataExtension
+	for _, ext := range s.ClientHelloSpec.Extensions {
+		switch ext := ext.(type) {
+		case *tls.SNIExtension:
+			e := *ext
+			chs.Extensions = append(chs.Extensions, &e)
+		case *tls.UtlsGREASEExtension:
+			e := *ext
+			chs.Extensions = append(chs.Extensions, &e)
+		case *tls.SupportedCurvesExtension:
+			chs.Extensions = append(chs.Extensions, &tls.SupportedCurvesExtension{
+				Curves: append([]tls.CurveID(nil), ext.Curves...),
+			})
+		case *tls.KeyShareExtension:
+			chs.Extensions = append(chs.Extensions, &tls.KeyShareExtension{
+				KeyShares: append([]tls.KeyShare(nil), ext.KeyShares...),
+			})
+		case *tls.SupportedVersionsExtension:
+			chs.Extensions = append(chs.Extensions, &tls.SupportedVersionsExtension{
+				Versions: append([]uint16(nil), ext.Versions...),
+			})
+		case *tls.UtlsPaddingExtension:
+			e := *ext
+			chs.Extensions = append(chs.Extensions, &e)
+		case *earlyDataExtension:
+			earlyData = newEarlyDataExtension()
+			chs.Extensions = append(chs.Extensions, earlyData)
+		case *tls.UtlsPreSharedKeyExtension:
+			// only sent if the ClientSessionCache holds a session for the server
+			if earlyData != nil {
+				chs.Extensions = append(chs.Extensions, &earlyDataPSKExtension{
+					UtlsPreSharedKeyExtension: &tls.UtlsPreSharedKeyExtension{},
+					earlyData:                 earlyData,
+				})
+				continue
+			}
+			chs.Extensions = append(chs.Extensions, &tls.UtlsPreSharedKeyExtension{})
+		case *tls.GREASEEncryptedClientHelloExtension:
+			if s.Rand != nil && !useECH {
+				chs.Extensions = append(chs.Extensions, seededGREASEECHExtension(ext, r))
+				continue
+			}
+			chs.Extensions = append(chs.Extensions, &tls.GREASEEncryptedClientHelloExtension{
+				CandidateCipherSuites: ext.CandidateCipherSuites,
+				CandidateConfigIds:    ext.CandidateConfigIds,
+				CandidatePayloadLens:  ext.CandidatePayloadLens,
+			})
+		case *tls.QUICTransportParametersExtension:
+			// the initial_source_connection_id is populated for each connection,
+			// and GREASE values are chosen for each connection
+			tps := make(tls.TransportParameters, 0, len(ext.TransportParameters))
+			for _, tp := range ext.TransportParameters {
+				switch tp := tp.(type) {
+				case *tls.GREASETransportParameter:
+					tps = append(tps, newGREASETransportParameter(tp, r))
+				case *tls.VersionInformation:
+					tps = append(tps, newVersionInformation(tp, r))
+				default:
+					tps = append(tps, tp)
+				}
+			}
+			chs.Extensions = append(chs.Extensions, &tls.QUICTransportParametersExtension{TransportParameters: tps})
+		default:
+			chs.Extensions = append(chs.Extensions, ext)
+		}
+	}
+	return &chs
+}
+
+// newGREASETransportParameter returns a copy of the GREASE transport parameter, with
+// a random ID and value unless they are overridden.
+func newGREASETransportParameter(grease *tls.GREASETransportParameter, r *connRand) *tls.GREASETransportParameter {
+	g := *grease
+	if !g.IsGREASEID(g.IdOverride) {
+		g.IdOverride = 27 + 31*r.Uint64N(tls.GREASE_MAX_MULTIPLIER)
+	}
+	if len(g.ValueOverride) == 0 && g.Length > 0 {
+		g.ValueOverride = make([]byte, g.Length)
+		io.ReadFull(r, g.ValueOverride)
+	}
+	return &g
+}
+
+// newVersionInformation returns a copy of the version_information transport parameter,
+// with a random GREASE version in place of tls.VERSION_GREASE.
+func newVersionInformation(vi *tls.VersionInformation, r *connRand) *tls.VersionInformation {
+	v := *vi
+	v.AvailableVersions = make([]uint32, len(vi.AvailableVersions))
+	for i, version := range vi.AvailableVersions {
+		if version == tls.VERSION_GREASE {
+			version = greaseQUICVersion(r)
+		}
+		v.AvailableVersions[i] = version
+	}
+	return &v
 }
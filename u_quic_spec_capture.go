@@ -0,0 +1,619 @@
+package quic
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/Noooste/uquic-go/internal/handshake"
+	"github.com/Noooste/uquic-go/internal/protocol"
+	"github.com/Noooste/uquic-go/internal/wire"
+	"github.com/Noooste/uquic-go/quicvarint"
+	tls "github.com/Noooste/utls"
+	"github.com/gaukas/clienthellod"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// [UQUIC]
+// QUICSpecFromInitialPackets builds a QUICSpec from the UDP payloads of the Initial
+// packets sent by a client, as captured on the wire. If the ClientHello spans
+// multiple Initial packets, all of them must be passed, in any order. Datagrams
+// received after the ClientHello is complete are ignored.
+//
+// The Initial packets are decrypted using the Initial keys derived from the
+// Destination Connection ID. The resulting QUICSpec reproduces:
+//   - the connection ID lengths, the packet number and its length, and the token length
+//   - the version of the Initial packets, as the InitialVersion of the VersionSpec
+//   - the frame layout of the first Initial packet: a single CRYPTO frame is sent as
+//     QUICFrames{}, frames in order are reproduced as QUICFrames, and shuffled frames
+//     (e.g. Chrome's) or a ClientHello split across packets are sent as
+//     QUICRandomFrames with the same number of frames of each type and the same
+//     total length
+//   - the size of the UDP datagram carrying the first Initial packet, including any
+//     padding after the QUIC packets
+//   - if the ClientHello spans multiple Initial packets, the frames of the following
+//     packets, the size of their datagrams, whether they are padded with PADDING
+//     frames, and whether other packets are coalesced with them, see
+//     InitialPacketSpec.ClientHelloPackets
+//   - the ClientHello, parsed by uTLS, with the QUIC transport parameters.
+//
+// Values generated for each connection, such as the key shares, GREASE values and
+// the server name, are not copied from the capture.
+func QUICSpecFromInitialPackets(datagrams ...[]byte) (QUICSpec, error) {
+	var c initialCapture
+	for _, datagram := range datagrams {
+		if c.complete() {
+			break
+		}
+		if err := c.add(datagram); err != nil {
+			return QUICSpec{}, err
+		}
+	}
+	return c.spec()
+}
+
+// QUICSpecFromPcap builds a QUICSpec from the first QUIC connection attempt found in
+// a pcap or pcapng capture, see QUICSpecFromInitialPackets.
+func QUICSpecFromPcap(r io.Reader) (QUICSpec, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return QUICSpec{}, err
+	}
+	var source interface {
+		ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	}
+	var linkType layers.LinkType
+	if binary.BigEndian.Uint32(magic) == 0x0a0d0d0a { // pcapng Section Header Block
+		ngr, err := pcapgo.NewNgReader(br, pcapgo.DefaultNgReaderOptions)
+		if err != nil {
+			return QUICSpec{}, err
+		}
+		source, linkType = ngr, ngr.LinkType()
+	} else {
+		pr, err := pcapgo.NewReader(br)
+		if err != nil {
+			return QUICSpec{}, err
+		}
+		source, linkType = pr, pr.LinkType()
+	}
+
+	var (
+		c    initialCapture
+		flow *gopacket.Flow // network flow of the client's datagrams
+		port *gopacket.Flow // transport flow of the client's datagrams
+	)
+	for !c.complete() {
+		data, _, err := source.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return QUICSpec{}, err
+		}
+		packet := gopacket.NewPacket(data, linkType, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+		udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+		if !ok || packet.NetworkLayer() == nil {
+			continue
+		}
+		netFlow, udpFlow := packet.NetworkLayer().NetworkFlow(), udp.TransportFlow()
+		if flow == nil {
+			if !isClientInitial(udp.Payload) {
+				continue
+			}
+			flow, port = &netFlow, &udpFlow
+		} else if netFlow != *flow || udpFlow != *port {
+			continue
+		}
+		if err := c.add(udp.Payload); err != nil {
+			return QUICSpec{}, err
+		}
+	}
+	if flow == nil {
+		return QUICSpec{}, errors.New("no QUIC Initial packet found")
+	}
+	return c.spec()
+}
+
+// QUICSpecFromPcapFile reads the pcap or pcapng file and parses it with QUICSpecFromPcap.
+func QUICSpecFromPcapFile(name string) (QUICSpec, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return QUICSpec{}, err
+	}
+	defer f.Close()
+	return QUICSpecFromPcap(f)
+}
+
+func isClientInitial(datagram []byte) bool {
+	if len(datagram) == 0 || !wire.IsLongHeaderPacket(datagram[0]) {
+		return false
+	}
+	hdr, _, _, err := wire.ParsePacket(datagram)
+	return err == nil && hdr.Type == protocol.PacketTypeInitial
+}
+
+// initialCapture collects the Initial packets sent by a client.
+type initialCapture struct {
+	origDestConnID protocol.ConnectionID
+	opener         handshake.LongHeaderOpener
+	// anyDestConnID is set if the datagrams are known to belong to the same connection,
+	// whose Initial packets may use different Destination Connection IDs
+	anyDestConnID bool
+
+	// the first Initial packet, i.e. the one carrying the beginning of the ClientHello
+	firstHdr          *wire.ExtendedHeader
+	firstFrames       []clienthellod.Frame
+	firstPayloadLen   int
+	firstDatagramSize int
+
+	numPackets         int
+	lowestPacketNumber protocol.PacketNumber
+	cryptoFrames       []clienthellod.Frame
+	clientHello        []byte
+
+	datagramSizes []int
+	packets       []capturedPacket // all long header packets, in the order they were added
+}
+
+// capturedPacket is a long header packet found in a captured datagram.
+type capturedPacket struct {
+	datagram int // the index of the datagram carrying the packet
+	length   int
+	hdr      *wire.Header
+	// only set for Initial packets
+	extHdr     *wire.ExtendedHeader
+	frames     []clienthellod.Frame
+	payloadLen int
+}
+
+func (c *initialCapture) complete() bool { return c.clientHello != nil }
+
+func (c *initialCapture) add(datagram []byte) error {
+	data := slices.Clone(datagram) // header protection is removed in place
+	c.datagramSizes = append(c.datagramSizes, len(datagram))
+	for len(data) > 0 && wire.IsLongHeaderPacket(data[0]) {
+		hdr, packetData, rest, err := wire.ParsePacket(data)
+		if err != nil {
+			return fmt.Errorf("parsing packet: %w", err)
+		}
+		data = rest
+		c.packets = append(c.packets, capturedPacket{datagram: len(c.datagramSizes) - 1, length: len(packetData), hdr: hdr})
+		if hdr.Type != protocol.PacketTypeInitial {
+			continue
+		}
+		if c.opener == nil {
+			c.origDestConnID = hdr.DestConnectionID
+			_, c.opener = handshake.NewInitialAEAD(hdr.DestConnectionID, protocol.PerspectiveServer, hdr.Version)
+		} else if hdr.DestConnectionID != c.origDestConnID && !c.anyDestConnID {
+			return errors.New("Initial packets belong to different connections")
+		}
+
+		extHdr, err := unpackLongHeader(c.opener, hdr, packetData)
+		if err != nil {
+			return fmt.Errorf("removing header protection: %w", err)
+		}
+		extHdrLen := extHdr.ParsedLen()
+		extHdr.PacketNumber = c.opener.DecodePacketNumber(extHdr.PacketNumber, extHdr.PacketNumberLen)
+		payload, err := c.opener.Open(nil, packetData[extHdrLen:], extHdr.PacketNumber, packetData[:extHdrLen])
+		if err != nil {
+			return fmt.Errorf("decrypting Initial packet %d: %w", extHdr.PacketNumber, err)
+		}
+		frames, err := parseInitialFrames(payload)
+		if err != nil {
+			return fmt.Errorf("parsing frames of Initial packet %d: %w", extHdr.PacketNumber, err)
+		}
+
+		c.packets[len(c.packets)-1].extHdr = extHdr
+		c.packets[len(c.packets)-1].frames = frames
+		c.packets[len(c.packets)-1].payloadLen = len(payload)
+		if c.numPackets == 0 || extHdr.PacketNumber < c.lowestPacketNumber {
+			c.lowestPacketNumber = extHdr.PacketNumber
+		}
+		c.numPackets++
+		for _, frame := range frames {
+			if crypto, ok := frame.(*clienthellod.CRYPTO); ok {
+				if crypto.Offset == 0 {
+					c.firstHdr = extHdr
+					c.firstFrames = frames
+					c.firstPayloadLen = len(payload)
+					c.firstDatagramSize = len(datagram)
+				}
+				c.cryptoFrames = append(c.cryptoFrames, frame)
+			}
+		}
+	}
+
+	// check if the ClientHello is complete
+	cryptoData, err := clienthellod.ReassembleCRYPTOFrames(c.cryptoFrames)
+	if err != nil || len(cryptoData) < 4 {
+		return nil // waiting for more CRYPTO frames
+	}
+	msgLen := 4 + (int(cryptoData[1])<<16 | int(cryptoData[2])<<8 | int(cryptoData[3]))
+	if len(cryptoData) >= msgLen {
+		c.clientHello = cryptoData[:msgLen]
+	}
+	return nil
+}
+
+func (c *initialCapture) spec() (QUICSpec, error) {
+	if c.firstHdr == nil {
+		return QUICSpec{}, errors.New("no Initial packet carrying the beginning of the ClientHello")
+	}
+	if !c.complete() {
+		return QUICSpec{}, errors.New("incomplete ClientHello, missing Initial packets")
+	}
+	if c.clientHello[0] != 1 { // handshake message type ClientHello
+		return QUICSpec{}, fmt.Errorf("unexpected handshake message type %d", c.clientHello[0])
+	}
+	frameBuilder, err := frameBuilderFromCapture(c.firstFrames, c.firstPayloadLen, 0, len(c.clientHello))
+	if err != nil {
+		return QUICSpec{}, err
+	}
+	clientHelloPackets, err := c.clientHelloPackets()
+	if err != nil {
+		return QUICSpec{}, err
+	}
+	chs, err := clientHelloSpecFromCapture(c.clientHello)
+	if err != nil {
+		return QUICSpec{}, err
+	}
+	return QUICSpec{
+		InitialPacketSpec: InitialPacketSpec{
+			SrcConnIDLength:        c.firstHdr.SrcConnectionID.Len(),
+			DestConnIDLength:       c.firstHdr.DestConnectionID.Len(),
+			InitPacketNumberLength: c.firstHdr.PacketNumberLen,
+			InitPacketNumber:       uint64(c.lowestPacketNumber),
+			ClientTokenLength:      len(c.firstHdr.Token),
+			FrameBuilder:           frameBuilder,
+			ClientHelloPackets:     clientHelloPackets,
+		},
+		ClientHelloSpec:    chs,
+		UDPDatagramMinSize: c.firstDatagramSize,
+		VersionSpec:        VersionSpec{InitialVersion: c.firstHdr.Version},
+	}, nil
+}
+
+// clientHelloPackets returns the specs of the Initial packets carrying the remainder of
+// the ClientHello, ordered by the offset of their crypto data. Retransmissions are
+// ignored.
+func (c *initialCapture) clientHelloPackets() ([]ClientHelloPacketSpec, error) {
+	type continuation struct {
+		offset int
+		spec   ClientHelloPacketSpec
+	}
+	var continuations []continuation
+	for i, cp := range c.packets {
+		offset := -1
+		for _, frame := range cp.frames {
+			if crypto, ok := frame.(*clienthellod.CRYPTO); ok && (offset == -1 || int(crypto.Offset) < offset) {
+				offset = int(crypto.Offset)
+			}
+		}
+		if offset <= 0 || offset >= len(c.clientHello) || slices.ContainsFunc(continuations, func(cont continuation) bool { return cont.offset == offset }) {
+			continue
+		}
+		chp := ClientHelloPacketSpec{UDPDatagramMinSize: c.datagramSizes[cp.datagram]}
+		datagramLen := 0
+		for j, other := range c.packets {
+			if other.datagram != cp.datagram {
+				continue
+			}
+			datagramLen += other.length
+			if j > i {
+				chp.Coalesce = true
+			}
+		}
+		if isCryptoFollowedByPadding(cp.frames) {
+			// padded by the client up to the size of the datagram
+			chp.PaddingFrames = datagramLen == chp.UDPDatagramMinSize
+		} else {
+			fb, err := frameBuilderFromCapture(cp.frames, cp.payloadLen, offset, len(c.clientHello))
+			if err != nil {
+				return nil, err
+			}
+			if qfs, ok := fb.(QUICFrames); !ok || len(qfs) > 0 {
+				chp.FrameBuilder = fb
+			}
+		}
+		continuations = append(continuations, continuation{offset: offset, spec: chp})
+	}
+	slices.SortFunc(continuations, func(a, b continuation) int { return a.offset - b.offset })
+	var specs []ClientHelloPacketSpec
+	for _, cont := range continuations {
+		specs = append(specs, cont.spec)
+	}
+	return specs, nil
+}
+
+// isCryptoFollowedByPadding says if the frames are a single CRYPTO frame, followed
+// by PADDING frames.
+func isCryptoFollowedByPadding(frames []clienthellod.Frame) bool {
+	if len(frames) < 2 {
+		return false
+	}
+	if _, ok := frames[0].(*clienthellod.CRYPTO); !ok {
+		return false
+	}
+	for _, frame := range frames[1:] {
+		if _, ok := frame.(*clienthellod.PADDING); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// parseInitialFrames parses the frames of a client Initial packet.
+// clienthellod.ReadAllFrames is not used, since it loses frames following
+// several PADDING frames.
+func parseInitialFrames(b []byte) ([]clienthellod.Frame, error) {
+	var frames []clienthellod.Frame
+	for len(b) > 0 {
+		switch b[0] {
+		case 0x00:
+			l := 1
+			for l < len(b) && b[l] == 0x00 {
+				l++
+			}
+			frames = append(frames, &clienthellod.PADDING{Length: uint64(l)})
+			b = b[l:]
+		case 0x01:
+			frames = append(frames, &clienthellod.PING{})
+			b = b[1:]
+		case 0x06:
+			b = b[1:]
+			offset, l, err := quicvarint.Parse(b)
+			if err != nil {
+				return nil, err
+			}
+			b = b[l:]
+			length, l, err := quicvarint.Parse(b)
+			if err != nil {
+				return nil, err
+			}
+			b = b[l:]
+			if uint64(len(b)) < length {
+				return nil, io.ErrUnexpectedEOF
+			}
+			frames = append(frames, &clienthellod.CRYPTO{Offset: offset, Length: length, Data: b[:length]})
+			b = b[length:]
+		default:
+			return nil, fmt.Errorf("unexpected frame type %#x", b[0])
+		}
+	}
+	return frames, nil
+}
+
+// frameBuilderFromCapture returns a QUICFrameBuilder reproducing the frames of an
+// Initial packet carrying a ClientHello, from the given offset of the ClientHello.
+// Adjacent PADDING frames can't be told apart on the wire, so each run of padding
+// is counted as a single PADDING frame.
+func frameBuilderFromCapture(frames []clienthellod.Frame, payloadLen, offset, clientHelloLen int) (QUICFrameBuilder, error) {
+	var (
+		numPING, numCRYPTO, numPADDING int
+		inOrder                        = true // CRYPTO frames in order, followed by PADDING frames
+		nextOffset                     = uint64(offset)
+	)
+	for _, frame := range frames {
+		switch frame := frame.(type) {
+		case *clienthellod.PING:
+			numPING++
+			inOrder = false
+		case *clienthellod.CRYPTO:
+			numCRYPTO++
+			if numPADDING > 0 || frame.Offset != nextOffset {
+				inOrder = false
+			}
+			nextOffset = frame.Offset + frame.Length
+		case *clienthellod.PADDING:
+			numPADDING++
+		}
+	}
+
+	if numCRYPTO == 1 && numPING == 0 && numPADDING == 0 {
+		return QUICFrames{}, nil // a single CRYPTO frame, as packed by default
+	}
+	// Only a QUICRandomFrames limits the amount of crypto data packed into the first
+	// Initial packet, so it is needed if the ClientHello is split across packets.
+	if inOrder && nextOffset == uint64(clientHelloLen) {
+		var (
+			qfs       QUICFrames
+			numCrypto int
+		)
+		for _, frame := range frames {
+			switch frame := frame.(type) {
+			case *clienthellod.CRYPTO:
+				numCrypto++
+				length := int(frame.Length)
+				if numCrypto == numCRYPTO {
+					length = 0 // the last CRYPTO frame carries the remaining data
+				}
+				qfs = append(qfs, QUICFrameCrypto{Offset: int(frame.Offset) - offset, Length: length})
+			case *clienthellod.PADDING:
+				qfs = append(qfs, QUICFramePadding{Length: int(frame.Length)})
+			}
+		}
+		return qfs, nil
+	}
+
+	if max(numPING, numCRYPTO, numPADDING) >= 0xff || payloadLen > 0xffff {
+		return nil, errors.New("too many frames in an Initial packet")
+	}
+	qrf := &QUICRandomFrames{
+		MinPING:    uint8(numPING),
+		MaxPING:    uint8(numPING) + 1,
+		MinCRYPTO:  uint8(numCRYPTO),
+		MaxCRYPTO:  uint8(numCRYPTO) + 1,
+		MinPADDING: uint8(max(numPADDING, 1)),
+		MaxPADDING: uint8(max(numPADDING, 1)) + 1,
+		Length:     uint16(payloadLen),
+	}
+	return qrf, nil
+}
+
+// clientHelloSpecFromCapture builds a ClientHelloSpec from a ClientHello handshake message.
+func clientHelloSpecFromCapture(clientHello []byte) (*tls.ClientHelloSpec, error) {
+	record := make([]byte, 0, 5+len(clientHello))
+	record = append(record, 0x16, 0x03, 0x01, byte(len(clientHello)>>8), byte(len(clientHello)))
+	record = append(record, clientHello...)
+	f := &tls.Fingerprinter{AllowBluntMimicry: true, RealPSKResumption: true}
+	chs, err := f.RawClientHello(record)
+	if err != nil {
+		return nil, fmt.Errorf("parsing ClientHello: %w", err)
+	}
+
+	exts := make([]tls.TLSExtension, 0, len(chs.Extensions))
+	for _, ext := range chs.Extensions {
+		switch e := ext.(type) {
+		case *tls.GenericExtension:
+			switch e.Id {
+			case 57: // quic_transport_parameters, not parsed by uTLS
+				tps, err := transportParametersFromCapture(e.Data)
+				if err != nil {
+					return nil, err
+				}
+				ext = &tls.QUICTransportParametersExtension{TransportParameters: tps}
+			case 42: // early_data, only sent when resuming a session that allows 0-RTT
+				ext = newEarlyDataExtension()
+			}
+		case *tls.UtlsGREASEExtension:
+			// uTLS picks the value, and the body of the second GREASE extension
+			ext = &tls.UtlsGREASEExtension{}
+		case *tls.UtlsPaddingExtension:
+			e.GetPaddingLen = tls.BoringPaddingStyle
+		case *tls.GREASEEncryptedClientHelloExtension:
+			// drop the encapsulated key and the state picked while parsing
+			ext = &tls.GREASEEncryptedClientHelloExtension{
+				CandidateCipherSuites: e.CandidateCipherSuites,
+				CandidatePayloadLens:  e.CandidatePayloadLens,
+			}
+		case *tls.SupportedVersionsExtension:
+			for _, v := range e.Versions {
+				if v == tls.GREASE_PLACEHOLDER {
+					continue
+				}
+				if chs.TLSVersMin == 0 || v < chs.TLSVersMin {
+					chs.TLSVersMin = v
+				}
+				if v > chs.TLSVersMax {
+					chs.TLSVersMax = v
+				}
+			}
+		}
+		exts = append(exts, ext)
+	}
+	chs.Extensions = exts
+	return chs, nil
+}
+
+// transportParametersFromCapture parses the transport parameters sent by a client.
+func transportParametersFromCapture(b []byte) (tls.TransportParameters, error) {
+	var tps tls.TransportParameters
+	for len(b) > 0 {
+		id, l, err := quicvarint.Parse(b)
+		if err != nil {
+			return nil, fmt.Errorf("parsing transport parameter ID: %w", err)
+		}
+		b = b[l:]
+		length, l, err := quicvarint.Parse(b)
+		if err != nil {
+			return nil, fmt.Errorf("parsing length of transport parameter %#x: %w", id, err)
+		}
+		b = b[l:]
+		if uint64(len(b)) < length {
+			return nil, fmt.Errorf("transport parameter %#x too long", id)
+		}
+		val := b[:length]
+		b = b[length:]
+
+		tp, err := transportParameterFromCapture(id, val)
+		if err != nil {
+			return nil, err
+		}
+		tps = append(tps, tp)
+	}
+	return tps, nil
+}
+
+func transportParameterFromCapture(id uint64, val []byte) (tls.TransportParameter, error) {
+	if (tls.GREASETransportParameter{}).IsGREASEID(id) {
+		// the ID and the value are randomized for each connection
+		return &tls.GREASETransportParameter{Length: uint16(len(val))}, nil
+	}
+	integer := func() (uint64, error) {
+		v, l, err := quicvarint.Parse(val)
+		if err != nil || l != len(val) {
+			return 0, fmt.Errorf("invalid value for transport parameter %#x", id)
+		}
+		return v, nil
+	}
+	switch id {
+	case 0x01, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0b, 0x0e, 0x20:
+		v, err := integer()
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case 0x01:
+			return tls.MaxIdleTimeout(v), nil
+		case 0x03:
+			return tls.MaxUDPPayloadSize(v), nil
+		case 0x04:
+			return tls.InitialMaxData(v), nil
+		case 0x05:
+			return tls.InitialMaxStreamDataBidiLocal(v), nil
+		case 0x06:
+			return tls.InitialMaxStreamDataBidiRemote(v), nil
+		case 0x07:
+			return tls.InitialMaxStreamDataUni(v), nil
+		case 0x08:
+			return tls.InitialMaxStreamsBidi(v), nil
+		case 0x09:
+			return tls.InitialMaxStreamsUni(v), nil
+		case 0x0b:
+			return tls.MaxAckDelay(v), nil
+		case 0x0e:
+			return tls.ActiveConnectionIDLimit(v), nil
+		default:
+			return tls.MaxDatagramFrameSize(v), nil
+		}
+	case 0x0c:
+		return &tls.DisableActiveMigration{}, nil
+	case 0x0f:
+		return tls.InitialSourceConnectionID([]byte{}), nil // set to the Source Connection ID of each connection
+	case 0x15:
+		return tls.PaddingTransportParameter(slices.Clone(val)), nil
+	case 0x2ab2:
+		return &tls.GREASEQUICBit{}, nil
+	case 0x11, 0xff73db: // version_information, and its legacy ID
+		if len(val) < 4 || len(val)%4 != 0 {
+			return nil, errors.New("invalid version_information transport parameter")
+		}
+		vi := &tls.VersionInformation{LegacyID: id == 0xff73db}
+		vi.ChoosenVersion = captureQUICVersion(binary.BigEndian.Uint32(val))
+		for i := 4; i < len(val); i += 4 {
+			vi.AvailableVersions = append(vi.AvailableVersions, captureQUICVersion(binary.BigEndian.Uint32(val[i:])))
+		}
+		return vi, nil
+	case 0x00:
+		return nil, errors.New("unexpected transport parameter original_destination_connection_id")
+	default:
+		return &tls.FakeQUICTransportParameter{Id: id, Val: slices.Clone(val)}, nil
+	}
+}
+
+// captureQUICVersion replaces GREASE versions by the placeholder.
+// GREASE versions are 0x?a?a?a?a, but uTLS only sets the 0x0a bits of each byte,
+// so a looser check is needed to recognize the versions it generates.
+func captureQUICVersion(v uint32) uint32 {
+	if v&0x0a0a0a0a == 0x0a0a0a0a {
+		return tls.VERSION_GREASE
+	}
+	return v
+}
@@ -0,0 +1,226 @@
+package quic
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/testdata"
+	tls "github.com/Noooste/utls"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/require"
+)
+
+// captureInitialPackets dials the server using the QUICSpec and returns the
+// datagrams sent by the client.
+func captureInitialPackets(t *testing.T, ln *Listener, spec *QUICSpec) [][]byte {
+	t.Helper()
+	recorder := newInitialRecorder(t, ln.Addr())
+	tr := newUTransportWithSpecForTest(t, spec)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, err := tr.Dial(
+		ctx,
+		recorder.conn.LocalAddr(),
+		&tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA(), NextProtos: []string{"h3"}},
+		&Config{},
+	)
+	require.NoError(t, err)
+	conn.CloseWithError(0, "")
+	return recorder.clientDatagrams()
+}
+
+// comparableClientHello returns the serialized ClientHelloSpec, without the parts
+// which are not sent on the wire or are picked randomly for each connection.
+func comparableClientHello(t *testing.T, chs *tls.ClientHelloSpec) string {
+	t.Helper()
+	spec := QUICSpec{ClientHelloSpec: &tls.ClientHelloSpec{
+		TLSVersMin:         chs.TLSVersMin,
+		TLSVersMax:         chs.TLSVersMax,
+		CipherSuites:       chs.CipherSuites,
+		CompressionMethods: chs.CompressionMethods,
+	}}
+	for _, ext := range chs.Extensions {
+		switch ext.(type) {
+		case *tls.UtlsPreSharedKeyExtension, *earlyDataExtension: // only sent when resuming
+			continue
+		case *tls.GREASEEncryptedClientHelloExtension: // the cipher suite and payload length are picked randomly
+			ext = &tls.GREASEEncryptedClientHelloExtension{}
+		}
+		spec.ClientHelloSpec.Extensions = append(spec.ClientHelloSpec.Extensions, ext)
+	}
+	data, err := MarshalQUICSpec(&spec)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestQUICSpecFromInitialPackets(t *testing.T) {
+	ln := newUTransportTestServer(t)
+
+	for _, id := range testQUICIDs {
+		t.Run(id.Client+"_"+id.Version, func(t *testing.T) {
+			spec, err := testQUICID2Spec(id)
+			require.NoError(t, err)
+			datagrams := captureInitialPackets(t, ln, &spec)
+			captured, err := QUICSpecFromInitialPackets(datagrams...)
+			require.NoError(t, err)
+
+			expected := spec.InitialPacketSpec
+			actual := captured.InitialPacketSpec
+			require.Equal(t, expected.SrcConnIDLength, actual.SrcConnIDLength)
+			require.Equal(t, expected.DestConnIDLength, actual.DestConnIDLength)
+			require.Equal(t, expected.InitPacketNumberLength, actual.InitPacketNumberLength)
+			require.Equal(t, expected.InitPacketNumber, actual.InitPacketNumber)
+			require.Equal(t, expected.ClientTokenLength, actual.ClientTokenLength)
+			switch fb := expected.FrameBuilder.(type) {
+			case *QUICRandomFrames:
+				// the frames may happen to be in order, resulting in a QUICFrames
+				if qrf, ok := actual.FrameBuilder.(*QUICRandomFrames); ok {
+					require.Equal(t, fb.Length, qrf.Length)
+				} else {
+					require.IsType(t, QUICFrames{}, actual.FrameBuilder)
+				}
+			default:
+				require.Equal(t, QUICFrames{}, actual.FrameBuilder)
+			}
+			require.Equal(t, len(datagrams[0]), captured.UDPDatagramMinSize)
+			require.GreaterOrEqual(t, captured.UDPDatagramMinSize, spec.UDPDatagramMinSize)
+			require.Equal(t, comparableClientHello(t, spec.ClientHelloSpec), comparableClientHello(t, captured.ClientHelloSpec))
+
+			// the captured spec produces the same Initial packets,
+			// apart from the frame layout which may be picked randomly
+			recaptured, err := QUICSpecFromInitialPackets(captureInitialPackets(t, ln, &captured)...)
+			require.NoError(t, err)
+			recaptured.InitialPacketSpec.FrameBuilder = captured.InitialPacketSpec.FrameBuilder
+			require.Equal(t, captured.InitialPacketSpec, recaptured.InitialPacketSpec)
+			require.Equal(t, captured.UDPDatagramMinSize, recaptured.UDPDatagramMinSize)
+			require.Equal(t, comparableClientHello(t, captured.ClientHelloSpec), comparableClientHello(t, recaptured.ClientHelloSpec))
+		})
+	}
+}
+
+func TestQUICSpecFromInitialPacketsIncomplete(t *testing.T) {
+	ln := newUTransportTestServer(t)
+	spec, err := testQUICID2Spec(testQUICIDPQ)
+	require.NoError(t, err)
+	datagrams := captureInitialPackets(t, ln, &spec)
+
+	// the ClientHello spans 2 Initial packets
+	_, err = QUICSpecFromInitialPackets(datagrams[0])
+	require.ErrorContains(t, err, "incomplete ClientHello")
+	_, err = QUICSpecFromInitialPackets(datagrams[1:2]...)
+	require.ErrorContains(t, err, "no Initial packet carrying the beginning of the ClientHello")
+
+	// the order doesn't matter
+	_, err = QUICSpecFromInitialPackets(datagrams[1], datagrams[0])
+	require.NoError(t, err)
+}
+
+func TestQUICSpecFromInitialPacketsClientHelloPackets(t *testing.T) {
+	ln := newUTransportTestServer(t)
+
+	// the second packet is padded with PADDING frames
+	spec, err := testQUICID2Spec(testQUICIDPQ)
+	require.NoError(t, err)
+	captured, err := QUICSpecFromInitialPackets(captureInitialPackets(t, ln, &spec)...)
+	require.NoError(t, err)
+	require.Equal(t,
+		[]ClientHelloPacketSpec{{UDPDatagramMinSize: 1250, PaddingFrames: true}},
+		captured.InitialPacketSpec.ClientHelloPackets,
+	)
+
+	// the datagrams are padded with zeros
+	spec, err = testQUICID2Spec(testQUICIDPQConnID3)
+	require.NoError(t, err)
+	captured, err = QUICSpecFromInitialPackets(captureInitialPackets(t, ln, &spec)...)
+	require.NoError(t, err)
+	require.Equal(t,
+		[]ClientHelloPacketSpec{{UDPDatagramMinSize: 1357}},
+		captured.InitialPacketSpec.ClientHelloPackets,
+	)
+}
+
+func TestQUICSpecFromPcap(t *testing.T) {
+	ln := newUTransportTestServer(t)
+	spec, err := testQUICID2Spec(testQUICIDPQConnID3)
+	require.NoError(t, err)
+	datagrams := captureInitialPackets(t, ln, &spec)
+	expected, err := QUICSpecFromInitialPackets(datagrams...)
+	require.NoError(t, err)
+
+	clientAddr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 54321}
+	serverAddr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 443}
+	writeDatagram := func(w *pcapgo.Writer, src, dst *net.UDPAddr, payload []byte) {
+		ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: src.IP, DstIP: dst.IP}
+		udp := &layers.UDP{SrcPort: layers.UDPPort(src.Port), DstPort: layers.UDPPort(dst.Port)}
+		require.NoError(t, udp.SetNetworkLayerForChecksum(ip))
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		require.NoError(t, gopacket.SerializeLayers(buf, opts, ip, udp, gopacket.Payload(payload)))
+		require.NoError(t, w.WritePacket(gopacket.CaptureInfo{
+			Timestamp:     time.Now(),
+			CaptureLength: len(buf.Bytes()),
+			Length:        len(buf.Bytes()),
+		}, buf.Bytes()))
+	}
+
+	var b bytes.Buffer
+	w := pcapgo.NewWriter(&b)
+	require.NoError(t, w.WriteFileHeader(65535, layers.LinkTypeRaw))
+	writeDatagram(w, serverAddr, clientAddr, []byte("unrelated"))
+	for _, d := range datagrams {
+		writeDatagram(w, clientAddr, serverAddr, d)
+		writeDatagram(w, serverAddr, clientAddr, []byte("response"))
+	}
+
+	captured, err := QUICSpecFromPcap(bytes.NewReader(b.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, expected.InitialPacketSpec, captured.InitialPacketSpec)
+	require.Equal(t, expected.UDPDatagramMinSize, captured.UDPDatagramMinSize)
+	require.Equal(t, comparableClientHello(t, expected.ClientHelloSpec), comparableClientHello(t, captured.ClientHelloSpec))
+
+	_, err = QUICSpecFromPcap(bytes.NewReader(b.Bytes()[:24])) // only the file header
+	require.ErrorContains(t, err, "no QUIC Initial packet found")
+}
+
+// The captures are Initial packets sent by browsers, see testdata/README.md.
+func TestQUICSpecFromPcapFile(t *testing.T) {
+	for _, tc := range []struct {
+		name, file, datagram string
+		srcConnIDLength      int
+		destConnIDLength     int
+		packetNumber         uint64
+		clientTokenLength    int
+		datagramSize         int
+	}{
+		{"Chrome 115, IPv6, pcap", "chrome_115_ipv6.pcap", "chrome_115_ipv6.bin", 0, 8, 1, 0, 1230},
+		{"Firefox 116, resumed, pcapng", "firefox_116_resumed.pcapng", "firefox_116_resumed.bin", 3, 13, 0, 86, 1357},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			datagram, err := os.ReadFile(filepath.Join("testdata", "captures", tc.datagram))
+			require.NoError(t, err)
+			expected, err := QUICSpecFromInitialPackets(datagram)
+			require.NoError(t, err)
+
+			captured, err := QUICSpecFromPcapFile(filepath.Join("testdata", "captures", tc.file))
+			require.NoError(t, err)
+			require.Equal(t, tc.srcConnIDLength, captured.InitialPacketSpec.SrcConnIDLength)
+			require.Equal(t, tc.destConnIDLength, captured.InitialPacketSpec.DestConnIDLength)
+			require.Equal(t, tc.packetNumber, captured.InitialPacketSpec.InitPacketNumber)
+			require.Equal(t, tc.clientTokenLength, captured.InitialPacketSpec.ClientTokenLength)
+			require.Equal(t, tc.datagramSize, captured.UDPDatagramMinSize)
+			require.Equal(t, expected.InitialPacketSpec, captured.InitialPacketSpec)
+			require.Equal(t, comparableClientHello(t, expected.ClientHelloSpec), comparableClientHello(t, captured.ClientHelloSpec))
+		})
+	}
+
+	_, err := QUICSpecFromPcapFile(filepath.Join("testdata", "captures", "missing.pcap"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,1218 @@
+package quic
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/protocol"
+	tls "github.com/Noooste/utls"
+	"github.com/Noooste/utls/dicttls"
+	"gopkg.in/yaml.v3"
+)
+
+// [UQUIC]
+// A QUICSpec can be stored as a JSON or YAML document, which allows shipping
+// fingerprints as data instead of Go code. Codepoints (cipher suites, groups,
+// signature schemes, extensions, transport parameters, ...) are written by their
+// IANA name when known, or as a hexadecimal string (e.g. "0x1a2a") otherwise.
+// GREASE values are written as "GREASE". Byte strings are hex encoded.
+// Durations are written in milliseconds.
+//
+// Extensions and transport parameters are identified by their name. An entry
+// with an explicit id is loaded as a tls.GenericExtension or a
+// tls.FakeQUICTransportParameter respectively.
+//
+// The TokenStore of the InitialPacketSpec is not part of the document, and
+// neither are custom QUICFrameBuilder, QUICFrame, tls.TLSExtension or
+// tls.TransportParameter implementations.
+
+// LoadQUICSpec parses a QUICSpec from its JSON or YAML representation.
+func LoadQUICSpec(data []byte) (QUICSpec, error) {
+	var f quicSpecFile
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&f); err != nil {
+			return QUICSpec{}, fmt.Errorf("quic spec: %w", err)
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&f); err != nil {
+			return QUICSpec{}, fmt.Errorf("quic spec: %w", err)
+		}
+	}
+	spec, err := f.toSpec()
+	if err != nil {
+		return QUICSpec{}, fmt.Errorf("quic spec: %w", err)
+	}
+	return spec, nil
+}
+
+// LoadQUICSpecFile reads the file and parses it with LoadQUICSpec.
+func LoadQUICSpecFile(name string) (QUICSpec, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return QUICSpec{}, err
+	}
+	return LoadQUICSpec(data)
+}
+
+// MarshalQUICSpec returns the JSON representation of the QUICSpec.
+func MarshalQUICSpec(spec *QUICSpec) ([]byte, error) {
+	f, err := newQUICSpecFile(spec)
+	if err != nil {
+		return nil, fmt.Errorf("quic spec: %w", err)
+	}
+	return json.MarshalIndent(f, "", "  ")
+}
+
+// MarshalQUICSpecYAML returns the YAML representation of the QUICSpec.
+func MarshalQUICSpecYAML(spec *QUICSpec) ([]byte, error) {
+	f, err := newQUICSpecFile(spec)
+	if err != nil {
+		return nil, fmt.Errorf("quic spec: %w", err)
+	}
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(f); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+type quicSpecFile struct {
+	InitialPacket      initialPacketFile  `json:"initial_packet" yaml:"initial_packet"`
+	ClientHello        *clientHelloFile   `json:"client_hello,omitempty" yaml:"client_hello,omitempty"`
+	UDPDatagramMinSize int                `json:"udp_datagram_min_size,omitempty" yaml:"udp_datagram_min_size,omitempty"`
+	PostHandshake      *postHandshakeFile `json:"post_handshake,omitempty" yaml:"post_handshake,omitempty"`
+	Version            *versionFile       `json:"version,omitempty" yaml:"version,omitempty"`
+	IPv4               *addrFamilyFile    `json:"ipv4,omitempty" yaml:"ipv4,omitempty"`
+	IPv6               *addrFamilyFile    `json:"ipv6,omitempty" yaml:"ipv6,omitempty"`
+}
+
+type addrFamilyFile struct {
+	UDPDatagramMinSize int    `json:"udp_datagram_min_size,omitempty" yaml:"udp_datagram_min_size,omitempty"`
+	RandomFramesLength uint16 `json:"random_frames_length,omitempty" yaml:"random_frames_length,omitempty"`
+}
+
+func newAddrFamilyFile(afs *AddrFamilySpec) *addrFamilyFile {
+	if afs == nil {
+		return nil
+	}
+	return &addrFamilyFile{UDPDatagramMinSize: afs.UDPDatagramMinSize, RandomFramesLength: afs.RandomFramesLength}
+}
+
+func (f *addrFamilyFile) toAddrFamilySpec() *AddrFamilySpec {
+	if f == nil {
+		return nil
+	}
+	return &AddrFamilySpec{UDPDatagramMinSize: f.UDPDatagramMinSize, RandomFramesLength: f.RandomFramesLength}
+}
+
+type postHandshakeFile struct {
+	AckElicitingThreshold       int   `json:"ack_eliciting_threshold,omitempty" yaml:"ack_eliciting_threshold,omitempty"`
+	MaxAckDelay                 int64 `json:"max_ack_delay,omitempty" yaml:"max_ack_delay,omitempty"`
+	NewConnectionIDs            int   `json:"new_connection_ids,omitempty" yaml:"new_connection_ids,omitempty"`
+	KeepAlivePeriod             int64 `json:"keep_alive_period,omitempty" yaml:"keep_alive_period,omitempty"`
+	DisablePacketNumberSkipping bool  `json:"disable_packet_number_skipping,omitempty" yaml:"disable_packet_number_skipping,omitempty"`
+}
+
+type versionFile struct {
+	InitialVersion string   `json:"initial_version,omitempty" yaml:"initial_version,omitempty"`
+	Versions       []string `json:"versions,omitempty" yaml:"versions,omitempty"`
+}
+
+func newVersionFile(vs *VersionSpec) *versionFile {
+	if vs.InitialVersion == 0 && len(vs.Versions) == 0 {
+		return nil
+	}
+	f := &versionFile{}
+	if vs.InitialVersion != 0 {
+		f.InitialVersion = codepointName(uint32(vs.InitialVersion), quicVersionNames)
+	}
+	for _, v := range vs.Versions {
+		f.Versions = append(f.Versions, codepointName(uint32(v), quicVersionNames))
+	}
+	return f
+}
+
+func (f *versionFile) toVersionSpec() (VersionSpec, error) {
+	var vs VersionSpec
+	if f == nil {
+		return vs, nil
+	}
+	if f.InitialVersion != "" {
+		v, err := codepointValue(f.InitialVersion, quicVersionNames)
+		if err != nil {
+			return VersionSpec{}, err
+		}
+		vs.InitialVersion = Version(v)
+	}
+	versions, err := quicVersionValueList(f.Versions)
+	if err != nil {
+		return VersionSpec{}, err
+	}
+	for _, v := range versions {
+		vs.Versions = append(vs.Versions, Version(v))
+	}
+	return vs, nil
+}
+
+type initialPacketFile struct {
+	SrcConnIDLength    int         `json:"src_conn_id_length" yaml:"src_conn_id_length"`
+	DestConnIDLength   int         `json:"dest_conn_id_length" yaml:"dest_conn_id_length"`
+	PacketNumberLength int         `json:"packet_number_length,omitempty" yaml:"packet_number_length,omitempty"`
+	PacketNumber       uint64      `json:"packet_number,omitempty" yaml:"packet_number,omitempty"`
+	ClientTokenLength  int         `json:"client_token_length,omitempty" yaml:"client_token_length,omitempty"`
+	Frames             *framesFile `json:"frames,omitempty" yaml:"frames,omitempty"`
+
+	ClientHelloPackets []clientHelloPacketFile `json:"client_hello_packets,omitempty" yaml:"client_hello_packets,omitempty"`
+}
+
+type clientHelloPacketFile struct {
+	Frames             *framesFile `json:"frames,omitempty" yaml:"frames,omitempty"`
+	UDPDatagramMinSize int         `json:"udp_datagram_min_size,omitempty" yaml:"udp_datagram_min_size,omitempty"`
+	PaddingFrames      bool        `json:"padding_frames,omitempty" yaml:"padding_frames,omitempty"`
+	Coalesce           bool        `json:"coalesce,omitempty" yaml:"coalesce,omitempty"`
+}
+
+const (
+	framesTypeFixed     = "fixed"     // QUICFrames
+	framesTypeRandom    = "random"    // QUICRandomFrames
+	framesTypeScrambled = "scrambled" // QUICScrambledFrames
+)
+
+type framesFile struct {
+	Type string `json:"type" yaml:"type"`
+
+	// QUICFrames
+	Frames []frameFile `json:"frames,omitempty" yaml:"frames,omitempty"`
+
+	// QUICRandomFrames
+	MinPING    uint8  `json:"min_ping,omitempty" yaml:"min_ping,omitempty"`
+	MaxPING    uint8  `json:"max_ping,omitempty" yaml:"max_ping,omitempty"`
+	MinCRYPTO  uint8  `json:"min_crypto,omitempty" yaml:"min_crypto,omitempty"`
+	MaxCRYPTO  uint8  `json:"max_crypto,omitempty" yaml:"max_crypto,omitempty"`
+	MinPADDING uint8  `json:"min_padding,omitempty" yaml:"min_padding,omitempty"`
+	MaxPADDING uint8  `json:"max_padding,omitempty" yaml:"max_padding,omitempty"`
+	Length     uint16 `json:"length,omitempty" yaml:"length,omitempty"`
+
+	// QUICScrambledFrames
+	SplitSNI bool        `json:"split_sni,omitempty" yaml:"split_sni,omitempty"`
+	SplitECH bool        `json:"split_ech,omitempty" yaml:"split_ech,omitempty"`
+	Offsets  []int       `json:"offsets,omitempty" yaml:"offsets,omitempty"`
+	Order    string      `json:"order,omitempty" yaml:"order,omitempty"`
+	Layout   *framesFile `json:"layout,omitempty" yaml:"layout,omitempty"`
+}
+
+const (
+	frameTypeCrypto  = "crypto"
+	frameTypePadding = "padding"
+	frameTypePing    = "ping"
+)
+
+type frameFile struct {
+	Type   string `json:"type" yaml:"type"`
+	Offset int    `json:"offset,omitempty" yaml:"offset,omitempty"`
+	Length int    `json:"length,omitempty" yaml:"length,omitempty"`
+}
+
+type clientHelloFile struct {
+	TLSVersMin         string          `json:"tls_vers_min,omitempty" yaml:"tls_vers_min,omitempty"`
+	TLSVersMax         string          `json:"tls_vers_max,omitempty" yaml:"tls_vers_max,omitempty"`
+	CipherSuites       []string        `json:"cipher_suites,omitempty" yaml:"cipher_suites,omitempty"`
+	CompressionMethods []string        `json:"compression_methods,omitempty" yaml:"compression_methods,omitempty"`
+	Extensions         []extensionFile `json:"extensions,omitempty" yaml:"extensions,omitempty"`
+
+	// ShuffleExtensions shuffles the extensions the way Chrome does when the
+	// spec is loaded, see tls.ShuffleChromeTLSExtensions.
+	ShuffleExtensions bool `json:"shuffle_extensions,omitempty" yaml:"shuffle_extensions,omitempty"`
+}
+
+type extensionFile struct {
+	Name string  `json:"name" yaml:"name"`
+	ID   *uint16 `json:"id,omitempty" yaml:"id,omitempty"`
+	Data string  `json:"data,omitempty" yaml:"data,omitempty"`
+
+	ServerName          string         `json:"server_name,omitempty" yaml:"server_name,omitempty"`
+	Groups              []string       `json:"groups,omitempty" yaml:"groups,omitempty"`
+	PointFormats        []string       `json:"point_formats,omitempty" yaml:"point_formats,omitempty"`
+	SignatureAlgorithms []string       `json:"signature_algorithms,omitempty" yaml:"signature_algorithms,omitempty"`
+	Protocols           []string       `json:"protocols,omitempty" yaml:"protocols,omitempty"`
+	Versions            []string       `json:"versions,omitempty" yaml:"versions,omitempty"`
+	Modes               []string       `json:"modes,omitempty" yaml:"modes,omitempty"`
+	Algorithms          []string       `json:"algorithms,omitempty" yaml:"algorithms,omitempty"`
+	KeyShares           []keyShareFile `json:"key_shares,omitempty" yaml:"key_shares,omitempty"`
+	Limit               uint16         `json:"limit,omitempty" yaml:"limit,omitempty"`
+	Renegotiation       string         `json:"renegotiation,omitempty" yaml:"renegotiation,omitempty"`
+
+	// padding
+	PaddingStyle  string `json:"padding_style,omitempty" yaml:"padding_style,omitempty"`
+	PaddingLength int    `json:"padding_length,omitempty" yaml:"padding_length,omitempty"`
+	WillPad       bool   `json:"will_pad,omitempty" yaml:"will_pad,omitempty"`
+
+	// encrypted_client_hello (GREASE)
+	HPKECipherSuites []hpkeCipherSuiteFile `json:"hpke_cipher_suites,omitempty" yaml:"hpke_cipher_suites,omitempty"`
+	ConfigIDs        []int                 `json:"config_ids,omitempty" yaml:"config_ids,omitempty"`
+	PayloadLengths   []uint16              `json:"payload_lengths,omitempty" yaml:"payload_lengths,omitempty"`
+
+	// quic_transport_parameters
+	TransportParameters        []transportParameterFile `json:"transport_parameters,omitempty" yaml:"transport_parameters,omitempty"`
+	ShuffleTransportParameters bool                     `json:"shuffle_transport_parameters,omitempty" yaml:"shuffle_transport_parameters,omitempty"`
+}
+
+type keyShareFile struct {
+	Group string `json:"group" yaml:"group"`
+	Data  string `json:"data,omitempty" yaml:"data,omitempty"`
+}
+
+type hpkeCipherSuiteFile struct {
+	KDF  string `json:"kdf" yaml:"kdf"`
+	AEAD string `json:"aead" yaml:"aead"`
+}
+
+type transportParameterFile struct {
+	Name  string  `json:"name" yaml:"name"`
+	ID    *uint64 `json:"id,omitempty" yaml:"id,omitempty"`
+	Value *uint64 `json:"value,omitempty" yaml:"value,omitempty"`
+	Data  string  `json:"data,omitempty" yaml:"data,omitempty"`
+
+	// GREASE
+	Length uint16 `json:"length,omitempty" yaml:"length,omitempty"`
+
+	// version_information
+	ChosenVersion     string   `json:"chosen_version,omitempty" yaml:"chosen_version,omitempty"`
+	AvailableVersions []string `json:"available_versions,omitempty" yaml:"available_versions,omitempty"`
+	LegacyID          bool     `json:"legacy_id,omitempty" yaml:"legacy_id,omitempty"`
+}
+
+const nameGREASE = "GREASE"
+
+const (
+	extNameServerName              = "server_name"
+	extNameStatusRequest           = "status_request"
+	extNameSupportedGroups         = "supported_groups"
+	extNameECPointFormats          = "ec_point_formats"
+	extNameSignatureAlgorithms     = "signature_algorithms"
+	extNameSignatureAlgorithmsCert = "signature_algorithms_cert"
+	extNameALPN                    = "application_layer_protocol_negotiation"
+	extNameSCT                     = "signed_certificate_timestamp"
+	extNamePadding                 = "padding"
+	extNameExtendedMasterSecret    = "extended_master_secret"
+	extNameCompressCertificate     = "compress_certificate"
+	extNameRecordSizeLimit         = "record_size_limit"
+	extNameDelegatedCredentials    = "delegated_credentials"
+	extNameSessionTicket           = "session_ticket"
+	extNamePreSharedKey            = "pre_shared_key"
+	extNameEarlyData               = "early_data"
+	extNameSupportedVersions       = "supported_versions"
+	extNamePSKKeyExchangeModes     = "psk_key_exchange_modes"
+	extNameKeyShare                = "key_share"
+	extNameQUICTransportParameters = "quic_transport_parameters"
+	extNameRenegotiationInfo       = "renegotiation_info"
+	extNameApplicationSettings     = "application_settings"
+	extNameApplicationSettingsNew  = "application_settings_new"
+	extNameEncryptedClientHello    = "encrypted_client_hello"
+)
+
+// paddingStyleBoring stands for tls.BoringPaddingStyle
+const paddingStyleBoring = "boring"
+
+const (
+	tpNameMaxIdleTimeout            = "max_idle_timeout"
+	tpNameMaxUDPPayloadSize         = "max_udp_payload_size"
+	tpNameInitialMaxData            = "initial_max_data"
+	tpNameInitialMaxStreamDataBidiL = "initial_max_stream_data_bidi_local"
+	tpNameInitialMaxStreamDataBidiR = "initial_max_stream_data_bidi_remote"
+	tpNameInitialMaxStreamDataUni   = "initial_max_stream_data_uni"
+	tpNameInitialMaxStreamsBidi     = "initial_max_streams_bidi"
+	tpNameInitialMaxStreamsUni      = "initial_max_streams_uni"
+	tpNameMaxAckDelay               = "max_ack_delay"
+	tpNameDisableActiveMigration    = "disable_active_migration"
+	tpNameActiveConnectionIDLimit   = "active_connection_id_limit"
+	tpNameInitialSourceConnectionID = "initial_source_connection_id"
+	tpNameVersionInformation        = "version_information"
+	tpNamePadding                   = "padding"
+	tpNameMaxDatagramFrameSize      = "max_datagram_frame_size"
+	tpNameGREASEQUICBit             = "grease_quic_bit"
+)
+
+var (
+	// names of the groups missing from dicttls
+	extraGroupNames = map[uint16]string{
+		0x11ec: "X25519MLKEM768",
+		0x6399: "X25519Kyber768Draft00",
+	}
+	// names of the extensions missing from dicttls
+	extraExtensionNames = map[uint16]string{
+		0xfe0d: extNameEncryptedClientHello,
+	}
+	tlsVersionNames = map[uint16]string{
+		tls.VersionTLS10: "TLS 1.0",
+		tls.VersionTLS11: "TLS 1.1",
+		tls.VersionTLS12: "TLS 1.2",
+		tls.VersionTLS13: "TLS 1.3",
+	}
+	quicVersionNames = map[uint32]string{
+		uint32(protocol.Version1): "v1",
+		uint32(protocol.Version2): "v2",
+		tls.VERSION_GREASE:        nameGREASE,
+	}
+	renegotiationNames = map[tls.RenegotiationSupport]string{
+		tls.RenegotiateNever:          "never",
+		tls.RenegotiateOnceAsClient:   "once_as_client",
+		tls.RenegotiateFreelyAsClient: "freely_as_client",
+	}
+	cryptoFrameOrderNames = map[CryptoFrameOrder]string{
+		CryptoFrameOrderSequential: "sequential",
+		CryptoFrameOrderReversed:   "reversed",
+		CryptoFrameOrderShuffled:   "shuffled",
+		CryptoFrameOrderEvenOdd:    "even_odd",
+	}
+)
+
+type codepoint interface {
+	~uint8 | ~uint16 | ~uint32 | ~uint64 | ~int
+}
+
+// codepointValue returns the codepoint with the given name, or parses a
+// hexadecimal or decimal number. If several codepoints share the same name, the
+// smallest one is returned.
+func codepointValue[T codepoint](name string, tables ...map[T]string) (T, error) {
+	var (
+		v     T
+		found bool
+	)
+	for _, table := range tables {
+		for value, n := range table {
+			if n == name && (!found || value < v) {
+				v, found = value, true
+			}
+		}
+	}
+	if found {
+		return v, nil
+	}
+	n, err := strconv.ParseUint(name, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unknown codepoint %q", name)
+	}
+	if uint64(T(n)) != n {
+		return 0, fmt.Errorf("codepoint %q out of range", name)
+	}
+	return T(n), nil
+}
+
+// codepointName returns the name of the codepoint, or its hexadecimal
+// representation if it has no (unambiguous) name.
+func codepointName[T codepoint](v T, tables ...map[T]string) string {
+	for _, table := range tables {
+		if name, ok := table[v]; ok {
+			if value, err := codepointValue(name, tables...); err == nil && value == v {
+				return name
+			}
+			break
+		}
+	}
+	return fmt.Sprintf("0x%x", uint64(v))
+}
+
+func isGREASEUint16(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// uint16Name is like codepointName, but handles GREASE values.
+func uint16Name[T ~uint16](v T, tables ...map[uint16]string) string {
+	if isGREASEUint16(uint16(v)) {
+		return nameGREASE
+	}
+	return codepointName(uint16(v), tables...)
+}
+
+// uint16Value is like codepointValue, but handles GREASE values.
+func uint16Value[T ~uint16](name string, tables ...map[uint16]string) (T, error) {
+	if name == nameGREASE {
+		return tls.GREASE_PLACEHOLDER, nil
+	}
+	v, err := codepointValue(name, tables...)
+	return T(v), err
+}
+
+func uint16Names[T ~uint16](values []T, tables ...map[uint16]string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(values))
+	for _, v := range values {
+		names = append(names, uint16Name(v, tables...))
+	}
+	return names
+}
+
+func uint16Values[T ~uint16](names []string, tables ...map[uint16]string) ([]T, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	values := make([]T, 0, len(names))
+	for _, name := range names {
+		v, err := uint16Value[T](name, tables...)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func uint8Names(values []uint8, table map[uint8]string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(values))
+	for _, v := range values {
+		names = append(names, codepointName(v, table))
+	}
+	return names
+}
+
+func uint8Values(names []string, table map[uint8]string) ([]uint8, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	values := make([]uint8, 0, len(names))
+	for _, name := range names {
+		v, err := codepointValue(name, table)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func quicVersionNameList(versions []uint32) []string {
+	names := make([]string, 0, len(versions))
+	for _, v := range versions {
+		names = append(names, codepointName(v, quicVersionNames))
+	}
+	return names
+}
+
+func quicVersionValueList(names []string) ([]uint32, error) {
+	versions := make([]uint32, 0, len(names))
+	for _, name := range names {
+		v, err := codepointValue(name, quicVersionNames)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+func hexString(b []byte) string { return hex.EncodeToString(b) }
+
+func isBoringPaddingStyle(f func(int) (int, bool)) bool {
+	return f != nil && reflect.ValueOf(f).Pointer() == reflect.ValueOf(tls.BoringPaddingStyle).Pointer()
+}
+
+func newQUICSpecFile(spec *QUICSpec) (*quicSpecFile, error) {
+	f := &quicSpecFile{
+		InitialPacket: initialPacketFile{
+			SrcConnIDLength:    spec.InitialPacketSpec.SrcConnIDLength,
+			DestConnIDLength:   spec.InitialPacketSpec.DestConnIDLength,
+			PacketNumberLength: int(spec.InitialPacketSpec.InitPacketNumberLength),
+			PacketNumber:       spec.InitialPacketSpec.InitPacketNumber,
+			ClientTokenLength:  spec.InitialPacketSpec.ClientTokenLength,
+		},
+		UDPDatagramMinSize: spec.UDPDatagramMinSize,
+		IPv4:               newAddrFamilyFile(spec.IPv4),
+		IPv6:               newAddrFamilyFile(spec.IPv6),
+		Version:            newVersionFile(&spec.VersionSpec),
+	}
+	frames, err := newFramesFile(spec.InitialPacketSpec.FrameBuilder)
+	if err != nil {
+		return nil, err
+	}
+	f.InitialPacket.Frames = frames
+	for _, chp := range spec.InitialPacketSpec.ClientHelloPackets {
+		frames, err := newFramesFile(chp.FrameBuilder)
+		if err != nil {
+			return nil, err
+		}
+		f.InitialPacket.ClientHelloPackets = append(f.InitialPacket.ClientHelloPackets, clientHelloPacketFile{
+			Frames:             frames,
+			UDPDatagramMinSize: chp.UDPDatagramMinSize,
+			PaddingFrames:      chp.PaddingFrames,
+			Coalesce:           chp.Coalesce,
+		})
+	}
+	if ps := spec.PostHandshakeSpec; ps != (PostHandshakeSpec{}) {
+		f.PostHandshake = &postHandshakeFile{
+			AckElicitingThreshold:       ps.AckElicitingThreshold,
+			MaxAckDelay:                 ps.MaxAckDelay.Milliseconds(),
+			NewConnectionIDs:            ps.NewConnectionIDs,
+			KeepAlivePeriod:             ps.KeepAlivePeriod.Milliseconds(),
+			DisablePacketNumberSkipping: ps.DisablePacketNumberSkipping,
+		}
+	}
+	if spec.ClientHelloSpec != nil {
+		chf, err := newClientHelloFile(spec.ClientHelloSpec)
+		if err != nil {
+			return nil, err
+		}
+		f.ClientHello = chf
+	}
+	return f, nil
+}
+
+func newFramesFile(fb QUICFrameBuilder) (*framesFile, error) {
+	switch fb := fb.(type) {
+	case nil:
+		return nil, nil
+	case *QUICRandomFrames:
+		return &framesFile{
+			Type:       framesTypeRandom,
+			MinPING:    fb.MinPING,
+			MaxPING:    fb.MaxPING,
+			MinCRYPTO:  fb.MinCRYPTO,
+			MaxCRYPTO:  fb.MaxCRYPTO,
+			MinPADDING: fb.MinPADDING,
+			MaxPADDING: fb.MaxPADDING,
+			Length:     fb.Length,
+		}, nil
+	case QUICFrames:
+		f := &framesFile{Type: framesTypeFixed}
+		for _, frame := range fb {
+			switch frame := frame.(type) {
+			case QUICFrameCrypto:
+				f.Frames = append(f.Frames, frameFile{Type: frameTypeCrypto, Offset: frame.Offset, Length: frame.Length})
+			case QUICFramePadding:
+				f.Frames = append(f.Frames, frameFile{Type: frameTypePadding, Length: frame.Length})
+			case QUICFramePing:
+				f.Frames = append(f.Frames, frameFile{Type: frameTypePing})
+			default:
+				return nil, fmt.Errorf("unsupported QUIC frame %T", frame)
+			}
+		}
+		return f, nil
+	case *QUICScrambledFrames:
+		f := &framesFile{
+			Type:     framesTypeScrambled,
+			SplitSNI: fb.SplitSNI,
+			SplitECH: fb.SplitECH,
+			Offsets:  fb.Offsets,
+			Order:    codepointName(fb.Order, cryptoFrameOrderNames),
+		}
+		if fb.Layout != nil {
+			layout, err := newFramesFile(fb.Layout)
+			if err != nil {
+				return nil, err
+			}
+			f.Layout = layout
+		}
+		return f, nil
+	default:
+		return nil, fmt.Errorf("unsupported QUIC frame builder %T", fb)
+	}
+}
+
+func newClientHelloFile(chs *tls.ClientHelloSpec) (*clientHelloFile, error) {
+	f := &clientHelloFile{
+		CipherSuites:       uint16Names(chs.CipherSuites, dicttls.DictCipherSuiteValueIndexed),
+		CompressionMethods: uint8Names(chs.CompressionMethods, dicttls.DictCompMethValueIndexed),
+	}
+	if chs.TLSVersMin != 0 {
+		f.TLSVersMin = codepointName(chs.TLSVersMin, tlsVersionNames)
+	}
+	if chs.TLSVersMax != 0 {
+		f.TLSVersMax = codepointName(chs.TLSVersMax, tlsVersionNames)
+	}
+	for _, ext := range chs.Extensions {
+		ef, err := newExtensionFile(ext)
+		if err != nil {
+			return nil, err
+		}
+		f.Extensions = append(f.Extensions, *ef)
+	}
+	return f, nil
+}
+
+func newExtensionFile(ext tls.TLSExtension) (*extensionFile, error) {
+	switch ext := ext.(type) {
+	case *tls.SNIExtension:
+		return &extensionFile{Name: extNameServerName, ServerName: ext.ServerName}, nil
+	case *tls.StatusRequestExtension:
+		return &extensionFile{Name: extNameStatusRequest}, nil
+	case *tls.SupportedCurvesExtension:
+		return &extensionFile{
+			Name:   extNameSupportedGroups,
+			Groups: uint16Names(ext.Curves, dicttls.DictSupportedGroupsValueIndexed, extraGroupNames),
+		}, nil
+	case *tls.SupportedPointsExtension:
+		return &extensionFile{
+			Name:         extNameECPointFormats,
+			PointFormats: uint8Names(ext.SupportedPoints, dicttls.DictECPointFormatValueIndexed),
+		}, nil
+	case *tls.SignatureAlgorithmsExtension:
+		return &extensionFile{
+			Name:                extNameSignatureAlgorithms,
+			SignatureAlgorithms: uint16Names(ext.SupportedSignatureAlgorithms, dicttls.DictSignatureSchemeValueIndexed),
+		}, nil
+	case *tls.SignatureAlgorithmsCertExtension:
+		return &extensionFile{
+			Name:                extNameSignatureAlgorithmsCert,
+			SignatureAlgorithms: uint16Names(ext.SupportedSignatureAlgorithms, dicttls.DictSignatureSchemeValueIndexed),
+		}, nil
+	case *tls.ALPNExtension:
+		return &extensionFile{Name: extNameALPN, Protocols: ext.AlpnProtocols}, nil
+	case *tls.SCTExtension:
+		return &extensionFile{Name: extNameSCT}, nil
+	case *tls.UtlsPaddingExtension:
+		if ext.GetPaddingLen == nil {
+			return &extensionFile{Name: extNamePadding, PaddingLength: ext.PaddingLen, WillPad: ext.WillPad}, nil
+		}
+		if !isBoringPaddingStyle(ext.GetPaddingLen) {
+			return nil, errors.New("unsupported padding style")
+		}
+		return &extensionFile{Name: extNamePadding, PaddingStyle: paddingStyleBoring}, nil
+	case *tls.ExtendedMasterSecretExtension:
+		return &extensionFile{Name: extNameExtendedMasterSecret}, nil
+	case *tls.UtlsCompressCertExtension:
+		return &extensionFile{
+			Name:       extNameCompressCertificate,
+			Algorithms: uint16Names(ext.Algorithms, dicttls.DictCertificateCompressionAlgorithmValueIndexed),
+		}, nil
+	case *tls.FakeRecordSizeLimitExtension:
+		return &extensionFile{Name: extNameRecordSizeLimit, Limit: ext.Limit}, nil
+	case *tls.FakeDelegatedCredentialsExtension:
+		return &extensionFile{
+			Name:                extNameDelegatedCredentials,
+			SignatureAlgorithms: uint16Names(ext.SupportedSignatureAlgorithms, dicttls.DictSignatureSchemeValueIndexed),
+		}, nil
+	case *tls.SessionTicketExtension:
+		return &extensionFile{Name: extNameSessionTicket}, nil
+	case *tls.UtlsPreSharedKeyExtension:
+		return &extensionFile{Name: extNamePreSharedKey}, nil
+	case *earlyDataExtension:
+		return &extensionFile{Name: extNameEarlyData}, nil
+	case *tls.SupportedVersionsExtension:
+		return &extensionFile{Name: extNameSupportedVersions, Versions: uint16Names(ext.Versions, tlsVersionNames)}, nil
+	case *tls.PSKKeyExchangeModesExtension:
+		return &extensionFile{
+			Name:  extNamePSKKeyExchangeModes,
+			Modes: uint8Names(ext.Modes, dicttls.DictPSKKeyExchangeModeValueIndexed),
+		}, nil
+	case *tls.KeyShareExtension:
+		f := &extensionFile{Name: extNameKeyShare}
+		for _, ks := range ext.KeyShares {
+			f.KeyShares = append(f.KeyShares, keyShareFile{
+				Group: uint16Name(ks.Group, dicttls.DictSupportedGroupsValueIndexed, extraGroupNames),
+				Data:  hexString(ks.Data),
+			})
+		}
+		return f, nil
+	case *tls.QUICTransportParametersExtension:
+		f := &extensionFile{Name: extNameQUICTransportParameters}
+		for _, tp := range ext.TransportParameters {
+			tpf, err := newTransportParameterFile(tp)
+			if err != nil {
+				return nil, err
+			}
+			f.TransportParameters = append(f.TransportParameters, *tpf)
+		}
+		return f, nil
+	case *tls.RenegotiationInfoExtension:
+		return &extensionFile{
+			Name:          extNameRenegotiationInfo,
+			Renegotiation: codepointName(ext.Renegotiation, renegotiationNames),
+		}, nil
+	case *tls.ApplicationSettingsExtension:
+		return &extensionFile{Name: extNameApplicationSettings, Protocols: ext.SupportedProtocols}, nil
+	case *tls.ApplicationSettingsExtensionNew:
+		return &extensionFile{Name: extNameApplicationSettingsNew, Protocols: ext.SupportedProtocols}, nil
+	case *tls.GREASEEncryptedClientHelloExtension:
+		f := &extensionFile{Name: extNameEncryptedClientHello, PayloadLengths: ext.CandidatePayloadLens}
+		for _, cs := range ext.CandidateCipherSuites {
+			f.HPKECipherSuites = append(f.HPKECipherSuites, hpkeCipherSuiteFile{
+				KDF:  codepointName(cs.KdfId, dicttls.DictKDFIdentifierValueIndexed),
+				AEAD: codepointName(cs.AeadId, dicttls.DictAEADIdentifierValueIndexed),
+			})
+		}
+		for _, id := range ext.CandidateConfigIds {
+			f.ConfigIDs = append(f.ConfigIDs, int(id))
+		}
+		return f, nil
+	case *tls.UtlsGREASEExtension:
+		f := &extensionFile{Name: nameGREASE, Data: hexString(ext.Body)}
+		if ext.Value != 0 {
+			id := ext.Value
+			f.ID = &id
+		}
+		return f, nil
+	case *tls.GenericExtension:
+		id := ext.Id
+		return &extensionFile{
+			Name: codepointName(id, dicttls.DictExtTypeValueIndexed, extraExtensionNames),
+			ID:   &id,
+			Data: hexString(ext.Data),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported TLS extension %T", ext)
+	}
+}
+
+func newTransportParameterFile(tp tls.TransportParameter) (*transportParameterFile, error) {
+	integer := func(name string, v uint64) (*transportParameterFile, error) {
+		return &transportParameterFile{Name: name, Value: &v}, nil
+	}
+	switch tp := tp.(type) {
+	case tls.MaxIdleTimeout:
+		return integer(tpNameMaxIdleTimeout, uint64(tp))
+	case tls.MaxUDPPayloadSize:
+		return integer(tpNameMaxUDPPayloadSize, uint64(tp))
+	case tls.InitialMaxData:
+		return integer(tpNameInitialMaxData, uint64(tp))
+	case tls.InitialMaxStreamDataBidiLocal:
+		return integer(tpNameInitialMaxStreamDataBidiL, uint64(tp))
+	case tls.InitialMaxStreamDataBidiRemote:
+		return integer(tpNameInitialMaxStreamDataBidiR, uint64(tp))
+	case tls.InitialMaxStreamDataUni:
+		return integer(tpNameInitialMaxStreamDataUni, uint64(tp))
+	case tls.InitialMaxStreamsBidi:
+		return integer(tpNameInitialMaxStreamsBidi, uint64(tp))
+	case tls.InitialMaxStreamsUni:
+		return integer(tpNameInitialMaxStreamsUni, uint64(tp))
+	case tls.MaxAckDelay:
+		return integer(tpNameMaxAckDelay, uint64(tp))
+	case tls.ActiveConnectionIDLimit:
+		return integer(tpNameActiveConnectionIDLimit, uint64(tp))
+	case tls.MaxDatagramFrameSize:
+		return integer(tpNameMaxDatagramFrameSize, uint64(tp))
+	case *tls.DisableActiveMigration:
+		return &transportParameterFile{Name: tpNameDisableActiveMigration}, nil
+	case *tls.GREASEQUICBit:
+		return &transportParameterFile{Name: tpNameGREASEQUICBit}, nil
+	case tls.InitialSourceConnectionID:
+		return &transportParameterFile{Name: tpNameInitialSourceConnectionID, Data: hexString(tp)}, nil
+	case tls.PaddingTransportParameter:
+		return &transportParameterFile{Name: tpNamePadding, Data: hexString(tp)}, nil
+	case *tls.VersionInformation:
+		return &transportParameterFile{
+			Name:              tpNameVersionInformation,
+			ChosenVersion:     codepointName(tp.ChoosenVersion, quicVersionNames),
+			AvailableVersions: quicVersionNameList(tp.AvailableVersions),
+			LegacyID:          tp.LegacyID,
+		}, nil
+	case *tls.GREASETransportParameter:
+		// the ID() and Value() methods generate random values, only the overrides are exported
+		f := &transportParameterFile{Name: nameGREASE, Length: tp.Length, Data: hexString(tp.ValueOverride)}
+		if tp.IdOverride != 0 {
+			id := tp.IdOverride
+			f.ID = &id
+		}
+		return f, nil
+	case *tls.FakeQUICTransportParameter:
+		id := tp.Id
+		return &transportParameterFile{
+			Name: codepointName(id, dicttls.DictQUICTransportParameterValueIndexed),
+			ID:   &id,
+			Data: hexString(tp.Val),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported QUIC transport parameter %T", tp)
+	}
+}
+
+func (f *quicSpecFile) toSpec() (QUICSpec, error) {
+	spec := QUICSpec{
+		InitialPacketSpec: InitialPacketSpec{
+			SrcConnIDLength:        f.InitialPacket.SrcConnIDLength,
+			DestConnIDLength:       f.InitialPacket.DestConnIDLength,
+			InitPacketNumberLength: protocol.PacketNumberLen(f.InitialPacket.PacketNumberLength),
+			InitPacketNumber:       f.InitialPacket.PacketNumber,
+			ClientTokenLength:      f.InitialPacket.ClientTokenLength,
+		},
+		UDPDatagramMinSize: f.UDPDatagramMinSize,
+		IPv4:               f.IPv4.toAddrFamilySpec(),
+		IPv6:               f.IPv6.toAddrFamilySpec(),
+	}
+	if f.InitialPacket.Frames != nil {
+		fb, err := f.InitialPacket.Frames.toFrameBuilder()
+		if err != nil {
+			return QUICSpec{}, err
+		}
+		spec.InitialPacketSpec.FrameBuilder = fb
+	}
+	for _, chpf := range f.InitialPacket.ClientHelloPackets {
+		chp := ClientHelloPacketSpec{
+			UDPDatagramMinSize: chpf.UDPDatagramMinSize,
+			PaddingFrames:      chpf.PaddingFrames,
+			Coalesce:           chpf.Coalesce,
+		}
+		if chpf.Frames != nil {
+			fb, err := chpf.Frames.toFrameBuilder()
+			if err != nil {
+				return QUICSpec{}, err
+			}
+			chp.FrameBuilder = fb
+		}
+		spec.InitialPacketSpec.ClientHelloPackets = append(spec.InitialPacketSpec.ClientHelloPackets, chp)
+	}
+	if f.ClientHello != nil {
+		chs, err := f.ClientHello.toClientHelloSpec()
+		if err != nil {
+			return QUICSpec{}, err
+		}
+		spec.ClientHelloSpec = chs
+	}
+	if f.PostHandshake != nil {
+		spec.PostHandshakeSpec = PostHandshakeSpec{
+			AckElicitingThreshold:       f.PostHandshake.AckElicitingThreshold,
+			MaxAckDelay:                 time.Duration(f.PostHandshake.MaxAckDelay) * time.Millisecond,
+			NewConnectionIDs:            f.PostHandshake.NewConnectionIDs,
+			KeepAlivePeriod:             time.Duration(f.PostHandshake.KeepAlivePeriod) * time.Millisecond,
+			DisablePacketNumberSkipping: f.PostHandshake.DisablePacketNumberSkipping,
+		}
+	}
+	vs, err := f.Version.toVersionSpec()
+	if err != nil {
+		return QUICSpec{}, err
+	}
+	spec.VersionSpec = vs
+	return spec, nil
+}
+
+func (f *framesFile) toFrameBuilder() (QUICFrameBuilder, error) {
+	switch f.Type {
+	case framesTypeRandom:
+		return &QUICRandomFrames{
+			MinPING:    f.MinPING,
+			MaxPING:    f.MaxPING,
+			MinCRYPTO:  f.MinCRYPTO,
+			MaxCRYPTO:  f.MaxCRYPTO,
+			MinPADDING: f.MinPADDING,
+			MaxPADDING: f.MaxPADDING,
+			Length:     f.Length,
+		}, nil
+	case framesTypeFixed:
+		frames := QUICFrames{}
+		for _, frame := range f.Frames {
+			switch frame.Type {
+			case frameTypeCrypto:
+				frames = append(frames, QUICFrameCrypto{Offset: frame.Offset, Length: frame.Length})
+			case frameTypePadding:
+				frames = append(frames, QUICFramePadding{Length: frame.Length})
+			case frameTypePing:
+				frames = append(frames, QUICFramePing{})
+			default:
+				return nil, fmt.Errorf("unknown frame type %q", frame.Type)
+			}
+		}
+		return frames, nil
+	case framesTypeScrambled:
+		sf := &QUICScrambledFrames{SplitSNI: f.SplitSNI, SplitECH: f.SplitECH, Offsets: f.Offsets}
+		if f.Order != "" {
+			order, err := codepointValue(f.Order, cryptoFrameOrderNames)
+			if err != nil {
+				return nil, fmt.Errorf("CRYPTO frame order: %w", err)
+			}
+			sf.Order = order
+		}
+		if f.Layout != nil {
+			if f.Layout.Type != framesTypeRandom {
+				return nil, fmt.Errorf("layout of scrambled frames must be of type %q", framesTypeRandom)
+			}
+			layout, err := f.Layout.toFrameBuilder()
+			if err != nil {
+				return nil, err
+			}
+			sf.Layout = layout.(*QUICRandomFrames)
+		}
+		return sf, nil
+	default:
+		return nil, fmt.Errorf("unknown frames type %q", f.Type)
+	}
+}
+
+func (f *clientHelloFile) toClientHelloSpec() (*tls.ClientHelloSpec, error) {
+	chs := &tls.ClientHelloSpec{}
+	var err error
+	if f.TLSVersMin != "" {
+		if chs.TLSVersMin, err = codepointValue(f.TLSVersMin, tlsVersionNames); err != nil {
+			return nil, err
+		}
+	}
+	if f.TLSVersMax != "" {
+		if chs.TLSVersMax, err = codepointValue(f.TLSVersMax, tlsVersionNames); err != nil {
+			return nil, err
+		}
+	}
+	if chs.CipherSuites, err = uint16Values[uint16](f.CipherSuites, dicttls.DictCipherSuiteValueIndexed); err != nil {
+		return nil, err
+	}
+	if chs.CompressionMethods, err = uint8Values(f.CompressionMethods, dicttls.DictCompMethValueIndexed); err != nil {
+		return nil, err
+	}
+	for _, ef := range f.Extensions {
+		ext, err := ef.toExtension()
+		if err != nil {
+			return nil, fmt.Errorf("extension %s: %w", ef.Name, err)
+		}
+		chs.Extensions = append(chs.Extensions, ext)
+	}
+	if f.ShuffleExtensions {
+		chs.Extensions = tls.ShuffleChromeTLSExtensions(chs.Extensions)
+	}
+	return chs, nil
+}
+
+func decodeHex(s string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex data %q", s)
+	}
+	return b, nil
+}
+
+func (f *extensionFile) toExtension() (tls.TLSExtension, error) {
+	data, err := decodeHex(f.Data)
+	if err != nil {
+		return nil, err
+	}
+	if f.Name == nameGREASE {
+		ext := &tls.UtlsGREASEExtension{Body: data}
+		if f.ID != nil {
+			ext.Value = *f.ID
+		}
+		if len(ext.Body) == 0 {
+			ext.Body = nil
+		}
+		return ext, nil
+	}
+	if f.ID != nil {
+		return &tls.GenericExtension{Id: *f.ID, Data: data}, nil
+	}
+
+	signatureAlgorithms, err := uint16Values[tls.SignatureScheme](f.SignatureAlgorithms, dicttls.DictSignatureSchemeValueIndexed)
+	if err != nil {
+		return nil, err
+	}
+	switch f.Name {
+	case extNameServerName:
+		return &tls.SNIExtension{ServerName: f.ServerName}, nil
+	case extNameStatusRequest:
+		return &tls.StatusRequestExtension{}, nil
+	case extNameSupportedGroups:
+		curves, err := uint16Values[tls.CurveID](f.Groups, dicttls.DictSupportedGroupsValueIndexed, extraGroupNames)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.SupportedCurvesExtension{Curves: curves}, nil
+	case extNameECPointFormats:
+		points, err := uint8Values(f.PointFormats, dicttls.DictECPointFormatValueIndexed)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.SupportedPointsExtension{SupportedPoints: points}, nil
+	case extNameSignatureAlgorithms:
+		return &tls.SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: signatureAlgorithms}, nil
+	case extNameSignatureAlgorithmsCert:
+		return &tls.SignatureAlgorithmsCertExtension{SupportedSignatureAlgorithms: signatureAlgorithms}, nil
+	case extNameALPN:
+		return &tls.ALPNExtension{AlpnProtocols: f.Protocols}, nil
+	case extNameSCT:
+		return &tls.SCTExtension{}, nil
+	case extNamePadding:
+		switch f.PaddingStyle {
+		case "":
+			return &tls.UtlsPaddingExtension{PaddingLen: f.PaddingLength, WillPad: f.WillPad}, nil
+		case paddingStyleBoring:
+			return &tls.UtlsPaddingExtension{GetPaddingLen: tls.BoringPaddingStyle}, nil
+		default:
+			return nil, fmt.Errorf("unknown padding style %q", f.PaddingStyle)
+		}
+	case extNameExtendedMasterSecret:
+		return &tls.ExtendedMasterSecretExtension{}, nil
+	case extNameCompressCertificate:
+		algorithms, err := uint16Values[tls.CertCompressionAlgo](f.Algorithms, dicttls.DictCertificateCompressionAlgorithmValueIndexed)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.UtlsCompressCertExtension{Algorithms: algorithms}, nil
+	case extNameRecordSizeLimit:
+		return &tls.FakeRecordSizeLimitExtension{Limit: f.Limit}, nil
+	case extNameDelegatedCredentials:
+		return &tls.FakeDelegatedCredentialsExtension{SupportedSignatureAlgorithms: signatureAlgorithms}, nil
+	case extNameSessionTicket:
+		return &tls.SessionTicketExtension{}, nil
+	case extNamePreSharedKey:
+		return &tls.UtlsPreSharedKeyExtension{}, nil
+	case extNameEarlyData:
+		return newEarlyDataExtension(), nil
+	case extNameSupportedVersions:
+		versions, err := uint16Values[uint16](f.Versions, tlsVersionNames)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.SupportedVersionsExtension{Versions: versions}, nil
+	case extNamePSKKeyExchangeModes:
+		modes, err := uint8Values(f.Modes, dicttls.DictPSKKeyExchangeModeValueIndexed)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.PSKKeyExchangeModesExtension{Modes: modes}, nil
+	case extNameKeyShare:
+		ext := &tls.KeyShareExtension{}
+		for _, ks := range f.KeyShares {
+			group, err := uint16Value[tls.CurveID](ks.Group, dicttls.DictSupportedGroupsValueIndexed, extraGroupNames)
+			if err != nil {
+				return nil, err
+			}
+			data, err := decodeHex(ks.Data)
+			if err != nil {
+				return nil, err
+			}
+			if len(data) == 0 {
+				data = nil
+			}
+			ext.KeyShares = append(ext.KeyShares, tls.KeyShare{Group: group, Data: data})
+		}
+		return ext, nil
+	case extNameQUICTransportParameters:
+		ext := &tls.QUICTransportParametersExtension{}
+		for _, tpf := range f.TransportParameters {
+			tp, err := tpf.toTransportParameter()
+			if err != nil {
+				return nil, fmt.Errorf("transport parameter %s: %w", tpf.Name, err)
+			}
+			ext.TransportParameters = append(ext.TransportParameters, tp)
+		}
+		if f.ShuffleTransportParameters {
+			ext = ShuffleQUICTransportParameters(ext)
+		}
+		return ext, nil
+	case extNameRenegotiationInfo:
+		renegotiation, err := codepointValue(f.Renegotiation, renegotiationNames)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.RenegotiationInfoExtension{Renegotiation: renegotiation}, nil
+	case extNameApplicationSettings:
+		return &tls.ApplicationSettingsExtension{SupportedProtocols: f.Protocols}, nil
+	case extNameApplicationSettingsNew:
+		return &tls.ApplicationSettingsExtensionNew{SupportedProtocols: f.Protocols}, nil
+	case extNameEncryptedClientHello:
+		ext := &tls.GREASEEncryptedClientHelloExtension{CandidatePayloadLens: f.PayloadLengths}
+		for _, cs := range f.HPKECipherSuites {
+			kdf, err := codepointValue(cs.KDF, dicttls.DictKDFIdentifierValueIndexed)
+			if err != nil {
+				return nil, err
+			}
+			aead, err := codepointValue(cs.AEAD, dicttls.DictAEADIdentifierValueIndexed)
+			if err != nil {
+				return nil, err
+			}
+			ext.CandidateCipherSuites = append(ext.CandidateCipherSuites, tls.HPKESymmetricCipherSuite{KdfId: kdf, AeadId: aead})
+		}
+		for _, id := range f.ConfigIDs {
+			if id < 0 || id > 0xff {
+				return nil, fmt.Errorf("invalid config id %d", id)
+			}
+			ext.CandidateConfigIds = append(ext.CandidateConfigIds, uint8(id))
+		}
+		return ext, nil
+	default:
+		return nil, errors.New("unknown extension, set an id to send it as a generic extension")
+	}
+}
+
+func (f *transportParameterFile) toTransportParameter() (tls.TransportParameter, error) {
+	data, err := decodeHex(f.Data)
+	if err != nil {
+		return nil, err
+	}
+	if f.Name == nameGREASE {
+		tp := &tls.GREASETransportParameter{Length: f.Length}
+		if f.ID != nil {
+			if !tp.IsGREASEID(*f.ID) {
+				return nil, fmt.Errorf("%#x is not a GREASE transport parameter ID", *f.ID)
+			}
+			tp.IdOverride = *f.ID
+		}
+		if len(data) > 0 {
+			tp.ValueOverride = data
+		}
+		return tp, nil
+	}
+	if f.ID != nil {
+		return &tls.FakeQUICTransportParameter{Id: *f.ID, Val: data}, nil
+	}
+
+	var value uint64
+	switch f.Name {
+	case tpNameMaxIdleTimeout, tpNameMaxUDPPayloadSize, tpNameInitialMaxData, tpNameInitialMaxStreamDataBidiL,
+		tpNameInitialMaxStreamDataBidiR, tpNameInitialMaxStreamDataUni, tpNameInitialMaxStreamsBidi,
+		tpNameInitialMaxStreamsUni, tpNameMaxAckDelay, tpNameActiveConnectionIDLimit, tpNameMaxDatagramFrameSize:
+		if f.Value == nil {
+			return nil, errors.New("missing value")
+		}
+		value = *f.Value
+	}
+	switch f.Name {
+	case tpNameMaxIdleTimeout:
+		return tls.MaxIdleTimeout(value), nil
+	case tpNameMaxUDPPayloadSize:
+		return tls.MaxUDPPayloadSize(value), nil
+	case tpNameInitialMaxData:
+		return tls.InitialMaxData(value), nil
+	case tpNameInitialMaxStreamDataBidiL:
+		return tls.InitialMaxStreamDataBidiLocal(value), nil
+	case tpNameInitialMaxStreamDataBidiR:
+		return tls.InitialMaxStreamDataBidiRemote(value), nil
+	case tpNameInitialMaxStreamDataUni:
+		return tls.InitialMaxStreamDataUni(value), nil
+	case tpNameInitialMaxStreamsBidi:
+		return tls.InitialMaxStreamsBidi(value), nil
+	case tpNameInitialMaxStreamsUni:
+		return tls.InitialMaxStreamsUni(value), nil
+	case tpNameMaxAckDelay:
+		return tls.MaxAckDelay(value), nil
+	case tpNameActiveConnectionIDLimit:
+		return tls.ActiveConnectionIDLimit(value), nil
+	case tpNameMaxDatagramFrameSize:
+		return tls.MaxDatagramFrameSize(value), nil
+	case tpNameDisableActiveMigration:
+		return &tls.DisableActiveMigration{}, nil
+	case tpNameGREASEQUICBit:
+		return &tls.GREASEQUICBit{}, nil
+	case tpNameInitialSourceConnectionID:
+		return tls.InitialSourceConnectionID(data), nil
+	case tpNamePadding:
+		return tls.PaddingTransportParameter(data), nil
+	case tpNameVersionInformation:
+		tp := &tls.VersionInformation{LegacyID: f.LegacyID}
+		if tp.ChoosenVersion, err = codepointValue(f.ChosenVersion, quicVersionNames); err != nil {
+			return nil, err
+		}
+		if tp.AvailableVersions, err = quicVersionValueList(f.AvailableVersions); err != nil {
+			return nil, err
+		}
+		return tp, nil
+	default:
+		return nil, errors.New("unknown transport parameter, set an id to send it as an opaque value")
+	}
+}
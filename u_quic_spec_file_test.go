@@ -0,0 +1,307 @@
+package quic
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	tls "github.com/Noooste/utls"
+
+	"github.com/stretchr/testify/require"
+)
+
+var builtinQUICIDs = []QUICID{
+	QUICFirefox_116A,
+	QUICFirefox_116B,
+	QUICFirefox_116C,
+	QUICChrome_115_IPv4,
+	QUICChrome_115_IPv6,
+}
+
+// testQUICIDs are the builtinQUICIDs and the QUICIDs of the testQUICSpecs.
+var testQUICIDs = append(
+	slices.Clone(builtinQUICIDs),
+	testQUICIDPQ, testQUICIDPQIPv6, testQUICIDPQConnID3, testQUICIDConnID8,
+)
+
+// requireEqualQUICSpec compares two QUICSpecs.
+// Functions can't be compared, so the padding style is compared by identity.
+func requireEqualQUICSpec(t *testing.T, expected, actual QUICSpec) {
+	t.Helper()
+	normalize := func(spec *QUICSpec) {
+		if spec.ClientHelloSpec == nil {
+			return
+		}
+		for _, ext := range spec.ClientHelloSpec.Extensions {
+			if padding, ok := ext.(*tls.UtlsPaddingExtension); ok && padding.GetPaddingLen != nil {
+				require.True(t, isBoringPaddingStyle(padding.GetPaddingLen))
+				padding.GetPaddingLen = nil
+			}
+		}
+	}
+	normalize(&expected)
+	normalize(&actual)
+	require.Equal(t, expected, actual)
+}
+
+func TestQUICSpecFileRoundTrip(t *testing.T) {
+	for _, id := range testQUICIDs {
+		t.Run(id.Client+"_"+id.Version, func(t *testing.T) {
+			spec, err := testQUICID2Spec(id)
+			require.NoError(t, err)
+
+			t.Run("JSON", func(t *testing.T) {
+				data, err := MarshalQUICSpec(&spec)
+				require.NoError(t, err)
+				loaded, err := LoadQUICSpec(data)
+				require.NoError(t, err)
+				data2, err := MarshalQUICSpec(&loaded)
+				require.NoError(t, err)
+				require.JSONEq(t, string(data), string(data2))
+				requireEqualQUICSpec(t, spec, loaded)
+			})
+
+			t.Run("YAML", func(t *testing.T) {
+				data, err := MarshalQUICSpecYAML(&spec)
+				require.NoError(t, err)
+				loaded, err := LoadQUICSpec(data)
+				require.NoError(t, err)
+				data2, err := MarshalQUICSpecYAML(&loaded)
+				require.NoError(t, err)
+				require.YAMLEq(t, string(data), string(data2))
+				requireEqualQUICSpec(t, spec, loaded)
+			})
+		})
+	}
+}
+
+func TestQUICSpecFileLoad(t *testing.T) {
+	spec, err := LoadQUICSpec([]byte(`
+initial_packet:
+  src_conn_id_length: 3
+  dest_conn_id_length: 8
+  packet_number_length: 1
+  frames:
+    type: fixed
+    frames:
+      - type: ping
+      - type: crypto
+        offset: 0
+        length: 10
+      - type: padding
+        length: 5
+      - type: crypto
+        offset: 10
+client_hello:
+  tls_vers_min: TLS 1.3
+  tls_vers_max: TLS 1.3
+  cipher_suites: [GREASE, TLS_AES_128_GCM_SHA256, "0x1305"]
+  compression_methods: ["NULL"]
+  extensions:
+    - name: GREASE
+    - name: supported_groups
+      groups: [GREASE, X25519MLKEM768, x25519]
+    - name: key_share
+      key_shares:
+        - group: x25519
+    - name: quic_transport_parameters
+      transport_parameters:
+        - name: max_idle_timeout
+          value: 30000
+        - name: initial_source_connection_id
+        - name: GREASE
+          length: 4
+        - name: google_version
+          id: 0x4752
+          data: "00000001"
+    - name: my_extension
+      id: 0x1234
+      data: cafe
+udp_datagram_min_size: 1357
+post_handshake:
+  ack_eliciting_threshold: 10
+  max_ack_delay: 20
+  new_connection_ids: -1
+  keep_alive_period: 15000
+  disable_packet_number_skipping: true
+ipv6:
+  udp_datagram_min_size: 1337
+  random_frames_length: 1195
+`))
+	require.NoError(t, err)
+	require.Equal(t, QUICSpec{
+		InitialPacketSpec: InitialPacketSpec{
+			SrcConnIDLength:        3,
+			DestConnIDLength:       8,
+			InitPacketNumberLength: 1,
+			FrameBuilder: QUICFrames{
+				QUICFramePing{},
+				QUICFrameCrypto{Offset: 0, Length: 10},
+				QUICFramePadding{Length: 5},
+				QUICFrameCrypto{Offset: 10},
+			},
+		},
+		ClientHelloSpec: &tls.ClientHelloSpec{
+			TLSVersMin:         tls.VersionTLS13,
+			TLSVersMax:         tls.VersionTLS13,
+			CipherSuites:       []uint16{tls.GREASE_PLACEHOLDER, tls.TLS_AES_128_GCM_SHA256, 0x1305},
+			CompressionMethods: []uint8{0},
+			Extensions: []tls.TLSExtension{
+				&tls.UtlsGREASEExtension{},
+				&tls.SupportedCurvesExtension{
+					Curves: []tls.CurveID{tls.GREASE_PLACEHOLDER, tls.X25519MLKEM768, tls.X25519},
+				},
+				&tls.KeyShareExtension{KeyShares: []tls.KeyShare{{Group: tls.X25519}}},
+				&tls.QUICTransportParametersExtension{
+					TransportParameters: tls.TransportParameters{
+						tls.MaxIdleTimeout(30000),
+						tls.InitialSourceConnectionID([]byte{}),
+						&tls.GREASETransportParameter{Length: 4},
+						&tls.FakeQUICTransportParameter{Id: 0x4752, Val: []byte{0, 0, 0, 1}},
+					},
+				},
+				&tls.GenericExtension{Id: 0x1234, Data: []byte{0xca, 0xfe}},
+			},
+		},
+		UDPDatagramMinSize: 1357,
+		PostHandshakeSpec: PostHandshakeSpec{
+			AckElicitingThreshold:       10,
+			MaxAckDelay:                 20 * time.Millisecond,
+			NewConnectionIDs:            -1,
+			KeepAlivePeriod:             15 * time.Second,
+			DisablePacketNumberSkipping: true,
+		},
+		IPv6: &AddrFamilySpec{UDPDatagramMinSize: 1337, RandomFramesLength: 1195},
+	}, spec)
+}
+
+func TestQUICSpecFileScrambledFrames(t *testing.T) {
+	spec, err := LoadQUICSpec([]byte(`
+initial_packet:
+  frames:
+    type: scrambled
+    split_sni: true
+    offsets: [10, 20]
+    order: even_odd
+    layout:
+      type: random
+      min_ping: 1
+      max_ping: 2
+      min_crypto: 3
+      max_crypto: 4
+      min_padding: 1
+      max_padding: 2
+      length: 1200
+`))
+	require.NoError(t, err)
+	expected := &QUICScrambledFrames{
+		SplitSNI: true,
+		Offsets:  []int{10, 20},
+		Order:    CryptoFrameOrderEvenOdd,
+		Layout: &QUICRandomFrames{
+			MinPING: 1, MaxPING: 2,
+			MinCRYPTO: 3, MaxCRYPTO: 4,
+			MinPADDING: 1, MaxPADDING: 2,
+			Length: 1200,
+		},
+	}
+	require.Equal(t, expected, spec.InitialPacketSpec.FrameBuilder)
+
+	data, err := MarshalQUICSpec(&spec)
+	require.NoError(t, err)
+	loaded, err := LoadQUICSpec(data)
+	require.NoError(t, err)
+	require.Equal(t, expected, loaded.InitialPacketSpec.FrameBuilder)
+
+	_, err = LoadQUICSpec([]byte("initial_packet:\n  frames:\n    type: scrambled\n    order: foo"))
+	require.ErrorContains(t, err, `unknown codepoint "foo"`)
+	_, err = LoadQUICSpec([]byte("initial_packet:\n  frames:\n    type: scrambled\n    layout:\n      type: fixed"))
+	require.ErrorContains(t, err, "layout of scrambled frames must be of type")
+}
+
+func TestQUICSpecFileClientHelloPackets(t *testing.T) {
+	spec, err := LoadQUICSpec([]byte(`
+initial_packet:
+  client_hello_packets:
+    - frames:
+        type: random
+        min_crypto: 2
+        max_crypto: 3
+        min_padding: 1
+        max_padding: 2
+        length: 600
+      udp_datagram_min_size: 900
+    - padding_frames: true
+      coalesce: true
+`))
+	require.NoError(t, err)
+	expected := []ClientHelloPacketSpec{
+		{
+			FrameBuilder: &QUICRandomFrames{
+				MinCRYPTO: 2, MaxCRYPTO: 3,
+				MinPADDING: 1, MaxPADDING: 2,
+				Length: 600,
+			},
+			UDPDatagramMinSize: 900,
+		},
+		{PaddingFrames: true, Coalesce: true},
+	}
+	require.Equal(t, expected, spec.InitialPacketSpec.ClientHelloPackets)
+
+	data, err := MarshalQUICSpec(&spec)
+	require.NoError(t, err)
+	loaded, err := LoadQUICSpec(data)
+	require.NoError(t, err)
+	require.Equal(t, expected, loaded.InitialPacketSpec.ClientHelloPackets)
+}
+
+func TestQUICSpecFileVersion(t *testing.T) {
+	spec, err := LoadQUICSpec([]byte(`
+version:
+  initial_version: v2
+  versions: [v2, v1]
+`))
+	require.NoError(t, err)
+	expected := VersionSpec{InitialVersion: Version2, Versions: []Version{Version2, Version1}}
+	require.Equal(t, expected, spec.VersionSpec)
+
+	data, err := MarshalQUICSpec(&spec)
+	require.NoError(t, err)
+	loaded, err := LoadQUICSpec(data)
+	require.NoError(t, err)
+	require.Equal(t, expected, loaded.VersionSpec)
+
+	_, err = LoadQUICSpec([]byte("version:\n  versions: [foo]"))
+	require.ErrorContains(t, err, `unknown codepoint "foo"`)
+}
+
+func TestQUICSpecFileLoadErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, data, err string
+	}{
+		{"unknown field", `{"initial_packet": {"foo": 1}}`, "unknown field"},
+		{"unknown frames type", "initial_packet:\n  frames:\n    type: foo", `unknown frames type "foo"`},
+		{"unknown cipher suite", "client_hello:\n  cipher_suites: [foo]", `unknown codepoint "foo"`},
+		{"unknown extension", "client_hello:\n  extensions:\n    - name: foo", "extension foo: unknown extension"},
+		{
+			"missing transport parameter value",
+			"client_hello:\n  extensions:\n    - name: quic_transport_parameters\n      transport_parameters:\n        - name: max_idle_timeout",
+			"transport parameter max_idle_timeout: missing value",
+		},
+		{"invalid hex", "client_hello:\n  extensions:\n    - name: foo\n      id: 1\n      data: xyz", "invalid hex data"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := LoadQUICSpec([]byte(tc.data))
+			require.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func TestQUICSpecFileMarshalUnsupported(t *testing.T) {
+	_, err := MarshalQUICSpec(&QUICSpec{
+		ClientHelloSpec: &tls.ClientHelloSpec{
+			Extensions: []tls.TLSExtension{&tls.UtlsPaddingExtension{GetPaddingLen: tls.AlwaysPadToLen(512)}},
+		},
+	})
+	require.ErrorContains(t, err, "unsupported padding style")
+}
@@ -0,0 +1,274 @@
+package quic
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/Noooste/uquic-go/internal/protocol"
+	"github.com/Noooste/uquic-go/quicvarint"
+	tls "github.com/Noooste/utls"
+)
+
+// [UQUIC]
+// Errors wrapped by a QUICSpecError, describing how a QUICSpec is misconfigured.
+// Use errors.Is to tell them apart.
+var (
+	// ErrMissingClientHelloSpec is returned for a QUICSpec without a ClientHelloSpec.
+	ErrMissingClientHelloSpec = errors.New("no ClientHelloSpec")
+	// ErrMissingTransportParameters is returned for a ClientHelloSpec without a
+	// QUICTransportParametersExtension.
+	ErrMissingTransportParameters = errors.New("no QUICTransportParametersExtension")
+	// ErrInvalidClientHelloSpec is returned when uTLS fails to apply the ClientHelloSpec.
+	ErrInvalidClientHelloSpec = errors.New("invalid ClientHelloSpec")
+	// ErrInvalidFrameBounds is returned for a QUICRandomFrames whose bounds on the number
+	// of frames are empty or inverted.
+	ErrInvalidFrameBounds = errors.New("invalid bounds on the number of frames")
+	// ErrFramesTooShort is returned for a QUICRandomFrames whose Length leaves no room
+	// for the ClientHello.
+	ErrFramesTooShort = errors.New("frames too short to carry the ClientHello")
+	// ErrInvalidCryptoFrames is returned for QUICFrames whose CRYPTO frames don't carry
+	// the ClientHello contiguously, from its beginning.
+	ErrInvalidCryptoFrames = errors.New("invalid CRYPTO frames")
+	// ErrUnsupportedVersion is returned for a VersionSpec using a QUIC version that is
+	// not supported.
+	ErrUnsupportedVersion = errors.New("unsupported QUIC version")
+	// ErrValueOutOfRange is returned for a field whose value is out of range, such as
+	// the length of a connection ID.
+	ErrValueOutOfRange = errors.New("value out of range")
+)
+
+// [UQUIC]
+// A QUICSpecError is returned by QUICSpec.Validate, and when dialing with an invalid
+// QUICSpec.
+type QUICSpecError struct {
+	// Field is the invalid field of the QUICSpec, e.g. "InitialPacketSpec.FrameBuilder".
+	Field string
+	// Err is one of the errors describing the misconfiguration, such as
+	// ErrInvalidFrameBounds, possibly wrapped with more details.
+	Err error
+}
+
+func (e *QUICSpecError) Error() string {
+	return fmt.Sprintf("invalid QUICSpec: %s: %s", e.Field, e.Err)
+}
+
+func (e *QUICSpecError) Unwrap() error { return e.Err }
+
+func specError(field string, sentinel error, format string, args ...any) *QUICSpecError {
+	return &QUICSpecError{Field: field, Err: fmt.Errorf("%w: "+format, append([]any{sentinel}, args...)...)}
+}
+
+// minCryptoFramesLength is the smallest payload of an Initial packet leaving room for a
+// CRYPTO frame with a single byte of the ClientHello: the AEAD tag, counted in the
+// size of the packet, and a CRYPTO frame with 2-byte offset and length fields.
+const minCryptoFramesLength = 16 + 5 + 1
+
+// [UQUIC]
+// Validate checks that the QUICSpec is consistent, and returns a QUICSpecError
+// describing the first misconfiguration found. Dialing with an invalid QUICSpec
+// fails with the same error.
+//
+// Only the frame builders of this package are checked, custom QUICFrameBuilders are
+// not. A ClientHelloSpec that uTLS fails to apply is only detected when dialing.
+func (s *QUICSpec) Validate() error {
+	if err := s.validateClientHelloSpec(); err != nil {
+		return err
+	}
+	if err := s.InitialPacketSpec.validate(); err != nil {
+		return err
+	}
+	if err := validateDatagramSize("UDPDatagramMinSize", s.UDPDatagramMinSize); err != nil {
+		return err
+	}
+	if err := s.PostHandshakeSpec.validate(); err != nil {
+		return err
+	}
+	if err := s.validateVersionSpec(); err != nil {
+		return err
+	}
+	for _, afs := range []struct {
+		name string
+		spec *AddrFamilySpec
+	}{{"IPv4", s.IPv4}, {"IPv6", s.IPv6}} {
+		if afs.spec == nil {
+			continue
+		}
+		if err := validateDatagramSize(afs.name+".UDPDatagramMinSize", afs.spec.UDPDatagramMinSize); err != nil {
+			return err
+		}
+		if afs.spec.RandomFramesLength == 0 {
+			continue
+		}
+		spec := *s
+		afs.spec.apply(&spec)
+		if err := validateFrameBuilder(afs.name+".RandomFramesLength", spec.InitialPacketSpec.FrameBuilder); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *QUICSpec) validateClientHelloSpec() error {
+	if s.ClientHelloSpec == nil {
+		return &QUICSpecError{Field: "ClientHelloSpec", Err: ErrMissingClientHelloSpec}
+	}
+	if !slices.ContainsFunc(s.ClientHelloSpec.Extensions, func(ext tls.TLSExtension) bool {
+		_, ok := ext.(*tls.QUICTransportParametersExtension)
+		return ok
+	}) {
+		return &QUICSpecError{Field: "ClientHelloSpec.Extensions", Err: ErrMissingTransportParameters}
+	}
+	return nil
+}
+
+func (ps *InitialPacketSpec) validate() error {
+	if ps.SrcConnIDLength < 0 || ps.SrcConnIDLength > protocol.MaxConnIDLen {
+		return specError("InitialPacketSpec.SrcConnIDLength", ErrValueOutOfRange,
+			"%d is not between 0 and %d", ps.SrcConnIDLength, protocol.MaxConnIDLen)
+	}
+	// the Destination Connection ID of the Initial packets must be at least 8 bytes long (RFC 9000, Section 7.2)
+	if ps.DestConnIDLength != 0 && (ps.DestConnIDLength < protocol.MinConnectionIDLenInitial || ps.DestConnIDLength > protocol.MaxConnIDLen) {
+		return specError("InitialPacketSpec.DestConnIDLength", ErrValueOutOfRange,
+			"%d is not between %d and %d", ps.DestConnIDLength, protocol.MinConnectionIDLenInitial, protocol.MaxConnIDLen)
+	}
+	if ps.InitPacketNumberLength > protocol.PacketNumberLen4 {
+		return specError("InitialPacketSpec.InitPacketNumberLength", ErrValueOutOfRange,
+			"%d is not between 1 and 4", ps.InitPacketNumberLength)
+	}
+	if ps.InitPacketNumber > quicvarint.Max {
+		return specError("InitialPacketSpec.InitPacketNumber", ErrValueOutOfRange,
+			"%d exceeds the maximum packet number", ps.InitPacketNumber)
+	}
+	if ps.ClientTokenLength < 0 {
+		return specError("InitialPacketSpec.ClientTokenLength", ErrValueOutOfRange, "%d is negative", ps.ClientTokenLength)
+	}
+	if err := validateFrameBuilder("InitialPacketSpec.FrameBuilder", ps.FrameBuilder); err != nil {
+		return err
+	}
+	for i, chp := range ps.ClientHelloPackets {
+		field := fmt.Sprintf("InitialPacketSpec.ClientHelloPackets[%d]", i)
+		if err := validateFrameBuilder(field+".FrameBuilder", chp.FrameBuilder); err != nil {
+			return err
+		}
+		if err := validateDatagramSize(field+".UDPDatagramMinSize", chp.UDPDatagramMinSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateDatagramSize checks a minimum size of UDP datagrams, which must fit into the
+// buffers used for packing packets. A size of 0 keeps the default.
+func validateDatagramSize(field string, size int) error {
+	if size < 0 || size > protocol.MaxPacketBufferSize {
+		return specError(field, ErrValueOutOfRange, "%d is not between 0 and %d", size, protocol.MaxPacketBufferSize)
+	}
+	return nil
+}
+
+func validateFrameBuilder(field string, fb QUICFrameBuilder) error {
+	var err error
+	switch fb := fb.(type) {
+	case *QUICRandomFrames:
+		err = fb.validateSpec(0)
+	case *QUICScrambledFrames:
+		if fb.Order > CryptoFrameOrderEvenOdd {
+			return specError(field+".Order", ErrValueOutOfRange, "unknown CRYPTO frame order %d", fb.Order)
+		}
+		if i := slices.IndexFunc(fb.Offsets, func(offset int) bool { return offset < 0 }); i != -1 {
+			return specError(fmt.Sprintf("%s.Offsets[%d]", field, i), ErrValueOutOfRange, "%d is negative", fb.Offsets[i])
+		}
+		if fb.Layout != nil {
+			field += ".Layout"
+			// the frames of the Layout must leave room for the cut CRYPTO frames
+			err = fb.Layout.validateSpec(fb.maxFrameOverhead() - fb.Layout.maxFrameOverhead())
+		}
+	case QUICFrames:
+		err = fb.validate()
+	}
+	if err != nil {
+		return &QUICSpecError{Field: field, Err: err}
+	}
+	return nil
+}
+
+// validateSpec is like validate, with the errors used by QUICSpec.Validate.
+// It also checks that the Length leaves room for the ClientHello, given the overhead
+// of the frames added to them by another frame builder.
+func (qrf *QUICRandomFrames) validateSpec(extraOverhead int) error {
+	if err := qrf.validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidFrameBounds, err)
+	}
+	if minLength := qrf.maxFrameOverhead() + extraOverhead + minCryptoFramesLength; qrf.Length != 0 && int(qrf.Length) < minLength {
+		return fmt.Errorf("%w: Length must be at least %d, got %d", ErrFramesTooShort, minLength, qrf.Length)
+	}
+	return nil
+}
+
+// validate checks that the CRYPTO frames start at the beginning of the ClientHello and
+// are contiguous. Only the last one may carry the remainder of the crypto data, with a
+// Length of 0.
+func (qfs QUICFrames) validate() error {
+	if len(qfs) == 0 {
+		return nil // a single CRYPTO frame
+	}
+	type cryptoFrame struct{ offset, length int }
+	var cryptoFrames []cryptoFrame
+	for _, frame := range qfs {
+		if offset, length, ok := frame.CryptoFrameInfo(); ok {
+			if offset < 0 || length < 0 {
+				return fmt.Errorf("%w: negative offset or length", ErrInvalidCryptoFrames)
+			}
+			cryptoFrames = append(cryptoFrames, cryptoFrame{offset: offset, length: length})
+		} else if padding, ok := frame.(QUICFramePadding); ok && padding.Length < 0 {
+			return fmt.Errorf("%w: PADDING frame with a negative length", ErrValueOutOfRange)
+		}
+	}
+	if len(cryptoFrames) == 0 {
+		return fmt.Errorf("%w: no CRYPTO frame", ErrInvalidCryptoFrames)
+	}
+	slices.SortFunc(cryptoFrames, func(a, b cryptoFrame) int { return a.offset - b.offset })
+	if cryptoFrames[0].offset != 0 {
+		return fmt.Errorf("%w: the first CRYPTO frame starts at offset %d", ErrInvalidCryptoFrames, cryptoFrames[0].offset)
+	}
+	for i, cf := range cryptoFrames[:len(cryptoFrames)-1] {
+		if cf.length == 0 {
+			return fmt.Errorf("%w: only the last CRYPTO frame may have a Length of 0", ErrInvalidCryptoFrames)
+		}
+		if next := cryptoFrames[i+1].offset; next != cf.offset+cf.length {
+			return fmt.Errorf("%w: CRYPTO frame at offset %d ends at %d, but the next one starts at %d",
+				ErrInvalidCryptoFrames, cf.offset, cf.offset+cf.length, next)
+		}
+	}
+	return nil
+}
+
+func (ps *PostHandshakeSpec) validate() error {
+	if ps.AckElicitingThreshold < 0 {
+		return specError("PostHandshakeSpec.AckElicitingThreshold", ErrValueOutOfRange, "%d is negative", ps.AckElicitingThreshold)
+	}
+	if ps.MaxAckDelay < 0 {
+		return specError("PostHandshakeSpec.MaxAckDelay", ErrValueOutOfRange, "%s is negative", ps.MaxAckDelay)
+	}
+	return nil
+}
+
+func (s *QUICSpec) validateVersionSpec() error {
+	vs := s.VersionSpec
+	for i, v := range vs.Versions {
+		if !protocol.IsValidVersion(v) {
+			return specError(fmt.Sprintf("VersionSpec.Versions[%d]", i), ErrUnsupportedVersion, "%s", v)
+		}
+	}
+	if vs.InitialVersion == 0 {
+		return nil
+	}
+	if !protocol.IsValidVersion(vs.InitialVersion) {
+		return specError("VersionSpec.InitialVersion", ErrUnsupportedVersion, "%s", vs.InitialVersion)
+	}
+	if versions := s.versionSpec().Versions; len(versions) > 0 && !slices.Contains(versions, vs.InitialVersion) {
+		return specError("VersionSpec.InitialVersion", ErrUnsupportedVersion, "%s is not one of the offered versions %v", vs.InitialVersion, versions)
+	}
+	return nil
+}
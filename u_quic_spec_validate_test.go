@@ -0,0 +1,214 @@
+package quic
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/testdata"
+	tls "github.com/Noooste/utls"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQUICSpecValidateParrots(t *testing.T) {
+	for _, id := range testQUICIDs {
+		spec, err := testQUICID2Spec(id)
+		require.NoError(t, err)
+		require.NoError(t, spec.Validate(), "%s %s", id.Client, id.Version)
+	}
+}
+
+func TestQUICSpecValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		modify   func(*QUICSpec)
+		field    string
+		expected error
+	}{
+		{
+			name:     "no ClientHelloSpec",
+			modify:   func(s *QUICSpec) { s.ClientHelloSpec = nil },
+			field:    "ClientHelloSpec",
+			expected: ErrMissingClientHelloSpec,
+		},
+		{
+			name: "no transport parameters",
+			modify: func(s *QUICSpec) {
+				chs := *s.ClientHelloSpec
+				chs.Extensions = nil
+				for _, ext := range s.ClientHelloSpec.Extensions {
+					if _, ok := ext.(*tls.QUICTransportParametersExtension); !ok {
+						chs.Extensions = append(chs.Extensions, ext)
+					}
+				}
+				s.ClientHelloSpec = &chs
+			},
+			field:    "ClientHelloSpec.Extensions",
+			expected: ErrMissingTransportParameters,
+		},
+		{
+			name:     "short destination connection ID",
+			modify:   func(s *QUICSpec) { s.InitialPacketSpec.DestConnIDLength = 4 },
+			field:    "InitialPacketSpec.DestConnIDLength",
+			expected: ErrValueOutOfRange,
+		},
+		{
+			name:     "long source connection ID",
+			modify:   func(s *QUICSpec) { s.InitialPacketSpec.SrcConnIDLength = 21 },
+			field:    "InitialPacketSpec.SrcConnIDLength",
+			expected: ErrValueOutOfRange,
+		},
+		{
+			name:     "packet number length",
+			modify:   func(s *QUICSpec) { s.InitialPacketSpec.InitPacketNumberLength = 5 },
+			field:    "InitialPacketSpec.InitPacketNumberLength",
+			expected: ErrValueOutOfRange,
+		},
+		{
+			name: "inverted bounds",
+			modify: func(s *QUICSpec) {
+				s.InitialPacketSpec.FrameBuilder = &QUICRandomFrames{MinCRYPTO: 5, MaxCRYPTO: 2, MaxPING: 1}
+			},
+			field:    "InitialPacketSpec.FrameBuilder",
+			expected: ErrInvalidFrameBounds,
+		},
+		{
+			name: "empty bounds",
+			modify: func(s *QUICSpec) {
+				s.InitialPacketSpec.FrameBuilder = &QUICRandomFrames{MinCRYPTO: 1, MaxCRYPTO: 2}
+			},
+			field:    "InitialPacketSpec.FrameBuilder",
+			expected: ErrInvalidFrameBounds,
+		},
+		{
+			name: "Length too short",
+			modify: func(s *QUICSpec) {
+				s.InitialPacketSpec.FrameBuilder = &QUICRandomFrames{
+					MaxPING: 1, MinCRYPTO: 1, MaxCRYPTO: 2, MinPADDING: 1, MaxPADDING: 2, Length: 20,
+				}
+			},
+			field:    "InitialPacketSpec.FrameBuilder",
+			expected: ErrFramesTooShort,
+		},
+		{
+			name: "Layout too short",
+			modify: func(s *QUICSpec) {
+				s.InitialPacketSpec.FrameBuilder = &QUICScrambledFrames{
+					SplitSNI: true,
+					Layout:   &QUICRandomFrames{MaxPING: 1, MinCRYPTO: 1, MaxCRYPTO: 2, MinPADDING: 1, MaxPADDING: 2, Length: 30},
+				}
+			},
+			field:    "InitialPacketSpec.FrameBuilder.Layout",
+			expected: ErrFramesTooShort,
+		},
+		{
+			name: "IPv6 Length too short",
+			modify: func(s *QUICSpec) {
+				s.IPv6 = &AddrFamilySpec{RandomFramesLength: 10}
+			},
+			field:    "IPv6.RandomFramesLength",
+			expected: ErrFramesTooShort,
+		},
+		{
+			name: "gap between CRYPTO frames",
+			modify: func(s *QUICSpec) {
+				s.InitialPacketSpec.FrameBuilder = QUICFrames{QUICFrameCrypto{Length: 10}, QUICFrameCrypto{Offset: 20}}
+			},
+			field:    "InitialPacketSpec.FrameBuilder",
+			expected: ErrInvalidCryptoFrames,
+		},
+		{
+			name: "CRYPTO frames not starting at 0",
+			modify: func(s *QUICSpec) {
+				s.InitialPacketSpec.FrameBuilder = QUICFrames{QUICFrameCrypto{Offset: 10}, QUICFramePadding{Length: 10}}
+			},
+			field:    "InitialPacketSpec.FrameBuilder",
+			expected: ErrInvalidCryptoFrames,
+		},
+		{
+			name: "no CRYPTO frame",
+			modify: func(s *QUICSpec) {
+				s.InitialPacketSpec.ClientHelloPackets = []ClientHelloPacketSpec{{FrameBuilder: QUICFrames{QUICFramePing{}}}}
+			},
+			field:    "InitialPacketSpec.ClientHelloPackets[0].FrameBuilder",
+			expected: ErrInvalidCryptoFrames,
+		},
+		{
+			name:     "datagram size",
+			modify:   func(s *QUICSpec) { s.UDPDatagramMinSize = 1500 },
+			field:    "UDPDatagramMinSize",
+			expected: ErrValueOutOfRange,
+		},
+		{
+			name:     "negative ACK delay",
+			modify:   func(s *QUICSpec) { s.PostHandshakeSpec.MaxAckDelay = -time.Millisecond },
+			field:    "PostHandshakeSpec.MaxAckDelay",
+			expected: ErrValueOutOfRange,
+		},
+		{
+			name:     "unsupported version",
+			modify:   func(s *QUICSpec) { s.VersionSpec.Versions = []Version{Version1, 0x1a2a3a4a} },
+			field:    "VersionSpec.Versions[1]",
+			expected: ErrUnsupportedVersion,
+		},
+		{
+			name:     "initial version not offered",
+			modify:   func(s *QUICSpec) { s.VersionSpec.InitialVersion = Version2 },
+			field:    "VersionSpec.InitialVersion",
+			expected: ErrUnsupportedVersion,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			spec, err := testQUICID2Spec(testQUICIDPQ)
+			require.NoError(t, err)
+			tc.modify(&spec)
+			err = spec.Validate()
+			require.ErrorIs(t, err, tc.expected)
+			var specErr *QUICSpecError
+			require.True(t, errors.As(err, &specErr))
+			require.Equal(t, tc.field, specErr.Field)
+		})
+	}
+}
+
+func TestUTransportDialInvalidQUICSpec(t *testing.T) {
+	ln := newUTransportTestServer(t)
+	tlsConf := &tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA(), NextProtos: []string{"h3"}}
+
+	t.Run("Validate", func(t *testing.T) {
+		spec, err := testQUICID2Spec(testQUICIDPQ)
+		require.NoError(t, err)
+		spec.InitialPacketSpec.FrameBuilder = &QUICRandomFrames{MinCRYPTO: 3, MaxCRYPTO: 3, MaxPING: 1}
+		tr := newUTransportWithSpecForTest(t, &spec)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err = tr.Dial(ctx, ln.Addr(), tlsConf, nil)
+		require.ErrorIs(t, err, ErrInvalidFrameBounds)
+	})
+
+	// uTLS fails to apply the ClientHelloSpec
+	t.Run("ClientHelloSpec", func(t *testing.T) {
+		spec, err := testQUICID2Spec(testQUICIDPQ)
+		require.NoError(t, err)
+		chs := *spec.ClientHelloSpec
+		chs.Extensions = append([]tls.TLSExtension{
+			&tls.KeyShareExtension{KeyShares: []tls.KeyShare{{Group: 0x1234}}},
+		}, chs.Extensions...)
+		spec.ClientHelloSpec = &chs
+		tr := newUTransportWithSpecForTest(t, &spec)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err = tr.Dial(ctx, ln.Addr(), tlsConf, nil)
+		require.ErrorIs(t, err, ErrInvalidClientHelloSpec)
+		var specErr *QUICSpecError
+		require.True(t, errors.As(err, &specErr))
+		require.Equal(t, "ClientHelloSpec", specErr.Field)
+
+		// the UTransport is still usable
+		conn, err := tr.DialWithSpec(ctx, ln.Addr(), tlsConf, nil, newUTransportForTest(t, testQUICIDPQ).QUICSpec)
+		require.NoError(t, err)
+		conn.CloseWithError(0, "")
+	})
+}
@@ -0,0 +1,128 @@
+package quic
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"io"
+	"math/rand/v2"
+	"sync"
+
+	tls "github.com/Noooste/utls"
+	"github.com/Noooste/utls/dicttls"
+)
+
+// [UQUIC]
+// specRandMutex serializes reads from the Rand of QUICSpecs, since a QUICSpec may be
+// used to dial multiple connections concurrently.
+var specRandMutex sync.Mutex
+
+type lockedReader struct{ r io.Reader }
+
+func (r lockedReader) Read(b []byte) (int, error) {
+	specRandMutex.Lock()
+	defer specRandMutex.Unlock()
+	return r.r.Read(b)
+}
+
+// rand returns the source of randomness of the QUICSpec: its Rand if set,
+// crypto/rand otherwise.
+func (s *QUICSpec) rand() io.Reader {
+	if s.Rand == nil {
+		return crand.Reader
+	}
+	return lockedReader{r: s.Rand}
+}
+
+// connRand is the source of randomness of a connection dialed with a QUICSpec.
+//
+// If the QUICSpec has a Rand, every connection uses a ChaCha8 generator seeded
+// from it, so that the random values used by a connection don't depend on the
+// timing of the other connections. Otherwise, random bytes are read from
+// crypto/rand, and only the frame layout and the order of the frames use the
+// (cryptographically seeded) generator.
+type connRand struct {
+	*rand.Rand
+	io.Reader
+}
+
+func (s *QUICSpec) newConnRand() (*connRand, error) {
+	chacha, err := newChaCha8(s.rand())
+	if err != nil {
+		return nil, err
+	}
+	r := &connRand{Rand: rand.New(chacha), Reader: chacha}
+	if s.Rand == nil {
+		r.Reader = crand.Reader
+	}
+	return r, nil
+}
+
+// newChaCha8 returns a ChaCha8 generator seeded from r.
+func newChaCha8(r io.Reader) (*rand.ChaCha8, error) {
+	var seed [32]byte
+	if _, err := io.ReadFull(r, seed[:]); err != nil {
+		return nil, err
+	}
+	return rand.NewChaCha8(seed), nil
+}
+
+// tlsRand is the randomness used by uTLS for a connection dialed with a QUICSpec
+// that has a Rand.
+//
+// When using a custom io.Reader, crypto/ecdh reads an extra byte from it half of the
+// time (see randutil.MaybeReadByte), so that callers can't rely on the generated
+// keys being deterministic. Single-byte reads are answered without advancing the
+// connection's randomness, keeping the key shares reproducible.
+type tlsRand struct{ r io.Reader }
+
+func (r tlsRand) Read(b []byte) (int, error) {
+	if len(b) == 1 {
+		b[0] = 0
+		return 1, nil
+	}
+	return r.r.Read(b)
+}
+
+// greaseQUICVersion returns a random reserved QUIC version, of the form 0x?a?a?a?a
+// (see section 15 of RFC 9000), as sent in the version_information transport parameter.
+func greaseQUICVersion(r *connRand) uint32 {
+	return r.Uint32()&0xf0f0f0f0 | 0x0a0a0a0a
+}
+
+// seededGREASEECHExtension returns the GREASE ECH extension sent by a connection
+// dialed with a QUICSpec that has a Rand. uTLS generates the values of GREASE ECH
+// extensions using crypto/rand, so the extension is encoded using r instead, and sent
+// as a tls.GenericExtension. This makes no difference on the wire, since a GREASE
+// ECH extension is never accepted by the server.
+func seededGREASEECHExtension(g *tls.GREASEEncryptedClientHelloExtension, r *connRand) *tls.GenericExtension {
+	suite := tls.HPKESymmetricCipherSuite{KdfId: dicttls.HKDF_SHA256, AeadId: dicttls.AEAD_AES_128_GCM}
+	if len(g.CandidateCipherSuites) > 0 {
+		suite = g.CandidateCipherSuites[r.IntN(len(g.CandidateCipherSuites))]
+	}
+	configID := uint8(r.Uint32())
+	if len(g.CandidateConfigIds) > 0 {
+		configID = g.CandidateConfigIds[r.IntN(len(g.CandidateConfigIds))]
+	}
+	enc := g.EncapsulatedKey
+	if len(enc) == 0 {
+		enc = make([]byte, 32) // the size of an X25519 public key
+		io.ReadFull(r, enc)
+	}
+	payloadLen := 128
+	if len(g.CandidatePayloadLens) > 0 {
+		payloadLen = int(g.CandidatePayloadLens[r.IntN(len(g.CandidatePayloadLens))])
+	}
+	payload := make([]byte, payloadLen+16) // the AEADs used by HPKE have a 16-byte tag
+	io.ReadFull(r, payload)
+
+	b := make([]byte, 0, 10+len(enc)+len(payload))
+	b = append(b, 0) // outer ClientHello
+	b = binary.BigEndian.AppendUint16(b, suite.KdfId)
+	b = binary.BigEndian.AppendUint16(b, suite.AeadId)
+	b = append(b, configID)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(enc)))
+	b = append(b, enc...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(payload)))
+	b = append(b, payload...)
+	return &tls.GenericExtension{Id: 0xfe0d, Data: b}
+}
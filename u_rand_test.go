@@ -0,0 +1,90 @@
+package quic
+
+import (
+	"context"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/testdata"
+	tls "github.com/Noooste/utls"
+
+	"github.com/stretchr/testify/require"
+)
+
+// sendFirstFlight dials with the QUICSpec and returns the datagrams sent before the
+// first retransmission. The datagrams are not delivered to the server.
+func sendFirstFlight(t *testing.T, spec *QUICSpec) [][]byte {
+	t.Helper()
+	ln := newUTransportTestServer(t)
+	recorder := newLossyInitialRecorder(t, ln.Addr(), func(int) bool { return true })
+	tr := newUTransportWithSpecForTest(t, spec)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	tlsConf := &tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA(), NextProtos: []string{"h3"}}
+	_, err := tr.Dial(ctx, recorder.conn.LocalAddr(), tlsConf, nil)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	datagrams := recorder.clientDatagrams()
+	require.NotEmpty(t, datagrams)
+	return datagrams
+}
+
+func TestQUICSpecRand(t *testing.T) {
+	for _, id := range []QUICID{testQUICIDPQ, QUICFirefox_116C, testQUICIDConnID8} {
+		t.Run(id.Client+"_"+id.Version, func(t *testing.T) {
+			newSpec := func(seed byte) *QUICSpec {
+				spec, err := testQUICID2SpecWithRand(id, rand.NewChaCha8([32]byte{seed}))
+				require.NoError(t, err)
+				spec.InitialPacketSpec.ClientTokenLength = 16
+				return &spec
+			}
+
+			datagrams := sendFirstFlight(t, newSpec(1))
+			require.Equal(t, datagrams, sendFirstFlight(t, newSpec(1)))
+			require.NotEqual(t, datagrams, sendFirstFlight(t, newSpec(2)))
+		})
+	}
+}
+
+func TestQUICSpecRandRedial(t *testing.T) {
+	spec, err := testQUICID2SpecWithRand(testQUICIDPQ, rand.NewChaCha8([32]byte{1}))
+	require.NoError(t, err)
+	// every connection uses different random values
+	require.NotEqual(t, sendFirstFlight(t, &spec), sendFirstFlight(t, &spec))
+}
+
+func TestQUICRandomFramesSeeded(t *testing.T) {
+	qrf := &QUICRandomFrames{
+		MinPING: 0, MaxPING: 10,
+		MinCRYPTO: 1, MaxCRYPTO: 10,
+		MinPADDING: 3, MaxPADDING: 6,
+		Length: 1200,
+	}
+	cryptoData := make([]byte, 500)
+	build := func(seed byte) []byte {
+		payload, err := qrf.buildAt(rand.New(rand.NewChaCha8([32]byte{seed})), 0, cryptoData)
+		require.NoError(t, err)
+		require.Len(t, payload, 1200)
+		return payload
+	}
+	require.Equal(t, build(1), build(1))
+	require.NotEqual(t, build(1), build(2))
+}
+
+func TestGREASEQUICVersion(t *testing.T) {
+	r := &connRand{Rand: rand.New(rand.NewChaCha8([32]byte{1}))}
+	versions := make(map[uint32]struct{})
+	for range 1000 {
+		v := greaseQUICVersion(r)
+		require.Equal(t, uint32(0x0a0a0a0a), v&0x0f0f0f0f, "not a reserved version: %#x", v)
+		versions[v] = struct{}{}
+	}
+	require.Greater(t, len(versions), 900)
+
+	vi := newVersionInformation(&tls.VersionInformation{
+		ChoosenVersion:    uint32(Version1),
+		AvailableVersions: []uint32{tls.VERSION_GREASE, uint32(Version1)},
+	}, r)
+	require.Equal(t, uint32(0x0a0a0a0a), vi.AvailableVersions[0]&0x0f0f0f0f)
+	require.Equal(t, uint32(Version1), vi.AvailableVersions[1])
+}
@@ -0,0 +1,263 @@
+package quic
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	mrand "math/rand/v2"
+	"slices"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// [UQUIC]
+// CryptoFrameOrder is the order in which a QUICScrambledFrames sends the CRYPTO frames
+// carrying the ClientHello.
+type CryptoFrameOrder uint8
+
+const (
+	// CryptoFrameOrderSequential sends the CRYPTO frames in the order of their offsets.
+	CryptoFrameOrderSequential CryptoFrameOrder = iota
+	// CryptoFrameOrderReversed sends the CRYPTO frames in the reverse order of their offsets.
+	CryptoFrameOrderReversed
+	// CryptoFrameOrderShuffled sends the CRYPTO frames in a random order.
+	CryptoFrameOrderShuffled
+	// CryptoFrameOrderEvenOdd sends the first, third, fifth, ... CRYPTO frames, followed
+	// by the second, fourth, ... CRYPTO frames. Combined with SplitSNI and SplitECH, the
+	// second half of the host name and the part following the cut in the ECH extension
+	// are sent last.
+	CryptoFrameOrderEvenOdd
+)
+
+// [UQUIC]
+// QUICScrambledFrames cuts the ClientHello into CRYPTO frames at chosen points, such as
+// in the middle of the server name, and sends these CRYPTO frames out of order. This
+// defeats middleboxes that look for the server name without reassembling the crypto
+// stream.
+//
+// quic-go scrambles the ClientHello of clients that don't use a QUICSpec in the same
+// way as
+//
+//	&QUICScrambledFrames{SplitSNI: true, SplitECH: true, Order: CryptoFrameOrderEvenOdd}
+//
+// If the ClientHello spans multiple packets, the CRYPTO frames of every packet are
+// cut and ordered, but only the first packet gets the PING and PADDING frames of the
+// Layout, unless InitialPacketSpec.ClientHelloPackets specifies other frame builders.
+// When calling Build directly, the cut points are located in the crypto data passed to
+// it, i.e. the beginning of the ClientHello.
+type QUICScrambledFrames struct {
+	// SplitSNI cuts the host name of the server_name extension in its middle and at
+	// its end.
+	SplitSNI bool
+
+	// SplitECH cuts the type of the encrypted_client_hello extension in half, and
+	// cuts again 16 bytes later, most likely in the value of the extension.
+	SplitECH bool
+
+	// Offsets are additional cut points, relative to the beginning of the ClientHello.
+	// Offsets beyond the end of the ClientHello are ignored.
+	Offsets []int
+
+	// Order is the order in which the CRYPTO frames are sent.
+	Order CryptoFrameOrder
+
+	// Layout adds PING and PADDING frames to the first packet as the QUICRandomFrames
+	// does, e.g. using the FrameBuilder of a parrot. The PING and PADDING frames are
+	// inserted at random positions, without changing the order of the CRYPTO frames.
+	// If the Layout calls for more CRYPTO frames than the cut points produce, the
+	// ClientHello is cut at additional random points.
+	//
+	// If nil, only CRYPTO frames are sent.
+	Layout *QUICRandomFrames
+}
+
+// Build ingests data from crypto frames without the crypto frame header
+// and returns the byte representation of all frames.
+func (sf *QUICScrambledFrames) Build(cryptoData []byte) (payload []byte, err error) {
+	chacha, err := newChaCha8(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return sf.buildAt(mrand.New(chacha), 0, cryptoData, sf.cutPoints(cryptoData))
+}
+
+// buildAt is like Build, for crypto data starting at the given offset of the crypto
+// stream. The crypto data is cut at the cut points (offsets in the crypto stream)
+// within it. Random choices are made using rnd.
+func (sf *QUICScrambledFrames) buildAt(rnd *mrand.Rand, cryptoOffset int, cryptoData []byte, cuts []int) ([]byte, error) {
+	if err := sf.validate(); err != nil {
+		return nil, err
+	}
+
+	// make the cut points relative to the crypto data
+	var relCuts []int
+	for _, cut := range cuts {
+		if cut > cryptoOffset && cut < cryptoOffset+len(cryptoData) {
+			relCuts = append(relCuts, cut-cryptoOffset)
+		}
+	}
+	if sf.Layout != nil && len(cryptoData) > 1 {
+		numCRYPTO := int(sf.Layout.MinCRYPTO) + rnd.IntN(int(sf.Layout.MaxCRYPTO-sf.Layout.MinCRYPTO))
+		for len(relCuts)+1 < numCRYPTO && len(relCuts) < len(cryptoData)-1 {
+			cut := 1 + rnd.IntN(len(cryptoData)-1)
+			if i, found := slices.BinarySearch(relCuts, cut); !found {
+				relCuts = slices.Insert(relCuts, i, cut)
+			}
+		}
+	}
+
+	bounds := append([]int{0}, relCuts...)
+	bounds = append(bounds, len(cryptoData))
+	frameList := make(QUICFrames, 0, len(bounds)-1)
+	for i := range len(bounds) - 1 {
+		frameList = append(frameList, QUICFrameCrypto{Offset: cryptoOffset + bounds[i], Length: bounds[i+1] - bounds[i]})
+	}
+
+	switch sf.Order {
+	case CryptoFrameOrderReversed:
+		slices.Reverse(frameList)
+	case CryptoFrameOrderShuffled:
+		rnd.Shuffle(len(frameList), func(i, j int) {
+			frameList[i], frameList[j] = frameList[j], frameList[i]
+		})
+	case CryptoFrameOrderEvenOdd:
+		ordered := make(QUICFrames, 0, len(frameList))
+		for i := 0; i < len(frameList); i += 2 {
+			ordered = append(ordered, frameList[i])
+		}
+		for i := 1; i < len(frameList); i += 2 {
+			ordered = append(ordered, frameList[i])
+		}
+		frameList = ordered
+	}
+
+	if sf.Layout == nil {
+		return frameList.Build(cryptoData)
+	}
+
+	qrf := sf.Layout
+	var otherFrames []QUICFrame
+	numPING := uint64(qrf.MinPING) + rnd.Uint64N(uint64(qrf.MaxPING-qrf.MinPING))
+	for range numPING {
+		otherFrames = append(otherFrames, QUICFramePing{})
+	}
+	dryrunPayload, err := frameList.Build(cryptoData)
+	if err != nil {
+		return nil, err
+	}
+	// determine length of PADDING frames to append
+	lenPADDINGsigned := int64(qrf.Length) - int64(len(dryrunPayload)) - int64(numPING)
+	if lenPADDINGsigned > 0 {
+		otherFrames = append(otherFrames, qrf.paddingFrames(rnd, uint64(lenPADDINGsigned))...)
+	}
+	for _, frame := range otherFrames {
+		frameList = slices.Insert(frameList, rnd.IntN(len(frameList)+1), frame)
+	}
+	return frameList.Build(cryptoData)
+}
+
+func (sf *QUICScrambledFrames) validate() error {
+	if sf.Order > CryptoFrameOrderEvenOdd {
+		return fmt.Errorf("unknown CRYPTO frame order %d", sf.Order)
+	}
+	for _, offset := range sf.Offsets {
+		if offset < 0 {
+			return errors.New("Offsets must not be negative")
+		}
+	}
+	if sf.Layout != nil {
+		return sf.Layout.validate()
+	}
+	return nil
+}
+
+// cutPoints returns the sorted offsets at which a ClientHello is cut.
+// The ClientHello may be truncated, see locateSNIAndECH.
+func (sf *QUICScrambledFrames) cutPoints(clientHello []byte) []int {
+	cuts := slices.Clone(sf.Offsets)
+	if sf.SplitSNI || sf.SplitECH {
+		sniPos, sniLen, echPos := locateSNIAndECH(clientHello)
+		if sf.SplitSNI && sniPos != -1 {
+			cuts = append(cuts, sniPos+sniLen/2, sniPos+sniLen)
+		}
+		if sf.SplitECH && echPos != -1 {
+			cuts = append(cuts, echPos+1, echPos+17)
+		}
+	}
+	slices.Sort(cuts)
+	return slices.Compact(cuts)
+}
+
+// maxFrameOverhead returns the maximum number of bytes added to a single CRYPTO
+// frame carrying the same data when it is cut and mixed with PING frames.
+func (sf *QUICScrambledFrames) maxFrameOverhead() int {
+	numCuts := len(sf.Offsets)
+	if sf.SplitSNI {
+		numCuts += 2
+	}
+	if sf.SplitECH {
+		numCuts += 2
+	}
+	overhead := numCuts * 5 // type, 2-byte offset and 2-byte length
+	if sf.Layout != nil {
+		overhead += sf.Layout.maxFrameOverhead()
+	}
+	return overhead
+}
+
+// locateSNIAndECH locates the host name in the server_name extension and the
+// encrypted_client_hello extension of a ClientHello, like findSNIAndECH. Unlike
+// findSNIAndECH, it accepts the beginning of a ClientHello spanning multiple packets,
+// and only considers the extensions entirely contained in data.
+// It returns -1 for the positions that were not found.
+func locateSNIAndECH(data []byte) (sniPos, sniLen, echPos int) {
+	sniPos, echPos = -1, -1
+	// cryptobyte.String values are sub-slices of data,
+	// so their position in data can be derived from their capacity
+	pos := func(b cryptobyte.String) int { return cap(data) - cap(b) }
+
+	s := cryptobyte.String(data)
+	var (
+		msgType, legacySessionIDLen uint8
+		msgLen                      uint32
+		cipherSuites, compression   cryptobyte.String
+	)
+	if !s.ReadUint8(&msgType) || msgType != 1 || // ClientHello
+		!s.ReadUint24(&msgLen) ||
+		!s.Skip(2+32) || // legacy_version and random
+		!s.ReadUint8(&legacySessionIDLen) || !s.Skip(int(legacySessionIDLen)) ||
+		!s.ReadUint16LengthPrefixed(&cipherSuites) ||
+		!s.ReadUint8LengthPrefixed(&compression) ||
+		!s.Skip(2) { // length of the extensions
+		return sniPos, sniLen, echPos
+	}
+	for sniPos == -1 || echPos == -1 {
+		extPos := pos(s)
+		var extType uint16
+		var ext cryptobyte.String
+		if !s.ReadUint16(&extType) || !s.ReadUint16LengthPrefixed(&ext) {
+			break
+		}
+		switch extType {
+		case extTypeSNI:
+			var names cryptobyte.String
+			if !ext.ReadUint16LengthPrefixed(&names) {
+				continue
+			}
+			for !names.Empty() {
+				var nameType uint8
+				var name cryptobyte.String
+				if !names.ReadUint8(&nameType) || !names.ReadUint16LengthPrefixed(&name) {
+					break
+				}
+				if nameType == 0 { // host_name
+					sniPos, sniLen = pos(name), len(name)
+					break
+				}
+			}
+		case extTypeECH:
+			echPos = extPos
+		}
+	}
+	return sniPos, sniLen, echPos
+}
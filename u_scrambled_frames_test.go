@@ -0,0 +1,271 @@
+package quic
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math/rand/v2"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/protocol"
+	"github.com/Noooste/uquic-go/internal/testdata"
+	tls "github.com/Noooste/utls"
+	"github.com/gaukas/clienthellod"
+
+	"github.com/stretchr/testify/require"
+)
+
+// dryRunClientHello returns the ClientHello sent by a testQUICIDPQConnID3 client,
+// which offers GREASE ECH.
+func dryRunClientHello(t *testing.T, serverName string) []byte {
+	t.Helper()
+	spec, err := testQUICID2Spec(testQUICIDPQConnID3)
+	require.NoError(t, err)
+	res, err := spec.DryRun(
+		context.Background(),
+		&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 443},
+		&tls.Config{ServerName: serverName, NextProtos: []string{"h3"}},
+		nil,
+	)
+	require.NoError(t, err)
+	return res.ClientHello
+}
+
+type builtFrames struct {
+	crypto     []*clienthellod.CRYPTO
+	numPING    int
+	numPADDING int
+	length     int
+}
+
+func parseBuiltFrames(t *testing.T, payload []byte) builtFrames {
+	t.Helper()
+	frames, err := parseInitialFrames(payload)
+	require.NoError(t, err)
+	bf := builtFrames{length: len(payload)}
+	for _, f := range frames {
+		switch f := f.(type) {
+		case *clienthellod.CRYPTO:
+			bf.crypto = append(bf.crypto, f)
+		case *clienthellod.PING:
+			bf.numPING++
+		case *clienthellod.PADDING:
+			bf.numPADDING++
+		}
+	}
+	return bf
+}
+
+// requireReassembles checks that the CRYPTO frames cover the crypto data exactly once.
+func requireReassembles(t *testing.T, frames []*clienthellod.CRYPTO, offset int, cryptoData []byte) {
+	t.Helper()
+	reassembled := make([]byte, len(cryptoData))
+	covered := make([]bool, len(cryptoData))
+	for _, f := range frames {
+		start := int(f.Offset) - offset
+		for i, b := range f.Data {
+			require.False(t, covered[start+i], "overlapping CRYPTO frames")
+			covered[start+i] = true
+			reassembled[start+i] = b
+		}
+	}
+	require.Equal(t, cryptoData, reassembled)
+}
+
+func TestLocateSNIAndECH(t *testing.T) {
+	clientHello := dryRunClientHello(t, "example.com")
+	sniPos, sniLen, echPos := locateSNIAndECH(clientHello)
+	require.Equal(t, "example.com", string(clientHello[sniPos:sniPos+sniLen]))
+	require.Equal(t, uint16(extTypeECH), binary.BigEndian.Uint16(clientHello[echPos:]))
+
+	expectedSNIPos, expectedSNILen, expectedECHPos, err := findSNIAndECH(clientHello)
+	require.NoError(t, err)
+	require.Equal(t, expectedSNIPos, sniPos)
+	require.Equal(t, expectedSNILen, sniLen)
+	require.Equal(t, expectedECHPos, echPos)
+
+	t.Run("truncated ClientHello", func(t *testing.T) {
+		// the server_name extension is cut off
+		sniPos, _, _ := locateSNIAndECH(clientHello[:expectedSNIPos+expectedSNILen-1])
+		require.Equal(t, -1, sniPos)
+		// the server_name extension is complete
+		sniPos, _, _ = locateSNIAndECH(clientHello[:expectedSNIPos+expectedSNILen])
+		require.Equal(t, expectedSNIPos, sniPos)
+	})
+
+	t.Run("not a ClientHello", func(t *testing.T) {
+		sniPos, _, echPos := locateSNIAndECH([]byte{2, 0, 0, 0})
+		require.Equal(t, -1, sniPos)
+		require.Equal(t, -1, echPos)
+	})
+}
+
+func TestQUICScrambledFramesMatchesQUICGo(t *testing.T) {
+	t.Setenv(disableClientHelloScramblingEnv, "false")
+	clientHello := dryRunClientHello(t, "example.com")
+
+	// the frames sent by the initialCryptoStream of a client not using a QUICSpec
+	str := newInitialCryptoStream(true)
+	_, err := str.Write(clientHello)
+	require.NoError(t, err)
+	var expected [][2]int
+	for str.HasData() {
+		f := str.PopCryptoFrame(protocol.MaxByteCount)
+		require.NotNil(t, f)
+		expected = append(expected, [2]int{int(f.Offset), len(f.Data)})
+	}
+	require.Len(t, expected, 5) // 2 cuts at the SNI, 2 cuts at the ECH extension
+
+	sf := &QUICScrambledFrames{SplitSNI: true, SplitECH: true, Order: CryptoFrameOrderEvenOdd}
+	payload, err := sf.Build(clientHello)
+	require.NoError(t, err)
+	var actual [][2]int
+	for _, f := range parseBuiltFrames(t, payload).crypto {
+		actual = append(actual, [2]int{int(f.Offset), len(f.Data)})
+	}
+	require.Equal(t, expected, actual)
+}
+
+// The ClientHello of a QUICSpec is only scrambled by its FrameBuilder.
+func TestQUICSpecClientHelloNotScrambled(t *testing.T) {
+	t.Setenv(disableClientHelloScramblingEnv, "false")
+	spec, err := QUICID2Spec(QUICFirefox_116)
+	require.NoError(t, err)
+	res, err := spec.DryRun(
+		context.Background(),
+		&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 443},
+		&tls.Config{ServerName: "example.com", NextProtos: []string{"h3"}},
+		nil,
+	)
+	require.NoError(t, err)
+	require.Len(t, res.Datagrams, 1)
+	frames := res.Datagrams[0].Packets[0].Frames
+	require.NotEmpty(t, frames)
+	require.Equal(t, QUICFrameCrypto{Offset: 0, Length: len(res.ClientHello)}, frames[0])
+	for _, f := range frames[1:] {
+		require.IsType(t, QUICFramePadding{}, f)
+	}
+}
+
+func TestQUICScrambledFramesOrder(t *testing.T) {
+	cryptoData := make([]byte, 100)
+	for i := range cryptoData {
+		cryptoData[i] = byte(i)
+	}
+	offsetsOf := func(frames []*clienthellod.CRYPTO) []uint64 {
+		var offsets []uint64
+		for _, f := range frames {
+			offsets = append(offsets, f.Offset)
+		}
+		return offsets
+	}
+
+	for _, tc := range []struct {
+		order    CryptoFrameOrder
+		expected []uint64
+	}{
+		{CryptoFrameOrderSequential, []uint64{1000, 1010, 1020, 1050}},
+		{CryptoFrameOrderReversed, []uint64{1050, 1020, 1010, 1000}},
+		{CryptoFrameOrderEvenOdd, []uint64{1000, 1020, 1010, 1050}},
+	} {
+		// offsets out of range and duplicates are ignored
+		sf := &QUICScrambledFrames{Offsets: []int{50, 10, 20, 10, 0, 100, 200}, Order: tc.order}
+		payload, err := sf.buildAt(rand.New(rand.NewPCG(1, 2)), 1000, cryptoData, clientHelloCutPoints(sf, 1000, cryptoData))
+		require.NoError(t, err)
+		frames := parseBuiltFrames(t, payload)
+		require.Equal(t, tc.expected, offsetsOf(frames.crypto))
+		requireReassembles(t, frames.crypto, 1000, cryptoData)
+	}
+
+	sf := &QUICScrambledFrames{Offsets: []int{10, 20, 30, 40, 50, 60, 70, 80, 90}, Order: CryptoFrameOrderShuffled}
+	payload, err := sf.buildAt(rand.New(rand.NewPCG(1, 2)), 0, cryptoData, sf.cutPoints(cryptoData))
+	require.NoError(t, err)
+	frames := parseBuiltFrames(t, payload)
+	require.Len(t, frames.crypto, 10)
+	require.NotEqual(t, []uint64{0, 10, 20, 30, 40, 50, 60, 70, 80, 90}, offsetsOf(frames.crypto))
+	requireReassembles(t, frames.crypto, 0, cryptoData)
+
+	_, err = (&QUICScrambledFrames{Order: CryptoFrameOrderEvenOdd + 1}).Build(cryptoData)
+	require.ErrorContains(t, err, "unknown CRYPTO frame order")
+	_, err = (&QUICScrambledFrames{Offsets: []int{-1}}).Build(cryptoData)
+	require.ErrorContains(t, err, "must not be negative")
+}
+
+func TestQUICScrambledFramesLayout(t *testing.T) {
+	clientHello := dryRunClientHello(t, "example.com")
+	sniPos, _, _ := locateSNIAndECH(clientHello)
+	sf := &QUICScrambledFrames{
+		SplitSNI: true,
+		Order:    CryptoFrameOrderReversed,
+		Layout: &QUICRandomFrames{
+			MinPING: 1, MaxPING: 3,
+			MinCRYPTO: 6, MaxCRYPTO: 7,
+			MinPADDING: 2, MaxPADDING: 3,
+			Length: 2000,
+		},
+	}
+	for i := range 20 {
+		payload, err := sf.buildAt(rand.New(rand.NewPCG(uint64(i), 0)), 0, clientHello, sf.cutPoints(clientHello))
+		require.NoError(t, err)
+		frames := parseBuiltFrames(t, payload)
+		require.Equal(t, 2000, frames.length)
+		require.GreaterOrEqual(t, frames.numPING, 1)
+		require.LessOrEqual(t, frames.numPING, 2)
+		require.NotZero(t, frames.numPADDING) // adjacent PADDING frames can't be told apart
+		require.Len(t, frames.crypto, 6)      // 3 frames from the SNI cuts, 3 from random cuts
+		requireReassembles(t, frames.crypto, 0, clientHello)
+		for i := 1; i < len(frames.crypto); i++ {
+			require.Less(t, frames.crypto[i].Offset, frames.crypto[i-1].Offset)
+		}
+		for _, f := range frames.crypto {
+			require.False(t, bytes.Contains(f.Data, []byte("example.com")))
+		}
+		// the host name is cut in its middle
+		require.Condition(t, func() bool {
+			for _, f := range frames.crypto {
+				if int(f.Offset) == sniPos+len("example.com")/2 {
+					return true
+				}
+			}
+			return false
+		})
+	}
+}
+
+func TestUTransportScrambledFrames(t *testing.T) {
+	spec, err := testQUICID2Spec(testQUICIDPQ)
+	require.NoError(t, err)
+	layout := spec.InitialPacketSpec.FrameBuilder.(*QUICRandomFrames)
+	spec.InitialPacketSpec.FrameBuilder = &QUICScrambledFrames{
+		SplitSNI: true,
+		SplitECH: true,
+		Order:    CryptoFrameOrderShuffled,
+		Layout:   layout,
+	}
+
+	ln := newUTransportTestServer(t)
+	tr := newUTransportWithSpecForTest(t, &spec)
+	recorder := newInitialRecorder(t, ln.Addr())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tlsConf := &tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA(), NextProtos: []string{"h3"}}
+	conn, err := tr.Dial(ctx, recorder.conn.LocalAddr(), tlsConf, nil)
+	require.NoError(t, err)
+	defer conn.CloseWithError(0, "")
+
+	// the ClientHello spans two packets, the server name may be in either of them
+	datagrams := recorder.clientDatagrams()
+	packets := decryptClientInitials(t, datagrams)
+	require.NotEmpty(t, packets)
+	first := packets[0]
+	require.True(t, first.startsClientHello())
+	require.Equal(t, int(layout.Length), first.payloadLen)
+	require.GreaterOrEqual(t, len(datagrams[0]), spec.UDPDatagramMinSize)
+	for _, p := range packets {
+		for _, f := range p.cryptoFrames {
+			require.False(t, bytes.Contains(f.Data, []byte("localhost")))
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package quic
+
+import (
+	"errors"
+
+	"github.com/Noooste/uquic-go/internal/ackhandler"
+	"github.com/Noooste/uquic-go/internal/handshake"
+	"github.com/Noooste/uquic-go/internal/monotime"
+	"github.com/Noooste/uquic-go/internal/protocol"
+	"github.com/Noooste/uquic-go/internal/wire"
+)
+
+// [UQUIC]
+// uServerPacketPacker is an extended packetPacker packing the server's first flight
+// according to a ServerSpec.
+type uServerPacketPacker struct {
+	*packetPacker
+
+	spec *ServerSpec
+}
+
+func newUServerPacketPacker(packetPacker *packetPacker, spec *ServerSpec) *uServerPacketPacker {
+	return &uServerPacketPacker{packetPacker: packetPacker, spec: spec}
+}
+
+// PackCoalescedPacket packs a new packet.
+// It packs an Initial / Handshake if there is data to send in these packet number spaces.
+// It should only be called before the handshake is confirmed.
+func (p *uServerPacketPacker) PackCoalescedPacket(onlyAck bool, maxSize protocol.ByteCount, now monotime.Time, v protocol.Version) (*coalescedPacket, error) {
+	// [UQUIC] see ServerSpec.MaxDatagramSize
+	if p.spec.MaxDatagramSize > 0 {
+		maxSize = min(maxSize, protocol.ByteCount(p.spec.MaxDatagramSize))
+	}
+	var (
+		initialHdr, handshakeHdr                        *wire.ExtendedHeader
+		initialPayload, handshakePayload, oneRTTPayload payload
+		oneRTTPacketNumber                              protocol.PacketNumber
+		oneRTTPacketNumberLen                           protocol.PacketNumberLen
+	)
+	// Try packing an Initial packet.
+	initialSealer, err := p.cryptoSetup.GetInitialSealer()
+	if err != nil && err != handshake.ErrKeysDropped {
+		return nil, err
+	}
+	var size protocol.ByteCount
+	if initialSealer != nil {
+		initialHdr, initialPayload = p.maybeGetCryptoPacket(
+			maxSize-protocol.ByteCount(initialSealer.Overhead()),
+			protocol.EncryptionInitial,
+			now,
+			false,
+			onlyAck,
+			v,
+		)
+		if initialPayload.length > 0 {
+			size += p.longHeaderPacketLength(initialHdr, initialPayload, v) + protocol.ByteCount(initialSealer.Overhead())
+		}
+	}
+
+	// [UQUIC] see ServerSpec.SeparateInitial
+	coalesce := initialPayload.length == 0 || !p.spec.SeparateInitial
+
+	// Add a Handshake packet.
+	var handshakeSealer sealer
+	if coalesce && ((onlyAck && size == 0) || (!onlyAck && size < maxSize-protocol.MinCoalescedPacketSize)) { // [UQUIC]
+		var err error
+		handshakeSealer, err = p.cryptoSetup.GetHandshakeSealer()
+		if err != nil && err != handshake.ErrKeysDropped && err != handshake.ErrKeysNotYetAvailable {
+			return nil, err
+		}
+		if handshakeSealer != nil {
+			handshakeHdr, handshakePayload = p.maybeGetCryptoPacket(
+				maxSize-size-protocol.ByteCount(handshakeSealer.Overhead()),
+				protocol.EncryptionHandshake,
+				now,
+				false,
+				onlyAck,
+				v,
+			)
+			if handshakePayload.length > 0 {
+				size += p.longHeaderPacketLength(handshakeHdr, handshakePayload, v) + protocol.ByteCount(handshakeSealer.Overhead())
+			}
+		}
+	}
+
+	// Add a 1-RTT packet. The server doesn't send 0-RTT packets.
+	var oneRTTSealer handshake.ShortHeaderSealer
+	var connID protocol.ConnectionID
+	var kp protocol.KeyPhaseBit
+	if coalesce && ((onlyAck && size == 0) || (!onlyAck && size < maxSize-protocol.MinCoalescedPacketSize)) { // [UQUIC]
+		var err error
+		oneRTTSealer, err = p.cryptoSetup.Get1RTTSealer()
+		if err != nil && !errors.Is(err, handshake.ErrKeysDropped) && !errors.Is(err, handshake.ErrKeysNotYetAvailable) {
+			return nil, err
+		}
+		if err == nil {
+			kp = oneRTTSealer.KeyPhase()
+			connID = p.getDestConnID()
+			oneRTTPacketNumber, oneRTTPacketNumberLen = p.pnManager.PeekPacketNumber(protocol.Encryption1RTT)
+			hdrLen := wire.ShortHeaderLen(connID, oneRTTPacketNumberLen)
+			oneRTTPayload = p.maybeGetShortHeaderPacket(oneRTTSealer, hdrLen, maxSize-size, onlyAck, now, v)
+			if oneRTTPayload.length > 0 {
+				size += p.shortHeaderPacketLength(connID, oneRTTPacketNumberLen, oneRTTPayload) + protocol.ByteCount(oneRTTSealer.Overhead())
+			}
+		}
+	}
+
+	if initialPayload.length == 0 && handshakePayload.length == 0 && oneRTTPayload.length == 0 {
+		return nil, nil
+	}
+
+	// [UQUIC] only datagrams carrying ack-eliciting Initial packets need to be padded
+	var padTo protocol.ByteCount
+	if initialPayload.length > 0 && ackhandler.HasAckElicitingFrames(initialPayload.frames) {
+		padTo = maxSize
+		if p.spec.InitialDatagramSize > 0 {
+			padTo = min(padTo, protocol.ByteCount(p.spec.InitialDatagramSize))
+		}
+	}
+
+	buffer := getPacketBuffer()
+	packet := &coalescedPacket{
+		buffer:         buffer,
+		longHdrPackets: make([]*longHeaderPacket, 0, 2),
+	}
+	if initialPayload.length > 0 {
+		var padding protocol.ByteCount
+		if !p.spec.PadWithZeros && size < padTo {
+			padding = padTo - size
+		}
+		cont, err := p.appendLongHeaderPacket(buffer, initialHdr, initialPayload, padding, protocol.EncryptionInitial, initialSealer, v)
+		if err != nil {
+			return nil, err
+		}
+		packet.longHdrPackets = append(packet.longHdrPackets, cont)
+	}
+	if handshakePayload.length > 0 {
+		cont, err := p.appendLongHeaderPacket(buffer, handshakeHdr, handshakePayload, 0, protocol.EncryptionHandshake, handshakeSealer, v)
+		if err != nil {
+			return nil, err
+		}
+		packet.longHdrPackets = append(packet.longHdrPackets, cont)
+	}
+	if oneRTTPayload.length > 0 {
+		shp, err := p.appendShortHeaderPacket(buffer, connID, oneRTTPacketNumber, oneRTTPacketNumberLen, kp, oneRTTPayload, 0, maxSize, oneRTTSealer, false, v)
+		if err != nil {
+			return nil, err
+		}
+		packet.shortHdrPacket = &shp
+	}
+	// [UQUIC] see ServerSpec.PadWithZeros
+	if p.spec.PadWithZeros && protocol.ByteCount(len(buffer.Data)) < padTo {
+		buffer.Data = append(buffer.Data, make([]byte, int(padTo)-len(buffer.Data))...)
+	}
+	return packet, nil
+}
+
+// PackPTOProbePacket packs a probe packet.
+// Initial and Handshake probe packets are sized and padded like the rest of the first
+// flight, see ServerSpec.
+func (p *uServerPacketPacker) PackPTOProbePacket(
+	encLevel protocol.EncryptionLevel,
+	maxPacketSize protocol.ByteCount,
+	addPingIfEmpty bool,
+	now monotime.Time,
+	v protocol.Version,
+) (*coalescedPacket, error) {
+	if encLevel == protocol.Encryption1RTT {
+		return p.packetPacker.PackPTOProbePacket(encLevel, maxPacketSize, addPingIfEmpty, now, v)
+	}
+	// [UQUIC] see ServerSpec.MaxDatagramSize
+	if p.spec.MaxDatagramSize > 0 {
+		maxPacketSize = min(maxPacketSize, protocol.ByteCount(p.spec.MaxDatagramSize))
+	}
+
+	var sealer handshake.LongHeaderSealer
+	//nolint:exhaustive // Probe packets are never sent for 0-RTT.
+	switch encLevel {
+	case protocol.EncryptionInitial:
+		var err error
+		sealer, err = p.cryptoSetup.GetInitialSealer()
+		if err != nil {
+			return nil, err
+		}
+	case protocol.EncryptionHandshake:
+		var err error
+		sealer, err = p.cryptoSetup.GetHandshakeSealer()
+		if err != nil {
+			return nil, err
+		}
+	default:
+		panic("unknown encryption level")
+	}
+	hdr, pl := p.maybeGetCryptoPacket(
+		maxPacketSize-protocol.ByteCount(sealer.Overhead()),
+		encLevel,
+		now,
+		addPingIfEmpty,
+		false,
+		v,
+	)
+	if pl.length == 0 {
+		return nil, nil
+	}
+
+	// [UQUIC] only datagrams carrying ack-eliciting Initial packets need to be padded
+	var padTo protocol.ByteCount
+	if encLevel == protocol.EncryptionInitial && ackhandler.HasAckElicitingFrames(pl.frames) {
+		padTo = maxPacketSize
+		if p.spec.InitialDatagramSize > 0 {
+			padTo = min(padTo, protocol.ByteCount(p.spec.InitialDatagramSize))
+		}
+	}
+	buffer := getPacketBuffer()
+	packet := &coalescedPacket{buffer: buffer}
+	size := p.longHeaderPacketLength(hdr, pl, v) + protocol.ByteCount(sealer.Overhead())
+	var padding protocol.ByteCount
+	if !p.spec.PadWithZeros && size < padTo {
+		padding = padTo - size
+	}
+	longHdrPacket, err := p.appendLongHeaderPacket(buffer, hdr, pl, padding, encLevel, sealer, v)
+	if err != nil {
+		return nil, err
+	}
+	packet.longHdrPackets = []*longHeaderPacket{longHdrPacket}
+	// [UQUIC] see ServerSpec.PadWithZeros
+	if p.spec.PadWithZeros && protocol.ByteCount(len(buffer.Data)) < padTo {
+		buffer.Data = append(buffer.Data, make([]byte, int(padTo)-len(buffer.Data))...)
+	}
+	return packet, nil
+}
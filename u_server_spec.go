@@ -0,0 +1,108 @@
+package quic
+
+import (
+	"github.com/Noooste/uquic-go/internal/protocol"
+	"github.com/Noooste/uquic-go/internal/wire"
+)
+
+// [UQUIC]
+// ServerSpec is the server counterpart of a QUICSpec. It specifies how the server builds
+// its first flight, the Initial and Handshake packets answering the ClientHello, such
+// that it resembles a given server implementation.
+//
+// It is set on the Config passed to Listen or ListenEarly, and can be chosen for each
+// client by returning a Config with a different ServerSpec from GetConfigForClient.
+// The zero value keeps the default behavior of quic-go.
+type ServerSpec struct {
+	// ConnIDLength is the length of the connection IDs chosen by the server. Since the
+	// length of the connection ID is not encoded in short header packets, all
+	// connections of a Transport use connection IDs of the same length: it is used by
+	// the Transport if its ConnectionIDLength and ConnectionIDGenerator are not set,
+	// unless the Transport is already in use, and a
+	// ServerSpec returned by GetConfigForClient must use the same length, or the
+	// connection is refused. If 0, the length of the Transport is used.
+	ConnIDLength int
+
+	// MaxDatagramSize limits the size of the datagrams of the first flight. If 0,
+	// the datagrams are as large as the path allows, see Config.InitialPacketSize.
+	MaxDatagramSize int
+
+	// InitialDatagramSize is the size that the datagrams carrying ack-eliciting Initial
+	// packets are padded to. If 0, they are padded to the size of the largest datagram.
+	InitialDatagramSize int
+
+	// PadWithZeros pads the datagrams carrying Initial packets with zeros after the QUIC
+	// packets, instead of PADDING frames in the Initial packet.
+	PadWithZeros bool
+
+	// SeparateInitial sends the Initial packets in their own datagrams, instead of
+	// coalescing them with the Handshake packets carrying the beginning of the server's
+	// certificate.
+	SeparateInitial bool
+
+	// TransportParameterOrder lists the IDs of the transport parameters sent first, in
+	// this order. Any GREASE ID (27 + 31*N) stands for the GREASE transport parameter
+	// sent by quic-go. The transport parameters that aren't listed follow in the
+	// default order.
+	TransportParameterOrder []uint64
+
+	// AdditionalTransportParameters are sent in addition to the transport parameters
+	// of quic-go, by ID, and are positioned by TransportParameterOrder. Those that
+	// aren't listed are sent last, by increasing ID. Parameters with the ID of a
+	// transport parameter sent by quic-go are ignored. The values of the transport
+	// parameters of quic-go are set by the Config.
+	AdditionalTransportParameters map[uint64][]byte
+
+	// DisableGREASE omits the GREASE transport parameter.
+	DisableGREASE bool
+}
+
+// Validate checks that the ServerSpec is consistent, and returns a QUICSpecError
+// describing the first misconfiguration found. Listening with an invalid ServerSpec
+// fails with the same error, and connections are refused if GetConfigForClient returns
+// an invalid ServerSpec.
+func (s *ServerSpec) Validate() error {
+	if s.ConnIDLength < 0 || s.ConnIDLength > protocol.MaxConnIDLen {
+		return specError("ServerSpec.ConnIDLength", ErrValueOutOfRange, "%d, must be between 0 and %d", s.ConnIDLength, protocol.MaxConnIDLen)
+	}
+	for _, f := range []struct {
+		name string
+		size int
+	}{{"MaxDatagramSize", s.MaxDatagramSize}, {"InitialDatagramSize", s.InitialDatagramSize}} {
+		if f.size != 0 && (f.size < protocol.MinInitialPacketSize || f.size > protocol.MaxPacketBufferSize) {
+			return specError("ServerSpec."+f.name, ErrValueOutOfRange, "%d, must be 0 or between %d and %d", f.size, protocol.MinInitialPacketSize, protocol.MaxPacketBufferSize)
+		}
+	}
+	if s.MaxDatagramSize != 0 && s.InitialDatagramSize > s.MaxDatagramSize {
+		return specError("ServerSpec.InitialDatagramSize", ErrValueOutOfRange, "%d, exceeds the MaxDatagramSize %d", s.InitialDatagramSize, s.MaxDatagramSize)
+	}
+	return nil
+}
+
+// validateServerSpec validates the ServerSpec of a Config used by a server, whose
+// connection IDs are connIDLen bytes long.
+func validateServerSpec(s *ServerSpec, connIDLen int) error {
+	if s == nil {
+		return nil
+	}
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if s.ConnIDLength != 0 && s.ConnIDLength != connIDLen {
+		return specError("ServerSpec.ConnIDLength", ErrValueOutOfRange, "%d, the Transport uses connection IDs of %d bytes", s.ConnIDLength, connIDLen)
+	}
+	return nil
+}
+
+// transportParametersLayout returns the layout of the server's transport parameters,
+// or nil for the default layout.
+func (s *ServerSpec) transportParametersLayout() *wire.TransportParametersLayout {
+	if len(s.TransportParameterOrder) == 0 && len(s.AdditionalTransportParameters) == 0 && !s.DisableGREASE {
+		return nil
+	}
+	return &wire.TransportParametersLayout{
+		Order:      s.TransportParameterOrder,
+		Additional: s.AdditionalTransportParameters,
+		OmitGREASE: s.DisableGREASE,
+	}
+}
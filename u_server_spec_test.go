@@ -0,0 +1,284 @@
+package quic
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/protocol"
+	"github.com/Noooste/uquic-go/internal/testdata"
+	"github.com/Noooste/uquic-go/internal/wire"
+	tls "github.com/Noooste/utls"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerSpecValidate(t *testing.T) {
+	require.NoError(t, (&ServerSpec{}).Validate())
+	require.NoError(t, (&ServerSpec{ConnIDLength: 8, MaxDatagramSize: 1252, InitialDatagramSize: 1200}).Validate())
+
+	for _, tc := range []struct {
+		name  string
+		spec  ServerSpec
+		field string
+	}{
+		{name: "connection ID length", spec: ServerSpec{ConnIDLength: 21}, field: "ServerSpec.ConnIDLength"},
+		{name: "small datagrams", spec: ServerSpec{MaxDatagramSize: 1000}, field: "ServerSpec.MaxDatagramSize"},
+		{name: "large datagrams", spec: ServerSpec{InitialDatagramSize: 2000}, field: "ServerSpec.InitialDatagramSize"},
+		{name: "padding exceeds datagram size", spec: ServerSpec{MaxDatagramSize: 1200, InitialDatagramSize: 1250}, field: "ServerSpec.InitialDatagramSize"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.spec.Validate()
+			require.ErrorIs(t, err, ErrValueOutOfRange)
+			var specErr *QUICSpecError
+			require.True(t, errors.As(err, &specErr))
+			require.Equal(t, tc.field, specErr.Field)
+
+			// listening fails with the same error
+			_, err = ListenAddr("127.0.0.1:0", testdata.GetTLSConfig(), &Config{ServerSpec: &tc.spec})
+			require.ErrorIs(t, err, ErrValueOutOfRange)
+		})
+	}
+}
+
+func TestServerSpecTransportParametersLayout(t *testing.T) {
+	require.Nil(t, (&ServerSpec{MaxDatagramSize: 1200}).transportParametersLayout())
+
+	spec := &ServerSpec{
+		TransportParameterOrder:       []uint64{0x4752, 0x1},
+		AdditionalTransportParameters: map[uint64][]byte{0x4752: {1, 2, 3, 4}},
+		DisableGREASE:                 true,
+	}
+	require.Equal(t, &wire.TransportParametersLayout{
+		Order:      spec.TransportParameterOrder,
+		Additional: spec.AdditionalTransportParameters,
+		OmitGREASE: true,
+	}, spec.transportParametersLayout())
+}
+
+func newServerSpecTestServer(t *testing.T, tr *Transport, conf *Config) *Listener {
+	tlsConf := testdata.GetTLSConfig()
+	tlsConf.NextProtos = []string{"h3"}
+	ln, err := tr.Listen(tlsConf, conf)
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			go func() {
+				<-conn.Context().Done()
+			}()
+		}
+	}()
+	return ln
+}
+
+func newServerSpecTestTransport(t *testing.T) *Transport {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	tr := &Transport{Conn: conn}
+	t.Cleanup(func() { tr.Close() })
+	return tr
+}
+
+// dialServerSpecTestServer dials the server, and returns the datagrams sent by the server
+// during the handshake.
+func dialServerSpecTestServer(t *testing.T, addr net.Addr) ([][]byte, error) {
+	t.Helper()
+	// without a post-quantum key share, the ServerHello leaves room in the first datagram
+	tr := newUTransportForTest(t, QUICFirefox_116)
+	recorder := newInitialRecorder(t, addr)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := tr.Dial(
+		ctx,
+		recorder.conn.LocalAddr(),
+		&tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA(), NextProtos: []string{"h3"}},
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.CloseWithError(0, "")
+	return recorder.serverDatagramsSent(), nil
+}
+
+// serverFirstFlight returns the long header packets of the datagrams sent by the server,
+// and the bytes following them in each datagram.
+func serverFirstFlight(t *testing.T, datagrams [][]byte) (hdrs [][]*wire.Header, trailers [][]byte) {
+	t.Helper()
+	for _, datagram := range datagrams {
+		var dgHdrs []*wire.Header
+		data := datagram
+		for len(data) > 0 && wire.IsLongHeaderPacket(data[0]) {
+			hdr, _, rest, err := wire.ParsePacket(data)
+			require.NoError(t, err)
+			dgHdrs = append(dgHdrs, hdr)
+			data = rest
+		}
+		if len(dgHdrs) == 0 {
+			break
+		}
+		hdrs = append(hdrs, dgHdrs)
+		trailers = append(trailers, data)
+	}
+	return hdrs, trailers
+}
+
+func TestServerSpec(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		ln := newServerSpecTestServer(t, newServerSpecTestTransport(t), nil)
+		datagrams, err := dialServerSpecTestServer(t, ln.Addr())
+		require.NoError(t, err)
+		hdrs, _ := serverFirstFlight(t, datagrams)
+		// the Initial packet is coalesced with a Handshake packet
+		require.Len(t, hdrs[0], 2)
+		require.Equal(t, protocol.PacketTypeInitial, hdrs[0][0].Type)
+		require.Equal(t, protocol.PacketTypeHandshake, hdrs[0][1].Type)
+		require.Equal(t, protocol.DefaultConnectionIDLength, hdrs[0][0].SrcConnectionID.Len())
+	})
+
+	t.Run("first flight", func(t *testing.T) {
+		ln := newServerSpecTestServer(t, newServerSpecTestTransport(t), &Config{
+			ServerSpec: &ServerSpec{
+				ConnIDLength:                  8,
+				MaxDatagramSize:               1252,
+				InitialDatagramSize:           1200,
+				PadWithZeros:                  true,
+				SeparateInitial:               true,
+				TransportParameterOrder:       []uint64{0x4752, 0xf, 0x0},
+				AdditionalTransportParameters: map[uint64][]byte{0x4752: {0xff, 0, 0, 0x1d}},
+				DisableGREASE:                 true,
+			},
+		})
+		datagrams, err := dialServerSpecTestServer(t, ln.Addr())
+		require.NoError(t, err)
+		hdrs, trailers := serverFirstFlight(t, datagrams)
+		require.GreaterOrEqual(t, len(hdrs), 2)
+
+		// the Initial packet is sent alone, padded with zeros
+		require.Len(t, hdrs[0], 1)
+		require.Equal(t, protocol.PacketTypeInitial, hdrs[0][0].Type)
+		require.Equal(t, 8, hdrs[0][0].SrcConnectionID.Len())
+		require.Len(t, datagrams[0], 1200)
+		require.NotEmpty(t, trailers[0])
+		require.Equal(t, make([]byte, len(trailers[0])), trailers[0])
+
+		for i, dgHdrs := range hdrs[1:] {
+			require.LessOrEqual(t, len(datagrams[i+1]), 1252)
+			for _, hdr := range dgHdrs {
+				require.Equal(t, protocol.PacketTypeHandshake, hdr.Type)
+			}
+		}
+	})
+
+	t.Run("PTO probes", func(t *testing.T) {
+		ln := newServerSpecTestServer(t, newServerSpecTestTransport(t), &Config{
+			ServerSpec: &ServerSpec{
+				MaxDatagramSize:     1252,
+				InitialDatagramSize: 1200,
+				PadWithZeros:        true,
+				SeparateInitial:     true,
+			},
+		})
+		// drop the server's first flight, such that it sends probe packets
+		recorder := newInitialRecorder(t, ln.Addr())
+		recorder.mx.Lock()
+		recorder.dropFromServer = func(n int) bool { return n < 3 }
+		recorder.mx.Unlock()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := newUTransportForTest(t, QUICFirefox_116).Dial(
+			ctx,
+			recorder.conn.LocalAddr(),
+			&tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA(), NextProtos: []string{"h3"}},
+			nil,
+		)
+		require.NoError(t, err)
+		defer conn.CloseWithError(0, "")
+		numInitials := func() (n int) {
+			for _, datagram := range recorder.serverDatagramsSent() {
+				if !wire.IsLongHeaderPacket(datagram[0]) {
+					continue
+				}
+				if hdr, _, _, err := wire.ParsePacket(datagram); err == nil && hdr.Type == protocol.PacketTypeInitial {
+					n++
+				}
+			}
+			return n
+		}
+		require.Eventually(t, func() bool { return numInitials() > 1 }, 5*time.Second, 10*time.Millisecond)
+
+		var numPadded int
+		for _, datagram := range recorder.serverDatagramsSent() {
+			require.LessOrEqual(t, len(datagram), 1252)
+			if !wire.IsLongHeaderPacket(datagram[0]) {
+				continue
+			}
+			hdrs, trailers := serverFirstFlight(t, [][]byte{datagram})
+			if hdrs[0][0].Type != protocol.PacketTypeInitial {
+				continue
+			}
+			// Initial packets only carrying an ACK frame are not padded
+			require.Len(t, hdrs[0], 1)
+			require.LessOrEqual(t, len(datagram), 1200)
+			require.Equal(t, make([]byte, len(trailers[0])), trailers[0])
+			if len(datagram) == 1200 {
+				numPadded++
+			}
+		}
+		// the first Initial, and at least one probe packet
+		require.Greater(t, numPadded, 1)
+	})
+
+	t.Run("PADDING frames", func(t *testing.T) {
+		ln := newServerSpecTestServer(t, newServerSpecTestTransport(t), &Config{
+			ServerSpec: &ServerSpec{InitialDatagramSize: 1200, SeparateInitial: true},
+		})
+		datagrams, err := dialServerSpecTestServer(t, ln.Addr())
+		require.NoError(t, err)
+		hdrs, trailers := serverFirstFlight(t, datagrams)
+		require.Len(t, hdrs[0], 1)
+		require.Len(t, datagrams[0], 1200)
+		require.Empty(t, trailers[0])
+	})
+}
+
+func TestServerSpecGetConfigForClient(t *testing.T) {
+	var spec atomic.Pointer[ServerSpec]
+	tr := newServerSpecTestTransport(t)
+	ln := newServerSpecTestServer(t, tr, &Config{
+		ServerSpec: &ServerSpec{ConnIDLength: 12},
+		GetConfigForClient: func(*ClientInfo) (*Config, error) {
+			return &Config{ServerSpec: spec.Load()}, nil
+		},
+	})
+	require.Equal(t, 12, tr.connIDLen)
+	// the Transport's configuration is not modified
+	require.Zero(t, tr.ConnectionIDLength)
+
+	spec.Store(&ServerSpec{SeparateInitial: true})
+	datagrams, err := dialServerSpecTestServer(t, ln.Addr())
+	require.NoError(t, err)
+	hdrs, _ := serverFirstFlight(t, datagrams)
+	require.Len(t, hdrs[0], 1)
+	require.Equal(t, 12, hdrs[0][0].SrcConnectionID.Len())
+
+	// the connection IDs of all connections have the same length
+	spec.Store(&ServerSpec{ConnIDLength: 8})
+	_, err = dialServerSpecTestServer(t, ln.Addr())
+	var transportErr *TransportError
+	require.True(t, errors.As(err, &transportErr))
+	require.Equal(t, ConnectionRefused, transportErr.ErrorCode)
+
+	// a Transport in use keeps the length of its connection IDs
+	ln.Close()
+	_, err = tr.Listen(testdata.GetTLSConfig(), &Config{ServerSpec: &ServerSpec{ConnIDLength: 8}})
+	require.ErrorIs(t, err, ErrValueOutOfRange)
+}
@@ -15,19 +15,41 @@ type UTransport struct {
 	*Transport
 
 	QUICSpec *QUICSpec // [UQUIC] using ptr to avoid copying
+
+	// [UQUIC]
+	// GetECHConfigList, if not nil, is called to get the ECHConfigList of the server when
+	// dialing with a tls.Config that has no EncryptedClientHelloConfigList, e.g. using
+	// LookupECHConfigList. If it returns nil, Encrypted Client Hello is not used, and
+	// parrots that send a GREASE ECH extension keep sending it.
+	GetECHConfigList func(ctx context.Context, serverName string) ([]byte, error)
 }
 
 // Dial dials a new connection to a remote host (not using 0-RTT).
 func (t *UTransport) Dial(ctx context.Context, addr net.Addr, tlsConf *tls.Config, conf *Config) (*Conn, error) {
-	return t.dial(ctx, addr, "", tlsConf, conf, conf.Allow0RTT)
+	return t.dial(ctx, addr, "", tlsConf, conf, false, t.QUICSpec)
 }
 
 // DialEarly dials a new connection, attempting to use 0-RTT if possible.
 func (t *UTransport) DialEarly(ctx context.Context, addr net.Addr, tlsConf *tls.Config, conf *Config) (*Conn, error) {
-	return t.dial(ctx, addr, "", tlsConf, conf, conf.Allow0RTT)
+	return t.dial(ctx, addr, "", tlsConf, conf, true, t.QUICSpec)
 }
 
-func (t *UTransport) dial(ctx context.Context, addr net.Addr, host string, tlsConf *tls.Config, conf *Config, use0RTT bool) (*Conn, error) {
+// [UQUIC]
+// DialWithSpec is like Dial, but uses the given QUICSpec instead of the UTransport's.
+// This allows dialing connections with different fingerprints from the same UDP socket.
+// If spec is nil, the connection is dialed without a QUICSpec.
+func (t *UTransport) DialWithSpec(ctx context.Context, addr net.Addr, tlsConf *tls.Config, conf *Config, spec *QUICSpec) (*Conn, error) {
+	return t.dial(ctx, addr, "", tlsConf, conf, false, spec)
+}
+
+// [UQUIC]
+// DialEarlyWithSpec is like DialEarly, but uses the given QUICSpec instead of the
+// UTransport's, see DialWithSpec.
+func (t *UTransport) DialEarlyWithSpec(ctx context.Context, addr net.Addr, tlsConf *tls.Config, conf *Config, spec *QUICSpec) (*Conn, error) {
+	return t.dial(ctx, addr, "", tlsConf, conf, true, spec)
+}
+
+func (t *UTransport) dial(ctx context.Context, addr net.Addr, host string, tlsConf *tls.Config, conf *Config, use0RTT bool, spec *QUICSpec) (*Conn, error) {
 	if err := t.init(t.isSingleUse); err != nil {
 		return nil, err
 	}
@@ -37,27 +59,36 @@ func (t *UTransport) dial(ctx context.Context, addr net.Addr, host string, tlsCo
 	conf = populateConfig(conf)
 
 	// [UQUIC]
-	// Override the default connection ID generator if the user has specified a length in QUICSpec.
-	if t.QUICSpec != nil {
-		if t.QUICSpec.InitialPacketSpec.SrcConnIDLength != 0 {
-			t.ConnectionIDGenerator = &protocol.DefaultConnectionIDGenerator{ConnLen: t.QUICSpec.InitialPacketSpec.SrcConnIDLength}
-		} else {
-			t.ConnectionIDGenerator = &protocol.ExpEmptyConnectionIDGenerator{}
+	var (
+		uSpec               *QUICSpec
+		initialPacketNumber protocol.PacketNumber
+		version             = conf.Versions[0]
+	)
+	if spec != nil {
+		if err := spec.Validate(); err != nil {
+			return nil, err
 		}
+		uSpec = spec.forAddr(addr)
+		initialPacketNumber = protocol.PacketNumber(uSpec.InitialPacketSpec.InitPacketNumber)
+		uSpec.UpdateConfig(conf)
+		version = uSpec.initialVersion(conf)
 	}
 	// [/UQUIC]
 
 	tlsConf = tlsConf.Clone()
 	setTLSConfigServerName(tlsConf, addr, host)
-	return t.doDial(ctx,
-		newSendConn(t.conn, addr, packetInfo{}, utils.DefaultLogger),
-		tlsConf,
-		conf,
-		0,
-		false,
-		use0RTT,
-		conf.Versions[0],
-	)
+	return t.dialECH(ctx, tlsConf, uSpec, func(tlsConf *tls.Config) (*Conn, error) { // [UQUIC]
+		return t.doDial(ctx,
+			newSendConn(t.conn, addr, packetInfo{}, utils.DefaultLogger),
+			tlsConf,
+			conf,
+			initialPacketNumber,
+			false,
+			use0RTT,
+			version, // [UQUIC]
+			uSpec,
+		)
+	})
 }
 
 func (t *UTransport) doDial(
@@ -69,15 +100,37 @@ func (t *UTransport) doDial(
 	hasNegotiatedVersion bool,
 	use0RTT bool,
 	version protocol.Version,
+	uSpec *QUICSpec, // [UQUIC]
 ) (*Conn, error) {
-	srcConnID, err := t.connIDGenerator.GenerateConnectionID()
+	// [UQUIC]
+	// The connection IDs of a connection dialed with a QUICSpec are generated as specified
+	// by its InitialPacketSpec, independently of the Transport's ConnectionIDGenerator.
+	// Their random values are taken from the connection's randomness, see QUICSpec.Rand.
+	var (
+		connIDGenerator = t.connIDGenerator
+		uRand           *connRand
+	)
+	if uSpec != nil {
+		var err error
+		if uRand, err = uSpec.newConnRand(); err != nil {
+			return nil, err
+		}
+		connIDGenerator = uSpec.InitialPacketSpec.connIDGenerator(uRand)
+	}
+	srcConnID, err := connIDGenerator.GenerateConnectionID()
 	if err != nil {
 		return nil, err
 	}
-	destConnID, err := generateConnectionIDForInitial()
+	var destConnID protocol.ConnectionID
+	if uSpec != nil {
+		destConnID, err = protocol.ReadConnectionIDForInitial(uRand, uSpec.InitialPacketSpec.DestConnIDLength)
+	} else {
+		destConnID, err = generateConnectionIDForInitial()
+	}
 	if err != nil {
 		return nil, err
 	}
+	// [/UQUIC]
 
 	t.mutex.Lock()
 	if t.closeErr != nil {
@@ -94,8 +147,11 @@ func (t *UTransport) doDial(
 	logger.Infof("Starting new connection to %s (%s -> %s), source connection ID %s, destination connection ID %s, version %s", tlsConf.ServerName, sendConn.LocalAddr(), sendConn.RemoteAddr(), srcConnID, destConnID, version)
 
 	// [uQUIC SECTION BEGIN]
-	var conn *wrappedConn
-	if t.QUICSpec == nil {
+	var (
+		conn    *wrappedConn
+		uRunner *uConnRunner
+	)
+	if uSpec == nil {
 		conn = newClientConnection(
 			context.WithoutCancel(ctx),
 			sendConn,
@@ -114,13 +170,25 @@ func (t *UTransport) doDial(
 			version,
 		)
 	} else {
-		conn = newUClientConnection(
+		var runner connRunner = (*packetHandlerMap)(t.Transport)
+		if srcConnID.Len() == 0 && (*packetHandlerMap)(t.Transport).zeroLenConnInUse(sendConn.RemoteAddr().String()) {
+			t.mutex.Unlock()
+			return nil, ErrZeroLengthConnIDInUse
+		}
+		// Zero-length connection IDs are routed by the remote address, even if the
+		// Transport uses them as well.
+		if srcConnID.Len() == 0 || srcConnID.Len() != t.connIDLen {
+			uRunner = newUConnRunner((*packetHandlerMap)(t.Transport), sendConn.RemoteAddr())
+			runner = uRunner
+		}
+		var err error
+		conn, err = newUClientConnection(
 			context.WithoutCancel(ctx),
 			sendConn,
-			(*packetHandlerMap)(t.Transport),
+			runner,
 			destConnID,
 			srcConnID,
-			t.connIDGenerator,
+			connIDGenerator,
 			t.statelessResetter,
 			config,
 			tlsConf,
@@ -130,12 +198,21 @@ func (t *UTransport) doDial(
 			qlogTrace,
 			logger,
 			version,
-			t.QUICSpec,
+			uSpec,
+			uRand,
 		)
+		if err != nil {
+			t.mutex.Unlock()
+			return nil, err
+		}
 	}
-	// [uQUIC SECTION END]
 
-	t.handlers[srcConnID] = conn
+	if uSpec == nil {
+		t.handlers[srcConnID] = conn
+	} else {
+		(*packetHandlerMap)(t.Transport).addUConn(srcConnID, uRunner, conn)
+	}
+	// [uQUIC SECTION END]
 	t.mutex.Unlock()
 
 	// The error channel needs to be buffered, as the run loop will continue running
@@ -181,6 +258,7 @@ func (t *UTransport) doDial(
 			true,
 			use0RTT,
 			params.nextVersion,
+			uSpec,
 		)
 	case err := <-errChan:
 		return nil, err
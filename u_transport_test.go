@@ -0,0 +1,545 @@
+package quic
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/protocol"
+	"github.com/Noooste/uquic-go/internal/testdata"
+	"github.com/Noooste/uquic-go/internal/wire"
+	tls "github.com/Noooste/utls"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/sync/errgroup"
+)
+
+// initialRecorder relays UDP datagrams between a client and a server,
+// recording the datagrams and the tokens of the Initial packets sent by the client.
+type initialRecorder struct {
+	conn       *net.UDPConn
+	serverAddr net.Addr
+	// drop is called for every datagram sent by the client, which is dropped if it returns true
+	drop func(n int) bool
+	// dropFromServer is the same as drop, for the datagrams sent by the server, if set
+	dropFromServer func(n int) bool
+
+	mx              sync.Mutex
+	tokens          [][]byte
+	datagrams       [][]byte
+	serverDatagrams [][]byte
+}
+
+func newInitialRecorder(t *testing.T, serverAddr net.Addr) *initialRecorder {
+	return newLossyInitialRecorder(t, serverAddr, func(int) bool { return false })
+}
+
+// newLossyInitialRecorder creates an initialRecorder dropping the n-th datagram sent
+// by the client if drop(n) returns true. Dropped datagrams are still recorded.
+func newLossyInitialRecorder(t *testing.T, serverAddr net.Addr, drop func(n int) bool) *initialRecorder {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	r := &initialRecorder{conn: conn, serverAddr: serverAddr, drop: drop}
+	go r.run()
+	return r
+}
+
+func (r *initialRecorder) run() {
+	var clientAddr net.Addr
+	b := make([]byte, protocol.MaxPacketBufferSize)
+	for {
+		n, addr, err := r.conn.ReadFrom(b)
+		if err != nil {
+			return
+		}
+		if addr.String() == r.serverAddr.String() {
+			r.mx.Lock()
+			r.serverDatagrams = append(r.serverDatagrams, append([]byte(nil), b[:n]...))
+			drop := r.dropFromServer != nil && r.dropFromServer(len(r.serverDatagrams)-1)
+			r.mx.Unlock()
+			if clientAddr != nil && !drop {
+				r.conn.WriteTo(b[:n], clientAddr)
+			}
+			continue
+		}
+		clientAddr = addr
+		r.mx.Lock()
+		r.datagrams = append(r.datagrams, append([]byte(nil), b[:n]...))
+		drop := r.drop(len(r.datagrams) - 1)
+		r.mx.Unlock()
+		if wire.IsLongHeaderPacket(b[0]) {
+			if hdr, _, _, err := wire.ParsePacket(b[:n]); err == nil && hdr.Type == protocol.PacketTypeInitial {
+				r.mx.Lock()
+				r.tokens = append(r.tokens, append([]byte(nil), hdr.Token...))
+				r.mx.Unlock()
+			}
+		}
+		if !drop {
+			r.conn.WriteTo(b[:n], r.serverAddr)
+		}
+	}
+}
+
+// firstToken returns the token of the first Initial packet, and resets the recorder.
+func (r *initialRecorder) firstToken(t *testing.T) []byte {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	require.NotEmpty(t, r.tokens)
+	token := r.tokens[0]
+	r.tokens = nil
+	return token
+}
+
+// serverDatagramsSent returns the datagrams sent by the server.
+func (r *initialRecorder) serverDatagramsSent() [][]byte {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return slices.Clone(r.serverDatagrams)
+}
+
+// clientDatagrams returns the datagrams sent by the client, and resets the recorder.
+func (r *initialRecorder) clientDatagrams() [][]byte {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	datagrams := r.datagrams
+	r.datagrams = nil
+	r.tokens = nil
+	return datagrams
+}
+
+func newUTransportTestServer(t *testing.T) *Listener {
+	tlsConf := testdata.GetTLSConfig()
+	tlsConf.NextProtos = []string{"h3"}
+	ln, err := ListenAddr("127.0.0.1:0", tlsConf, &Config{Allow0RTT: true})
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			go func() {
+				<-conn.Context().Done()
+			}()
+		}
+	}()
+	return ln
+}
+
+func newUTransportForTest(t *testing.T, id QUICID) *UTransport {
+	spec, err := testQUICID2Spec(id)
+	require.NoError(t, err)
+	return newUTransportWithSpecForTest(t, &spec)
+}
+
+func newUTransportWithSpecForTest(t *testing.T, spec *QUICSpec) *UTransport {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	tr := &UTransport{Transport: &Transport{Conn: conn}, QUICSpec: spec}
+	t.Cleanup(func() { tr.Close() })
+	return tr
+}
+
+func TestUTransportRedialWithSameSpec(t *testing.T) {
+	ln := newUTransportTestServer(t)
+	tr := newUTransportForTest(t, testQUICIDPQ)
+
+	for range 3 {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		conn, err := tr.Dial(
+			ctx,
+			ln.Addr(),
+			&tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA(), NextProtos: []string{"h3"}},
+			&Config{},
+		)
+		cancel()
+		require.NoError(t, err)
+		conn.CloseWithError(0, "")
+	}
+}
+
+func TestUTransportZeroLengthConnIDInUse(t *testing.T) {
+	ln := newUTransportTestServer(t)
+	tr := newUTransportForTest(t, testQUICIDPQ) // zero-length connection IDs
+	tlsConf := &tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA(), NextProtos: []string{"h3"}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, err := tr.Dial(ctx, ln.Addr(), tlsConf, &Config{})
+	require.NoError(t, err)
+	// packets of a second connection to the same address couldn't be told apart
+	_, err = tr.Dial(ctx, ln.Addr(), tlsConf, &Config{})
+	require.ErrorIs(t, err, ErrZeroLengthConnIDInUse)
+	// the first connection is still routed
+	str, err := conn.OpenUniStream()
+	require.NoError(t, err)
+	_, err = str.Write([]byte("foobar"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Context().Err())
+
+	// once it is closed, the address can be dialed again
+	conn.CloseWithError(0, "")
+	conn, err = tr.Dial(ctx, ln.Addr(), tlsConf, &Config{})
+	require.NoError(t, err)
+	conn.CloseWithError(0, "")
+}
+
+func TestUTransportZeroLengthConnIDInUseOnZeroLengthTransport(t *testing.T) {
+	ln := newUTransportTestServer(t)
+	tr := newUTransportForTest(t, testQUICIDPQ) // zero-length connection IDs
+	// the Transport uses zero-length connection IDs as well
+	tr.ConnectionIDGenerator = &protocol.DefaultConnectionIDGenerator{ConnLen: 0}
+	tlsConf := &tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA(), NextProtos: []string{"h3"}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, err := tr.Dial(ctx, ln.Addr(), tlsConf, &Config{})
+	require.NoError(t, err)
+	require.Zero(t, tr.connIDLen)
+	// the second connection would replace the handler of the first one
+	_, err = tr.Dial(ctx, ln.Addr(), tlsConf, &Config{})
+	require.ErrorIs(t, err, ErrZeroLengthConnIDInUse)
+	str, err := conn.OpenUniStream()
+	require.NoError(t, err)
+	_, err = str.Write([]byte("foobar"))
+	require.NoError(t, err)
+	require.NoError(t, str.Close())
+	require.NoError(t, conn.Context().Err())
+
+	conn.CloseWithError(0, "")
+	conn, err = tr.Dial(ctx, ln.Addr(), tlsConf, &Config{})
+	require.NoError(t, err)
+	conn.CloseWithError(0, "")
+}
+
+func TestUTransportConnIDLengthsRemoved(t *testing.T) {
+	ln := newUTransportTestServer(t)
+	tr := newUTransportForTest(t, testQUICIDPQConnID3) // 3 byte connection IDs
+	uConnIDLens := func() map[int]int {
+		tr.mutex.Lock()
+		defer tr.mutex.Unlock()
+		return maps.Clone(tr.uConnIDLens)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, err := tr.Dial(
+		ctx,
+		ln.Addr(),
+		&tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA(), NextProtos: []string{"h3"}},
+		&Config{},
+	)
+	require.NoError(t, err)
+	require.Contains(t, uConnIDLens(), 3)
+
+	conn.CloseWithError(0, "")
+	require.Eventually(t, func() bool { return len(uConnIDLens()) == 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+// clientHelloExtensions returns the types of the extensions of a ClientHello, in order.
+func clientHelloExtensions(t *testing.T, clientHello []byte) []uint16 {
+	t.Helper()
+	s := cryptobyte.String(clientHello)
+	var (
+		legacySessionID, cipherSuites, compression, extensions cryptobyte.String
+		msgLen                                                 uint32
+	)
+	require.True(t,
+		s.Skip(1) && s.ReadUint24(&msgLen) && s.Skip(2+32) &&
+			s.ReadUint8LengthPrefixed(&legacySessionID) &&
+			s.ReadUint16LengthPrefixed(&cipherSuites) &&
+			s.ReadUint8LengthPrefixed(&compression) &&
+			s.ReadUint16LengthPrefixed(&extensions),
+	)
+	var types []uint16
+	for !extensions.Empty() {
+		var typ uint16
+		var ext cryptobyte.String
+		require.True(t, extensions.ReadUint16(&typ) && extensions.ReadUint16LengthPrefixed(&ext))
+		types = append(types, typ)
+	}
+	return types
+}
+
+func TestUTransportResumption(t *testing.T) {
+	const (
+		extEarlyData     = 42
+		extPreSharedKey  = 41
+		extPSKKeyExModes = 45
+		extKeyShare      = 51
+	)
+
+	for _, id := range []QUICID{QUICChrome_115, testQUICIDPQ, QUICFirefox_116, testQUICIDPQConnID3, testQUICIDConnID8} {
+		t.Run(id.Client+"_"+id.Version, func(t *testing.T) {
+			ln := newUTransportTestServer(t)
+			recorder := newInitialRecorder(t, ln.Addr())
+			tr := newUTransportForTest(t, id)
+
+			tlsConf := &tls.Config{
+				ServerName:         "localhost",
+				RootCAs:            testdata.GetRootCA(),
+				NextProtos:         []string{"h3"},
+				ClientSessionCache: tls.NewLRUClientSessionCache(1),
+			}
+			tokenStore := newTokenStoreWithNotify(NewLRUTokenStore(1, 1))
+			conf := &Config{TokenStore: tokenStore}
+			waitForToken := func() {
+				t.Helper()
+				select {
+				case <-tokenStore.received:
+				case <-time.After(time.Second):
+					t.Fatal("timeout waiting for the NEW_TOKEN frame")
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			conn, err := tr.Dial(ctx, recorder.conn.LocalAddr(), tlsConf, conf)
+			require.NoError(t, err)
+			require.False(t, conn.ConnectionState().TLS.DidResume)
+			waitForToken()
+			// the client doesn't send any Initial packets after receiving the NEW_TOKEN frame
+			require.Empty(t, recorder.firstToken(t))
+			exts := clientHelloExtensions(t, reassembleClientHello(t, recorder.clientDatagrams()))
+			require.NotContains(t, exts, uint16(extPreSharedKey))
+			require.NotContains(t, exts, uint16(extEarlyData))
+			conn.CloseWithError(0, "")
+
+			// without 0-RTT, the session is resumed without offering early data
+			conn, err = tr.Dial(ctx, recorder.conn.LocalAddr(), tlsConf, conf)
+			require.NoError(t, err)
+			require.True(t, conn.ConnectionState().TLS.DidResume)
+			require.False(t, conn.ConnectionState().Used0RTT)
+			waitForToken()
+			require.NotEmpty(t, recorder.firstToken(t))
+			exts = clientHelloExtensions(t, reassembleClientHello(t, recorder.clientDatagrams()))
+			require.Equal(t, uint16(extPreSharedKey), exts[len(exts)-1])
+			require.NotContains(t, exts, uint16(extEarlyData))
+			conn.CloseWithError(0, "")
+
+			// with 0-RTT, early data is offered in the browser's layout, and accepted by the server
+			conn, err = tr.DialEarly(ctx, recorder.conn.LocalAddr(), tlsConf, conf)
+			require.NoError(t, err)
+			str, err := conn.OpenStream()
+			require.NoError(t, err)
+			_, err = str.Write([]byte("foobar"))
+			require.NoError(t, err)
+			select {
+			case <-conn.HandshakeComplete():
+			case <-ctx.Done():
+				t.Fatal("timeout waiting for the handshake to complete")
+			}
+			require.True(t, conn.ConnectionState().TLS.DidResume)
+			require.True(t, conn.ConnectionState().Used0RTT)
+			require.NotEmpty(t, recorder.firstToken(t))
+			datagrams := recorder.clientDatagrams()
+			var sent0RTT bool
+			for _, datagram := range datagrams {
+				for data := datagram; len(data) > 0 && wire.IsLongHeaderPacket(data[0]); {
+					hdr, _, rest, err := wire.ParsePacket(data)
+					require.NoError(t, err)
+					sent0RTT = sent0RTT || hdr.Type == protocol.PacketType0RTT
+					data = rest
+				}
+			}
+			require.True(t, sent0RTT, "no 0-RTT packets sent")
+			exts = clientHelloExtensions(t, reassembleClientHello(t, datagrams))
+			require.Equal(t, uint16(extPreSharedKey), exts[len(exts)-1])
+			earlyData := slices.Index(exts, extEarlyData)
+			require.NotEqual(t, -1, earlyData)
+			switch id {
+			case QUICFirefox_116, testQUICIDPQConnID3: // NSS sends early_data right after key_share
+				require.Equal(t, uint16(extKeyShare), exts[earlyData-1])
+			case testQUICIDConnID8:
+				require.Equal(t, uint16(extPSKKeyExModes), exts[earlyData-1])
+			}
+			conn.CloseWithError(0, "")
+		})
+	}
+}
+
+// tokenStoreWithNotify notifies when a token is received from the server.
+// The session ticket is sent in the same packet as the NEW_TOKEN frame.
+type tokenStoreWithNotify struct {
+	TokenStore
+	received chan struct{}
+}
+
+func newTokenStoreWithNotify(ts TokenStore) *tokenStoreWithNotify {
+	return &tokenStoreWithNotify{TokenStore: ts, received: make(chan struct{}, 1)}
+}
+
+func (t *tokenStoreWithNotify) Put(key string, token *ClientToken) {
+	t.TokenStore.Put(key, token)
+	select {
+	case t.received <- struct{}{}:
+	default:
+	}
+}
+
+// runUTransportEchoServer accepts connections on the listener, echoing the data
+// received on every stream.
+func runUTransportEchoServer(ln *Listener) {
+	for {
+		conn, err := ln.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		go func() {
+			for {
+				str, err := conn.AcceptStream(context.Background())
+				if err != nil {
+					return
+				}
+				go func() {
+					defer str.Close()
+					io.Copy(str, str)
+				}()
+			}
+		}()
+	}
+}
+
+func TestUTransportSharedSocket(t *testing.T) {
+	serverTLSConf := testdata.GetTLSConfig()
+	serverTLSConf.NextProtos = []string{"h3"}
+	newServer := func() *Listener {
+		ln, err := ListenAddr("127.0.0.1:0", serverTLSConf, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { ln.Close() })
+		go runUTransportEchoServer(ln)
+		return ln
+	}
+	serverA := newServer()
+	serverB := newServer()
+
+	// a single socket hosts a listener and the connections dialed with different QUICSpecs
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	tr := &Transport{Conn: conn}
+	defer tr.Close()
+	ln, err := tr.Listen(serverTLSConf, nil)
+	require.NoError(t, err)
+	go runUTransportEchoServer(ln)
+
+	echo := func(conn *Conn) error {
+		defer conn.CloseWithError(0, "")
+		data := make([]byte, 50_000)
+		rand.Read(data)
+		str, err := conn.OpenStreamSync(context.Background())
+		if err != nil {
+			return err
+		}
+		if _, err := str.Write(data); err != nil {
+			return err
+		}
+		str.Close()
+		received, err := io.ReadAll(str)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(data, received) {
+			return fmt.Errorf("echoed data doesn't match")
+		}
+		return nil
+	}
+	clientTLSConf := &tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA(), NextProtos: []string{"h3"}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var g errgroup.Group
+	for _, tc := range []struct {
+		id     QUICID
+		server *Listener
+	}{
+		{id: testQUICIDPQ, server: serverA},        // zero-length connection IDs
+		{id: QUICChrome_115, server: serverB},      // zero-length connection IDs, to a different address
+		{id: testQUICIDPQConnID3, server: serverA}, // 3 bytes
+		{id: QUICFirefox_116A, server: serverA},    // 3 bytes
+		{id: testQUICIDConnID8, server: serverB},   // 8 bytes
+	} {
+		g.Go(func() error {
+			spec, err := testQUICID2Spec(tc.id)
+			if err != nil {
+				return err
+			}
+			ut := &UTransport{Transport: tr, QUICSpec: &spec}
+			conn, err := ut.Dial(ctx, tc.server.Addr(), clientTLSConf, nil)
+			if err != nil {
+				return fmt.Errorf("%s %s: %w", tc.id.Client, tc.id.Version, err)
+			}
+			if err := echo(conn); err != nil {
+				return fmt.Errorf("%s %s: %w", tc.id.Client, tc.id.Version, err)
+			}
+			return nil
+		})
+	}
+	// connections dialed without a QUICSpec use the Transport's connection IDs
+	g.Go(func() error {
+		conn, err := tr.Dial(ctx, serverB.Addr(), clientTLSConf, nil)
+		if err != nil {
+			return err
+		}
+		return echo(conn)
+	})
+	// connections accepted by the listener
+	g.Go(func() error {
+		conn, err := DialAddr(ctx, ln.Addr().String(), clientTLSConf, nil)
+		if err != nil {
+			return err
+		}
+		return echo(conn)
+	})
+	require.NoError(t, g.Wait())
+	require.Nil(t, tr.ConnectionIDGenerator)
+}
+
+func TestUTransportDialWithSpec(t *testing.T) {
+	ln := newUTransportTestServer(t)
+	tr := newUTransportForTest(t, testQUICIDPQ)
+
+	firefox, err := QUICID2Spec(QUICFirefox_116C)
+	require.NoError(t, err)
+	connID8, err := testQUICID2Spec(testQUICIDConnID8)
+	require.NoError(t, err)
+	connID8.InitialPacketSpec.ClientTokenLength = 20
+
+	for _, tc := range []struct {
+		name                                  string
+		spec                                  *QUICSpec
+		srcConnIDLen, destConnIDLen, tokenLen int
+	}{
+		{name: "Firefox", spec: &firefox, srcConnIDLen: 3, destConnIDLen: 15},
+		{name: "8-byte connection IDs", spec: &connID8, srcConnIDLen: 8, destConnIDLen: 8, tokenLen: 20},
+		{name: "UTransport's QUICSpec", spec: tr.QUICSpec, srcConnIDLen: 0, destConnIDLen: 8},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			tlsConf := &tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA(), NextProtos: []string{"h3"}}
+			recorder := newInitialRecorder(t, ln.Addr())
+			conn, err := tr.DialWithSpec(ctx, recorder.conn.LocalAddr(), tlsConf, nil, tc.spec)
+			require.NoError(t, err)
+			conn.CloseWithError(0, "")
+			datagrams := recorder.clientDatagrams()
+			require.NotEmpty(t, datagrams)
+			hdr, _, _, err := wire.ParsePacket(datagrams[0])
+			require.NoError(t, err)
+			require.Equal(t, protocol.PacketTypeInitial, hdr.Type)
+			require.Equal(t, tc.srcConnIDLen, hdr.SrcConnectionID.Len())
+			require.Equal(t, tc.destConnIDLen, hdr.DestConnectionID.Len())
+			require.Len(t, hdr.Token, tc.tokenLen)
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package quic
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/Noooste/uquic-go/internal/handshake"
+	"github.com/Noooste/uquic-go/internal/protocol"
+	"github.com/Noooste/uquic-go/internal/qerr"
+	"github.com/Noooste/uquic-go/internal/wire"
+)
+
+// [UQUIC]
+// versionSwitcher is implemented by the CryptoSetups of the handshake package, which
+// can switch to a compatible version during the handshake (RFC 9368).
+type versionSwitcher interface {
+	Version() protocol.Version
+	SwitchVersion(protocol.Version)
+	GetOriginalInitialOpener() (handshake.LongHeaderOpener, error)
+	DropOriginalInitialOpener()
+}
+
+// [UQUIC]
+// acceptsCompatibleVersion says if the client switches to the version of a packet
+// that doesn't use the version of the connection: the server switched to another of
+// the versions the client offered in its version_information transport parameter,
+// using compatible version negotiation. The switch happens with the server's first
+// Initial packet.
+func (c *Conn) acceptsCompatibleVersion(hdr *wire.Header) bool {
+	return c.perspective == protocol.PerspectiveClient &&
+		!c.receivedFirstPacket &&
+		hdr.Type == protocol.PacketTypeInitial &&
+		slices.Contains(c.compatibleVersions, hdr.Version)
+}
+
+// [UQUIC]
+// switchVersion switches the connection to a version chosen by compatible version
+// negotiation.
+func (c *Conn) switchVersion(v protocol.Version) {
+	c.logger.Infof("Switching to QUIC version %s (compatible version negotiation).", v)
+	if c.originalVersion == 0 {
+		c.originalVersion = c.version
+	}
+	c.version = v
+	c.connState.Version = v
+	c.switchedVersion = true
+	if vs, ok := c.cryptoStreamHandler.(versionSwitcher); ok {
+		vs.SwitchVersion(v)
+	}
+}
+
+// [UQUIC]
+// acceptsOriginalVersion says if a packet using the version the connection was started
+// with is accepted after switching to a compatible version. The client sends Initial
+// packets of the original version until it receives the server's first Initial
+// packet, e.g. when retransmitting its first Initial packet, so the server accepts
+// them until it receives a packet of the negotiated version (RFC 9368, section 4).
+func (c *Conn) acceptsOriginalVersion(hdr *wire.Header) bool {
+	return c.originalVersion != 0 &&
+		hdr.Type == protocol.PacketTypeInitial &&
+		hdr.Version == c.originalVersion
+}
+
+// [UQUIC]
+// receivedNegotiatedVersion is called for every long header packet processed. Once
+// the peer uses the negotiated version, Initial packets of the original version are
+// not accepted anymore.
+func (c *Conn) receivedNegotiatedVersion(hdr *wire.Header) {
+	if c.originalVersion == 0 || hdr.Version != c.version {
+		return
+	}
+	c.originalVersion = 0
+	if vs, ok := c.cryptoStreamHandler.(versionSwitcher); ok {
+		vs.DropOriginalInitialOpener()
+	}
+}
+
+// [UQUIC]
+// initialOpener returns the opener of an Initial packet. After switching to a
+// compatible version, Initial packets of the original version are opened using the
+// Initial keys of this version, see Conn.acceptsOriginalVersion.
+func (u *packetUnpacker) initialOpener(hdr *wire.Header) (handshake.LongHeaderOpener, error) {
+	if vs, ok := u.cs.(versionSwitcher); ok && hdr.Version != vs.Version() {
+		return vs.GetOriginalInitialOpener()
+	}
+	return u.cs.GetInitialOpener()
+}
+
+// [UQUIC]
+// handleVersionInformation handles the version_information transport parameter of
+// the peer. The server's CryptoSetup may have switched to a compatible version when
+// receiving the client's transport parameters. The client checks that the server's
+// chosen version is the version in use, which prevents downgrades to another
+// compatible version.
+func (c *Conn) handleVersionInformation(params *wire.TransportParameters) error {
+	if c.perspective == protocol.PerspectiveServer {
+		if vs, ok := c.cryptoStreamHandler.(versionSwitcher); ok && vs.Version() != c.version {
+			c.switchVersion(vs.Version())
+		}
+		return nil
+	}
+	vi := params.VersionInformation
+	if vi == nil {
+		if c.switchedVersion {
+			return &qerr.TransportError{
+				ErrorCode:    qerr.VersionNegotiationErrorCode,
+				ErrorMessage: "server switched the version without sending version_information",
+			}
+		}
+		return nil
+	}
+	if vi.ChosenVersion != c.version {
+		return &qerr.TransportError{
+			ErrorCode:    qerr.VersionNegotiationErrorCode,
+			ErrorMessage: fmt.Sprintf("server's chosen version %s doesn't match the version in use (%s)", vi.ChosenVersion, c.version),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package quic
+
+import (
+	"slices"
+
+	"github.com/Noooste/uquic-go/internal/protocol"
+	tls "github.com/Noooste/utls"
+)
+
+// [UQUIC]
+// VersionSpec specifies the QUIC versions of a client dialing with a QUICSpec: the
+// version it starts the connection with, and the versions it offers.
+//
+// The version of the connection is applied consistently: it is the version of the
+// long header of the Initial packets, the Initial keys are derived using the salt of
+// that version, and it is the chosen version of the version_information transport
+// parameter (RFC 9368), if the ClientHelloSpec has one.
+type VersionSpec struct {
+	// InitialVersion is the version of the first Initial packet.
+	// If zero, the first of the Versions is used.
+	InitialVersion Version
+
+	// Versions are the versions supported by the client, in order of preference.
+	// They replace the Config's Versions: after receiving a Version Negotiation
+	// packet, the client switches to the first of them that the server supports.
+	//
+	// If the ClientHelloSpec has a version_information transport parameter, they are
+	// its available versions, and the GREASE versions it lists are kept. The server
+	// may then switch the connection to one of them compatible with InitialVersion,
+	// e.g. from QUIC v1 to QUIC v2, using compatible version negotiation (RFC 9368).
+	// Without the version_information transport parameter, the server can't switch
+	// the version of the connection.
+	//
+	// If empty, the chosen and available versions of the version_information transport
+	// parameter are used as InitialVersion and Versions. If the ClientHelloSpec has no
+	// version_information transport parameter, the Config's Versions are used.
+	Versions []Version
+}
+
+// versionSpec returns the VersionSpec of the QUICSpec, using the version_information
+// transport parameter of the ClientHelloSpec if the VersionSpec has no Versions.
+func (s *QUICSpec) versionSpec() VersionSpec {
+	vs := s.VersionSpec
+	if len(vs.Versions) > 0 {
+		return vs
+	}
+	vi := s.versionInformation()
+	if vi == nil {
+		return vs
+	}
+	if vs.InitialVersion == 0 && isOfferedVersion(vi.ChoosenVersion) {
+		vs.InitialVersion = Version(vi.ChoosenVersion)
+	}
+	for _, v := range vi.AvailableVersions {
+		if isOfferedVersion(v) {
+			vs.Versions = append(vs.Versions, Version(v))
+		}
+	}
+	if len(vs.Versions) == 0 && vs.InitialVersion != 0 {
+		vs.Versions = []Version{vs.InitialVersion}
+	}
+	return vs
+}
+
+// updateVersions replaces the Config's Versions with the versions of the VersionSpec.
+func (s *QUICSpec) updateVersions(config *Config) {
+	if vs := s.versionSpec(); len(vs.Versions) > 0 {
+		config.Versions = slices.Clone(vs.Versions)
+	}
+}
+
+// initialVersion returns the version of the first Initial packet, for a Config
+// updated by UpdateConfig.
+func (s *QUICSpec) initialVersion(config *Config) Version {
+	if vs := s.versionSpec(); vs.InitialVersion != 0 {
+		return vs.InitialVersion
+	}
+	return config.Versions[0]
+}
+
+// versionInformation returns the version_information transport parameter of the
+// ClientHelloSpec, or nil if it has none.
+func (s *QUICSpec) versionInformation() *tls.VersionInformation {
+	if s.ClientHelloSpec == nil {
+		return nil
+	}
+	for _, ext := range s.ClientHelloSpec.Extensions {
+		tpExt, ok := ext.(*tls.QUICTransportParametersExtension)
+		if !ok {
+			continue
+		}
+		for _, tp := range tpExt.TransportParameters {
+			if vi, ok := tp.(*tls.VersionInformation); ok {
+				return vi
+			}
+		}
+	}
+	return nil
+}
+
+func isOfferedVersion(v uint32) bool {
+	return v != 0 && !protocol.IsReservedVersion(Version(v))
+}
+
+// applyVersionInformation sets the chosen and available versions of the
+// version_information transport parameter of the ClientHelloSpec of a connection
+// using version v, and offering the versions. The GREASE versions preceding the first
+// available version are kept in front of the versions, the others after them.
+//
+// It returns the versions the server may switch the connection to using compatible
+// version negotiation, which requires the version_information transport parameter.
+func applyVersionInformation(chs *tls.ClientHelloSpec, v Version, versions []Version) []Version {
+	var vi *tls.VersionInformation
+	for _, ext := range chs.Extensions {
+		if tpExt, ok := ext.(*tls.QUICTransportParametersExtension); ok {
+			for _, tp := range tpExt.TransportParameters {
+				if tp, ok := tp.(*tls.VersionInformation); ok {
+					vi = tp
+				}
+			}
+		}
+	}
+	if vi == nil {
+		return nil
+	}
+
+	var leading, trailing []uint32
+	for i, av := range vi.AvailableVersions {
+		if isOfferedVersion(av) {
+			for _, av := range vi.AvailableVersions[i+1:] {
+				if !isOfferedVersion(av) {
+					trailing = append(trailing, av)
+				}
+			}
+			break
+		}
+		leading = append(leading, av)
+	}
+	available := leading
+	for _, ov := range versions {
+		available = append(available, uint32(ov))
+	}
+	vi.ChoosenVersion = uint32(v)
+	vi.AvailableVersions = append(available, trailing...)
+
+	var compatible []Version
+	for _, ov := range versions {
+		if ov != v && protocol.AreCompatibleVersions(v, ov) {
+			compatible = append(compatible, ov)
+		}
+	}
+	return compatible
+}
@@ -0,0 +1,292 @@
+package quic
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Noooste/uquic-go/internal/protocol"
+	"github.com/Noooste/uquic-go/internal/qerr"
+	"github.com/Noooste/uquic-go/internal/testdata"
+	"github.com/Noooste/uquic-go/internal/wire"
+	"github.com/Noooste/uquic-go/qlog"
+	"github.com/Noooste/uquic-go/qlogwriter"
+	"github.com/Noooste/uquic-go/testutils/events"
+	tls "github.com/Noooste/utls"
+
+	"github.com/stretchr/testify/require"
+)
+
+func specVersionInformation(t *testing.T, spec *QUICSpec) *tls.VersionInformation {
+	t.Helper()
+	vi := spec.versionInformation()
+	require.NotNil(t, vi)
+	return vi
+}
+
+func TestQUICSpecVersionSpec(t *testing.T) {
+	pq, err := testQUICID2Spec(testQUICIDPQ)
+	require.NoError(t, err)
+	require.Equal(t, VersionSpec{InitialVersion: Version1, Versions: []Version{Version1}}, pq.versionSpec())
+
+	v1Only, err := testQUICID2Spec(testQUICIDConnID8)
+	require.NoError(t, err)
+	require.Nil(t, v1Only.versionInformation())
+	config := &Config{Versions: []Version{Version2, Version1}}
+	v1Only.UpdateConfig(config)
+	require.Equal(t, []Version{Version1}, config.Versions)
+	require.Equal(t, Version1, v1Only.initialVersion(config))
+
+	// without version_information, the Config's versions are kept
+	spec := QUICSpec{ClientHelloSpec: &tls.ClientHelloSpec{}}
+	config = &Config{Versions: []Version{Version2, Version1}}
+	spec.updateVersions(config)
+	require.Equal(t, []Version{Version2, Version1}, config.Versions)
+	require.Equal(t, Version2, spec.initialVersion(config))
+
+	// the VersionSpec takes precedence over the version_information
+	pq.VersionSpec = VersionSpec{InitialVersion: Version2, Versions: []Version{Version2, Version1}}
+	config = &Config{}
+	pq.updateVersions(config)
+	require.Equal(t, []Version{Version2, Version1}, config.Versions)
+	require.Equal(t, Version2, pq.initialVersion(config))
+}
+
+func TestApplyVersionInformation(t *testing.T) {
+	vi := &tls.VersionInformation{
+		ChoosenVersion:    uint32(Version1),
+		AvailableVersions: []uint32{0x1a2a3a4a, uint32(Version1), 0x5a6a7a8a},
+	}
+	chs := &tls.ClientHelloSpec{Extensions: []tls.TLSExtension{
+		&tls.QUICTransportParametersExtension{TransportParameters: tls.TransportParameters{vi}},
+	}}
+	compatible := applyVersionInformation(chs, Version1, []Version{Version1, Version2, 0xdeadbeef})
+	require.Equal(t, []Version{Version2}, compatible)
+	require.Equal(t, uint32(Version1), vi.ChoosenVersion)
+	require.Equal(t, []uint32{0x1a2a3a4a, uint32(Version1), uint32(Version2), 0xdeadbeef, 0x5a6a7a8a}, vi.AvailableVersions)
+
+	compatible = applyVersionInformation(chs, Version2, []Version{Version2})
+	require.Empty(t, compatible)
+	require.Equal(t, uint32(Version2), vi.ChoosenVersion)
+	require.Equal(t, []uint32{0x1a2a3a4a, uint32(Version2), 0x5a6a7a8a}, vi.AvailableVersions)
+
+	require.Nil(t, applyVersionInformation(&tls.ClientHelloSpec{}, Version1, []Version{Version1, Version2}))
+}
+
+func newVersionTestServer(t *testing.T, versions ...Version) (*Listener, <-chan *Conn) {
+	tlsConf := testdata.GetTLSConfig()
+	tlsConf.NextProtos = []string{"h3"}
+	ln, err := ListenAddr("127.0.0.1:0", tlsConf, &Config{Versions: versions})
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	accepted := make(chan *Conn, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			select {
+			case accepted <- conn:
+			default:
+			}
+		}
+	}()
+	return ln, accepted
+}
+
+func dialVersionTestServer(t *testing.T, ln *Listener, spec *QUICSpec) (*Conn, *initialRecorder) {
+	t.Helper()
+	tr := newUTransportWithSpecForTest(t, spec)
+	recorder := newInitialRecorder(t, ln.Addr())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := tr.Dial(
+		ctx,
+		recorder.conn.LocalAddr(),
+		&tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA(), NextProtos: []string{"h3"}},
+		nil,
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.CloseWithError(0, "") })
+	return conn, recorder
+}
+
+func TestUTransportInitialVersion(t *testing.T) {
+	ln, accepted := newVersionTestServer(t, Version2, Version1)
+	spec, err := testQUICID2Spec(testQUICIDPQ)
+	require.NoError(t, err)
+	spec.VersionSpec = VersionSpec{InitialVersion: Version2, Versions: []Version{Version2, Version1}}
+
+	conn, recorder := dialVersionTestServer(t, ln, &spec)
+	require.Equal(t, Version2, conn.ConnectionState().Version)
+	select {
+	case serverConn := <-accepted:
+		require.Equal(t, Version2, serverConn.ConnectionState().Version)
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+
+	datagrams := recorder.clientDatagrams()
+	hdr, _, _, err := wire.ParsePacket(datagrams[0])
+	require.NoError(t, err)
+	require.Equal(t, protocol.PacketTypeInitial, hdr.Type)
+	require.Equal(t, protocol.Version2, hdr.Version)
+
+	// the Initial packets are protected using the keys of QUIC v2,
+	// and the version_information transport parameter is updated accordingly
+	captured, err := QUICSpecFromInitialPackets(datagrams...)
+	require.NoError(t, err)
+	require.Equal(t, Version2, captured.VersionSpec.InitialVersion)
+	vi := specVersionInformation(t, &captured)
+	require.Equal(t, uint32(Version2), vi.ChoosenVersion)
+	require.Contains(t, vi.AvailableVersions, uint32(Version2))
+	require.Contains(t, vi.AvailableVersions, uint32(Version1))
+	// the QUICSpec used for dialing is not modified
+	require.Equal(t, uint32(Version1), specVersionInformation(t, &spec).ChoosenVersion)
+}
+
+func TestUTransportCompatibleVersionNegotiation(t *testing.T) {
+	ln, accepted := newVersionTestServer(t, Version2, Version1)
+
+	for _, tc := range []struct {
+		name            string
+		modify          func(*QUICSpec)
+		expectedVersion Version
+	}{
+		{
+			name: "version_information",
+			modify: func(spec *QUICSpec) {
+				specVersionInformation(t, spec).LegacyID = false
+				spec.VersionSpec = VersionSpec{Versions: []Version{Version1, Version2}}
+			},
+			expectedVersion: Version2,
+		},
+		{
+			name: "no compatible version offered",
+			modify: func(spec *QUICSpec) {
+				specVersionInformation(t, spec).LegacyID = false
+			},
+			expectedVersion: Version1,
+		},
+		{
+			name: "legacy version_information",
+			modify: func(spec *QUICSpec) {
+				spec.VersionSpec = VersionSpec{Versions: []Version{Version1, Version2}}
+			},
+			expectedVersion: Version1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			spec, err := testQUICID2Spec(testQUICIDPQ)
+			require.NoError(t, err)
+			tc.modify(&spec)
+
+			conn, recorder := dialVersionTestServer(t, ln, &spec)
+			require.Equal(t, tc.expectedVersion, conn.ConnectionState().Version)
+			select {
+			case serverConn := <-accepted:
+				require.Equal(t, tc.expectedVersion, serverConn.ConnectionState().Version)
+			case <-time.After(time.Second):
+				t.Fatal("timeout")
+			}
+			// the connection is started with QUIC v1
+			hdr, _, _, err := wire.ParsePacket(recorder.clientDatagrams()[0])
+			require.NoError(t, err)
+			require.Equal(t, protocol.Version1, hdr.Version)
+
+			str, err := conn.OpenStream()
+			require.NoError(t, err)
+			_, err = str.Write([]byte("foobar"))
+			require.NoError(t, err)
+			require.NoError(t, str.Close())
+		})
+	}
+}
+
+func TestUTransportCompatibleVersionNegotiationRetransmission(t *testing.T) {
+	var serverEvents events.Recorder
+	tlsConf := testdata.GetTLSConfig()
+	tlsConf.NextProtos = []string{"h3"}
+	ln, err := ListenAddr("127.0.0.1:0", tlsConf, &Config{
+		Versions: []Version{Version2, Version1},
+		Tracer: func(context.Context, bool, ConnectionID) qlogwriter.Trace {
+			return &events.Trace{Recorder: &serverEvents}
+		},
+	})
+	require.NoError(t, err)
+	defer ln.Close()
+
+	spec, err := testQUICID2Spec(testQUICIDPQ) // the ClientHello spans 2 Initial packets
+	require.NoError(t, err)
+	specVersionInformation(t, &spec).LegacyID = false
+	spec.VersionSpec = VersionSpec{Versions: []Version{Version1, Version2}}
+	tr := newUTransportWithSpecForTest(t, &spec)
+
+	// Drop the server's datagrams until the client retransmits its first Initial packet.
+	// The server switched to QUIC v2 when receiving the ClientHello, but the client
+	// still uses QUIC v1.
+	recorder := newInitialRecorder(t, ln.Addr())
+	recorder.mx.Lock()
+	recorder.dropFromServer = func(int) bool { return len(recorder.datagrams) <= 2 }
+	recorder.mx.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := tr.Dial(
+		ctx,
+		recorder.conn.LocalAddr(),
+		&tls.Config{ServerName: "localhost", RootCAs: testdata.GetRootCA(), NextProtos: []string{"h3"}},
+		nil,
+	)
+	require.NoError(t, err)
+	defer conn.CloseWithError(0, "")
+	require.Equal(t, Version2, conn.ConnectionState().Version)
+	serverConn, err := ln.Accept(ctx)
+	require.NoError(t, err)
+	require.Equal(t, Version2, serverConn.ConnectionState().Version)
+
+	var sentV1 int
+	for _, datagram := range recorder.clientDatagrams() {
+		hdr, _, _, err := wire.ParsePacket(datagram)
+		if err == nil && hdr.Type == protocol.PacketTypeInitial && hdr.Version == protocol.Version1 {
+			sentV1++
+		}
+	}
+	require.Greater(t, sentV1, 2)
+
+	// the server processed the retransmission, using the Initial keys of QUIC v1
+	var receivedV1 int
+	for _, ev := range serverEvents.Events(qlog.PacketReceived{}) {
+		hdr := ev.(qlog.PacketReceived).Header
+		if hdr.PacketType == qlog.PacketTypeInitial && hdr.Version == protocol.Version1 {
+			receivedV1++
+		}
+	}
+	require.Equal(t, sentV1, receivedV1)
+	for _, ev := range serverEvents.Events(qlog.PacketDropped{}) {
+		require.NotEqual(t, qlog.PacketDropUnexpectedVersion, ev.(qlog.PacketDropped).Trigger)
+	}
+}
+
+func TestConnHandleVersionInformation(t *testing.T) {
+	var transportErr *qerr.TransportError
+
+	c := &Conn{perspective: protocol.PerspectiveClient, version: protocol.Version2}
+	require.NoError(t, c.handleVersionInformation(&wire.TransportParameters{}))
+	require.NoError(t, c.handleVersionInformation(&wire.TransportParameters{
+		VersionInformation: &wire.VersionInformation{ChosenVersion: protocol.Version2},
+	}))
+	err := c.handleVersionInformation(&wire.TransportParameters{
+		VersionInformation: &wire.VersionInformation{ChosenVersion: protocol.Version1},
+	})
+	require.True(t, errors.As(err, &transportErr))
+	require.Equal(t, qerr.VersionNegotiationErrorCode, transportErr.ErrorCode)
+
+	// the server needs to confirm a version switch
+	c.switchedVersion = true
+	err = c.handleVersionInformation(&wire.TransportParameters{})
+	require.True(t, errors.As(err, &transportErr))
+	require.Equal(t, qerr.VersionNegotiationErrorCode, transportErr.ErrorCode)
+}